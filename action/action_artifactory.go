@@ -11,7 +11,6 @@ import (
 	"github.com/hamster-shared/aline-engine/utils"
 	"io"
 	"os"
-	"os/exec"
 	path2 "path"
 	"path/filepath"
 	"strconv"
@@ -174,7 +173,7 @@ func GetFiles(workdir string, fuzzyPath []string, pathList []string) []string {
 }
 
 func (a *ArtifactoryAction) ExecuteCommand(commands []string, workdir string) (string, error) {
-	c := exec.CommandContext(a.ctx, commands[0], commands[1:]...) // mac linux
+	c := newCommand(a.ctx, commands) // mac linux
 	c.Dir = workdir
 	logger.Debugf("execute docker command: %s", strings.Join(commands, " "))
 	a.output.WriteCommandLine(strings.Join(commands, " "))
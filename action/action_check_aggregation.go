@@ -91,7 +91,7 @@ func (a *CheckAggregationAction) Hook() (*model.ActionResult, error) {
 				if err != nil {
 					continue
 				}
-				contractCheckResultDetails.Message = []byte(strings.ReplaceAll(string(marshal), "\\u001b", ""))
+				contractCheckResultDetails.Message = output.StripANSIJSON(marshal)
 				contractCheckResultDetailsList = append(contractCheckResultDetailsList, contractCheckResultDetails)
 			}
 			methodsPropertiesReportRaw.Context = contractCheckResultDetailsList
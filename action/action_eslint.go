@@ -11,7 +11,6 @@ import (
 	"github.com/hamster-shared/aline-engine/output"
 	"github.com/tidwall/gjson"
 	"os"
-	"os/exec"
 	path2 "path"
 	"strconv"
 	"strings"
@@ -205,7 +204,7 @@ func (a *EslintAction) Post() error {
 }
 
 func (a *EslintAction) ExecuteCommand(commands []string, workdir string) (string, error) {
-	c := exec.CommandContext(a.ctx, commands[0], commands[1:]...) // mac linux
+	c := newCommand(a.ctx, commands) // mac linux
 	c.Dir = workdir
 	logger.Debugf("execute mythril check command: %s", strings.Join(commands, " "))
 	a.output.WriteCommandLine(strings.Join(commands, " "))
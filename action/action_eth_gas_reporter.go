@@ -12,7 +12,6 @@ import (
 	"github.com/hamster-shared/aline-engine/output"
 	"io"
 	"os"
-	"os/exec"
 	path2 "path"
 	"regexp"
 	"strconv"
@@ -77,12 +76,7 @@ func (a *EthGasReporterAction) Hook() (*model.ActionResult, error) {
 	if out == "" && err != nil {
 		return nil, err
 	}
-	compile, err := regexp.Compile(`\[.{1,2}m`)
-	if err != nil {
-		logger.Errorf("regexp err %s", err)
-		return nil, err
-	}
-	replaceAfterString := compile.ReplaceAllString(out, "")
+	replaceAfterString := output.StripANSI(out)
 	create, err := os.Create(dest)
 	if err != nil {
 		return nil, err
@@ -356,7 +350,7 @@ func (a *EthGasReporterAction) Post() error {
 	if err != nil {
 		return err
 	}
-	_, err = create.WriteString(strings.ReplaceAll(string(marshal), "\\u001b", ""))
+	_, err = create.Write(output.StripANSIJSON(marshal))
 	if err != nil {
 		return err
 	}
@@ -365,7 +359,7 @@ func (a *EthGasReporterAction) Post() error {
 }
 
 func (a *EthGasReporterAction) ExecuteCommand(commands []string, workdir string) (string, error) {
-	c := exec.CommandContext(a.ctx, commands[0], commands[1:]...) // mac linux
+	c := newCommand(a.ctx, commands) // mac linux
 	c.Dir = workdir
 	logger.Debugf("execute eth-gas-reporter check command: %s", strings.Join(commands, " "))
 	a.output.WriteCommandLine(strings.Join(commands, " "))
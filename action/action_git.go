@@ -9,7 +9,6 @@ import (
 	"github.com/hamster-shared/aline-engine/output"
 	"github.com/hamster-shared/aline-engine/utils"
 	"os"
-	"os/exec"
 	"strings"
 )
 
@@ -17,6 +16,7 @@ import (
 type GitAction struct {
 	repository string
 	branch     string
+	commit     string // 指定 commit 时检出该 commit，而不是分支的最新提交
 	workdir    string
 	output     *output.Output
 	ctx        context.Context
@@ -30,6 +30,7 @@ func NewGitAction(step model2.Step, ctx context.Context, output *output.Output)
 	return &GitAction{
 		repository: utils.ReplaceWithParam(step.With["url"], params),
 		branch:     utils.ReplaceWithParam(step.With["branch"], params),
+		commit:     utils.ReplaceWithParam(step.With["commit"], params),
 		ctx:        ctx,
 		output:     output,
 	}
@@ -109,6 +110,9 @@ func (a *GitAction) Hook() (*model2.ActionResult, error) {
 	}
 
 	command = fmt.Sprintf("git rev-parse refs/remotes/origin/%s^{commit}", a.branch)
+	if a.commit != "" {
+		command = fmt.Sprintf("git rev-parse %s^{commit}", a.commit)
+	}
 	commitId, err := a.ExecuteCommandDirect(strings.Fields(command))
 	if err != nil {
 		return nil, err
@@ -162,6 +166,7 @@ func (a *GitAction) Hook() (*model2.ActionResult, error) {
 		CodeInfo: model2.CodeInfo{
 			Branch:        a.branch,
 			CommitId:      commitId[0:6],
+			CommitSha:     commitId,
 			CommitDate:    strings.ReplaceAll(commitDate, `"`, ``),
 			CommitMessage: strings.ReplaceAll(commitMessage, `"`, ``),
 		},
@@ -180,7 +185,7 @@ func (a *GitAction) ExecuteStringCommand(command string) (string, error) {
 
 func (a *GitAction) ExecuteCommand(commands []string) (string, error) {
 
-	c := exec.CommandContext(a.ctx, commands[0], commands[1:]...) // mac linux
+	c := newCommand(a.ctx, commands) // mac linux
 	c.Dir = a.workdir
 	logger.Debugf("execute git clone command: %s", strings.Join(commands, " "))
 	a.output.WriteCommandLine(strings.Join(commands, " "))
@@ -196,28 +201,6 @@ func (a *GitAction) ExecuteCommand(commands []string) (string, error) {
 		return "nil", err
 	}
 
-	go func() {
-		for {
-			// 检测到 ctx.Done() 之后停止读取
-			<-a.ctx.Done()
-			if a.ctx.Err() != nil {
-				logger.Errorf("shell command error: %v", a.ctx.Err())
-				return
-			} else {
-				p := c.Process
-				if p == nil {
-					return
-				}
-				// Kill by negative PID to kill the process group, which includes
-				// the top-level process we spawned as well as any subprocesses
-				// it spawned.
-				//_ = syscall.Kill(-p.Pid, syscall.SIGKILL)
-				logger.Info("shell command killed")
-				return
-			}
-		}
-	}()
-
 	stdoutScanner := bufio.NewScanner(stdout)
 	stderrScanner := bufio.NewScanner(stderr)
 	go func() {
@@ -253,7 +236,7 @@ func (a *GitAction) ExecuteCommand(commands []string) (string, error) {
 
 func (a *GitAction) ExecuteCommandDirect(commands []string) (string, error) {
 
-	c := exec.CommandContext(a.ctx, commands[0], commands[1:]...) // mac linux
+	c := newCommand(a.ctx, commands) // mac linux
 	c.Dir = a.workdir
 	logger.Debugf("execute git clone command: %s", strings.Join(commands, " "))
 	a.output.WriteCommandLine(strings.Join(commands, " "))
@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	model2 "github.com/hamster-shared/aline-engine/model"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestRev(t *testing.T) {
@@ -35,3 +39,34 @@ func TestTruffle(t *testing.T) {
 	fmt.Println("out:", string(out))
 
 }
+
+func TestGitActionCommitSha(t *testing.T) {
+	ctx, stack, out := newContainerTestContext(t)
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		c := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		c.Dir = repo
+		data, err := c.CombinedOutput()
+		assert.NoError(t, err, string(data))
+		return strings.TrimSpace(string(data))
+	}
+	git("init", "-b", "main")
+	assert.NoError(t, os.WriteFile(filepath.Join(repo, "README.md"), []byte("first"), 0644))
+	git("add", "README.md")
+	git("commit", "-m", "first")
+	sha := git("rev-parse", "HEAD")
+	assert.NoError(t, os.WriteFile(filepath.Join(repo, "README.md"), []byte("second"), 0644))
+	git("commit", "-am", "second")
+
+	// 按照上一次执行记录的完整 sha 检出
+	stack["workdir"] = filepath.Join(t.TempDir(), "checkout")
+	action := NewGitAction(model2.Step{With: map[string]string{"url": repo, "branch": "main", "commit": sha}}, ctx, out)
+	assert.NoError(t, action.Pre())
+	result, err := action.Hook()
+	assert.NoError(t, err)
+	assert.Equal(t, sha, result.CodeInfo.CommitSha)
+	assert.Equal(t, sha[:6], result.CodeInfo.CommitId)
+	data, err := os.ReadFile(filepath.Join(stack["workdir"].(string), "README.md"))
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+}
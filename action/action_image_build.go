@@ -4,12 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/hamster-shared/aline-engine/container"
 	"github.com/hamster-shared/aline-engine/logger"
 	"github.com/hamster-shared/aline-engine/model"
 	"github.com/hamster-shared/aline-engine/output"
 	"github.com/hamster-shared/aline-engine/utils"
-	"os/exec"
-	"strings"
 )
 
 type ImageBuildAction struct {
@@ -40,9 +39,15 @@ func (i *ImageBuildAction) Hook() (*model.ActionResult, error) {
 	if !ok {
 		return nil, errors.New("get workdir error")
 	}
-	buildCommands := []string{"docker", "build", "-t", i.imageName, "."}
-	_, err := i.ExecuteCommand(buildCommands, workdir)
+	rt := container.Default()
+	command := fmt.Sprintf("%s build -t %s .", rt.Name(), i.imageName)
+	logger.Debugf("execute container command: %s", command)
+	i.output.WriteCommandLine(command)
+	out := newLineWriter(i.output)
+	err := rt.Build(i.ctx, container.BuildSpec{ContextDir: workdir, Tag: i.imageName, Output: out})
+	out.Flush()
 	if err != nil {
+		i.output.WriteLine(err.Error())
 		return nil, errors.New("docker build image failed")
 	}
 	return nil, nil
@@ -50,17 +55,3 @@ func (i *ImageBuildAction) Hook() (*model.ActionResult, error) {
 func (i *ImageBuildAction) Post() error {
 	return nil
 }
-
-func (i *ImageBuildAction) ExecuteCommand(commands []string, workdir string) (string, error) {
-	c := exec.CommandContext(i.ctx, commands[0], commands[1:]...) // mac linux
-	c.Dir = workdir
-	logger.Debugf("execute docker command: %s", strings.Join(commands, " "))
-	i.output.WriteCommandLine(strings.Join(commands, " "))
-	out, err := c.CombinedOutput()
-	fmt.Println(string(out))
-	i.output.WriteCommandLine(string(out))
-	if err != nil {
-		i.output.WriteLine(err.Error())
-	}
-	return string(out), err
-}
@@ -4,12 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/hamster-shared/aline-engine/container"
 	"github.com/hamster-shared/aline-engine/logger"
 	"github.com/hamster-shared/aline-engine/model"
 	"github.com/hamster-shared/aline-engine/output"
 	"github.com/hamster-shared/aline-engine/utils"
-	"os/exec"
-	"strings"
 )
 
 type ImagePushAction struct {
@@ -35,18 +34,22 @@ func (i *ImagePushAction) Pre() error {
 }
 
 func (i *ImagePushAction) Hook() (*model.ActionResult, error) {
-	stack := i.ctx.Value(STACK).(map[string]interface{})
-	workdir, ok := stack["workdir"].(string)
-	if !ok {
-		return nil, errors.New("get workdir error")
-	}
-	pushCommands := []string{"docker", "push", i.imageName}
-	_, err := i.ExecuteCommand(pushCommands, workdir)
+	rt := container.Default()
+	command := fmt.Sprintf("%s push %s", rt.Name(), i.imageName)
+	logger.Debugf("execute container command: %s", command)
+	i.output.WriteCommandLine(command)
+	out := newLineWriter(i.output)
+	err := rt.Push(i.ctx, i.imageName, out)
+	out.Flush()
 	if err != nil {
+		i.output.WriteLine(err.Error())
 		return nil, errors.New("docker push image failed")
 	}
-	imageDeleteCommands := []string{"docker", "rmi", i.imageName}
-	i.ExecuteCommand(imageDeleteCommands, workdir)
+	// 推送后删除本地的镜像，删除失败不影响结果
+	i.output.WriteCommandLine(fmt.Sprintf("%s rmi %s", rt.Name(), i.imageName))
+	if err := rt.RemoveImage(i.ctx, i.imageName); err != nil {
+		i.output.WriteLine(err.Error())
+	}
 	actionResult := &model.ActionResult{
 		Artifactorys: []model.Artifactory{
 			{
@@ -64,17 +67,3 @@ func (i *ImagePushAction) Hook() (*model.ActionResult, error) {
 func (i *ImagePushAction) Post() error {
 	return nil
 }
-
-func (i *ImagePushAction) ExecuteCommand(commands []string, workdir string) (string, error) {
-	c := exec.CommandContext(i.ctx, commands[0], commands[1:]...) // mac linux
-	c.Dir = workdir
-	logger.Debugf("execute docker command: %s", strings.Join(commands, " "))
-	i.output.WriteCommandLine(strings.Join(commands, " "))
-	out, err := c.CombinedOutput()
-	fmt.Println(string(out))
-	i.output.WriteCommandLine(string(out))
-	if err != nil {
-		i.output.WriteLine(err.Error())
-	}
-	return string(out), err
-}
@@ -9,7 +9,6 @@ import (
 	"github.com/hamster-shared/aline-engine/output"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 )
@@ -96,7 +95,7 @@ func (a *InkAction) ExecuteStringCommand(command, workdir string) (string, error
 }
 
 func (a *InkAction) ExecuteCommand(commands []string, workdir string) (string, error) {
-	c := exec.CommandContext(a.ctx, commands[0], commands[1:]...) // mac linux
+	c := newCommand(a.ctx, commands) // mac linux
 	c.Dir = workdir
 	logger.Debugf("execute truffle deploy command: %s", strings.Join(commands, " "))
 	a.output.WriteCommandLine(strings.Join(commands, " "))
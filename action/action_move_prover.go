@@ -9,13 +9,12 @@ import (
 	"github.com/hamster-shared/aline-engine/utils"
 	"io"
 	"os"
-	"os/exec"
 	path2 "path"
-	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/hamster-shared/aline-engine/consts"
+	"github.com/hamster-shared/aline-engine/container"
 	"github.com/hamster-shared/aline-engine/logger"
 	"github.com/hamster-shared/aline-engine/model"
 	"github.com/hamster-shared/aline-engine/output"
@@ -81,35 +80,28 @@ func (a *MoveProverAction) Hook() (*model.ActionResult, error) {
 		_, filenameOnly := utils.GetFilenameWithSuffixAndFilenameOnly(path)
 		dest := path2.Join(destDir, filenameOnly+consts.SuffixType)
 		redundantPath, err := utils.GetRedundantPath(workdir, path)
-		commandTemplate := consts.MoveProveCheck
 		//获取--named-addresses
 		namedAddress, err := utils.GetDefaultNamedAddress(path2.Join(workdir, "Move.toml"))
 		if err != nil {
 			return nil, err
 		}
-		var cachePathCommand string
+		binds := []string{workdir + ":/tmp"}
 		if a.cachePath != "" {
-			cachePathCommand = "-v " + a.cachePath
+			binds = append([]string{a.cachePath}, binds...)
 		}
-		var command string
+		cmd := []string{"aptos", "move", "prove", "--package-dir", path2.Join("/tmp", redundantPath)}
 		if namedAddress != "" {
-			command = fmt.Sprintf(commandTemplate, cachePathCommand, workdir, path2.Join("/tmp", redundantPath), " --named-addresses "+namedAddress)
-		} else {
-			command = fmt.Sprintf(commandTemplate, cachePathCommand, workdir, path2.Join("/tmp", redundantPath), "")
+			cmd = append(cmd, "--named-addresses", namedAddress)
 		}
-		fields := strings.Fields(command)
-		out, err := a.ExecuteCommand(fields, workdir)
+		out, err := runContainer(a.ctx, a.output, container.RunSpec{
+			Image: consts.MoveProveCheckImage,
+			Cmd:   cmd,
+			Binds: binds,
+		})
 		if out == "" && err != nil {
 			return nil, err
 		}
-		compile, err := regexp.Compile(`\[.{1,6}m`)
-		if err != nil {
-			logger.Errorf("regexp err %s", err)
-			return nil, err
-		}
-		replaceAfterString := compile.ReplaceAllString(out, "")
-		replaceAfterString = strings.ReplaceAll(replaceAfterString, "\u001B", "")
-		//replaceAfterString = strings.ReplaceAll(replaceAfterString, "[38;5;11m", "")
+		replaceAfterString := output.StripANSI(out)
 		if strings.Contains(replaceAfterString, "Unable to resolve packages for package") {
 			return nil, errors.New(replaceAfterString)
 		}
@@ -232,17 +224,3 @@ func (a *MoveProverAction) Post() error {
 	create.Close()
 	return nil
 }
-
-func (a *MoveProverAction) ExecuteCommand(commands []string, workdir string) (string, error) {
-	c := exec.CommandContext(a.ctx, commands[0], commands[1:]...) // mac linux
-	c.Dir = workdir
-	logger.Debugf("execute move prove check command: %s", strings.Join(commands, " "))
-	a.output.WriteCommandLine(strings.Join(commands, " "))
-	out, err := c.CombinedOutput()
-	fmt.Println(string(out))
-	a.output.WriteCommandLine(string(out))
-	if err != nil {
-		a.output.WriteLine(err.Error())
-	}
-	return string(out), err
-}
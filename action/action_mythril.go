@@ -8,12 +8,12 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	path2 "path"
 	"strconv"
 	"strings"
 
 	"github.com/hamster-shared/aline-engine/consts"
+	"github.com/hamster-shared/aline-engine/container"
 	"github.com/hamster-shared/aline-engine/logger"
 	"github.com/hamster-shared/aline-engine/model"
 	"github.com/hamster-shared/aline-engine/output"
@@ -128,13 +128,16 @@ func (a *MythRilAction) Hook() (*model.ActionResult, error) {
 		if err != nil {
 			return nil, err
 		}
-		commandTemplate := consts.MythRilCheck
-		command := fmt.Sprintf(commandTemplate, workdir, path2.Join(a.path, redundantPath), consts.MythRilSolcJsonName)
+		cmd := []string{"analyze", path2.Join("/tmp", a.path, redundantPath), "--solc-json", consts.MythRilSolcJsonName, "--execution-timeout", "15"}
 		if a.solcVersion != "" {
-			command = command + " --solv " + a.solcVersion
+			cmd = append(cmd, "--solv", a.solcVersion)
 		}
-		fields := strings.Fields(command)
-		out, err := a.ExecuteCommand(fields, workdir)
+		out, err := runContainer(a.ctx, a.output, container.RunSpec{
+			Image:      consts.MythRilCheckImage,
+			Cmd:        cmd,
+			WorkingDir: "/tmp",
+			Binds:      []string{workdir + ":/tmp"},
+		})
 		if out == "" && err != nil {
 			return nil, err
 		}
@@ -260,17 +263,3 @@ func (a *MythRilAction) Post() error {
 	create.Close()
 	return nil
 }
-
-func (a *MythRilAction) ExecuteCommand(commands []string, workdir string) (string, error) {
-	c := exec.CommandContext(a.ctx, commands[0], commands[1:]...) // mac linux
-	c.Dir = workdir
-	logger.Debugf("execute mythril check command: %s", strings.Join(commands, " "))
-	a.output.WriteCommandLine(strings.Join(commands, " "))
-	out, err := c.CombinedOutput()
-	fmt.Println(string(out))
-	a.output.WriteCommandLine(string(out))
-	if err != nil {
-		a.output.WriteLine(err.Error())
-	}
-	return string(out), err
-}
@@ -6,58 +6,87 @@ import (
 	"fmt"
 	"github.com/hamster-shared/aline-engine/logger"
 	model2 "github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
 	"github.com/hamster-shared/aline-engine/utils"
 	"gopkg.in/yaml.v2"
-	"io"
 	"os"
-	"os/exec"
-	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 // RemoteAction 执行远程命令
 type RemoteAction struct {
-	name string
-	args map[string]string
-	ctx  context.Context
+	name   string
+	args   map[string]string
+	ctx    context.Context
+	output *output.Output
 
 	actionRoot string
+	// 工作目录中的 action 为空
+	commit string
+	// 嵌套在 composite action 中的层数
+	depth   int
+	outputs map[string]string
+	// 脚本和 shell step 一样放进沙箱，并限制资源
+	resources *model2.Resources
+	volumes   []string
 }
 
-func NewRemoteAction(step model2.Step, ctx context.Context) *RemoteAction {
+func NewRemoteAction(step model2.Step, ctx context.Context, output *output.Output) *RemoteAction {
 	return &RemoteAction{
-		name: step.Uses,
-		args: step.With,
-		ctx:  ctx,
+		name:      step.Uses,
+		args:      step.With,
+		ctx:       ctx,
+		output:    output,
+		resources: step.Resources,
+		volumes:   step.Volumes,
 	}
 }
 
 func (a *RemoteAction) Pre() error {
-
+	src := parseRemoteUses(a.name)
+	err := src.validate()
+	if err == nil {
+		err = src.allowed(currentRemoteActionConfig().Allowlist)
+	}
+	if err != nil {
+		a.output.WriteLine(err.Error())
+		return err
+	}
 	stack := a.ctx.Value(STACK).(map[string]interface{})
+	workdir, ok := stack["workdir"].(string)
+	if !ok {
+		return errors.New("workdir error")
+	}
 
-	pipelineName := stack["name"].(string)
-
-	logger.Infof("git stack: %v", stack)
-
-	hamsterRoot := stack["hamsterRoot"].(string)
-	cloneDir := utils.RandSeq(9)
-	a.actionRoot = path.Join(hamsterRoot, cloneDir)
-
-	_ = os.MkdirAll(hamsterRoot, os.ModePerm)
-	_ = os.Remove(path.Join(hamsterRoot, pipelineName))
-
-	githubUrl := fmt.Sprintf("https://github.com/%s", a.name)
-
-	commands := []string{"git", "clone", "--progress", githubUrl, cloneDir}
-	c := exec.CommandContext(a.ctx, commands[0], commands[1:]...) // mac linux
-	c.Dir = hamsterRoot
-
-	fmt.Println(a.name)
-	fmt.Println(a.args)
+	if src.local != "" {
+		// 工作目录中的 action 不能引用工作目录之外的文件
+		dir := filepath.Join(workdir, src.local)
+		if rel, err := filepath.Rel(workdir, dir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("action %s is outside the workdir", a.name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "action.yml")); err != nil {
+			return fmt.Errorf("action %s: %w", a.name, err)
+		}
+		a.actionRoot = dir
+		return nil
+	}
 
-	output, err := c.CombinedOutput()
-	fmt.Println(string(output))
-	return err
+	checkout, err := remoteActionCache.checkout(a.ctx, src, a.output)
+	if err == nil {
+		err = verifyActionLock(workdir, src, checkout)
+	}
+	if err != nil {
+		logger.Errorf("checkout remote action %s error: %s", a.name, err)
+		a.output.WriteLine(err.Error())
+		return err
+	}
+	logger.Infof("remote action %s at %s", a.name, checkout.Commit)
+	a.output.WriteLine(fmt.Sprintf("%s at %s", a.name, checkout.Commit))
+	a.actionRoot = checkout.Dir
+	a.commit = checkout.Commit
+	return nil
 }
 
 func (a *RemoteAction) Hook() (*model2.ActionResult, error) {
@@ -67,46 +96,115 @@ func (a *RemoteAction) Hook() (*model2.ActionResult, error) {
 	if !ok {
 		return nil, errors.New("env cannot be empty")
 	}
+	workdir, ok := stack["workdir"].(string)
+	if !ok {
+		return nil, errors.New("workdir error")
+	}
+	params, _ := stack["parameter"].(map[string]string)
 
-	file, err := os.Open(path.Join(a.actionRoot, "action.yml"))
+	yamlFile, err := os.ReadFile(filepath.Join(a.actionRoot, "action.yml"))
 	if err != nil {
 		return nil, err
 	}
-	yamlFile, err := io.ReadAll(file)
+	var remoteAction model2.RemoteAction
+	if err := yaml.Unmarshal(yamlFile, &remoteAction); err != nil {
+		return nil, fmt.Errorf("parse action.yml of %s: %w", a.name, err)
+	}
+
+	inputs, err := a.inputs(remoteAction.Inputs, params)
 	if err != nil {
+		a.output.WriteLine(err.Error())
 		return nil, err
 	}
-	var remoteAction model2.RemoteAction
-	err = yaml.Unmarshal(yamlFile, &remoteAction)
 
-	for envName := range remoteAction.Inputs {
-		env = append(env, fmt.Sprintf("%s=%s", envName, a.args[envName]))
+	run := &remoteRun{
+		action:     a,
+		params:     params,
+		inputs:     inputs,
+		env:        append(append([]string{}, env...), inputEnv(inputs)...),
+		workdir:    workdir,
+		workdirTmp: getWorkdirTmp(stack, workdir),
+		steps:      make(map[string]map[string]string),
+		collected:  make(map[string]string),
+	}
+	_ = os.MkdirAll(run.workdirTmp, os.ModePerm)
+
+	var result *model2.ActionResult
+	switch remoteAction.Runs.Using {
+	case "", model2.ACTION_USING_SHELL, model2.ACTION_USING_COMPOSITE:
+		result, err = run.runSteps(remoteAction.Runs.Steps)
+	case model2.ACTION_USING_DOCKER:
+		err = run.runDocker(remoteAction.Runs)
+	default:
+		err = fmt.Errorf("unsupported runs.using %q of %s", remoteAction.Runs.Using, a.name)
+	}
+	if err != nil {
+		return result, err
 	}
+	a.outputs = run.outputs(remoteAction.Outputs)
+	return result, nil
+}
 
-	for index, step := range remoteAction.Runs.Steps {
-		args := make([]string, 0)
-		if _, err := os.Stat(path.Join(a.actionRoot, step.Run)); err == nil {
-			args = append(args, step.Run)
+// Outputs action 执行成功后的输出
+func (a *RemoteAction) Outputs() map[string]string {
+	return a.outputs
+}
+
+// inputs 按照 action.yml 中的定义确定输入的值，没有传的输入使用默认值，缺少必填的输入时报错
+func (a *RemoteAction) inputs(defined map[string]model2.ActionInputArg, params map[string]string) (map[string]string, error) {
+	names := make([]string, 0, len(defined))
+	for name := range defined {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	inputs := make(map[string]string, len(defined))
+	var missing []string
+	for _, name := range names {
+		value, ok := a.args[name]
+		if ok {
+			value = utils.ReplaceWithParam(value, params)
 		} else {
-			stepFile := path.Join(a.actionRoot, fmt.Sprintf("step-%d", index))
-			err = os.WriteFile(stepFile, []byte(step.Run), os.ModePerm)
-			if err != nil {
-				return nil, err
-			}
-			args = append(args, "-c", stepFile)
+			value = defined[name].Default
+		}
+		if value == "" && defined[name].Required {
+			missing = append(missing, name)
+			continue
+		}
+		inputs[name] = value
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required inputs of %s: %s", a.name, strings.Join(missing, ", "))
+	}
+	var unexpected []string
+	for name := range a.args {
+		if _, ok := defined[name]; !ok {
+			unexpected = append(unexpected, name)
 		}
-
-		cmd := utils.NewCommand(a.ctx, step.Shell, args...)
-		cmd.Dir = a.actionRoot
-		cmd.Env = env
-		output, _ := cmd.CombinedOutput()
-		fmt.Println(string(output))
 	}
+	if len(unexpected) > 0 {
+		sort.Strings(unexpected)
+		a.output.WriteLine(fmt.Sprintf("warning: unexpected inputs of %s: %s", a.name, strings.Join(unexpected, ", ")))
+	}
+	return inputs, nil
+}
 
-	return nil, err
+// inputEnv 输入的环境变量，每个输入同时以原名和 INPUT_<NAME> 两种形式传给 action
+func inputEnv(inputs map[string]string) []string {
+	names := make([]string, 0, len(inputs))
+	for name := range inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	env := make([]string, 0, 2*len(inputs))
+	for _, name := range names {
+		value := inputs[name]
+		env = append(env, name+"="+value, "INPUT_"+strings.ToUpper(strings.ReplaceAll(name, " ", "_"))+"="+value)
+	}
+	return env
 }
 
 func (a *RemoteAction) Post() error {
-
-	return os.RemoveAll(a.actionRoot)
+	// 检出的目录是缓存，不删除
+	return nil
 }
@@ -0,0 +1,343 @@
+package action
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hamster-shared/aline-engine/container"
+	"github.com/hamster-shared/aline-engine/logger"
+	model2 "github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/sandbox"
+	"github.com/hamster-shared/aline-engine/utils"
+)
+
+// maxActionDepth composite action 最多嵌套的层数，避免 action 互相引用时无限递归
+const maxActionDepth = 8
+
+// OutputsProvider 会产生输出的 action，执行成功后输出记录到 step 上
+type OutputsProvider interface {
+	Outputs() map[string]string
+}
+
+// actionExpr action.yml 中引用输入和 step 输出的表达式
+var actionExpr = regexp.MustCompile(`\$\{\{\s*(?:inputs\.([\w-]+)|steps\.([\w-]+)\.outputs\.([\w-]+))\s*\}\}`)
+
+// remoteRun 一次 remote action 的执行过程
+type remoteRun struct {
+	action     *RemoteAction
+	params     map[string]string
+	inputs     map[string]string
+	env        []string
+	workdir    string
+	workdirTmp string
+	// 有 id 的 step 的输出
+	steps map[string]map[string]string
+	// 所有 step 写到 ALINE_OUTPUT 中的输出，后写的覆盖先写的
+	collected map[string]string
+}
+
+// render 替换 ${{ inputs.x }}、${{ steps.x.outputs.y }} 和 pipeline 的参数
+func (r *remoteRun) render(content string) string {
+	content = actionExpr.ReplaceAllStringFunc(content, func(expr string) string {
+		match := actionExpr.FindStringSubmatch(expr)
+		if match[1] != "" {
+			return r.inputs[match[1]]
+		}
+		return r.steps[match[2]][match[3]]
+	})
+	return utils.ReplaceWithParam(content, r.params)
+}
+
+// runSteps 按顺序执行 action 的 step，第一个失败的 step 使 action 失败
+func (r *remoteRun) runSteps(steps []model2.ActionStep) (*model2.ActionResult, error) {
+	var result *model2.ActionResult
+	for index, step := range steps {
+		var (
+			outputs map[string]string
+			stepRes *model2.ActionResult
+			err     error
+		)
+		if step.Uses != "" {
+			outputs, stepRes, err = r.runUses(step)
+		} else {
+			outputs, err = r.runScript(step)
+		}
+		if stepRes != nil {
+			if result == nil {
+				result = &model2.ActionResult{}
+			}
+			result.Merge(stepRes)
+		}
+		if err != nil {
+			logger.Errorf("remote action %s step %d error: %s", r.action.name, index+1, err)
+			r.action.output.WriteLine(fmt.Sprintf("step %d of %s failed: %s", index+1, r.action.name, err))
+			return result, fmt.Errorf("step %d of %s: %w", index+1, r.action.name, err)
+		}
+		if step.Id != "" {
+			r.steps[step.Id] = outputs
+		}
+		for k, v := range outputs {
+			r.collected[k] = v
+		}
+	}
+	return result, nil
+}
+
+// runScript 执行脚本，run 是 action 中的脚本文件时直接执行，否则写到临时文件中，不修改缓存中的 action
+func (r *remoteRun) runScript(step model2.ActionStep) (map[string]string, error) {
+	shell := step.Shell
+	if shell == "" {
+		shell = "sh"
+	}
+	script := filepath.Join(r.action.actionRoot, step.Run)
+	if info, err := os.Stat(script); err != nil || info.IsDir() {
+		script = filepath.Join(r.workdirTmp, utils.RandSeq(10))
+		if err := os.WriteFile(script, []byte(r.render(step.Run)), os.ModePerm); err != nil {
+			return nil, err
+		}
+		defer os.Remove(script)
+	}
+	outputFile := r.outputFile()
+	defer os.Remove(outputFile)
+
+	commands := []string{shell, script}
+	r.action.output.WriteCommandLine(strings.Join(commands, " "))
+
+	// 和 shell step 一样按照 worker 的配置放进沙箱，缓存中的 action 只读挂载
+	volumes := r.action.volumes
+	if r.action.commit != "" {
+		volumes = append(append([]string{}, volumes...), r.action.actionRoot+":"+r.action.actionRoot+":ro")
+	}
+	sb, err := sandbox.New(sandbox.Spec{Workdir: r.workdir, TmpDir: r.workdirTmp, Volumes: volumes})
+	if err != nil {
+		return nil, err
+	}
+	defer sb.Close()
+	commands = sb.Command(commands)
+
+	limiter, err := newResourceLimiter(r.action.ctx, r.action.resources, r.action.output)
+	if err != nil {
+		return nil, err
+	}
+	defer limiter.close()
+	commands = limiter.wrap(commands)
+	ctx, cancel := context.WithCancel(r.action.ctx)
+	defer cancel()
+	limiter.watch(cancel)
+
+	cmd := newCommand(ctx, commands)
+	cmd.Dir = r.action.actionRoot
+	// 和 shell step 一样带上 worker 的环境变量，沙箱中按照 PATH 查找 shell
+	cmd.Env = append(append(append([]string{}, r.env...), "ALINE_OUTPUT="+outputFile), os.Environ()...)
+	if err := sb.Apply(cmd.Cmd); err != nil {
+		return nil, err
+	}
+	out := newLineWriter(r.action.output)
+	errOut := out.errWriter()
+	cmd.Stdout = out
+	cmd.Stderr = errOut
+	err = cmd.Run()
+	out.Flush()
+	errOut.Flush()
+	if err != nil {
+		return nil, limiter.check(err)
+	}
+	return readOutputFile(outputFile)
+}
+
+// runUses 执行 composite action 中引用的内置 action 或其他远程 action
+func (r *remoteRun) runUses(step model2.ActionStep) (map[string]string, *model2.ActionResult, error) {
+	if r.action.depth >= maxActionDepth {
+		return nil, nil, fmt.Errorf("actions nested more than %d levels", maxActionDepth)
+	}
+	with := make(map[string]string, len(step.With))
+	for k, v := range step.With {
+		with[k] = r.render(v)
+	}
+	ah := New(model2.Step{Name: step.Name, Uses: step.Uses, With: with, Resources: r.action.resources, Volumes: r.action.volumes}, r.action.ctx, r.action.output)
+	if ah == nil {
+		return nil, nil, fmt.Errorf("unknown action %s", step.Uses)
+	}
+	if remote, ok := ah.(*RemoteAction); ok {
+		remote.depth = r.action.depth + 1
+	}
+	if err := ah.Pre(); err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err := ah.Post(); err != nil {
+			logger.Warnf("post of action %s error: %s", step.Uses, err)
+		}
+	}()
+	result, err := ah.Hook()
+	if err != nil {
+		return nil, result, err
+	}
+	var outputs map[string]string
+	if provider, ok := ah.(OutputsProvider); ok {
+		outputs = provider.Outputs()
+	}
+	return outputs, result, nil
+}
+
+// runDocker 在容器中执行 action，image 为 action 中 Dockerfile 的路径时先构建镜像
+func (r *remoteRun) runDocker(runs model2.ActionRun) error {
+	rt := container.Default()
+	ctx := r.action.ctx
+	image := runs.Image
+	switch {
+	case image == "":
+		return errors.New("runs.image is required for docker actions")
+	case strings.HasPrefix(image, "docker://"):
+		image = strings.TrimPrefix(image, "docker://")
+	default:
+		// 工作目录中的 action 没有 commit，每次都重新构建，tag 中带上 job id 和随机后缀，
+		// 避免同一个 worker 上并发的任务在构建和启动容器之间覆盖彼此的镜像，执行结束后删除
+		version := r.action.commit
+		if version != "" {
+			version = version[:12]
+		} else {
+			stack := ctx.Value(STACK).(map[string]interface{})
+			jobID, _ := stack["id"].(string)
+			version = fmt.Sprintf("local-%s-%s", jobID, strings.ToLower(utils.RandSeq(6)))
+		}
+		// 镜像名称使用仓库路径的最后两级，例如 owner-repo
+		segments := strings.Split(strings.ToLower(parseRemoteUses(r.action.name).name), "/")
+		if len(segments) > 2 {
+			segments = segments[len(segments)-2:]
+		}
+		name := strings.Trim(strings.ReplaceAll(strings.Join(segments, "-"), ".", "-"), "-")
+		tag := fmt.Sprintf("aline-action/%s:%s", name, version)
+		r.action.output.WriteCommandLine(fmt.Sprintf("%s build -t %s -f %s %s", rt.Name(), tag, image, r.action.actionRoot))
+		out := newLineWriter(r.action.output)
+		err := rt.Build(ctx, container.BuildSpec{ContextDir: r.action.actionRoot, Dockerfile: image, Tag: tag, Output: out})
+		out.Flush()
+		if err != nil {
+			return err
+		}
+		image = tag
+		if r.action.commit == "" {
+			defer func() {
+				if err := rt.RemoveImage(context.Background(), tag); err != nil {
+					logger.Warnf("remove image %s of action %s error: %s", tag, r.action.name, err)
+				}
+			}()
+		}
+	}
+
+	outputFile := r.outputFile()
+	defer os.Remove(outputFile)
+	env := append(append([]string{}, r.env...), "ALINE_OUTPUT="+outputFile)
+	keys := make([]string, 0, len(runs.Env))
+	for key := range runs.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+r.render(runs.Env[key]))
+	}
+	args := make([]string, 0, len(runs.Args))
+	for _, arg := range runs.Args {
+		args = append(args, r.render(arg))
+	}
+	spec := container.RunSpec{
+		Name:       fmt.Sprintf("action_%s", utils.RandSeq(10)),
+		Image:      image,
+		Cmd:        args,
+		Env:        env,
+		WorkingDir: r.workdir,
+		// 输出文件在临时目录中，和工作目录一样挂载到容器中的相同路径
+		Binds: []string{r.workdir + ":" + r.workdir, r.workdirTmp + ":" + r.workdirTmp},
+		// 和脚本一样使用 step 的资源限制
+		Resources: containerResources(r.action.resources),
+	}
+	if runs.Entrypoint != "" {
+		spec.Entrypoint = []string{runs.Entrypoint}
+	}
+	if _, err := runContainer(ctx, r.action.output, spec); err != nil {
+		return err
+	}
+	outputs, err := readOutputFile(outputFile)
+	if err != nil {
+		return err
+	}
+	for k, v := range outputs {
+		r.collected[k] = v
+	}
+	return nil
+}
+
+// outputs action.yml 中声明的输出，没有声明时使用所有 step 写到 ALINE_OUTPUT 中的输出
+func (r *remoteRun) outputs(declared map[string]model2.ActionOutput) map[string]string {
+	if len(declared) == 0 {
+		if len(r.collected) == 0 {
+			return nil
+		}
+		return r.collected
+	}
+	outputs := make(map[string]string, len(declared))
+	for name, output := range declared {
+		if output.Value != "" {
+			outputs[name] = r.render(output.Value)
+		} else {
+			outputs[name] = r.collected[name]
+		}
+	}
+	return outputs
+}
+
+// outputFile step 写入输出的文件，执行前创建，step 追加写入
+func (r *remoteRun) outputFile() string {
+	file := filepath.Join(r.workdirTmp, "output-"+utils.RandSeq(10))
+	_ = os.WriteFile(file, nil, 0666)
+	return file
+}
+
+// readOutputFile 解析输出文件，每行为 name=value，多行的值使用
+//
+//	name<<EOF
+//	...
+//	EOF
+func readOutputFile(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	outputs := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, delimiter, ok := strings.Cut(line, "<<"); ok && !strings.Contains(name, "=") {
+			var lines []string
+			closed := false
+			for scanner.Scan() {
+				if scanner.Text() == delimiter {
+					closed = true
+					break
+				}
+				lines = append(lines, scanner.Text())
+			}
+			if !closed {
+				return nil, fmt.Errorf("output %s: missing delimiter %s", name, delimiter)
+			}
+			outputs[name] = strings.Join(lines, "\n")
+			continue
+		}
+		if name, value, ok := strings.Cut(line, "="); ok && name != "" {
+			outputs[name] = value
+		}
+	}
+	return outputs, scanner.Err()
+}
@@ -5,32 +5,85 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/hamster-shared/aline-engine/container"
 	"github.com/hamster-shared/aline-engine/logger"
 	model2 "github.com/hamster-shared/aline-engine/model"
 	"github.com/hamster-shared/aline-engine/output"
+	"github.com/hamster-shared/aline-engine/sandbox"
 	utils2 "github.com/hamster-shared/aline-engine/utils"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 )
 
 // ShellAction 命令工作
 type ShellAction struct {
-	command  string
-	filename string
-	ctx      context.Context
-	output   *output.Output
+	command    string
+	shell      string
+	workingDir string
+	filename   string
+	// 执行脚本的命令和目录，在 Pre 中确定
+	commands  []string
+	dir       string
+	ctx       context.Context
+	output    *output.Output
+	resources *model2.Resources
+	volumes   []string
 }
 
 func NewShellAction(step model2.Step, ctx context.Context, output *output.Output) *ShellAction {
 
 	return &ShellAction{
-		command: step.Run,
-		ctx:     ctx,
-		output:  output,
+		command:    step.Run,
+		shell:      step.Shell,
+		workingDir: step.WorkingDirectory,
+		ctx:        ctx,
+		output:     output,
+		resources:  step.Resources,
+		volumes:    step.Volumes,
 	}
 }
 
+// shellTemplate 解释器执行脚本的方式，{0} 会被替换为脚本文件的路径
+type shellTemplate struct {
+	// 写在脚本开头的内容
+	header string
+	// 脚本文件的扩展名，部分解释器根据扩展名识别脚本
+	ext  string
+	args []string
+}
+
+var shellTemplates = map[string]shellTemplate{
+	"sh":     {header: "set -ex\n", ext: ".sh", args: []string{"sh", "{0}"}},
+	"bash":   {header: "set -exo pipefail\n", ext: ".sh", args: []string{"bash", "--noprofile", "--norc", "{0}"}},
+	"python": {ext: ".py", args: []string{"python3", "-u", "{0}"}},
+	"node":   {ext: ".js", args: []string{"node", "{0}"}},
+}
+
+// resolveShell 解析 step 的 shell，不在 shellTemplates 中时作为命令模板，必须包含 {0}
+func resolveShell(shell string) (shellTemplate, error) {
+	if template, ok := shellTemplates[shell]; ok {
+		return template, nil
+	}
+	args := strings.Fields(shell)
+	for _, arg := range args {
+		if strings.Contains(arg, "{0}") {
+			return shellTemplate{args: args}, nil
+		}
+	}
+	return shellTemplate{}, fmt.Errorf("invalid shell %q: must be one of bash, sh, python, node or a command containing {0}", shell)
+}
+
+// commands 按照模板生成执行脚本的命令
+func (t shellTemplate) commands(filename string) []string {
+	commands := make([]string, len(t.args))
+	for i, arg := range t.args {
+		commands[i] = strings.ReplaceAll(arg, "{0}", filename)
+	}
+	return commands
+}
+
 func (a *ShellAction) Pre() error {
 
 	stack := a.ctx.Value(STACK).(map[string]interface{})
@@ -46,15 +99,39 @@ func (a *ShellAction) Pre() error {
 		return errors.New("workdir error")
 	}
 
-	workdirTmp := workdir + "_tmp"
+	workdirTmp := getWorkdirTmp(stack, workdir)
 
 	_ = os.MkdirAll(workdirTmp, os.ModePerm)
 
-	a.filename = workdirTmp + "/" + utils2.RandSeq(10) + ".sh"
+	a.dir = workdir
+	if a.workingDir != "" {
+		dir := utils2.ReplaceWithParam(a.workingDir, params)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(workdir, dir)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("working-directory %s does not exist", a.workingDir)
+		}
+		a.dir = dir
+	}
 
 	command := utils2.ReplaceWithParam(a.command, params)
 
-	content := []byte("#!/bin/sh\nset -ex\n" + command)
+	var content []byte
+	if a.shell == "" {
+		// 没有指定 shell 时保持原来的行为，由脚本的 shebang 决定解释器
+		a.filename = workdirTmp + "/" + utils2.RandSeq(10) + ".sh"
+		a.commands = []string{"sh", "-c", a.filename}
+		content = []byte("#!/bin/sh\nset -ex\n" + command)
+	} else {
+		template, err := resolveShell(a.shell)
+		if err != nil {
+			return err
+		}
+		a.filename = workdirTmp + "/" + utils2.RandSeq(10) + template.ext
+		a.commands = template.commands(a.filename)
+		content = []byte(template.header + command)
+	}
 	err := os.WriteFile(a.filename, content, os.ModePerm)
 	if err != nil {
 		logger.Errorf("write tmp file error: %v", err)
@@ -85,19 +162,41 @@ func (a *ShellAction) Hook() (*model2.ActionResult, error) {
 	logger.Infof("shell stack: %v", stack)
 	env, _ := stack["env"].([]string)
 
-	commands := []string{"sh", "-c", a.filename}
-	val, ok := stack["withEnv"]
-	if ok {
-		precommand := val.([]string)
-		shellCommand := make([]string, len(commands))
-		copy(shellCommand, commands)
-		commands = append([]string{}, precommand...)
-		commands = append(commands, shellCommand...)
+	commands := a.commands
+
+	// runs-on 指定了容器时，在容器中执行，工作目录通过 bind 挂载到容器中的相同路径
+	if containerID, _ := stack["container"].(string); containerID != "" {
+		return a.execInContainer(containerID, commands, env)
+	}
+
+	// 在宿主机上执行时，按照 worker 的配置放进沙箱，只能访问工作目录和声明的 volume
+	sb, err := sandbox.New(sandbox.Spec{Workdir: workdir, TmpDir: getWorkdirTmp(stack, workdir), Volumes: a.volumes})
+	if err != nil {
+		logger.Errorf("create sandbox error: %v", err)
+		return nil, err
 	}
+	defer sb.Close()
+	commands = sb.Command(commands)
 
-	c := exec.CommandContext(a.ctx, commands[0], commands[1:]...) // mac linux
-	c.Dir = workdir
+	limiter, err := newResourceLimiter(a.ctx, a.resources, a.output)
+	if err != nil {
+		logger.Errorf("resource limits error: %v", err)
+		return nil, err
+	}
+	defer limiter.close()
+	commands = limiter.wrap(commands)
+
+	// 超出磁盘限制时单独结束这个 step，不影响 job 的上下文
+	ctx, cancel := context.WithCancel(a.ctx)
+	defer cancel()
+	limiter.watch(cancel)
+
+	c := newCommand(ctx, commands) // mac linux
+	c.Dir = a.dir
 	c.Env = append(env, os.Environ()...)
+	if err := sb.Apply(c.Cmd); err != nil {
+		return nil, err
+	}
 
 	logger.Debugf("execute shell command: %s", strings.Join(commands, " "))
 	a.output.WriteCommandLine(strings.Join(commands, " "))
@@ -113,40 +212,23 @@ func (a *ShellAction) Hook() (*model2.ActionResult, error) {
 		return nil, err
 	}
 
-	go func() {
-		for {
-			// 检测到 ctx.Done() 之后停止读取
-			<-a.ctx.Done()
-			if a.ctx.Err() != nil {
-				logger.Errorf("shell command error: %v", a.ctx.Err())
-				return
-			} else {
-				p := c.Process
-				if p == nil {
-					return
-				}
-				// Kill by negative PID to kill the process group, which includes
-				// the top-level process we spawned as well as any subprocesses
-				// it spawned.
-				//_ = syscall.Kill(-p.Pid, syscall.SIGKILL)
-				logger.Info("shell command killed")
-				return
-			}
-		}
-	}()
-
 	stdoutScanner := bufio.NewScanner(stdout)
 	stderrScanner := bufio.NewScanner(stderr)
+	// Wait 会关闭管道，要先把输出读完，否则最后几行可能丢失
+	var wg sync.WaitGroup
+	wg.Add(2)
 	go func() {
+		defer wg.Done()
 		for stdoutScanner.Scan() {
 			fmt.Println(stdoutScanner.Text())
 			a.output.WriteLine(stdoutScanner.Text())
 		}
 	}()
 	go func() {
+		defer wg.Done()
 		for stderrScanner.Scan() {
 			fmt.Println(stderrScanner.Text())
-			a.output.WriteLine(stderrScanner.Text())
+			a.output.WriteErrLine(stderrScanner.Text())
 		}
 	}()
 
@@ -156,16 +238,61 @@ func (a *ShellAction) Hook() (*model2.ActionResult, error) {
 		return nil, err
 	}
 
+	wg.Wait()
 	err = c.Wait()
 	if err != nil {
 		logger.Errorf("shell command wait error: %v", err)
-		return nil, err
+		return nil, limiter.check(err)
 	}
 
 	logger.Info("execute shell command success")
 	return nil, err
 }
 
+// execInContainer 在 runs-on 启动的容器中执行脚本，资源限制和用户已经在启动容器时设置
+// 没有配置 working-directory 时使用容器的工作目录
+func (a *ShellAction) execInContainer(containerID string, commands []string, env []string) (*model2.ActionResult, error) {
+	var dir string
+	if a.workingDir != "" {
+		dir = a.dir
+	}
+	limiter, err := newResourceLimiter(a.ctx, a.resources, a.output)
+	if err != nil {
+		logger.Errorf("resource limits error: %v", err)
+		return nil, err
+	}
+	defer limiter.close()
+	ctx, cancel := context.WithCancel(a.ctx)
+	defer cancel()
+	limiter.watch(cancel)
+
+	rt := container.Default()
+	command := strings.Join(append([]string{rt.Name(), "exec", containerID}, commands...), " ")
+	logger.Debugf("execute shell command: %s", command)
+	a.output.WriteCommandLine(command)
+
+	out := newLineWriter(a.output)
+	errOut := out.errWriter()
+	code, err := rt.Exec(ctx, containerID, container.ExecSpec{
+		Cmd:        commands,
+		Env:        env,
+		WorkingDir: dir,
+		Stdout:     out,
+		Stderr:     errOut,
+	})
+	out.Flush()
+	errOut.Flush()
+	if err == nil && code != 0 {
+		err = &container.ExitError{Code: code}
+	}
+	if err != nil {
+		logger.Errorf("shell command exec error: %v", err)
+		return nil, limiter.check(err)
+	}
+	logger.Info("execute shell command success")
+	return nil, nil
+}
+
 func (a *ShellAction) Post() error {
 	return os.Remove(a.filename)
 }
@@ -0,0 +1,68 @@
+package action
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	model2 "github.com/hamster-shared/aline-engine/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellActionShells(t *testing.T) {
+	ctx, stack, out := newContainerTestContext(t)
+	workdir := stack["workdir"].(string)
+	assert.NoError(t, os.MkdirAll(filepath.Join(workdir, "packages", "web"), os.ModePerm))
+	stack["parameter"] = map[string]string{"package": "web"}
+
+	for shell, run := range map[string]string{
+		"":                   "pwd",
+		"sh":                 "pwd",
+		"bash":               "[[ -n bash ]] && pwd | cat",
+		"python":             "import os\nprint(os.getcwd())",
+		"node":               "console.log(process.cwd())",
+		"bash --norc -e {0}": "pwd",
+	} {
+		action := NewShellAction(model2.Step{Run: run, Shell: shell, WorkingDirectory: "packages/${{ param.package }}"}, ctx, out)
+		assert.NoError(t, action.Pre(), shell)
+		_, err := action.Hook()
+		assert.NoError(t, err, shell)
+		assert.NoError(t, action.Post())
+		// set -x 的输出在 stderr 中，顺序不确定
+		assert.Contains(t, out.Tail(0, 2), filepath.Join(workdir, "packages", "web"), shell)
+	}
+
+	// bash 开启了 pipefail
+	action := NewShellAction(model2.Step{Run: "false | cat", Shell: "bash"}, ctx, out)
+	assert.NoError(t, action.Pre())
+	_, err := action.Hook()
+	assert.Error(t, err)
+	assert.NoError(t, action.Post())
+
+	assert.Error(t, NewShellAction(model2.Step{Run: "pwd", Shell: "ruby"}, ctx, out).Pre())
+	assert.Error(t, NewShellAction(model2.Step{Run: "pwd", WorkingDirectory: "missing"}, ctx, out).Pre())
+}
+
+func TestShellActionWorkingDirectoryInContainer(t *testing.T) {
+	rt := useFakeRuntime(t)
+	ctx, stack, out := newContainerTestContext(t)
+	workdir := stack["workdir"].(string)
+	assert.NoError(t, os.MkdirAll(filepath.Join(workdir, "app"), os.ModePerm))
+	stack["container"] = "container-1"
+
+	action := NewShellAction(model2.Step{Run: "pwd", Shell: "bash", WorkingDirectory: "app"}, ctx, out)
+	assert.NoError(t, action.Pre())
+	_, err := action.Hook()
+	assert.NoError(t, err)
+	assert.NoError(t, action.Post())
+	assert.Equal(t, filepath.Join(workdir, "app"), rt.execs[0].WorkingDir)
+	assert.Equal(t, "bash", rt.execs[0].Cmd[0])
+
+	// 没有配置 working-directory 时使用容器的工作目录
+	action = NewShellAction(model2.Step{Run: "pwd"}, ctx, out)
+	assert.NoError(t, action.Pre())
+	_, err = action.Hook()
+	assert.NoError(t, err)
+	assert.NoError(t, action.Post())
+	assert.Empty(t, rt.execs[1].WorkingDir)
+}
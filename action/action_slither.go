@@ -3,16 +3,14 @@ package action
 import (
 	"context"
 	"errors"
-	"fmt"
 	"github.com/hamster-shared/aline-engine/consts"
+	"github.com/hamster-shared/aline-engine/container"
 	"github.com/hamster-shared/aline-engine/logger"
 	"github.com/hamster-shared/aline-engine/model"
 	"github.com/hamster-shared/aline-engine/output"
 	"github.com/hamster-shared/aline-engine/utils"
 	"os"
-	"os/exec"
 	path2 "path"
-	"strings"
 )
 
 // SlitherAction slither合约检查
@@ -76,10 +74,11 @@ func (a *SlitherAction) Hook() (*model.ActionResult, error) {
 		if err != nil {
 			return nil, err
 		}
-		commandTemplate := consts.SlitherCheck
-		command := fmt.Sprintf(commandTemplate, basePath, redundantPath)
-		fields := strings.Fields(command)
-		out, err := a.ExecuteCommand(fields, workdir)
+		out, err := runContainer(a.ctx, a.output, container.RunSpec{
+			Image: consts.SlitherCheckImage,
+			Cmd:   []string{"slither", path2.Join("/tmp", redundantPath)},
+			Binds: []string{basePath + ":/tmp"},
+		})
 		if err != nil {
 			return nil, err
 		}
@@ -99,17 +98,3 @@ func (a *SlitherAction) Hook() (*model.ActionResult, error) {
 func (a *SlitherAction) Post() error {
 	return nil
 }
-
-func (a *SlitherAction) ExecuteCommand(commands []string, workdir string) (string, error) {
-	c := exec.CommandContext(a.ctx, commands[0], commands[1:]...) // mac linux
-	c.Dir = workdir
-	logger.Debugf("execute slither check command: %s", strings.Join(commands, " "))
-	a.output.WriteCommandLine(strings.Join(commands, " "))
-	out, err := c.CombinedOutput()
-	fmt.Println(string(out))
-	a.output.WriteCommandLine(string(out))
-	if err != nil {
-		a.output.WriteLine(err.Error())
-	}
-	return string(out), err
-}
@@ -8,9 +8,7 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	path2 "path"
-	"regexp"
 	"strconv"
 	"strings"
 
@@ -133,11 +131,6 @@ func (a *SolProfilerAction) Post() error {
 		return err
 	}
 	var checkResultDetailsList []model.ContractCheckResultDetails[[]model.ContractMethodsPropertiesReportDetails]
-	compile, err := regexp.Compile(`\[.{1,2}m`)
-	if err != nil {
-		logger.Errorf("regexp err %s", err)
-		return err
-	}
 	var total int
 	for _, info := range fileInfos {
 		path := path2.Join(a.path, info.Name())
@@ -155,7 +148,7 @@ func (a *SolProfilerAction) Post() error {
 				break
 			}
 			contentSting := string(content)
-			s := compile.ReplaceAllString(contentSting, "")
+			s := output.StripANSI(contentSting)
 			if strings.Contains(s, "┌─") || strings.Contains(s, "├─") || strings.Contains(s, "└─") || strings.Contains(s, "Contract/Library/Interface") {
 				continue
 			}
@@ -208,7 +201,7 @@ func (a *SolProfilerAction) Post() error {
 }
 
 func (a *SolProfilerAction) ExecuteCommand(commands []string, workdir string) (string, error) {
-	c := exec.CommandContext(a.ctx, commands[0], commands[1:]...) // mac linux
+	c := newCommand(a.ctx, commands) // mac linux
 	c.Dir = workdir
 	logger.Debugf("execute sol-profiler *.sol command: %s", strings.Join(commands, " "))
 	a.output.WriteCommandLine(strings.Join(commands, " "))
@@ -0,0 +1,26 @@
+package action
+
+import (
+	"context"
+
+	"github.com/hamster-shared/aline-engine/utils"
+)
+
+// newCommand 创建在独立进程组中运行的命令，取消时会结束所有子进程
+func newCommand(ctx context.Context, commands []string) *utils.Cmd {
+	c := utils.NewCommand(ctx, commands[0], commands[1:]...)
+	// 记录正在运行的进程组，worker 异常退出重启后据此清理
+	if stack, ok := ctx.Value(STACK).(map[string]interface{}); ok {
+		if recorder, ok := stack["processRecorder"].(ProcessRecorder); ok {
+			c.OnStart = recorder.ProcessStarted
+			c.OnExit = recorder.ProcessExited
+		}
+	}
+	return c
+}
+
+// ProcessRecorder 记录 step 启动的进程组
+type ProcessRecorder interface {
+	ProcessStarted(pgid int)
+	ProcessExited(pgid int)
+}
@@ -0,0 +1,162 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hamster-shared/aline-engine/container"
+	"github.com/hamster-shared/aline-engine/logger"
+	model2 "github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+	"github.com/hamster-shared/aline-engine/workspace"
+)
+
+// JobContainerLabel 记录容器属于哪个任务，值为 <jobName>/<jobID>，取消任务时据此停止容器
+const JobContainerLabel = "aline.job"
+
+// containerLabels 任务启动的容器都带上这些 label，janitor 和 StopJobContainers 据此清理
+func containerLabels(ctx context.Context) map[string]string {
+	labels := map[string]string{workspace.ContainerLabel: ""}
+	stack, ok := ctx.Value(STACK).(map[string]interface{})
+	if !ok {
+		return labels
+	}
+	name, _ := stack["name"].(string)
+	id, _ := stack["id"].(string)
+	if name != "" {
+		labels[JobContainerLabel] = name + "/" + id
+	}
+	return labels
+}
+
+// containerResources step 的资源限制转换为容器的资源限制，调用前需要先校验
+func containerResources(resources *model2.Resources) container.Resources {
+	if resources.IsEmpty() {
+		return container.Resources{}
+	}
+	cpu, _ := resources.CPUCores()
+	memory, _ := resources.MemoryBytes()
+	return container.Resources{CPUs: cpu, Memory: memory, Pids: resources.Pids}
+}
+
+// describeRun 输出到日志中的容器启动命令
+func describeRun(rt container.Runtime, spec container.RunSpec) string {
+	commands := []string{rt.Name(), "run"}
+	for _, bind := range spec.Binds {
+		commands = append(commands, "-v", bind)
+	}
+	if spec.WorkingDir != "" {
+		commands = append(commands, "-w", spec.WorkingDir)
+	}
+	if spec.User != "" {
+		commands = append(commands, "--user", spec.User)
+	}
+	if spec.Network != "" {
+		commands = append(commands, "--network", spec.Network)
+	}
+	// 环境变量和认证信息中可能有密钥，日志中不输出
+	commands = append(commands, spec.Image)
+	return strings.Join(append(commands, spec.Cmd...), " ")
+}
+
+// runContainer 在容器中执行一次性的命令，返回容器的全部输出，退出码不为 0 时返回 container.ExitError
+func runContainer(ctx context.Context, output *output.Output, spec container.RunSpec) (string, error) {
+	rt := container.Default()
+	spec.Labels = containerLabels(ctx)
+	command := describeRun(rt, spec)
+	logger.Debugf("execute container command: %s", command)
+	output.WriteCommandLine(command)
+
+	var buf bytes.Buffer
+	code, err := container.RunToCompletion(ctx, rt, spec, &buf)
+	out := buf.String()
+	fmt.Println(out)
+	output.WriteCommandLine(out)
+	if err == nil && code != 0 {
+		err = &container.ExitError{Code: code}
+	}
+	if err != nil {
+		output.WriteLine(err.Error())
+	}
+	return out, err
+}
+
+// StopJobContainers 停止并删除任务启动的所有容器，没有可用的容器运行时时跳过
+func StopJobContainers(jobName, jobID string) {
+	rt := container.Default()
+	ctx := context.Background()
+	containers, err := rt.List(ctx, JobContainerLabel+"="+jobName+"/"+jobID)
+	if errors.Is(err, container.ErrUnavailable) {
+		return
+	}
+	if err != nil {
+		logger.Warnf("list containers of job %s/%s failed: %s", jobName, jobID, err)
+		return
+	}
+	ids := make([]string, 0, len(containers))
+	for _, c := range containers {
+		if err := rt.Remove(ctx, c.ID); err != nil {
+			logger.Warnf("remove container %s of job %s/%s failed: %s", c.ID, jobName, jobID, err)
+			continue
+		}
+		ids = append(ids, c.ID)
+	}
+	if len(ids) > 0 {
+		logger.Infof("containers of job %s/%s removed: %s", jobName, jobID, strings.Join(ids, " "))
+	}
+}
+
+// lineWriter 把容器的输出按行写到 step 的输出中
+type lineWriter struct {
+	output *output.Output
+	mu     *sync.Mutex
+	buf    []byte
+	stderr bool
+}
+
+func newLineWriter(output *output.Output) *lineWriter {
+	return &lineWriter{output: output, mu: &sync.Mutex{}}
+}
+
+// errWriter 写入标准错误的 lineWriter，和 w 共用一把锁，两者的输出不会同时写入
+func (w *lineWriter) errWriter() *lineWriter {
+	return &lineWriter{output: w.output, mu: w.mu, stderr: true}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.writeLine(string(bytes.TrimSuffix(w.buf[:i], []byte("\r"))))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush 输出最后一行没有换行符的内容
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.writeLine(string(w.buf))
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) writeLine(line string) {
+	fmt.Println(line)
+	if w.stderr {
+		w.output.WriteErrLine(line)
+		return
+	}
+	w.output.WriteLine(line)
+}
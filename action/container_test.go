@@ -0,0 +1,184 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hamster-shared/aline-engine/container"
+	"github.com/hamster-shared/aline-engine/logger"
+	model2 "github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+	"github.com/hamster-shared/aline-engine/secret"
+	"github.com/hamster-shared/aline-engine/utils"
+	"github.com/hamster-shared/aline-engine/workspace"
+	"github.com/stretchr/testify/assert"
+)
+
+func newContainerTestContext(t *testing.T) (context.Context, map[string]interface{}, *output.Output) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	workdir := t.TempDir()
+	stack := map[string]interface{}{
+		"name":       "container",
+		"id":         "1",
+		"workdir":    workdir,
+		"workdirTmp": workdir + "_tmp",
+		"env":        []string{"PIPELINE_NAME=container"},
+		"parameter":  map[string]string{},
+	}
+	return context.WithValue(context.Background(), STACK, stack), stack, output.New("container", 1)
+}
+
+func TestDockerEnvWithShell(t *testing.T) {
+	rt := useFakeRuntime(t)
+	ctx, stack, out := newContainerTestContext(t)
+	workdir := stack["workdir"].(string)
+
+	env := NewDockerEnv(model2.Step{RunsOn: "node:18", Volumes: []string{"/cache:/cache"}, Resources: &model2.Resources{CPU: "2"}}, ctx, out)
+	assert.NoError(t, env.Pre())
+	assert.Len(t, rt.runs, 1)
+	spec := rt.runs[0]
+	assert.Equal(t, "node:18", spec.Image)
+	assert.Equal(t, workdir, spec.WorkingDir)
+	assert.Contains(t, spec.Binds, workdir+":"+workdir)
+	assert.Contains(t, spec.Binds, "/cache:/cache")
+	assert.Equal(t, "container/1", spec.Labels[JobContainerLabel])
+	assert.Contains(t, spec.Labels, workspace.ContainerLabel)
+	assert.Equal(t, 2.0, spec.Resources.CPUs)
+
+	_, err := env.Hook()
+	assert.NoError(t, err)
+	assert.Equal(t, "container-1", stack["container"])
+
+	// 之后的 shell step 在容器中执行
+	rt.execOut = "hello\nworld"
+	shell := NewShellAction(model2.Step{Run: "echo hello"}, ctx, out)
+	assert.NoError(t, shell.Pre())
+	_, err = shell.Hook()
+	assert.NoError(t, err)
+	assert.Len(t, rt.execs, 1)
+	assert.Equal(t, "sh", rt.execs[0].Cmd[0])
+	assert.Equal(t, []string{"PIPELINE_NAME=container"}, rt.execs[0].Env)
+	assert.Equal(t, []string{"hello", "world"}, out.Tail(0, 2))
+
+	rt.exitCode = 3
+	_, err = shell.Hook()
+	var exitErr *container.ExitError
+	assert.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, 3, exitErr.Code)
+	assert.NoError(t, shell.Post())
+
+	// 任务被取消时清理任务启动的容器
+	StopJobContainers("other", "1")
+	assert.Empty(t, rt.removed)
+
+	assert.NoError(t, env.Post())
+	assert.Equal(t, []string{"container-1"}, rt.stopped)
+	assert.Equal(t, []string{"container-1"}, rt.removed)
+	assert.NotContains(t, stack, "container")
+}
+
+func TestDockerEnvContainerOptions(t *testing.T) {
+	rt := useFakeRuntime(t)
+	ctx, stack, out := newContainerTestContext(t)
+	workdir := stack["workdir"].(string)
+	stack["parameter"] = map[string]string{"mode": "test"}
+	secrets := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(secrets, "ghcr"), []byte("user:pass\n"), 0600))
+	secret.Configure(secret.NewDirStore(secrets))
+	t.Cleanup(func() { secret.Configure(nil) })
+
+	entrypoint := ""
+	env := NewDockerEnv(model2.Step{RunsOn: "ghcr.io/org/node:18", Container: &model2.ContainerOptions{
+		Pull:        model2.PULL_ALWAYS,
+		Credentials: "ghcr",
+		User:        model2.CONTAINER_USER_WORKER,
+		Env:         map[string]string{"MODE": "${{ param.mode }}", "CI": "true"},
+		Network:     "host",
+		Entrypoint:  &entrypoint,
+		Workdir:     "app",
+	}}, ctx, out)
+	assert.NoError(t, env.Pre())
+	spec := rt.runs[0]
+	uid, gid, _ := utils.GetUIDAndGID()
+	assert.Equal(t, container.PullAlways, spec.Pull)
+	assert.Equal(t, &container.RegistryAuth{Username: "user", Password: "pass"}, spec.Auth)
+	assert.Equal(t, uid+":"+gid, spec.User)
+	assert.Equal(t, []string{"CI=true", "MODE=test"}, spec.Env)
+	assert.Equal(t, "host", spec.Network)
+	assert.Equal(t, []string{""}, spec.Entrypoint)
+	assert.Equal(t, []string{"cat"}, spec.Cmd)
+	assert.Equal(t, filepath.Join(workdir, "app"), spec.WorkingDir)
+	// 日志中不能出现密码
+	for _, line := range out.Tail(0, 10) {
+		assert.NotContains(t, line, "pass")
+	}
+
+	// 覆盖 entrypoint 后容器仍然需要一直运行
+	entrypoint = "/bin/bash"
+	env = NewDockerEnv(model2.Step{RunsOn: "node:18", Container: &model2.ContainerOptions{Entrypoint: &entrypoint}}, ctx, out)
+	assert.NoError(t, env.Pre())
+	spec = rt.runs[1]
+	assert.Equal(t, []string{"/bin/bash"}, spec.Entrypoint)
+	assert.Equal(t, []string{"-c", "exec cat"}, spec.Cmd)
+	entrypoint = "/docker-entrypoint.sh"
+	env = NewDockerEnv(model2.Step{RunsOn: "node:18", Container: &model2.ContainerOptions{Entrypoint: &entrypoint}}, ctx, out)
+	assert.NoError(t, env.Pre())
+	assert.Equal(t, []string{"cat"}, rt.runs[2].Cmd)
+
+	env = NewDockerEnv(model2.Step{RunsOn: "node:18", Container: &model2.ContainerOptions{Credentials: "missing"}}, ctx, out)
+	assert.True(t, errors.Is(env.Pre(), secret.ErrNotFound))
+	env = NewDockerEnv(model2.Step{RunsOn: "node:18", Container: &model2.ContainerOptions{Pull: "sometimes"}}, ctx, out)
+	assert.Error(t, env.Pre())
+	assert.Len(t, rt.runs, 3)
+}
+
+func TestStopJobContainers(t *testing.T) {
+	rt := useFakeRuntime(t)
+	ctx, _, out := newContainerTestContext(t)
+	assert.NoError(t, NewDockerEnv(model2.Step{RunsOn: "alpine"}, ctx, out).Pre())
+	StopJobContainers("container", "1")
+	assert.Equal(t, []string{"container-1"}, rt.removed)
+}
+
+func TestImageBuildAndPush(t *testing.T) {
+	rt := useFakeRuntime(t)
+	ctx, stack, out := newContainerTestContext(t)
+	stack["parameter"] = map[string]string{"tag": "v1"}
+	step := model2.Step{With: map[string]string{"image_name": "registry.example.com/app:${{ param.tag }}"}}
+
+	build := NewImageBuildAction(step, ctx, out)
+	assert.NoError(t, build.Pre())
+	_, err := build.Hook()
+	assert.NoError(t, err)
+	assert.Equal(t, "registry.example.com/app:v1", rt.builds[0].Tag)
+	assert.Equal(t, stack["workdir"], rt.builds[0].ContextDir)
+
+	push := NewImagePushAction(step, ctx, out)
+	assert.NoError(t, push.Pre())
+	result, err := push.Hook()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"registry.example.com/app:v1"}, rt.pushed)
+	assert.Equal(t, []string{"registry.example.com/app:v1"}, rt.images)
+	assert.Equal(t, "registry.example.com/app:v1", result.BuildData[0].ImageName)
+}
+
+func TestRunContainer(t *testing.T) {
+	rt := useFakeRuntime(t)
+	ctx, _, out := newContainerTestContext(t)
+	rt.logs = "analysis done"
+	got, err := runContainer(ctx, out, container.RunSpec{Image: "mythril/myth", Cmd: []string{"analyze"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "analysis done", got)
+	assert.Equal(t, "container/1", rt.runs[0].Labels[JobContainerLabel])
+	// 一次性的容器执行结束后删除
+	assert.Equal(t, []string{"container-1"}, rt.removed)
+
+	rt.exitCode = 1
+	got, err = runContainer(ctx, out, container.RunSpec{Image: "mythril/myth"})
+	assert.Equal(t, "analysis done", got)
+	assert.Error(t, err)
+}
@@ -4,31 +4,47 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/hamster-shared/aline-engine/container"
 	"github.com/hamster-shared/aline-engine/logger"
 	model2 "github.com/hamster-shared/aline-engine/model"
 	"github.com/hamster-shared/aline-engine/output"
+	"github.com/hamster-shared/aline-engine/secret"
+	"github.com/hamster-shared/aline-engine/utils"
 	"os"
-	"os/exec"
-	"strings"
+	"path"
+	"path/filepath"
+	"sort"
 	"time"
 )
 
 const STACK = "stack"
 
+// getWorkdirTmp 本次执行存放临时脚本的目录，旧的上下文中没有时使用 <workdir>_tmp
+func getWorkdirTmp(stack map[string]interface{}, workdir string) string {
+	if workdirTmp, ok := stack["workdirTmp"].(string); ok && workdirTmp != "" {
+		return workdirTmp
+	}
+	return workdir + "_tmp"
+}
+
 type DockerEnv struct {
 	ctx         context.Context
 	Image       string
 	containerID string
 	output      *output.Output
 	volumes     []string
+	resources   *model2.Resources
+	options     *model2.ContainerOptions
 }
 
 func NewDockerEnv(step model2.Step, ctx context.Context, output *output.Output) *DockerEnv {
 	return &DockerEnv{
-		ctx:     ctx,
-		Image:   step.RunsOn,
-		output:  output,
-		volumes: step.Volumes,
+		ctx:       ctx,
+		Image:     step.RunsOn,
+		output:    output,
+		volumes:   step.Volumes,
+		resources: step.Resources,
+		options:   step.Container,
 	}
 }
 
@@ -49,91 +65,132 @@ func (e *DockerEnv) Pre() error {
 		return errors.New("workdir error")
 	}
 
-	workdirTmp := workdir + "_tmp"
+	workdirTmp := getWorkdirTmp(stack, workdir)
 
 	_ = os.MkdirAll(workdirTmp, os.ModePerm)
 
-	//user := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
-	// "-u", user,
-
-	commands := []string{"docker", "run", "--name", fmt.Sprintf("%s_%s_%d", jobName, jobId, time.Now().Minute()), "-t", "-d", "-v", workdir + ":" + workdir, "-v", workdirTmp + ":" + workdirTmp}
-
-	for _, v := range e.volumes {
-		commands = append(commands, "-v", v)
+	if err := e.resources.Validate(); err != nil {
+		return err
+	}
+	if err := e.options.Validate(); err != nil {
+		return err
+	}
+	rt := container.Default()
+	spec := container.RunSpec{
+		Name:       fmt.Sprintf("%s_%s_%d", jobName, jobId, time.Now().Minute()),
+		Image:      e.Image,
+		Cmd:        []string{"cat"},
+		WorkingDir: workdir,
+		Binds:      append([]string{workdir + ":" + workdir, workdirTmp + ":" + workdirTmp}, e.volumes...),
+		Labels:     containerLabels(e.ctx),
+		Tty:        true,
+		Resources:  containerResources(e.resources),
+	}
+	params, _ := stack["parameter"].(map[string]string)
+	if err := applyContainerOptions(&spec, e.options, workdir, params); err != nil {
+		e.output.WriteLine(err.Error())
+		return err
 	}
-	commands = append(commands, "-w", workdir, e.Image, "cat")
 
-	logger.Debugf("execute docker command: %s", strings.Join(commands, " "))
-	e.output.WriteCommandLine(strings.Join(commands, " "))
-	c := exec.Command(commands[0], commands[1:]...)
-	c.Env = os.Environ()
-	output, err := c.Output()
+	command := describeRun(rt, spec)
+	logger.Debugf("execute container command: %s", command)
+	e.output.WriteCommandLine(command)
+	containerID, err := rt.Run(e.ctx, spec)
 	if err != nil {
-		logger.Error(output)
-		logger.Errorf("execute docker command error: %s", err.Error())
+		logger.Errorf("run container error: %s", err.Error())
+		e.output.WriteLine(err.Error())
 		return err
 	}
-	containerID := string(output)
-	logger.Debugf("docker command output: %s", containerID)
-	fmt.Printf("docker command output: %s \n", containerID)
+	logger.Debugf("container started: %s", containerID)
 	e.output.WriteLine(containerID)
-
-	filelds := strings.Fields(containerID)
-
-	fmt.Println(filelds)
-	e.containerID = filelds[len(filelds)-1]
-	return err
+	e.containerID = containerID
+	return nil
 }
 
-func (e *DockerEnv) Hook() (*model2.ActionResult, error) {
-
-	c := exec.Command("docker", "top", e.containerID, "-eo", "pid,comm")
-	c.Env = os.Environ()
-	logger.Debugf("execute docker command: %s", strings.Join(c.Args, " "))
-	e.output.WriteCommandLine(strings.Join(c.Args, " "))
-
-	output, err := c.CombinedOutput()
-	logger.Debugf("docker command output: %s", string(output))
-	e.output.WriteLine(string(output))
+// applyContainerOptions 把 step 上的容器选项设置到 spec 中，认证信息从 worker 的密钥存储中读取
+func applyContainerOptions(spec *container.RunSpec, options *model2.ContainerOptions, workdir string, params map[string]string) error {
+	if options == nil {
+		return nil
+	}
+	spec.Pull = string(options.Pull)
+	spec.Network = options.Network
+	if options.Credentials != "" {
+		value, err := secret.Get(options.Credentials)
+		if err != nil {
+			return fmt.Errorf("read registry credentials: %w", err)
+		}
+		auth, err := container.ParseRegistryAuth(value)
+		if err != nil {
+			return fmt.Errorf("secret %s: %w", options.Credentials, err)
+		}
+		spec.Auth = auth
+	}
+	if options.User == model2.CONTAINER_USER_WORKER {
+		uid, gid, err := utils.GetUIDAndGID()
+		if err != nil {
+			return err
+		}
+		spec.User = uid + ":" + gid
+	} else {
+		spec.User = options.User
+	}
+	if options.Entrypoint != nil {
+		spec.Entrypoint = []string{*options.Entrypoint}
+		spec.Cmd = keepAliveCmd(*options.Entrypoint)
+	}
+	if options.Workdir != "" {
+		spec.WorkingDir = options.Workdir
+		if !filepath.IsAbs(options.Workdir) {
+			spec.WorkingDir = filepath.Join(workdir, options.Workdir)
+		}
+	}
+	keys := make([]string, 0, len(options.Env))
+	for key := range options.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		spec.Env = append(spec.Env, key+"="+utils.ReplaceWithParam(options.Env[key], params))
+	}
+	return nil
+}
 
-	if err != nil {
-		logger.Errorf("execute docker command error: %s", err.Error())
-		return nil, err
+// keepAliveCmd 覆盖 entrypoint 时容器的参数，容器需要一直运行，之后的 step 通过 exec 执行
+// shell 作为 entrypoint 时通过 -c 执行 cat，其他的 entrypoint 需要执行传给它的参数（例如 exec "$@"）
+func keepAliveCmd(entrypoint string) []string {
+	switch path.Base(entrypoint) {
+	case "sh", "bash", "ash", "dash", "zsh":
+		return []string{"-c", "exec cat"}
 	}
+	return []string{"cat"}
+}
 
+func (e *DockerEnv) Hook() (*model2.ActionResult, error) {
+	// 之后的 step 在这个容器中执行
 	stack := e.ctx.Value(STACK).(map[string]interface{})
-	stack["withEnv"] = []string{"docker", "exec", e.containerID}
+	stack["container"] = e.containerID
 	return nil, nil
 }
 
 func (e *DockerEnv) Post() error {
-
-	c := exec.Command("docker", "stop", "--time=1", e.containerID)
-	logger.Debugf("execute docker command: %s", strings.Join(c.Args, " "))
-	e.output.WriteCommandLine(strings.Join(c.Args, " "))
-
-	output, err := c.CombinedOutput()
-	e.output.WriteLine(string(output))
-
-	if err != nil {
-		logger.Errorf("execute docker command error: %s", err.Error())
+	stack := e.ctx.Value(STACK).(map[string]interface{})
+	delete(stack, "container")
+
+	rt := container.Default()
+	// 任务被取消时 ctx 已经结束，仍然需要清理容器
+	ctx := context.Background()
+	e.output.WriteCommandLine(fmt.Sprintf("%s stop %s", rt.Name(), e.containerID))
+	if err := rt.Stop(ctx, e.containerID, time.Second); err != nil {
+		logger.Errorf("stop container error: %s", err.Error())
+		e.output.WriteLine(err.Error())
 		return err
 	}
 
-	c = exec.Command("docker", "rm", "-f", e.containerID)
-	logger.Debugf("execute docker command: %s", strings.Join(c.Args, " "))
-	e.output.WriteCommandLine(strings.Join(c.Args, " "))
-
-	output, err = c.CombinedOutput()
-	e.output.WriteLine(string(output))
-
-	if err != nil {
-		logger.Errorf("execute docker command error: %s", err.Error())
+	e.output.WriteCommandLine(fmt.Sprintf("%s rm -f %s", rt.Name(), e.containerID))
+	if err := rt.Remove(ctx, e.containerID); err != nil {
+		logger.Errorf("remove container error: %s", err.Error())
+		e.output.WriteLine(err.Error())
 		return err
 	}
-
-	stack := e.ctx.Value(STACK).(map[string]interface{})
-	stack["withEnv"] = []string{}
-
 	return nil
 }
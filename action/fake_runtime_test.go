@@ -0,0 +1,131 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/container"
+)
+
+// fakeRuntime 记录 action 对容器运行时的调用，不会真正启动容器
+type fakeRuntime struct {
+	mu       sync.Mutex
+	runs     []container.RunSpec
+	execs    []container.ExecSpec
+	builds   []container.BuildSpec
+	pushed   []string
+	removed  []string
+	stopped  []string
+	images   []string
+	running  map[string]bool
+	execOut  string
+	exitCode int
+	logs     string
+	// 启动容器时调用，模拟容器中的命令
+	onRun func(spec container.RunSpec)
+}
+
+// useFakeRuntime 让 action 在测试中使用 fakeRuntime
+func useFakeRuntime(t *testing.T) *fakeRuntime {
+	rt := &fakeRuntime{running: make(map[string]bool)}
+	container.SetDefault(rt)
+	t.Cleanup(func() { container.SetDefault(nil) })
+	return rt
+}
+
+func (f *fakeRuntime) Name() string { return "fake" }
+
+func (f *fakeRuntime) Pull(ctx context.Context, image string, out io.Writer) error {
+	return nil
+}
+
+func (f *fakeRuntime) Run(ctx context.Context, spec container.RunSpec) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.runs = append(f.runs, spec)
+	if f.onRun != nil {
+		f.onRun(spec)
+	}
+	id := fmt.Sprintf("container-%d", len(f.runs))
+	f.running[id] = true
+	return id, nil
+}
+
+func (f *fakeRuntime) Exec(ctx context.Context, id string, spec container.ExecSpec) (int, error) {
+	f.mu.Lock()
+	f.execs = append(f.execs, spec)
+	f.mu.Unlock()
+	if spec.Stdout != nil {
+		_, _ = io.WriteString(spec.Stdout, f.execOut)
+	}
+	return f.exitCode, nil
+}
+
+func (f *fakeRuntime) Wait(ctx context.Context, id string) (int, error) {
+	return f.exitCode, nil
+}
+
+func (f *fakeRuntime) Logs(ctx context.Context, id string, out io.Writer) error {
+	_, err := io.WriteString(out, f.logs)
+	return err
+}
+
+func (f *fakeRuntime) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopped = append(f.stopped, id)
+	f.running[id] = false
+	return nil
+}
+
+func (f *fakeRuntime) Remove(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removed = append(f.removed, id)
+	delete(f.running, id)
+	return nil
+}
+
+func (f *fakeRuntime) List(ctx context.Context, label string) ([]container.Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var list []container.Container
+	for i, spec := range f.runs {
+		id := fmt.Sprintf("container-%d", i+1)
+		if _, ok := f.running[id]; !ok {
+			continue
+		}
+		for key, value := range spec.Labels {
+			if label == key || label == key+"="+value {
+				list = append(list, container.Container{ID: id, Running: f.running[id], Labels: spec.Labels})
+				break
+			}
+		}
+	}
+	return list, nil
+}
+
+func (f *fakeRuntime) Build(ctx context.Context, spec container.BuildSpec) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.builds = append(f.builds, spec)
+	return nil
+}
+
+func (f *fakeRuntime) Push(ctx context.Context, image string, out io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pushed = append(f.pushed, image)
+	return nil
+}
+
+func (f *fakeRuntime) RemoveImage(ctx context.Context, image string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.images = append(f.images, image)
+	return nil
+}
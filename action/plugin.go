@@ -0,0 +1,323 @@
+package action
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hamster-shared/aline-engine/logger"
+	model2 "github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+	"github.com/hamster-shared/aline-engine/utils"
+)
+
+// 插件 action 是插件目录中的可执行文件，文件名（去掉扩展名）即 step 的 uses
+// 每个 step 启动一个插件进程，engine 和插件通过标准输入输出交换 json，每行一条消息：
+//
+//	engine -> 插件  {"type":"pre","version":1,"inputs":{...},"workspace":"...","env":[...],"params":{...},"job":{...}}
+//	engine -> 插件  {"type":"hook"}
+//	engine -> 插件  {"type":"post"}
+//	插件 -> engine  {"type":"log","line":"..."}
+//	插件 -> engine  {"type":"done","phase":"hook","error":"","result":{...},"outputs":{...}}
+//
+// 每个阶段插件都要回复一条 done，error 不为空时该阶段失败；post 之后 engine 关闭标准输入，插件应当退出
+// 标准错误的内容写到日志中；step 被取消时插件的进程组先收到 SIGTERM，超时后收到 SIGKILL
+const PluginProtocolVersion = 1
+
+// PluginMessage 插件协议中的消息
+type PluginMessage struct {
+	Type    string `json:"type"`
+	Version int    `json:"version,omitempty"`
+
+	// pre 消息中 step 的输入和执行环境
+	Inputs    map[string]string `json:"inputs,omitempty"`
+	Workspace string            `json:"workspace,omitempty"`
+	Env       []string          `json:"env,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+	Job       *PluginJob        `json:"job,omitempty"`
+
+	// 插件的日志
+	Line string `json:"line,omitempty"`
+
+	// 插件完成一个阶段
+	Phase   string               `json:"phase,omitempty"`
+	Error   string               `json:"error,omitempty"`
+	Result  *model2.ActionResult `json:"result,omitempty"`
+	Outputs map[string]string    `json:"outputs,omitempty"`
+}
+
+// PluginJob 插件所在的任务
+type PluginJob struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+	Step string `json:"step"`
+}
+
+var (
+	plugins   = make(map[string]string)
+	pluginsMu sync.RWMutex
+)
+
+// LoadPlugins 注册插件目录中的所有可执行文件，返回插件的名称，dir 为空时使用 ~/.aline/plugins
+// 和内置 action 同名的插件不会注册
+func LoadPlugins(dir string) ([]string, error) {
+	if dir == "" {
+		dir = filepath.Join(utils.DefaultConfigDir(), "plugins")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	loaded := make(map[string]string)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.IsDir() || strings.HasPrefix(entry.Name(), ".") || !isExecutable(info) {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if _, ok := builtinActions[name]; ok {
+			logger.Warnf("plugin %s is ignored: conflicts with a builtin action", entry.Name())
+			continue
+		}
+		loaded[name] = filepath.Join(dir, entry.Name())
+	}
+
+	pluginsMu.Lock()
+	plugins = loaded
+	pluginsMu.Unlock()
+	return Plugins(), nil
+}
+
+// Plugins 已经注册的插件名称
+func Plugins() []string {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupPlugin(uses string) (string, bool) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	path, ok := plugins[uses]
+	return path, ok
+}
+
+func isExecutable(info os.FileInfo) bool {
+	if runtime.GOOS == "windows" {
+		ext := strings.ToLower(filepath.Ext(info.Name()))
+		return ext == ".exe" || ext == ".bat" || ext == ".cmd"
+	}
+	return info.Mode()&0111 != 0
+}
+
+// PluginAction 执行插件
+type PluginAction struct {
+	name   string
+	path   string
+	step   model2.Step
+	ctx    context.Context
+	output *output.Output
+
+	cmd    *utils.Cmd
+	stdin  io.WriteCloser
+	stderr *lineWriter
+	// 插件回复的 done 消息
+	done chan PluginMessage
+	// 正在等待 done 的阶段，为空时没有在等待，插件多发的 done 会被丢弃
+	waitMu  sync.Mutex
+	waiting string
+	// 插件的标准输出读完后关闭
+	stdoutClosed chan struct{}
+	outputs      map[string]string
+}
+
+func NewPluginAction(step model2.Step, ctx context.Context, output *output.Output) *PluginAction {
+	path, _ := lookupPlugin(step.Uses)
+	return &PluginAction{
+		name:   step.Uses,
+		path:   path,
+		step:   step,
+		ctx:    ctx,
+		output: output,
+	}
+}
+
+func (a *PluginAction) Pre() error {
+	stack := a.ctx.Value(STACK).(map[string]interface{})
+	workdir, ok := stack["workdir"].(string)
+	if !ok {
+		return errors.New("workdir error")
+	}
+	env, _ := stack["env"].([]string)
+	params, _ := stack["parameter"].(map[string]string)
+	name, _ := stack["name"].(string)
+	id, _ := stack["id"].(string)
+
+	inputs := make(map[string]string, len(a.step.With))
+	for k, v := range a.step.With {
+		inputs[k] = utils.ReplaceWithParam(v, params)
+	}
+
+	a.cmd = newCommand(a.ctx, []string{a.path})
+	a.cmd.Dir = workdir
+	a.cmd.Env = append(append([]string{}, env...), os.Environ()...)
+	stdin, err := a.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := a.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	a.stderr = newLineWriter(a.output).errWriter()
+	a.cmd.Stderr = a.stderr
+	a.stdin = stdin
+	a.output.WriteCommandLine(a.path)
+	if err := a.cmd.Start(); err != nil {
+		return fmt.Errorf("start plugin %s: %w", a.name, err)
+	}
+	a.done = make(chan PluginMessage, 1)
+	a.stdoutClosed = make(chan struct{})
+	go a.read(stdout)
+
+	err = a.call(PluginMessage{
+		Type:      "pre",
+		Version:   PluginProtocolVersion,
+		Inputs:    inputs,
+		Workspace: workdir,
+		Env:       env,
+		Params:    params,
+		Job:       &PluginJob{Name: name, ID: id, Step: a.step.Name},
+	}, "pre", nil)
+	if err != nil {
+		// pre 失败时不会执行 post，这里结束插件进程
+		a.stop()
+	}
+	return err
+}
+
+func (a *PluginAction) Hook() (*model2.ActionResult, error) {
+	var result *model2.ActionResult
+	err := a.call(PluginMessage{Type: "hook"}, "hook", func(done PluginMessage) {
+		result = done.Result
+		a.outputs = done.Outputs
+	})
+	return result, err
+}
+
+func (a *PluginAction) Post() error {
+	if a.cmd == nil {
+		return nil
+	}
+	var err error
+	select {
+	case <-a.stdoutClosed:
+		// 插件已经退出
+	default:
+		err = a.call(PluginMessage{Type: "post"}, "post", nil)
+	}
+	if waitErr := a.stop(); err == nil && waitErr != nil {
+		err = fmt.Errorf("plugin %s: %w", a.name, waitErr)
+	}
+	return err
+}
+
+// stop 关闭插件的标准输入并等待插件退出
+func (a *PluginAction) stop() error {
+	_ = a.stdin.Close()
+	<-a.stdoutClosed
+	err := a.cmd.Wait()
+	a.stderr.Flush()
+	a.cmd = nil
+	return err
+}
+
+// Outputs 插件在 hook 阶段返回的输出
+func (a *PluginAction) Outputs() map[string]string {
+	return a.outputs
+}
+
+// call 发送一条消息并等待插件完成对应的阶段
+func (a *PluginAction) call(msg PluginMessage, phase string, handle func(done PluginMessage)) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	a.waitMu.Lock()
+	a.waiting = phase
+	a.waitMu.Unlock()
+	if _, err := a.stdin.Write(append(data, '\n')); err != nil {
+		a.waitMu.Lock()
+		a.waiting = ""
+		a.waitMu.Unlock()
+		return fmt.Errorf("plugin %s: %w", a.name, err)
+	}
+	done, ok := <-a.done
+	if !ok {
+		return fmt.Errorf("plugin %s exited during %s", a.name, phase)
+	}
+	if done.Phase != phase {
+		return fmt.Errorf("plugin %s: expect done of %s, got %s", a.name, phase, done.Phase)
+	}
+	if handle != nil {
+		handle(done)
+	}
+	if done.Error != "" {
+		a.output.WriteLine(done.Error)
+		return fmt.Errorf("plugin %s: %s", a.name, done.Error)
+	}
+	return nil
+}
+
+// read 读取插件的标准输出，日志写到 step 的输出中，不是 json 的行也作为日志
+func (a *PluginAction) read(stdout io.Reader) {
+	defer close(a.stdoutClosed)
+	defer close(a.done)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg PluginMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || msg.Type == "" {
+			a.output.WriteLine(scanner.Text())
+			continue
+		}
+		switch msg.Type {
+		case "log":
+			fmt.Println(msg.Line)
+			a.output.WriteLine(msg.Line)
+		case "done":
+			a.deliver(msg)
+		default:
+			logger.Warnf("plugin %s sent unknown message %s", a.name, msg.Type)
+		}
+	}
+}
+
+// deliver 把 done 交给正在等待的 call，没有在等待时丢弃，避免阻塞读取标准输出
+// 每次 call 只接收一条 done，done 的缓冲为 1，所以这里不会阻塞
+func (a *PluginAction) deliver(msg PluginMessage) {
+	a.waitMu.Lock()
+	waiting := a.waiting
+	a.waiting = ""
+	a.waitMu.Unlock()
+	if waiting == "" {
+		logger.Warnf("plugin %s sent unexpected done of %s, ignored", a.name, msg.Phase)
+		return
+	}
+	a.done <- msg
+}
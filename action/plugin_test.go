@@ -0,0 +1,165 @@
+package action
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	model2 "github.com/hamster-shared/aline-engine/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestPlugin 在插件目录中创建一个插件，插件由当前测试程序的 TestPluginHelperProcess 实现
+func newTestPlugin(t *testing.T, name, mode string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin scripts need sh")
+	}
+	dir := t.TempDir()
+	script := fmt.Sprintf("#!/bin/sh\nALINE_TEST_PLUGIN=%s exec %q -test.run='^TestPluginHelperProcess$'\n", mode, os.Args[0])
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, name+".sh"), []byte(script), 0755))
+	names, err := LoadPlugins(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{name}, names)
+	t.Cleanup(func() { _, _ = LoadPlugins(t.TempDir()) })
+}
+
+// TestPluginHelperProcess 作为插件运行时实现插件协议，正常执行测试时直接返回
+func TestPluginHelperProcess(t *testing.T) {
+	mode := os.Getenv("ALINE_TEST_PLUGIN")
+	if mode == "" {
+		return
+	}
+	send := func(msg PluginMessage) {
+		data, _ := json.Marshal(msg)
+		fmt.Println(string(data))
+	}
+	var pre PluginMessage
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var msg PluginMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			os.Exit(2)
+		}
+		switch msg.Type {
+		case "pre":
+			pre = msg
+			send(PluginMessage{Type: "log", Line: fmt.Sprintf("deploy %s to %s", pre.Inputs["target"], pre.Job.Name)})
+			send(PluginMessage{Type: "done", Phase: "pre"})
+			if mode == "duplicate" {
+				send(PluginMessage{Type: "done", Phase: "pre"})
+				send(PluginMessage{Type: "done", Phase: "pre"})
+				send(PluginMessage{Type: "log", Line: "duplicates sent"})
+			}
+		case "hook":
+			switch mode {
+			case "hang":
+				time.Sleep(time.Minute)
+			case "fail":
+				send(PluginMessage{Type: "done", Phase: "hook", Error: "deploy failed"})
+				continue
+			}
+			fmt.Fprintln(os.Stderr, "warning from stderr")
+			fmt.Println("plain line")
+			send(PluginMessage{
+				Type:    "done",
+				Phase:   "hook",
+				Result:  &model2.ActionResult{Deploys: []model2.DeployInfo{{Url: "https://" + pre.Inputs["target"]}}},
+				Outputs: map[string]string{"workspace": pre.Workspace, "param": pre.Params["env"]},
+			})
+		case "post":
+			send(PluginMessage{Type: "done", Phase: "post"})
+		}
+	}
+	os.Exit(0)
+}
+
+func TestPluginAction(t *testing.T) {
+	newTestPlugin(t, "deploy", "ok")
+	kind, _ := Resolve("deploy")
+	assert.Equal(t, KindPlugin, kind)
+
+	ctx, stack, out := newContainerTestContext(t)
+	stack["parameter"] = map[string]string{"env": "prod"}
+	a := New(model2.Step{Uses: "deploy", With: map[string]string{"target": "${{ param.env }}.example.com"}}, ctx, out)
+	assert.NoError(t, a.Pre())
+	result, err := a.Hook()
+	assert.NoError(t, err)
+	assert.NoError(t, a.Post())
+
+	assert.Equal(t, "https://prod.example.com", result.Deploys[0].Url)
+	assert.Equal(t, map[string]string{"workspace": stack["workdir"].(string), "param": "prod"}, a.(OutputsProvider).Outputs())
+	content, err := out.NewReader().ReadString()
+	assert.NoError(t, err)
+	assert.Contains(t, content, "deploy prod.example.com to container")
+	assert.Contains(t, content, "warning from stderr")
+	assert.Contains(t, content, "plain line")
+}
+
+func TestPluginActionFailure(t *testing.T) {
+	newTestPlugin(t, "deploy", "fail")
+	ctx, _, out := newContainerTestContext(t)
+	a := NewPluginAction(model2.Step{Uses: "deploy"}, ctx, out)
+	assert.NoError(t, a.Pre())
+	_, err := a.Hook()
+	assert.EqualError(t, err, "plugin deploy: deploy failed")
+	assert.NoError(t, a.Post())
+}
+
+func TestPluginActionDuplicateDone(t *testing.T) {
+	newTestPlugin(t, "deploy", "duplicate")
+	ctx, _, out := newContainerTestContext(t)
+	a := NewPluginAction(model2.Step{Uses: "deploy", With: map[string]string{"target": "example.com"}}, ctx, out)
+	assert.NoError(t, a.Pre())
+	// 多发的 done 没有 call 在等待，丢弃后继续读取后面的内容
+	assert.Eventually(t, func() bool {
+		content, _ := out.NewReader().ReadString()
+		return strings.Contains(content, "duplicates sent")
+	}, 10*time.Second, 10*time.Millisecond)
+	result, err := a.Hook()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com", result.Deploys[0].Url)
+	assert.NoError(t, a.Post())
+}
+
+func TestPluginActionCancel(t *testing.T) {
+	newTestPlugin(t, "deploy", "hang")
+	ctx, _, out := newContainerTestContext(t)
+	ctx, cancel := context.WithCancel(ctx)
+	a := NewPluginAction(model2.Step{Uses: "deploy"}, ctx, out)
+	assert.NoError(t, a.Pre())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := a.Hook()
+	assert.EqualError(t, err, "plugin deploy exited during hook")
+	assert.Less(t, time.Since(start), 30*time.Second)
+	assert.Error(t, a.Post())
+}
+
+func TestLoadPlugins(t *testing.T) {
+	dir := t.TempDir()
+	for name, mode := range map[string]os.FileMode{"notify.sh": 0755, "git-checkout": 0755, "README.md": 0644, ".hidden": 0755} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), mode))
+	}
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "lib"), 0755))
+	t.Cleanup(func() { _, _ = LoadPlugins(t.TempDir()) })
+
+	names, err := LoadPlugins(dir)
+	assert.NoError(t, err)
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, []string{"notify"}, names)
+	}
+	kind, _ := Resolve("git-checkout")
+	assert.Equal(t, KindBuiltin, kind)
+
+	names, err = LoadPlugins(filepath.Join(dir, "missing"))
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+	assert.Empty(t, Plugins())
+}
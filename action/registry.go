@@ -0,0 +1,138 @@
+package action
+
+import (
+	"context"
+	"strings"
+
+	aline_context "github.com/hamster-shared/aline-engine/ctx"
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+)
+
+// Constructor 根据 step 创建 action，只保存参数，不会执行任何操作
+type Constructor func(step model.Step, ctx context.Context, output *output.Output) ActionHandler
+
+// Kind action 的类型
+type Kind string
+
+const (
+	KindShell   Kind = "shell"
+	KindBuiltin Kind = "builtin"
+	KindRemote  Kind = "remote"
+	// KindPlugin worker 插件目录中的插件
+	KindPlugin Kind = "plugin"
+	// KindUnknown 不认识的 uses，执行时会直接跳过
+	KindUnknown Kind = "unknown"
+)
+
+// builtinActions 内置的 action，key 为 step 的 uses
+var builtinActions = map[string]Constructor{
+	"git-checkout": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewGitAction(step, ctx, output)
+	},
+	"hamster-ipfs": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewIpfsAction(step, ctx, output)
+	},
+	"hamster-pinata-ipfs": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewPinataIpfsAction(step, ctx, output)
+	},
+	"hamster-artifactory": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewArtifactoryAction(step, ctx, output)
+	},
+	"image-build": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewImageBuildAction(step, ctx, output)
+	},
+	"image-push": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewImagePushAction(step, ctx, output)
+	},
+	"k8s-frontend-deploy": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewK8sDeployAction(step, ctx, output)
+	},
+	"k8s-assign-domain": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewK8sIngressAction(step, ctx, output)
+	},
+	"metascan_action": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewMetaScanCheckAction(step, ctx, output)
+	},
+	"sol-profiler-check": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewSolProfilerAction(step, ctx, output)
+	},
+	"solhint-check": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewSolHintAction(step, ctx, output)
+	},
+	"mythril-check": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewMythRilAction(step, ctx, output)
+	},
+	"slither-check": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewSlitherAction(step, ctx, output)
+	},
+	"check-aggregation": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewCheckAggregationAction(step, ctx, output)
+	},
+	"deploy-ink-contract": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewInkAction(step, ctx, output)
+	},
+	"frontend-check": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewEslintAction(step, ctx, output)
+	},
+	"eth-gas-reporter": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewEthGasReporterAction(step, ctx, output)
+	},
+	"aptos-check": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewMoveProverAction(step, ctx, output)
+	},
+	"workdir": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewWorkdirAction(step, ctx, output)
+	},
+	"openai": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewOpenaiAction(step, ctx, output)
+	},
+	"icp-build": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewICPBuildAction(aline_context.NewActionContext(step, ctx, output))
+	},
+	"icp-deploy": func(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+		return NewICPDeployAction(aline_context.NewActionContext(step, ctx, output))
+	},
+}
+
+// Resolve 解析 uses 对应的 action 类型，remote action 同时返回仓库地址
+func Resolve(uses string) (Kind, string) {
+	if uses == "" || uses == "shell" {
+		return KindShell, ""
+	}
+	if _, ok := builtinActions[uses]; ok {
+		return KindBuiltin, ""
+	}
+	if _, ok := lookupPlugin(uses); ok {
+		return KindPlugin, ""
+	}
+	if strings.Contains(uses, "/") {
+		return KindRemote, remoteActionURL(uses)
+	}
+	return KindUnknown, ""
+}
+
+// New 创建 step 对应的 action，不认识的 uses 返回 nil
+func New(step model.Step, ctx context.Context, output *output.Output) ActionHandler {
+	kind, _ := Resolve(step.Uses)
+	switch kind {
+	case KindShell:
+		return NewShellAction(step, ctx, output)
+	case KindBuiltin:
+		return builtinActions[step.Uses](step, ctx, output)
+	case KindRemote:
+		return NewRemoteAction(step, ctx, output)
+	case KindPlugin:
+		return NewPluginAction(step, ctx, output)
+	}
+	return nil
+}
+
+// remoteActionURL remote action 所在的仓库，不包含 @ref，工作目录中的 action 为相对路径
+func remoteActionURL(uses string) string {
+	src := parseRemoteUses(uses)
+	if src.local != "" {
+		return src.name
+	}
+	return redactURL(src.url)
+}
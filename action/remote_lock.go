@@ -0,0 +1,87 @@
+package action
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ActionLockFile pipeline 仓库中记录 remote action commit 的文件，相对于工作目录
+// 内容为 uses 到 commit 的映射，存在时所有指定了 ref 的 remote action 都必须在其中
+const ActionLockFile = ".aline/actions.lock"
+
+// tagLockFile 工作目录中没有 lockfile 时，worker 记录 tag 第一次使用时的 commit，相对于缓存目录
+const tagLockFile = "tags.lock"
+
+var tagLockMu sync.Mutex
+
+// verifyActionLock 检查 action 检出的 commit 和 lockfile 中记录的是否一致
+func verifyActionLock(workdir string, src remoteSource, checkout *actionCheckout) error {
+	if src.ref == "" {
+		return nil
+	}
+	locked, err := readLockFile(filepath.Join(workdir, ActionLockFile))
+	if err != nil {
+		return err
+	}
+	if locked != nil {
+		commit, ok := locked[src.uses]
+		if !ok {
+			return fmt.Errorf("action %s is not in %s", src.uses, ActionLockFile)
+		}
+		if commit != checkout.Commit {
+			return fmt.Errorf("action %s is locked to %s but resolves to %s", src.uses, commit, checkout.Commit)
+		}
+		return nil
+	}
+	if !checkout.Tag {
+		return nil
+	}
+
+	// tag 不应该移动，移动之后执行失败，需要人工确认后删除缓存目录中的记录
+	tagLockMu.Lock()
+	defer tagLockMu.Unlock()
+	file := filepath.Join(currentRemoteActionConfig().CacheDir, tagLockFile)
+	tags, err := readLockFile(file)
+	if err != nil {
+		return err
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	key := src.name + "@" + src.ref
+	if commit, ok := tags[key]; ok {
+		if commit != checkout.Commit {
+			return fmt.Errorf("tag %s of %s moved from %s to %s", src.ref, src.name, commit, checkout.Commit)
+		}
+		return nil
+	}
+	tags[key] = checkout.Commit
+	data, err := yaml.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// readLockFile 文件不存在时返回 nil
+func readLockFile(file string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	locked := make(map[string]string)
+	if err := yaml.Unmarshal(data, &locked); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return locked, nil
+}
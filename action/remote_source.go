@@ -0,0 +1,149 @@
+package action
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hamster-shared/aline-engine/utils"
+)
+
+// RemoteActionConfig worker 上 remote action 的配置
+type RemoteActionConfig struct {
+	// 仓库的缓存目录，为空时使用 ~/.aline/actions
+	CacheDir string
+	// owner/repo 形式的 uses 所在的 git 服务，为空时使用 https://github.com
+	DefaultHost string
+	// 允许使用的 action 来源，为空时不限制
+	// 每项为来源的前缀，例如 github.com/hamster-shared/、gitlab.example.com/，./ 表示工作目录中的 action，* 表示不限制
+	Allowlist []string
+}
+
+var (
+	remoteActionConfig   RemoteActionConfig
+	remoteActionConfigMu sync.Mutex
+)
+
+// ConfigureRemoteActions 设置 worker 上 remote action 的配置
+func ConfigureRemoteActions(c RemoteActionConfig) {
+	remoteActionConfigMu.Lock()
+	defer remoteActionConfigMu.Unlock()
+	remoteActionConfig = c
+}
+
+func currentRemoteActionConfig() RemoteActionConfig {
+	remoteActionConfigMu.Lock()
+	defer remoteActionConfigMu.Unlock()
+	c := remoteActionConfig
+	if c.CacheDir == "" {
+		c.CacheDir = filepath.Join(utils.DefaultConfigDir(), "actions")
+	}
+	if c.DefaultHost == "" {
+		c.DefaultHost = "https://github.com"
+	}
+	return c
+}
+
+// remoteSource uses 中的 action 来源，支持
+//
+//	owner/repo[@ref]                      默认 git 服务上的仓库
+//	https://host/path/repo.git[@ref]      任意 git 服务上的仓库，也支持 ssh:// 和 git@host:path
+//	./path/in/workspace                   工作目录中的 action
+type remoteSource struct {
+	uses string
+	// 工作目录中的 action 相对于工作目录的路径，不为空时 url 和 ref 为空
+	local string
+	url   string
+	ref   string
+	// 来源的规范名称，host/path 形式，用于缓存目录和 allowlist
+	name string
+}
+
+// parseRemoteUses 解析 uses，没有写 ref 时使用默认分支
+func parseRemoteUses(uses string) remoteSource {
+	src := remoteSource{uses: uses}
+	if strings.HasPrefix(uses, "./") || strings.HasPrefix(uses, "../") {
+		src.local = path.Clean(uses)
+		src.name = "./" + src.local
+		return src
+	}
+
+	var host, repo string
+	switch {
+	case strings.Contains(uses, "://"):
+		scheme, rest, _ := strings.Cut(uses, "://")
+		host, repo, _ = strings.Cut(rest, "/")
+		repo, src.ref = cutRef(repo)
+		src.url = scheme + "://" + host + "/" + repo
+		// 去掉用户信息，不出现在缓存目录和日志中
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+	case isSCPLike(uses):
+		host, repo, _ = strings.Cut(uses, ":")
+		repo, src.ref = cutRef(repo)
+		src.url = host + ":" + repo
+		host = host[strings.LastIndex(host, "@")+1:]
+	default:
+		repo, src.ref = cutRef(uses)
+		defaultHost := currentRemoteActionConfig().DefaultHost
+		src.url = strings.TrimSuffix(defaultHost, "/") + "/" + repo
+		host = defaultHost
+		if _, rest, ok := strings.Cut(defaultHost, "://"); ok {
+			host = rest
+		}
+	}
+	src.name = strings.Trim(path.Join(host, strings.TrimSuffix(repo, ".git")), "/")
+	return src
+}
+
+// cutRef 拆分 path@ref，ref 中可以包含 /
+func cutRef(repo string) (string, string) {
+	if i := strings.LastIndex(repo, "@"); i >= 0 {
+		return repo[:i], repo[i+1:]
+	}
+	return repo, ""
+}
+
+// isSCPLike git@host:path 形式的 ssh 地址
+func isSCPLike(uses string) bool {
+	colon := strings.Index(uses, ":")
+	slash := strings.Index(uses, "/")
+	return colon > 0 && (slash < 0 || colon < slash)
+}
+
+// validate 来源名称会作为缓存中的目录，不能是绝对路径，也不能以 .. 开头，例如 owner/../../../x
+func (s remoteSource) validate() error {
+	if s.local != "" {
+		return nil
+	}
+	if s.name == "" || s.name == "." || s.name == ".." || strings.HasPrefix(s.name, "../") ||
+		path.IsAbs(s.name) || filepath.IsAbs(s.name) {
+		return fmt.Errorf("invalid action %s", s.uses)
+	}
+	return nil
+}
+
+// allowed 检查来源是否在 allowlist 中，allowlist 中的每一项是来源名称的前缀，需要是完整的路径
+func (s remoteSource) allowed(allowlist []string) error {
+	if len(allowlist) == 0 {
+		return nil
+	}
+	for _, entry := range allowlist {
+		entry = strings.TrimSpace(entry)
+		if _, rest, ok := strings.Cut(entry, "://"); ok {
+			entry = rest
+		}
+		if entry == "*" {
+			return nil
+		}
+		// 按照路径匹配，github.com/org 不能匹配 github.com/org-evil/x
+		entry = strings.TrimSuffix(entry, "/")
+		if entry != "" && (s.name == entry || strings.HasPrefix(s.name, entry+"/")) {
+			return nil
+		}
+	}
+	return fmt.Errorf("action %s from %s is not in the allowlist", s.uses, s.name)
+}
@@ -0,0 +1,157 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/container"
+	"github.com/hamster-shared/aline-engine/logger"
+	model2 "github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+	"github.com/hamster-shared/aline-engine/utils"
+)
+
+// 检查工作目录大小的间隔
+const diskCheckInterval = 5 * time.Second
+
+// resourceLimiter 限制 shell step 使用的资源，step 失败时判断是不是因为超出了限制
+// 在宿主机上执行时使用 cgroup v2，在 runs-on 容器中执行时由启动容器的参数限制，这里只负责判断
+// 磁盘大小没有办法直接限制，定时检查工作目录的大小，超出时结束 step
+type resourceLimiter struct {
+	resources *model2.Resources
+	output    *output.Output
+	cgroup    *utils.Cgroup
+	// step 所在的容器，为空表示在宿主机上执行
+	container    string
+	workdir      string
+	diskLimit    int64
+	diskExceeded atomic.Bool
+	done         chan struct{}
+}
+
+// newResourceLimiter 没有配置资源限制时返回 nil，nil 的 limiter 可以直接使用
+func newResourceLimiter(ctx context.Context, resources *model2.Resources, output *output.Output) (*resourceLimiter, error) {
+	if resources.IsEmpty() {
+		return nil, nil
+	}
+	if err := resources.Validate(); err != nil {
+		return nil, err
+	}
+	stack := ctx.Value(STACK).(map[string]interface{})
+	l := &resourceLimiter{
+		resources: resources,
+		output:    output,
+		done:      make(chan struct{}),
+	}
+	l.workdir, _ = stack["workdir"].(string)
+	l.container, _ = stack["container"].(string)
+	l.diskLimit, _ = resources.DiskBytes()
+	if l.container != "" {
+		return l, nil
+	}
+
+	cpu, _ := resources.CPUCores()
+	memory, _ := resources.MemoryBytes()
+	if cpu == 0 && memory == 0 && resources.Pids == 0 {
+		return l, nil
+	}
+	jobName, _ := stack["name"].(string)
+	jobID, _ := stack["id"].(string)
+	name := strings.ReplaceAll(fmt.Sprintf("%s-%s-%s", jobName, jobID, utils.RandSeq(6)), "/", "_")
+	cgroup, err := utils.NewCgroup(name, utils.CgroupLimits{CPU: cpu, Memory: memory, Pids: resources.Pids})
+	if err != nil {
+		// 不支持 cgroup 的机器上照常执行，只是不限制资源
+		logger.Warnf("resource limits are not enforced: %s", err)
+		output.WriteLine(fmt.Sprintf("[resources] cpu, memory and pids limits are not enforced: %s", err))
+		return l, nil
+	}
+	l.cgroup = cgroup
+	return l, nil
+}
+
+// wrap 让宿主机上执行的命令运行在 cgroup 中
+func (l *resourceLimiter) wrap(commands []string) []string {
+	if l == nil || l.cgroup == nil {
+		return commands
+	}
+	return l.cgroup.Wrap(commands)
+}
+
+// watch 定时检查工作目录的大小，超出限制时调用 kill 结束 step
+func (l *resourceLimiter) watch(kill func()) {
+	if l == nil || l.diskLimit == 0 || l.workdir == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(diskCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-ticker.C:
+			}
+			if size := utils.DirSize(l.workdir); size > l.diskLimit {
+				logger.Warnf("workdir %s uses %d bytes, exceeds disk limit %s", l.workdir, size, l.resources.Disk)
+				l.diskExceeded.Store(true)
+				kill()
+				return
+			}
+		}
+	}()
+}
+
+// check step 失败后调用，判断是不是因为超出了资源限制
+func (l *resourceLimiter) check(err error) error {
+	if l == nil {
+		return err
+	}
+	var limitErr *model2.ResourceLimitError
+	switch {
+	case l.diskExceeded.Load():
+		limitErr = &model2.ResourceLimitError{Resource: "disk", Limit: l.resources.Disk, Err: err}
+	case l.events("memory.events")["oom_kill"] > 0:
+		limitErr = &model2.ResourceLimitError{Resource: "memory", Limit: l.resources.Memory, Err: err}
+	case l.events("pids.events")["max"] > 0:
+		limitErr = &model2.ResourceLimitError{Resource: "pids", Limit: strconv.Itoa(l.resources.Pids), Err: err}
+	default:
+		return err
+	}
+	l.output.WriteLine("[resources] " + limitErr.Error())
+	return limitErr
+}
+
+// events 读取 cgroup 的事件计数，容器中执行时读取容器自己的 cgroup
+func (l *resourceLimiter) events(file string) map[string]int64 {
+	if l.cgroup != nil {
+		return l.cgroup.Events(file)
+	}
+	if l.container == "" {
+		return map[string]int64{}
+	}
+	var out bytes.Buffer
+	code, err := container.Default().Exec(context.Background(), l.container, container.ExecSpec{
+		Cmd:    []string{"cat", "/sys/fs/cgroup/" + file},
+		Stdout: &out,
+	})
+	if err != nil || code != 0 {
+		return map[string]int64{}
+	}
+	return utils.ParseCgroupEvents(out.String())
+}
+
+// close step 结束后停止检查，删除 cgroup
+func (l *resourceLimiter) close() {
+	if l == nil {
+		return
+	}
+	close(l.done)
+	if l.cgroup != nil {
+		l.cgroup.Remove()
+	}
+}
@@ -0,0 +1,45 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hamster-shared/aline-engine/container"
+	"github.com/hamster-shared/aline-engine/logger"
+	model2 "github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainerResources(t *testing.T) {
+	assert.Equal(t, container.Resources{}, containerResources(nil))
+	resources := containerResources(&model2.Resources{CPU: "1.5", Memory: "512M", Pids: 64, Disk: "1G"})
+	assert.Equal(t, container.Resources{CPUs: 1.5, Memory: 536870912, Pids: 64}, resources)
+}
+
+func TestResourceLimiterDisk(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	stack := map[string]interface{}{"name": "limit", "id": "1", "workdir": t.TempDir()}
+	ctx := context.WithValue(context.Background(), STACK, stack)
+
+	limiter, err := newResourceLimiter(ctx, nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, limiter)
+	cause := errors.New("exit status 1")
+	assert.Equal(t, cause, limiter.check(cause))
+
+	_, err = newResourceLimiter(ctx, &model2.Resources{Disk: "lots"}, nil)
+	assert.Error(t, err)
+
+	limiter, err = newResourceLimiter(ctx, &model2.Resources{Disk: "1G"}, output.New("limit", 1))
+	assert.NoError(t, err)
+	defer limiter.close()
+	limiter.diskExceeded.Store(true)
+	err = limiter.check(cause)
+	var limitErr *model2.ResourceLimitError
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "disk", limitErr.Resource)
+	assert.ErrorIs(t, err, cause)
+}
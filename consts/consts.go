@@ -5,6 +5,8 @@ const (
 	JOB_DIR_NAME            = "jobs"
 	JOB_DETAIL_DIR_NAME     = "job-details"
 	JOB_DETAIL_LOG_DIR_NAME = "job-details-log"
+	JOB_ATTEMPT_DIR_NAME    = "job-attempts"
+	WORKER_JOURNAL_DIR_NAME = "worker-journal"
 )
 
 const (
@@ -47,9 +49,9 @@ const (
 	MythRilSolcJsonName       = ".myhril.json"
 	MythRilSolcJson           = "{\n  \"remappings\": [%s]\n}"
 	MythRilSolcJsonReMappings = "\"%s/=node_modules/%s/\""
-	MythRilCheck              = "docker run --rm -v %s:/tmp -w /tmp mythril/myth analyze /tmp/%s --solc-json %s --execution-timeout 15"
+	MythRilCheckImage         = "mythril/myth"
 	SlitherCheckOutputDir     = "slither"
-	SlitherCheck              = "docker run --rm -v %s:/tmp bingjian/solidity_check:slither_091_1_0816 slither /tmp/%s"
+	SlitherCheckImage         = "bingjian/solidity_check:slither_091_1_0816"
 	EslintCheckOutputDir      = "eslint"
 	GasReporterTotalDir       = "gas-reporter"
 	EthGasReporterDir         = "eth-gas-reporter"
@@ -57,7 +59,7 @@ const (
 	MoveFileSuffix            = ".move"
 	MoveProve                 = "Move Prove"
 	MoveProveCheckOutputDir   = "move-prover"
-	MoveProveCheck            = "docker run --rm %s -v %s:/tmp hamstershare/aptoslabs-tools:aptos-node-v1.3.3 aptos move prove --package-dir %s %s"
+	MoveProveCheckImage       = "hamstershare/aptoslabs-tools:aptos-node-v1.3.3"
 )
 
 var InkUrlMap = map[string]string{
@@ -68,7 +70,9 @@ var InkUrlMap = map[string]string{
 }
 
 const (
-	STEP_TIMEOUT_MINUTE = 30 // 单位为分钟
+	STEP_TIMEOUT_MINUTE   = 30 // 单位为分钟
+	APPROVAL_TIMEOUT_HOUR = 24 // 审批默认超时时间，单位为小时
+	KILL_GRACE_SECOND     = 10 // 取消任务时，发送 SIGTERM 之后等待进程退出的时间，单位为秒
 )
 
 const SecretName = "hamster-tls"
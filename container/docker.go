@@ -0,0 +1,525 @@
+package container
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+// DefaultDockerHost docker Engine API 默认的地址
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// apiError Engine API 返回的错误
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("docker api error %d: %s", e.Status, e.Message)
+}
+
+func isStatus(err error, status int) bool {
+	var e *apiError
+	return errors.As(err, &e) && e.Status == status
+}
+
+// dockerRuntime 通过 unix socket 调用 docker Engine API
+type dockerRuntime struct {
+	socket string
+	client *http.Client
+}
+
+// NewDocker 创建使用 docker Engine API 的运行时，host 为空时依次使用 DOCKER_HOST 和默认的 socket
+func NewDocker(host string) Runtime {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = DefaultDockerHost
+	}
+	socket := strings.TrimPrefix(host, "unix://")
+	return &dockerRuntime{
+		socket: socket,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+func (d *dockerRuntime) Name() string {
+	return RuntimeDocker
+}
+
+// do 发送请求，状态码大于等于 400 时返回 apiError，调用方负责关闭返回的 body
+func (d *dockerRuntime) do(ctx context.Context, method, path string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	if _, err := os.Stat(d.socket); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+	u := "http://docker" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		var msg struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &msg) != nil || msg.Message == "" {
+			msg.Message = strings.TrimSpace(string(data))
+		}
+		return nil, &apiError{Status: resp.StatusCode, Message: msg.Message}
+	}
+	return resp, nil
+}
+
+// doJSON 发送 json 请求，把返回的 json 解析到 result，result 为 nil 时忽略返回内容
+func (d *dockerRuntime) doJSON(ctx context.Context, method, path string, query url.Values, body, result any) error {
+	var reader io.Reader
+	header := http.Header{}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+		header.Set("Content-Type", "application/json")
+	}
+	resp, err := d.do(ctx, method, path, query, reader, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if result == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (d *dockerRuntime) Pull(ctx context.Context, image string, out io.Writer) error {
+	return d.pull(ctx, image, nil, out)
+}
+
+func (d *dockerRuntime) pull(ctx context.Context, image string, auth *RegistryAuth, out io.Writer) error {
+	name, tag := splitImage(image)
+	query := url.Values{"fromImage": {name}}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	authHeader, err := registryAuth(image, auth)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(ctx, http.MethodPost, "/images/create", query, nil, http.Header{"X-Registry-Auth": {authHeader}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readMessages(resp.Body, out)
+}
+
+type dockerHostConfig struct {
+	Binds       []string `json:"Binds,omitempty"`
+	NetworkMode string   `json:"NetworkMode,omitempty"`
+	NanoCpus    int64    `json:"NanoCpus,omitempty"`
+	Memory      int64    `json:"Memory,omitempty"`
+	MemorySwap  int64    `json:"MemorySwap,omitempty"`
+	PidsLimit   int64    `json:"PidsLimit,omitempty"`
+}
+
+type dockerCreateRequest struct {
+	Image      string            `json:"Image"`
+	Entrypoint []string          `json:"Entrypoint,omitempty"`
+	Cmd        []string          `json:"Cmd,omitempty"`
+	User       string            `json:"User,omitempty"`
+	Env        []string          `json:"Env,omitempty"`
+	WorkingDir string            `json:"WorkingDir,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+	Tty        bool              `json:"Tty"`
+	HostConfig dockerHostConfig  `json:"HostConfig"`
+}
+
+func (d *dockerRuntime) Run(ctx context.Context, spec RunSpec) (string, error) {
+	req := dockerCreateRequest{
+		Image:      spec.Image,
+		Entrypoint: spec.Entrypoint,
+		Cmd:        spec.Cmd,
+		User:       spec.User,
+		Env:        spec.Env,
+		WorkingDir: spec.WorkingDir,
+		Labels:     spec.Labels,
+		Tty:        spec.Tty,
+		HostConfig: dockerHostConfig{
+			Binds:       spec.Binds,
+			NetworkMode: spec.Network,
+			NanoCpus:    int64(spec.Resources.CPUs * 1e9),
+			Memory:      spec.Resources.Memory,
+			PidsLimit:   int64(spec.Resources.Pids),
+		},
+	}
+	// swap 和内存使用相同的上限，即不允许使用 swap
+	req.HostConfig.MemorySwap = spec.Resources.Memory
+	query := url.Values{}
+	if spec.Name != "" {
+		query.Set("name", spec.Name)
+	}
+	if spec.Pull == PullAlways {
+		if err := d.pull(ctx, spec.Image, spec.Auth, io.Discard); err != nil {
+			return "", err
+		}
+	}
+	var created struct {
+		Id string
+	}
+	err := d.doJSON(ctx, http.MethodPost, "/containers/create", query, req, &created)
+	if isStatus(err, http.StatusNotFound) && spec.Pull != PullNever {
+		// 和 docker run 一样，镜像不存在时先拉取
+		if err := d.pull(ctx, spec.Image, spec.Auth, io.Discard); err != nil {
+			return "", err
+		}
+		err = d.doJSON(ctx, http.MethodPost, "/containers/create", query, req, &created)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := d.doJSON(ctx, http.MethodPost, "/containers/"+created.Id+"/start", nil, nil, nil); err != nil {
+		_ = d.Remove(context.Background(), created.Id)
+		return "", err
+	}
+	return created.Id, nil
+}
+
+func (d *dockerRuntime) Exec(ctx context.Context, id string, spec ExecSpec) (int, error) {
+	var created struct {
+		Id string
+	}
+	err := d.doJSON(ctx, http.MethodPost, "/containers/"+id+"/exec", nil, map[string]any{
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Cmd":          spec.Cmd,
+		"Env":          spec.Env,
+		"WorkingDir":   spec.WorkingDir,
+	}, &created)
+	if err != nil {
+		return -1, err
+	}
+	data, _ := json.Marshal(map[string]bool{"Detach": false, "Tty": false})
+	resp, err := d.do(ctx, http.MethodPost, "/exec/"+created.Id+"/start", nil, bytes.NewReader(data), http.Header{"Content-Type": {"application/json"}})
+	if err != nil {
+		return -1, err
+	}
+	err = demux(resp.Body, spec.Stdout, spec.Stderr)
+	resp.Body.Close()
+	if err != nil {
+		return -1, err
+	}
+	var inspect struct {
+		ExitCode int
+	}
+	if err := d.doJSON(ctx, http.MethodGet, "/exec/"+created.Id+"/json", nil, nil, &inspect); err != nil {
+		return -1, err
+	}
+	return inspect.ExitCode, nil
+}
+
+func (d *dockerRuntime) Wait(ctx context.Context, id string) (int, error) {
+	var result struct {
+		StatusCode int
+		Error      *struct {
+			Message string
+		}
+	}
+	if err := d.doJSON(ctx, http.MethodPost, "/containers/"+id+"/wait", nil, nil, &result); err != nil {
+		return -1, err
+	}
+	if result.Error != nil && result.Error.Message != "" {
+		return -1, errors.New(result.Error.Message)
+	}
+	return result.StatusCode, nil
+}
+
+func (d *dockerRuntime) Logs(ctx context.Context, id string, out io.Writer) error {
+	var inspect struct {
+		Config struct {
+			Tty bool
+		}
+	}
+	if err := d.doJSON(ctx, http.MethodGet, "/containers/"+id+"/json", nil, nil, &inspect); err != nil {
+		return err
+	}
+	resp, err := d.do(ctx, http.MethodGet, "/containers/"+id+"/logs", url.Values{"stdout": {"1"}, "stderr": {"1"}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// 使用 tty 的容器输出没有分帧
+	if inspect.Config.Tty {
+		_, err = io.Copy(out, resp.Body)
+		return err
+	}
+	return demux(resp.Body, out, out)
+}
+
+func (d *dockerRuntime) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	// 已经停止的容器返回 304，不是错误
+	query := url.Values{"t": {strconv.Itoa(int(timeout.Seconds()))}}
+	return d.doJSON(ctx, http.MethodPost, "/containers/"+id+"/stop", query, nil, nil)
+}
+
+func (d *dockerRuntime) Remove(ctx context.Context, id string) error {
+	err := d.doJSON(ctx, http.MethodDelete, "/containers/"+id, url.Values{"force": {"1"}, "v": {"1"}}, nil, nil)
+	if isStatus(err, http.StatusNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (d *dockerRuntime) List(ctx context.Context, label string) ([]Container, error) {
+	filters, _ := json.Marshal(map[string][]string{"label": {label}})
+	var list []struct {
+		Id     string
+		Names  []string
+		State  string
+		Labels map[string]string
+	}
+	if err := d.doJSON(ctx, http.MethodGet, "/containers/json", url.Values{"all": {"1"}, "filters": {string(filters)}}, nil, &list); err != nil {
+		return nil, err
+	}
+	containers := make([]Container, 0, len(list))
+	for _, c := range list {
+		name := ""
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		containers = append(containers, Container{ID: c.Id, Name: name, Running: c.State == "running", Labels: c.Labels})
+	}
+	return containers, nil
+}
+
+func (d *dockerRuntime) Build(ctx context.Context, spec BuildSpec) error {
+	query := url.Values{"t": {spec.Tag}, "rm": {"1"}}
+	if spec.Dockerfile != "" {
+		query.Set("dockerfile", spec.Dockerfile)
+	}
+	// 和 docker build 一样带上所有仓库的认证信息，FROM 的镜像可能来自私有仓库
+	config, err := registryConfig()
+	if err != nil {
+		return err
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(tarDir(spec.ContextDir, spec.Dockerfile, writer))
+	}()
+	defer reader.Close()
+	resp, err := d.do(ctx, http.MethodPost, "/build", query, reader, http.Header{
+		"Content-Type":      {"application/x-tar"},
+		"X-Registry-Config": {config},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readMessages(resp.Body, spec.Output)
+}
+
+func (d *dockerRuntime) Push(ctx context.Context, image string, out io.Writer) error {
+	name, tag := splitImage(image)
+	query := url.Values{}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	// 和 docker push 一样使用 docker 认证配置中这个仓库的认证信息
+	authHeader, err := registryAuth(image, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(ctx, http.MethodPost, "/images/"+name+"/push", query, nil, http.Header{"X-Registry-Auth": {authHeader}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readMessages(resp.Body, out)
+}
+
+func (d *dockerRuntime) RemoveImage(ctx context.Context, image string) error {
+	return d.doJSON(ctx, http.MethodDelete, "/images/"+image, nil, nil, nil)
+}
+
+// readMessages 读取 pull、push、build 返回的 json 消息流，把输出写到 out，遇到错误消息时返回错误
+func readMessages(r io.Reader, out io.Writer) error {
+	if out == nil {
+		out = io.Discard
+	}
+	decoder := json.NewDecoder(r)
+	for {
+		var msg struct {
+			Stream string `json:"stream"`
+			Status string `json:"status"`
+			ID     string `json:"id"`
+			Error  string `json:"error"`
+		}
+		if err := decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch {
+		case msg.Error != "":
+			return errors.New(msg.Error)
+		case msg.Stream != "":
+			_, _ = io.WriteString(out, msg.Stream)
+		case msg.Status != "" && msg.ID != "":
+			_, _ = fmt.Fprintf(out, "%s: %s\n", msg.ID, msg.Status)
+		case msg.Status != "":
+			_, _ = fmt.Fprintln(out, msg.Status)
+		}
+	}
+}
+
+// demux 拆分 docker 的多路输出流：每一帧为 8 字节的头（第 1 字节是 stream 类型，后 4 字节是长度）加上内容
+func demux(r io.Reader, stdout, stderr io.Writer) error {
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	reader := bufio.NewReader(r)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		out := stdout
+		if header[0] == 2 {
+			out = stderr
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(out, reader, size); err != nil {
+			return err
+		}
+	}
+}
+
+// tarDir 把目录打包为构建上下文，和 docker build 一样排除 .dockerignore 中的文件
+// Dockerfile 和 .dockerignore 即使被排除也会打包，daemon 需要读取它们
+func tarDir(dir, dockerfile string, w io.Writer) error {
+	excludes, err := readDockerignore(dir)
+	if err != nil {
+		return err
+	}
+	pm, err := patternmatcher.New(excludes)
+	if err != nil {
+		return fmt.Errorf("invalid .dockerignore: %w", err)
+	}
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	dockerfile = filepath.ToSlash(filepath.Clean(dockerfile))
+	keep := func(rel string) bool {
+		return rel == dockerfile || rel == ".dockerignore"
+	}
+
+	tw := tar.NewWriter(w)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !keep(rel) {
+			excluded, err := pm.MatchesOrParentMatches(rel)
+			if err != nil {
+				return err
+			}
+			if excluded {
+				// 有 ! 开头的规则时，被排除的目录中的文件也可能需要打包，不能跳过整个目录
+				if info.IsDir() && !pm.Exclusions() && !strings.HasPrefix(dockerfile, rel+"/") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = rel
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// readDockerignore 读取构建上下文中的 .dockerignore，不存在时返回空
+func readDockerignore(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ignorefile.ReadAll(f)
+}
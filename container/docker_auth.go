@@ -0,0 +1,236 @@
+package container
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Engine API 不会读取客户端的认证配置，docker CLI 会读取 ~/.docker/config.json 中的认证信息或者调用凭证助手，
+// 再通过 X-Registry-Auth、X-Registry-Config 传给 daemon，这里按照同样的方式读取
+
+// dockerIndexServer docker hub 在认证配置中的地址
+const dockerIndexServer = "https://index.docker.io/v1/"
+
+// dockerConfig config.json 中和认证相关的内容
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	// 所有仓库默认使用的凭证助手
+	CredsStore string `json:"credsStore"`
+	// 仓库地址对应的凭证助手，优先于 CredsStore
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// dockerConfigDir DOCKER_CONFIG 为空时使用 ~/.docker
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker")
+}
+
+// loadDockerConfig 读取 docker 的认证配置，文件不存在时返回空的配置
+func loadDockerConfig() (*dockerConfig, error) {
+	config := &dockerConfig{}
+	data, err := os.ReadFile(filepath.Join(dockerConfigDir(), "config.json"))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid docker config: %w", err)
+	}
+	return config, nil
+}
+
+// normalizeRegistry 去掉地址中的协议和路径，docker hub 的各种地址统一为 docker.io
+func normalizeRegistry(address string) string {
+	address = strings.TrimPrefix(strings.TrimPrefix(address, "https://"), "http://")
+	address, _, _ = strings.Cut(address, "/")
+	switch address {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return address
+}
+
+// serverAddress 仓库在认证配置和凭证助手中使用的地址
+func serverAddress(host string) string {
+	if host == "docker.io" {
+		return dockerIndexServer
+	}
+	return host
+}
+
+// lookup 查找仓库的认证信息，没有配置时返回 nil
+func (c *dockerConfig) lookup(host string) (*RegistryAuth, error) {
+	host = normalizeRegistry(host)
+	helper := c.CredsStore
+	for address, h := range c.CredHelpers {
+		if normalizeRegistry(address) == host {
+			helper = h
+		}
+	}
+	if helper != "" {
+		auth, err := credentialHelperGet(helper, serverAddress(host))
+		if err != nil || auth != nil {
+			return auth, err
+		}
+	}
+	for address, entry := range c.Auths {
+		if normalizeRegistry(address) != host {
+			continue
+		}
+		auth := &RegistryAuth{Username: entry.Username, Password: entry.Password, IdentityToken: entry.IdentityToken, ServerAddress: address}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth of %s in docker config: %w", address, err)
+			}
+			auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		return auth, nil
+	}
+	return nil, nil
+}
+
+// all 所有配置了认证信息的仓库，构建时 FROM 的镜像可能来自其中任何一个
+func (c *dockerConfig) all() (map[string]RegistryAuth, error) {
+	addresses := make(map[string]bool)
+	for address := range c.Auths {
+		addresses[address] = true
+	}
+	for address := range c.CredHelpers {
+		addresses[address] = true
+	}
+	if c.CredsStore != "" {
+		listed, err := credentialHelperList(c.CredsStore)
+		if err != nil {
+			return nil, err
+		}
+		for address := range listed {
+			addresses[address] = true
+		}
+	}
+	result := make(map[string]RegistryAuth)
+	for address := range addresses {
+		auth, err := c.lookup(address)
+		if err != nil {
+			return nil, err
+		}
+		if auth != nil {
+			auth.ServerAddress = address
+			result[address] = *auth
+		}
+	}
+	return result, nil
+}
+
+// credentialHelperGet 调用 docker-credential-<helper> get，没有这个仓库的凭证时返回 nil
+func credentialHelperGet(helper, address string) (*RegistryAuth, error) {
+	out, err := runCredentialHelper(helper, "get", address)
+	if err != nil {
+		// 没有安装凭证助手时和没有凭证一样，拉取公开的镜像不需要认证
+		if errors.Is(err, exec.ErrNotFound) || strings.Contains(err.Error(), "credentials not found") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var creds struct {
+		ServerURL string
+		Username  string
+		Secret    string
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return nil, fmt.Errorf("invalid output of docker-credential-%s: %w", helper, err)
+	}
+	// 用户名为 <token> 时 Secret 是 identity token
+	if creds.Username == "<token>" {
+		return &RegistryAuth{IdentityToken: creds.Secret, ServerAddress: address}, nil
+	}
+	return &RegistryAuth{Username: creds.Username, Password: creds.Secret, ServerAddress: address}, nil
+}
+
+// credentialHelperList 调用 docker-credential-<helper> list，返回仓库地址和用户名
+func credentialHelperList(helper string) (map[string]string, error) {
+	out, err := runCredentialHelper(helper, "list", "")
+	if err != nil {
+		return nil, err
+	}
+	list := make(map[string]string)
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, fmt.Errorf("invalid output of docker-credential-%s: %w", helper, err)
+	}
+	return list, nil
+}
+
+func runCredentialHelper(helper, action, input string) ([]byte, error) {
+	cmd := exec.Command("docker-credential-"+helper, action)
+	cmd.Stdin = strings.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, err
+		}
+		// 凭证助手把错误信息写到 stdout
+		msg := strings.TrimSpace(stdout.String() + stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, errors.New("docker-credential-" + helper + ": " + msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// registryAuth X-Registry-Auth 的内容，没有指定认证信息时使用 docker 认证配置中这个仓库的
+// 都没有时也必须带上空的认证信息
+func registryAuth(image string, auth *RegistryAuth) (string, error) {
+	if auth == nil {
+		config, err := loadDockerConfig()
+		if err != nil {
+			return "", err
+		}
+		if auth, err = config.lookup(registryHost(image)); err != nil {
+			return "", err
+		}
+	}
+	if auth == nil {
+		return base64.URLEncoding.EncodeToString([]byte("{}")), nil
+	}
+	a := *auth
+	if a.ServerAddress == "" {
+		a.ServerAddress = serverAddress(registryHost(image))
+	}
+	data, _ := json.Marshal(a)
+	return base64.URLEncoding.EncodeToString(data), nil
+}
+
+// registryConfig 构建时 X-Registry-Config 的内容，包含所有仓库的认证信息
+func registryConfig() (string, error) {
+	config, err := loadDockerConfig()
+	if err != nil {
+		return "", err
+	}
+	auths, err := config.all()
+	if err != nil {
+		return "", err
+	}
+	data, _ := json.Marshal(auths)
+	return base64.URLEncoding.EncodeToString(data), nil
+}
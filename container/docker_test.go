@@ -0,0 +1,408 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// frame 按照 docker 多路输出流的格式打包一帧
+func frame(stream byte, content string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(content)))
+	return append(header, content...)
+}
+
+// newFakeDocker 在 unix socket 上启动一个模拟的 Engine API
+func newFakeDocker(t *testing.T, handler http.Handler) Runtime {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return NewDocker("unix://" + socket)
+}
+
+func TestDockerRun(t *testing.T) {
+	pulled := false
+	var created dockerCreateRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		if !pulled {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"No such image: alpine:3"}`))
+			return
+		}
+		assert.Equal(t, "job_1", r.URL.Query().Get("name"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		_, _ = w.Write([]byte(`{"Id":"abc"}`))
+	})
+	mux.HandleFunc("/images/create", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "alpine", r.URL.Query().Get("fromImage"))
+		assert.Equal(t, "3", r.URL.Query().Get("tag"))
+		pulled = true
+		_, _ = w.Write([]byte(`{"status":"Pulling from library/alpine","id":"3"}` + "\n" + `{"status":"Downloaded newer image for alpine:3"}`))
+	})
+	mux.HandleFunc("/containers/abc/start", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rt := newFakeDocker(t, mux)
+
+	id, err := rt.Run(context.Background(), RunSpec{
+		Name:      "job_1",
+		Image:     "alpine:3",
+		Cmd:       []string{"cat"},
+		Binds:     []string{"/work:/work"},
+		Labels:    map[string]string{"aline.managed": ""},
+		Tty:       true,
+		Resources: Resources{CPUs: 1.5, Memory: 1024, Pids: 10},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", id)
+	assert.True(t, pulled)
+	assert.Equal(t, []string{"cat"}, created.Cmd)
+	assert.Equal(t, int64(1500000000), created.HostConfig.NanoCpus)
+	assert.Equal(t, int64(1024), created.HostConfig.MemorySwap)
+	assert.Equal(t, int64(10), created.HostConfig.PidsLimit)
+}
+
+func TestDockerExec(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/abc/exec", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "/work", req["WorkingDir"])
+		_, _ = w.Write([]byte(`{"Id":"e1"}`))
+	})
+	mux.HandleFunc("/exec/e1/start", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(frame(1, "out\n"))
+		_, _ = w.Write(frame(2, "err\n"))
+	})
+	mux.HandleFunc("/exec/e1/json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ExitCode":2}`))
+	})
+	rt := newFakeDocker(t, mux)
+
+	var stdout, stderr bytes.Buffer
+	code, err := rt.Exec(context.Background(), "abc", ExecSpec{Cmd: []string{"sh", "-c", "x"}, WorkingDir: "/work", Stdout: &stdout, Stderr: &stderr})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, code)
+	assert.Equal(t, "out\n", stdout.String())
+	assert.Equal(t, "err\n", stderr.String())
+}
+
+func TestDockerListAndRemove(t *testing.T) {
+	removed := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, `{"label":["aline.job=a/1"]}`, r.URL.Query().Get("filters"))
+		_, _ = w.Write([]byte(`[{"Id":"abc","Names":["/a_1_0"],"State":"exited","Labels":{"aline.job":"a/1"}}]`))
+	})
+	mux.HandleFunc("/containers/abc", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodDelete, r.Method)
+		removed = "abc"
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/containers/gone", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	rt := newFakeDocker(t, mux)
+
+	list, err := rt.List(context.Background(), "aline.job=a/1")
+	assert.NoError(t, err)
+	assert.Equal(t, []Container{{ID: "abc", Name: "a_1_0", Running: false, Labels: map[string]string{"aline.job": "a/1"}}}, list)
+	assert.NoError(t, rt.Remove(context.Background(), "abc"))
+	assert.Equal(t, "abc", removed)
+	// 容器已经不存在
+	assert.NoError(t, rt.Remove(context.Background(), "gone"))
+}
+
+func TestDockerPushError(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images/registry.example.com:5000/app/push", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "v1", r.URL.Query().Get("tag"))
+		assert.NotEmpty(t, r.Header.Get("X-Registry-Auth"))
+		_, _ = w.Write([]byte(`{"status":"The push refers to repository"}` + "\n" + `{"error":"denied: requested access to the resource is denied"}`))
+	})
+	rt := newFakeDocker(t, mux)
+	var out bytes.Buffer
+	err := rt.Push(context.Background(), "registry.example.com:5000/app:v1", &out)
+	assert.EqualError(t, err, "denied: requested access to the resource is denied")
+	assert.Equal(t, "The push refers to repository\n", out.String())
+}
+
+func TestDockerUnavailable(t *testing.T) {
+	rt := NewDocker(filepath.Join(t.TempDir(), "missing.sock"))
+	_, err := rt.List(context.Background(), "aline.managed")
+	assert.True(t, errors.Is(err, ErrUnavailable))
+}
+
+func TestSplitImage(t *testing.T) {
+	for image, want := range map[string][2]string{
+		"alpine":                         {"alpine", "latest"},
+		"alpine:3.18":                    {"alpine", "3.18"},
+		"localhost:5000/app":             {"localhost:5000/app", "latest"},
+		"localhost:5000/app:v1":          {"localhost:5000/app", "v1"},
+		"alpine@sha256:0123456789abcdef": {"alpine@sha256:0123456789abcdef", ""},
+	} {
+		name, tag := splitImage(image)
+		assert.Equal(t, want, [2]string{name, tag}, image)
+	}
+}
+
+func TestPodmanRunArgs(t *testing.T) {
+	args := runArgs(RunSpec{
+		Name:       "job_1",
+		Image:      "node:18",
+		Cmd:        []string{"cat"},
+		WorkingDir: "/work",
+		Binds:      []string{"/work:/work"},
+		Labels:     map[string]string{"aline.managed": "", "aline.job": "job/1"},
+		Tty:        true,
+		Resources:  Resources{CPUs: 1.5, Memory: 536870912, Pids: 64},
+	}, "")
+	assert.Equal(t, []string{
+		"run", "-d", "--name", "job_1", "-t",
+		"--label", "aline.job=job/1", "--label", "aline.managed",
+		"-v", "/work:/work", "-w", "/work",
+		"--cpus", "1.5", "--memory", "536870912", "--memory-swap", "536870912", "--pids-limit", "64",
+		"node:18", "cat",
+	}, args)
+}
+
+func TestPodmanRunArgsOptions(t *testing.T) {
+	args := runArgs(RunSpec{
+		Image:      "node:18",
+		Pull:       PullNever,
+		User:       "1000:1000",
+		Network:    "host",
+		Entrypoint: []string{""},
+	}, "/tmp/auth.json")
+	assert.Equal(t, []string{
+		"run", "-d", "--pull", "never", "--authfile", "/tmp/auth.json",
+		"--user", "1000:1000", "--network", "host", "--entrypoint", "",
+		"node:18",
+	}, args)
+
+	args = runArgs(RunSpec{Image: "node:18", Pull: PullAlways, Entrypoint: []string{"/bin/sh", "-c"}}, "")
+	assert.Equal(t, []string{"run", "-d", "--pull", "always", "--entrypoint", `["/bin/sh","-c"]`, "node:18"}, args)
+}
+
+func TestWriteAuthFile(t *testing.T) {
+	file, err := writeAuthFile("registry.example.com/app:v1", &RegistryAuth{Username: "user", Password: "pass"})
+	assert.NoError(t, err)
+	defer os.Remove(file)
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"auths":{"registry.example.com":{"auth":"dXNlcjpwYXNz"}}}`, string(data))
+
+	// 只有 identity token 时不能丢掉
+	file, err = writeAuthFile("registry.example.com/app:v1", &RegistryAuth{IdentityToken: "token", ServerAddress: "registry.example.com"})
+	assert.NoError(t, err)
+	defer os.Remove(file)
+	data, err = os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"auths":{"registry.example.com":{"identitytoken":"token"}}}`, string(data))
+}
+
+func TestDockerPullPolicy(t *testing.T) {
+	var pulls []string
+	var created dockerCreateRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		if len(pulls) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"No such image"}`))
+			return
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+		_, _ = w.Write([]byte(`{"Id":"abc"}`))
+	})
+	mux.HandleFunc("/images/create", func(w http.ResponseWriter, r *http.Request) {
+		pulls = append(pulls, r.Header.Get("X-Registry-Auth"))
+		_, _ = w.Write([]byte(`{"status":"Downloaded newer image"}`))
+	})
+	mux.HandleFunc("/containers/abc/start", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rt := newFakeDocker(t, mux)
+
+	// never 时镜像不存在直接报错，不会拉取
+	_, err := rt.Run(context.Background(), RunSpec{Image: "ghcr.io/org/app:v1", Pull: PullNever})
+	assert.Error(t, err)
+	assert.Empty(t, pulls)
+
+	// always 时先拉取，带上认证信息
+	_, err = rt.Run(context.Background(), RunSpec{
+		Image:      "ghcr.io/org/app:v1",
+		Pull:       PullAlways,
+		Auth:       &RegistryAuth{Username: "user", Password: "pass"},
+		User:       "1000:1000",
+		Network:    "none",
+		Entrypoint: []string{"/bin/sh"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, pulls, 1)
+	data, err := base64.URLEncoding.DecodeString(pulls[0])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"username":"user","password":"pass","serveraddress":"ghcr.io"}`, string(data))
+	assert.Equal(t, "1000:1000", created.User)
+	assert.Equal(t, "none", created.HostConfig.NetworkMode)
+	assert.Equal(t, []string{"/bin/sh"}, created.Entrypoint)
+}
+
+func TestParseRegistryAuth(t *testing.T) {
+	auth, err := ParseRegistryAuth("user:p:ss\n")
+	assert.NoError(t, err)
+	assert.Equal(t, &RegistryAuth{Username: "user", Password: "p:ss"}, auth)
+
+	auth, err = ParseRegistryAuth(`{"username":"user","password":"pass","serveraddress":"ghcr.io"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "ghcr.io", auth.ServerAddress)
+
+	_, err = ParseRegistryAuth("token")
+	assert.Error(t, err)
+
+	assert.Equal(t, "docker.io", registryHost("alpine:3"))
+	assert.Equal(t, "docker.io", registryHost("library/alpine"))
+	assert.Equal(t, "localhost:5000", registryHost("localhost:5000/app"))
+}
+
+func TestRunToCompletion(t *testing.T) {
+	removed := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"Id":"once"}`))
+	})
+	mux.HandleFunc("/containers/once/start", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/containers/once/wait", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"StatusCode":1}`))
+	})
+	mux.HandleFunc("/containers/once/json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"Config":{"Tty":false}}`))
+	})
+	mux.HandleFunc("/containers/once/logs", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(frame(1, "found 2 issues\n"))
+	})
+	mux.HandleFunc("/containers/once", func(w http.ResponseWriter, r *http.Request) {
+		removed = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rt := newFakeDocker(t, mux)
+
+	var out bytes.Buffer
+	code, err := RunToCompletion(context.Background(), rt, RunSpec{Image: "slither"}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "found 2 issues\n", out.String())
+	assert.True(t, removed)
+}
+
+func TestDockerPushAuth(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", configDir)
+	config := `{
+  "auths": {"registry.example.com:5000": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user:pass")) + `"}},
+  "credHelpers": {"ghcr.io": "fake"}
+}`
+	assert.NoError(t, os.WriteFile(filepath.Join(configDir, "config.json"), []byte(config), 0600))
+	// 模拟的凭证助手，读取 stdin 中的仓库地址
+	binDir := t.TempDir()
+	helper := "#!/bin/sh\nread server\necho \"{\\\"ServerURL\\\":\\\"$server\\\",\\\"Username\\\":\\\"<token>\\\",\\\"Secret\\\":\\\"tok\\\"}\"\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(binDir, "docker-credential-fake"), []byte(helper), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	auths := make(map[string]string)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images/", func(w http.ResponseWriter, r *http.Request) {
+		data, err := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Auth"))
+		assert.NoError(t, err)
+		auths[r.URL.Path] = string(data)
+		_, _ = w.Write([]byte(`{"status":"Pushed"}`))
+	})
+	rt := newFakeDocker(t, mux)
+
+	assert.NoError(t, rt.Push(context.Background(), "registry.example.com:5000/app:v1", nil))
+	assert.JSONEq(t, `{"username":"user","password":"pass","serveraddress":"registry.example.com:5000"}`,
+		auths["/images/registry.example.com:5000/app/push"])
+	assert.NoError(t, rt.Push(context.Background(), "ghcr.io/org/app:v1", nil))
+	assert.JSONEq(t, `{"username":"","password":"","identitytoken":"tok","serveraddress":"ghcr.io"}`,
+		auths["/images/ghcr.io/org/app/push"])
+	// 没有配置认证信息的仓库使用空的认证信息
+	assert.NoError(t, rt.Pull(context.Background(), "alpine:3", nil))
+	assert.Equal(t, "{}", auths["/images/create"])
+}
+
+func TestDockerBuild(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", configDir)
+	config := `{"auths": {"registry.example.com": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user:pass")) + `"}}}`
+	assert.NoError(t, os.WriteFile(filepath.Join(configDir, "config.json"), []byte(config), 0600))
+
+	contextDir := t.TempDir()
+	files := map[string]string{
+		".dockerignore":           ".git\nnode_modules\n*.log\n!keep.log\nbuild/Dockerfile\n",
+		"build/Dockerfile":        "FROM registry.example.com/base:1\n",
+		"main.go":                 "package main\n",
+		"debug.log":               "debug\n",
+		"keep.log":                "keep\n",
+		".git/HEAD":               "ref: refs/heads/main\n",
+		"node_modules/x/index.js": "x\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(contextDir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	var names []string
+	var registryConfig map[string]RegistryAuth
+	mux := http.NewServeMux()
+	mux.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "build/Dockerfile", r.URL.Query().Get("dockerfile"))
+		data, err := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Config"))
+		assert.NoError(t, err)
+		assert.NoError(t, json.Unmarshal(data, &registryConfig))
+		tr := tar.NewReader(r.Body)
+		for {
+			header, err := tr.Next()
+			if err != nil {
+				break
+			}
+			if header.Typeflag == tar.TypeReg {
+				names = append(names, header.Name)
+			}
+		}
+		_, _ = w.Write([]byte(`{"stream":"Successfully built abc\n"}`))
+	})
+	rt := newFakeDocker(t, mux)
+
+	var out bytes.Buffer
+	err := rt.Build(context.Background(), BuildSpec{ContextDir: contextDir, Dockerfile: "build/Dockerfile", Tag: "app:v1", Output: &out})
+	assert.NoError(t, err)
+	assert.Equal(t, "Successfully built abc\n", out.String())
+	assert.ElementsMatch(t, []string{".dockerignore", "build/Dockerfile", "keep.log", "main.go"}, names)
+	assert.Equal(t, RegistryAuth{Username: "user", Password: "pass", ServerAddress: "registry.example.com"}, registryConfig["registry.example.com"])
+}
@@ -0,0 +1,313 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// podmanRuntime 调用 podman 命令，podman 不需要守护进程，适合没有 docker 的 worker
+type podmanRuntime struct {
+	binary string
+}
+
+// NewPodman 创建使用 podman 命令的运行时，binary 为空时从 PATH 中查找 podman
+func NewPodman(binary string) Runtime {
+	if binary == "" {
+		binary = "podman"
+	}
+	return &podmanRuntime{binary: binary}
+}
+
+func (p *podmanRuntime) Name() string {
+	return RuntimePodman
+}
+
+func (p *podmanRuntime) command(ctx context.Context, args ...string) (*exec.Cmd, error) {
+	binary, err := exec.LookPath(p.binary)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+	return exec.CommandContext(ctx, binary, args...), nil
+}
+
+// run 执行命令并返回标准输出，失败时错误中带上标准错误的内容
+func (p *podmanRuntime) run(ctx context.Context, args ...string) (string, error) {
+	c, err := p.command(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return string(out), fmt.Errorf("%s %s: %w: %s", p.binary, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// stream 执行命令，输出写到 out
+func (p *podmanRuntime) stream(ctx context.Context, out io.Writer, args ...string) error {
+	c, err := p.command(ctx, args...)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		out = io.Discard
+	}
+	c.Stdout = out
+	c.Stderr = out
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w", p.binary, args[0], err)
+	}
+	return nil
+}
+
+func (p *podmanRuntime) Pull(ctx context.Context, image string, out io.Writer) error {
+	return p.stream(ctx, out, "pull", image)
+}
+
+// runArgs podman run 的参数，podman 在镜像不存在时会自动拉取，authfile 为空时使用 podman 自己的认证配置
+func runArgs(spec RunSpec, authfile string) []string {
+	args := []string{"run", "-d"}
+	if spec.Name != "" {
+		args = append(args, "--name", spec.Name)
+	}
+	switch spec.Pull {
+	case PullAlways:
+		args = append(args, "--pull", "always")
+	case PullNever:
+		args = append(args, "--pull", "never")
+	}
+	if authfile != "" {
+		args = append(args, "--authfile", authfile)
+	}
+	if spec.User != "" {
+		args = append(args, "--user", spec.User)
+	}
+	if spec.Network != "" {
+		args = append(args, "--network", spec.Network)
+	}
+	if spec.Entrypoint != nil {
+		entrypoint := strings.Join(spec.Entrypoint, "")
+		if len(spec.Entrypoint) > 1 {
+			// 多个参数时使用 json 数组的格式
+			data, _ := json.Marshal(spec.Entrypoint)
+			entrypoint = string(data)
+		}
+		args = append(args, "--entrypoint", entrypoint)
+	}
+	if spec.Tty {
+		args = append(args, "-t")
+	}
+	keys := make([]string, 0, len(spec.Labels))
+	for key := range spec.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		label := key
+		if value := spec.Labels[key]; value != "" {
+			label += "=" + value
+		}
+		args = append(args, "--label", label)
+	}
+	for _, bind := range spec.Binds {
+		args = append(args, "-v", bind)
+	}
+	for _, env := range spec.Env {
+		args = append(args, "-e", env)
+	}
+	if spec.WorkingDir != "" {
+		args = append(args, "-w", spec.WorkingDir)
+	}
+	if spec.Resources.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(spec.Resources.CPUs, 'f', -1, 64))
+	}
+	if spec.Resources.Memory > 0 {
+		// swap 和内存使用相同的上限，即不允许使用 swap
+		memory := strconv.FormatInt(spec.Resources.Memory, 10)
+		args = append(args, "--memory", memory, "--memory-swap", memory)
+	}
+	if spec.Resources.Pids > 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(spec.Resources.Pids))
+	}
+	args = append(args, spec.Image)
+	return append(args, spec.Cmd...)
+}
+
+func (p *podmanRuntime) Run(ctx context.Context, spec RunSpec) (string, error) {
+	var authfile string
+	if spec.Auth != nil {
+		// 使用临时的 authfile 而不是 --creds，避免密码出现在进程的命令行参数中
+		file, err := writeAuthFile(spec.Image, spec.Auth)
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(file)
+		authfile = file
+	}
+	out, err := p.run(ctx, runArgs(spec, authfile)...)
+	if err != nil {
+		return "", err
+	}
+	// 拉取镜像的输出在标准错误中，标准输出的最后一行是容器 id
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", errors.New("podman run: no container id")
+	}
+	return fields[len(fields)-1], nil
+}
+
+// writeAuthFile 把认证信息写到临时的 auth.json 中，返回文件的路径，调用方负责删除
+func writeAuthFile(image string, auth *RegistryAuth) (string, error) {
+	server := auth.ServerAddress
+	if server == "" {
+		server = registryHost(image)
+	}
+	// 和 docker 的 config.json 一样，identity token 写在 identitytoken 中，podman 会用它换取访问令牌
+	entry := make(map[string]string)
+	if auth.Username != "" || auth.Password != "" {
+		entry["auth"] = base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+	}
+	if auth.IdentityToken != "" {
+		entry["identitytoken"] = auth.IdentityToken
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"auths": map[string]interface{}{server: entry},
+	})
+	if err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp("", "aline-auth-*.json")
+	if err != nil {
+		return "", err
+	}
+	// CreateTemp 创建的文件权限为 0600
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+func (p *podmanRuntime) Exec(ctx context.Context, id string, spec ExecSpec) (int, error) {
+	args := []string{"exec"}
+	for _, env := range spec.Env {
+		args = append(args, "-e", env)
+	}
+	if spec.WorkingDir != "" {
+		args = append(args, "-w", spec.WorkingDir)
+	}
+	args = append(args, id)
+	c, err := p.command(ctx, append(args, spec.Cmd...)...)
+	if err != nil {
+		return -1, err
+	}
+	c.Stdout = spec.Stdout
+	c.Stderr = spec.Stderr
+	err = c.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+func (p *podmanRuntime) Wait(ctx context.Context, id string) (int, error) {
+	out, err := p.run(ctx, "wait", id)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
+
+func (p *podmanRuntime) Logs(ctx context.Context, id string, out io.Writer) error {
+	return p.stream(ctx, out, "logs", id)
+}
+
+func (p *podmanRuntime) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	_, err := p.run(ctx, "stop", "--time", strconv.Itoa(int(timeout.Seconds())), id)
+	return err
+}
+
+func (p *podmanRuntime) Remove(ctx context.Context, id string) error {
+	_, err := p.run(ctx, "rm", "-f", "--ignore", id)
+	return err
+}
+
+func (p *podmanRuntime) List(ctx context.Context, label string) ([]Container, error) {
+	out, err := p.run(ctx, "ps", "-a", "--filter", "label="+label, "--format", "json")
+	if err != nil {
+		return nil, err
+	}
+	var list []struct {
+		Id     string
+		Names  []string
+		State  string
+		Labels map[string]string
+	}
+	if strings.TrimSpace(out) != "" {
+		if err := json.Unmarshal([]byte(out), &list); err != nil {
+			return nil, err
+		}
+	}
+	containers := make([]Container, 0, len(list))
+	for _, c := range list {
+		name := ""
+		if len(c.Names) > 0 {
+			name = c.Names[0]
+		}
+		containers = append(containers, Container{ID: c.Id, Name: name, Running: c.State == "running", Labels: c.Labels})
+	}
+	return containers, nil
+}
+
+func (p *podmanRuntime) Build(ctx context.Context, spec BuildSpec) error {
+	args := []string{"build", "-t", spec.Tag}
+	if spec.Dockerfile != "" {
+		args = append(args, "-f", spec.Dockerfile)
+	}
+	c, err := p.command(ctx, append(args, ".")...)
+	if err != nil {
+		return err
+	}
+	c.Dir = spec.ContextDir
+	out := spec.Output
+	if out == nil {
+		out = io.Discard
+	}
+	c.Stdout = out
+	c.Stderr = out
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("%s build: %w", p.binary, err)
+	}
+	return nil
+}
+
+func (p *podmanRuntime) Push(ctx context.Context, image string, out io.Writer) error {
+	return p.stream(ctx, out, "push", image)
+}
+
+func (p *podmanRuntime) RemoveImage(ctx context.Context, image string) error {
+	_, err := p.run(ctx, "rmi", image)
+	return err
+}
@@ -0,0 +1,235 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RuntimeDocker = "docker"
+	RuntimePodman = "podman"
+)
+
+// ErrUnavailable worker 上没有可用的容器运行时，例如没有安装 podman、docker 的 socket 不存在
+var ErrUnavailable = errors.New("container runtime is not available")
+
+// ExitError 容器中的命令以非 0 状态退出
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// Resources 容器的资源限制，为 0 表示不限制
+type Resources struct {
+	// cpu 核数，可以是小数
+	CPUs   float64
+	Memory int64
+	Pids   int
+}
+
+const (
+	// PullIfNotPresent 本地没有镜像时才拉取，默认的策略
+	PullIfNotPresent = "if-not-present"
+	PullAlways       = "always"
+	PullNever        = "never"
+)
+
+// RegistryAuth 镜像仓库的认证信息
+type RegistryAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// 使用 token 认证的仓库，例如 docker credential helper 返回的用户名为 <token> 时
+	IdentityToken string `json:"identitytoken,omitempty"`
+	// 仓库地址，为空时根据镜像名称确定
+	ServerAddress string `json:"serveraddress,omitempty"`
+}
+
+// ParseRegistryAuth 解析密钥中保存的认证信息，格式为 username:password 或者 RegistryAuth 的 json
+func ParseRegistryAuth(value string) (*RegistryAuth, error) {
+	value = strings.TrimSpace(value)
+	auth := &RegistryAuth{}
+	if strings.HasPrefix(value, "{") {
+		if err := json.Unmarshal([]byte(value), auth); err != nil {
+			return nil, fmt.Errorf("invalid registry credentials: %w", err)
+		}
+	} else if username, password, ok := strings.Cut(value, ":"); ok {
+		auth.Username, auth.Password = username, password
+	}
+	if auth.Username == "" {
+		return nil, errors.New("invalid registry credentials: username is empty")
+	}
+	return auth, nil
+}
+
+// RunSpec 启动容器的参数
+type RunSpec struct {
+	Name  string
+	Image string
+	// 镜像拉取策略，为空时为 PullIfNotPresent
+	Pull string
+	// 拉取镜像使用的认证信息，为空时使用运行时自己的配置
+	Auth *RegistryAuth
+	// 覆盖镜像的 entrypoint，nil 表示使用镜像的 entrypoint，[""] 表示清除
+	Entrypoint []string
+	// 镜像 entrypoint 的参数，为空时使用镜像默认的命令
+	Cmd        []string
+	Env        []string
+	WorkingDir string
+	// 容器中的用户，uid:gid 或者用户名
+	User    string
+	Network string
+	// host:container[:ro]
+	Binds     []string
+	Labels    map[string]string
+	Tty       bool
+	Resources Resources
+}
+
+// ExecSpec 在运行中的容器里执行命令的参数
+type ExecSpec struct {
+	Cmd        []string
+	Env        []string
+	WorkingDir string
+	Stdout     io.Writer
+	Stderr     io.Writer
+}
+
+// BuildSpec 构建镜像的参数
+type BuildSpec struct {
+	// 构建上下文所在的目录
+	ContextDir string
+	// 相对于 ContextDir 的路径，为空时使用 Dockerfile
+	Dockerfile string
+	Tag        string
+	Output     io.Writer
+}
+
+// Container 容器的概要信息
+type Container struct {
+	ID      string
+	Name    string
+	Running bool
+	Labels  map[string]string
+}
+
+// Runtime 容器运行时，所有需要容器的 action 都通过它操作容器
+type Runtime interface {
+	// Name 运行时的名称，用于日志
+	Name() string
+	// Pull 拉取镜像，进度写到 out
+	Pull(ctx context.Context, image string, out io.Writer) error
+	// Run 在后台启动容器，镜像不存在时先拉取，返回容器 id
+	Run(ctx context.Context, spec RunSpec) (string, error)
+	// Exec 在容器中执行命令并等待结束，返回命令的退出码
+	Exec(ctx context.Context, id string, spec ExecSpec) (int, error)
+	// Wait 等待容器退出，返回容器的退出码
+	Wait(ctx context.Context, id string) (int, error)
+	// Logs 把容器到目前为止的输出写到 out
+	Logs(ctx context.Context, id string, out io.Writer) error
+	// Stop 停止容器，超过 timeout 后强制结束
+	Stop(ctx context.Context, id string, timeout time.Duration) error
+	// Remove 强制删除容器，容器不存在时不报错
+	Remove(ctx context.Context, id string) error
+	// List 列出带有 label 的所有容器，label 为 key 或 key=value
+	List(ctx context.Context, label string) ([]Container, error)
+	// Build 构建镜像
+	Build(ctx context.Context, spec BuildSpec) error
+	// Push 推送镜像，输出写到 out
+	Push(ctx context.Context, image string, out io.Writer) error
+	// RemoveImage 删除本地的镜像
+	RemoveImage(ctx context.Context, image string) error
+}
+
+// Config worker 使用的容器运行时
+type Config struct {
+	// docker 或 podman，为空时使用 docker
+	Runtime string
+	// docker 为 Engine API 的地址，unix:///var/run/docker.sock 或者 socket 的路径
+	// podman 为可执行文件的路径，为空时从 PATH 中查找
+	Host string
+}
+
+// New 按照配置创建容器运行时
+func New(c Config) (Runtime, error) {
+	switch strings.ToLower(c.Runtime) {
+	case "", RuntimeDocker:
+		return NewDocker(c.Host), nil
+	case RuntimePodman:
+		return NewPodman(c.Host), nil
+	}
+	return nil, fmt.Errorf("unknown container runtime %s", c.Runtime)
+}
+
+var (
+	defaultRuntime Runtime
+	defaultMu      sync.Mutex
+)
+
+// SetDefault 设置 worker 使用的容器运行时
+func SetDefault(rt Runtime) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultRuntime = rt
+}
+
+// Default 返回 worker 使用的容器运行时，没有设置时使用本机的 docker
+func Default() Runtime {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultRuntime == nil {
+		defaultRuntime = NewDocker("")
+	}
+	return defaultRuntime
+}
+
+// RunToCompletion 启动容器并等待它退出，输出写到 out，结束后删除容器
+// ctx 被取消时强制删除容器
+func RunToCompletion(ctx context.Context, rt Runtime, spec RunSpec, out io.Writer) (int, error) {
+	id, err := rt.Run(ctx, spec)
+	if err != nil {
+		return -1, err
+	}
+	defer func() {
+		// ctx 可能已经被取消，删除容器不能使用它
+		_ = rt.Remove(context.Background(), id)
+	}()
+	code, err := rt.Wait(ctx, id)
+	if err != nil {
+		return -1, err
+	}
+	if err := rt.Logs(ctx, id, out); err != nil {
+		return -1, err
+	}
+	return code, nil
+}
+
+// registryHost 镜像所在的仓库，没有写仓库地址时为 docker.io
+func registryHost(image string) string {
+	first, _, ok := strings.Cut(image, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return "docker.io"
+}
+
+// splitImage 把镜像拆分为名称和 tag，没有 tag 时为 latest，使用 digest 时 tag 为空
+func splitImage(image string) (string, string) {
+	if strings.Contains(image, "@") {
+		return image, ""
+	}
+	slash := strings.LastIndex(image, "/")
+	colon := strings.LastIndex(image, ":")
+	if colon > slash {
+		return image[:colon], image[colon+1:]
+	}
+	return image, "latest"
+}
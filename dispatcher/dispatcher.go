@@ -12,6 +12,9 @@ import (
 	"github.com/hamster-shared/aline-engine/utils"
 )
 
+// ErrAllNodesFull 所有节点都没有空闲的执行槽位
+var ErrAllNodesFull = errors.New("all nodes are full")
+
 type IDispatcher interface {
 	// DispatchNode 选择节点
 	DispatchNode() (*model.Node, error)
@@ -31,6 +34,8 @@ type IDispatcher interface {
 	// CancelJobWithNode 通过指定节点取消任务
 	CancelJobWithNode(name string, jobDetailID int, node *model.Node) *api.AlineMessage
 	GetJobStatus(name string, jobDetailID int) (*api.AlineMessage, error)
+	// ApproveJob 将 stage 审批结果发给执行任务的节点
+	ApproveJob(name string, jobDetailID int, approval *api.Approval) (*api.AlineMessage, error)
 	// IsValidNode 判断有没有这个节点
 	IsValidNode(n string) bool
 }
@@ -62,25 +67,50 @@ func NewGrpcDispatcher() IDispatcher {
 	}
 }
 
-// DispatchNode 选择节点
+// DispatchNode 选择节点，从轮询位置开始跳过已经满载的节点
+// 所有节点都满载时返回 ErrAllNodesFull，任务留在 master 上等待，不会发给满载的节点
 func (d *GrpcDispatcher) DispatchNode() (*model.Node, error) {
 	// 加锁，防止多个 goroutine 同时修改 poller.index
 	d.mu.Lock()
+	defer d.mu.Unlock()
 	if len(d.poller.keyList) == 0 {
-		d.mu.Unlock()
 		return nil, errors.New("no node available, len key list is 0")
 	}
-	key := d.poller.keyList[d.poller.index]
-	d.poller.index = (d.poller.index + 1) % int64(len(d.poller.keyList))
-	d.mu.Unlock()
-	if value, ok := d.nodes.Load(key); ok {
-		return value.(NodeInfo).node, nil
+	full := false
+	for i := 0; i < len(d.poller.keyList); i++ {
+		index := (d.poller.index + int64(i)) % int64(len(d.poller.keyList))
+		key := d.poller.keyList[index]
+		value, ok := d.nodes.Load(key)
+		if !ok {
+			logger.Errorf("DispatchNode failed, node not exists: %s, index is %d", key, index)
+			continue
+		}
+		node := value.(NodeInfo).node
+		if node.Capacity.IsFull() {
+			full = true
+			continue
+		}
+		d.poller.index = (index + 1) % int64(len(d.poller.keyList))
+		d.reserve(node)
+		return node, nil
+	}
+	if full {
+		return nil, ErrAllNodesFull
 	}
-	logger.Errorf("DispatchNode failed, node not exists: %s, index is %d", key, d.poller.index)
 	logger.Tracef("list: %v", d.poller.keyList)
 	return nil, errors.New("no node available")
 }
 
+// reserve 分发任务后先在本地更新节点的执行能力，避免在下一次心跳之前把任务都发给同一个节点
+func (d *GrpcDispatcher) reserve(node *model.Node) {
+	if node.Capacity == nil {
+		return
+	}
+	capacity := *node.Capacity
+	capacity.Running++
+	node.Capacity = &capacity
+}
+
 // Register 节点注册
 func (d *GrpcDispatcher) Register(node *model.Node) error {
 	key := utils.GetNodeKey(node.Name, node.Address)
@@ -228,6 +258,24 @@ func (d *GrpcDispatcher) GetJobStatus(name string, id int) (*api.AlineMessage, e
 	}, nil
 }
 
+func (d *GrpcDispatcher) ApproveJob(name string, id int, approval *api.Approval) (*api.AlineMessage, error) {
+	node, err := d.GetJobLatestNode(name, id)
+	if err != nil {
+		return nil, fmt.Errorf("job %s(%d) not found execute node", name, id)
+	}
+	logger.Tracef("ApproveJob: %s(%d) stage %s to %s@%s", name, id, approval.Stage, node.Name, node.Address)
+	return &api.AlineMessage{
+		Name:     node.Name,
+		Address:  node.Address,
+		Type:     api.MessageType_APPROVAL,
+		Approval: approval,
+		ExecReq: &api.ExecuteReq{
+			Name:        name,
+			JobDetailId: int64(id),
+		},
+	}, nil
+}
+
 func (d *GrpcDispatcher) IsValidNode(n string) bool {
 	_, ok := d.nodes.Load(n)
 	return ok
@@ -0,0 +1,27 @@
+package dispatcher
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hamster-shared/aline-engine/logger"
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchNodeSkipsFullNodes(t *testing.T) {
+	logger.Init().ToStdout()
+	d := NewGrpcDispatcher()
+	busy := &model.Node{Name: "busy", Address: "127.0.0.1", Capacity: &model.NodeCapacity{Max: 1, Running: 1}}
+	idle := &model.Node{Name: "idle", Address: "127.0.0.2", Capacity: &model.NodeCapacity{Max: 1}}
+	assert.NoError(t, d.Register(busy))
+	assert.NoError(t, d.Register(idle))
+
+	node, err := d.DispatchNode()
+	assert.NoError(t, err)
+	assert.Equal(t, "idle", node.Name)
+
+	// 两个节点都满载了，任务不能再发给任何一个节点
+	_, err = d.DispatchNode()
+	assert.True(t, errors.Is(err, ErrAllNodesFull))
+}
@@ -1,14 +1,27 @@
 package engine
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
-
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/action"
+	"github.com/hamster-shared/aline-engine/container"
+	"github.com/hamster-shared/aline-engine/executor"
 	jober "github.com/hamster-shared/aline-engine/job"
 	"github.com/hamster-shared/aline-engine/logger"
 	"github.com/hamster-shared/aline-engine/model"
 	"github.com/hamster-shared/aline-engine/output"
+	"github.com/hamster-shared/aline-engine/sandbox"
+	"github.com/hamster-shared/aline-engine/secret"
 	"github.com/hamster-shared/aline-engine/utils"
+	"github.com/hamster-shared/aline-engine/workspace"
 	"github.com/sirupsen/logrus"
 )
 
@@ -19,20 +32,31 @@ type Engine interface {
 	DeleteJob(name string) error
 	UpdateJob(name, newName, jobYaml string) error
 	GetJob(name string) (*model.Job, error)
+	PlanJob(name string, params map[string]string) (*model.JobPlan, error)
 	GetJobs(keyword string, page, size int) (*model.JobPage, error)
 	GetCodeInfo(name string, historyId int) (*model.CodeInfo, error)
 	ExecuteJob(name string, id int) (*model.JobDetail, error)
 	ReExecuteJob(name string, id int) error
+	ResumeJob(name string, id int, fromStage string) error
 	GetJobHistory(name string, id int) (*model.JobDetail, error)
 	GetJobHistorys(name string, page, size int) (*model.JobDetailPage, error)
 	DeleteJobHistory(name string, id int) error
 	CreateJobDetail(name string, id int) (*model.JobDetail, error)
 	ExecuteJobDetail(name string, id int) error
 	RegisterStatusChangeHook(hook func(message model.StatusChangeMessage))
+	RegisterTransitionHook(hook func(event model.TransitionEvent))
 	GetJobHistoryLog(name string, id int) (*model.JobLog, error)
+	GetJobHistoryAttempts(name string, id int) ([]model.JobAttempt, error)
+	GetJobHistoryAttempt(name string, id, attempt int) (*model.JobDetail, error)
+	GetJobHistoryAttemptLog(name string, id, attempt int) (*model.JobLog, error)
 	GetJobHistoryStageLog(name string, id int, stageName string, start int) (*model.JobStageLog, error)
 	GetJobHistoryStepLog(name string, id int, stageName string, stepName string) (*output.Step, error)
+	StreamJobLog(ctx context.Context, name string, id int, fromOffset int64) (<-chan model.JobLogEvent, error)
+	SearchLogs(query string, filter model.LogSearchFilter) ([]model.LogSearchResult, error)
+	ExportJobLog(name string, id int, format string, w io.Writer) error
 	TerminalJob(name string, id int) error
+	ApproveStage(name string, id int, stageName, approver, comment string) error
+	RejectStage(name string, id int, stageName, approver, comment string) error
 	GetCurrentJobStatus(jobName string, jobID int) (model.Status, error)
 	IsValidWorker(w string) bool
 	GetWorkRootPath() string
@@ -105,6 +129,15 @@ func (e *engine) GetJob(name string) (*model.Job, error) {
 	return jober.GetJobObject(name)
 }
 
+// PlanJob 生成任务的执行计划，不会执行任何 step，params 覆盖任务保存的参数
+func (e *engine) PlanJob(name string, params map[string]string) (*model.JobPlan, error) {
+	job, err := jober.GetJobObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return executor.Plan(job, params)
+}
+
 func (e *engine) GetJobs(keyword string, page, size int) (*model.JobPage, error) {
 	return jober.JobList(keyword, page, size)
 }
@@ -142,6 +175,14 @@ func (e *engine) ReExecuteJob(name string, id int) error {
 	return e.master.dispatchJob(name, id)
 }
 
+// ResumeJob 从失败的 stage 恢复执行，fromStage 为空时从第一个失败的 stage 开始
+func (e *engine) ResumeJob(name string, id int, fromStage string) error {
+	if e.role != RoleMaster {
+		return fmt.Errorf("only master can resume job")
+	}
+	return e.master.resumeJob(name, id, fromStage)
+}
+
 func (e *engine) CancelJob(name string, id int) error {
 	if e.role != RoleMaster {
 		return fmt.Errorf("only master can cancel job")
@@ -169,6 +210,12 @@ func (e *engine) RegisterStatusChangeHook(hook func(message model.StatusChangeMe
 	e.master.registerStatusChangeHook(hook)
 }
 
+// RegisterTransitionHook 注册本节点执行器的状态变化回调，job、stage、step 的每一次状态变化都会调用
+func (e *engine) RegisterTransitionHook(hook func(event model.TransitionEvent)) {
+	logger.Infof("register transition hook")
+	e.worker.registerTransitionHook(hook)
+}
+
 func (e *engine) GetJobHistorys(name string, page, size int) (*model.JobDetailPage, error) {
 	return jober.JobDetailList(name, page, size)
 }
@@ -177,6 +224,21 @@ func (e *engine) GetJobHistoryLog(name string, id int) (*model.JobLog, error) {
 	return jober.GetJobLog(name, id)
 }
 
+// GetJobHistoryAttempts 列出 job detail 的每一次执行，最后一个是当前这次执行
+func (e *engine) GetJobHistoryAttempts(name string, id int) ([]model.JobAttempt, error) {
+	return jober.GetJobAttempts(name, id)
+}
+
+// GetJobHistoryAttempt 获取 job detail 某一次执行的详情
+func (e *engine) GetJobHistoryAttempt(name string, id, attempt int) (*model.JobDetail, error) {
+	return jober.GetJobAttempt(name, id, attempt)
+}
+
+// GetJobHistoryAttemptLog 获取 job detail 某一次执行的日志
+func (e *engine) GetJobHistoryAttemptLog(name string, id, attempt int) (*model.JobLog, error) {
+	return jober.GetJobAttemptLog(name, id, attempt)
+}
+
 func (e *engine) GetJobHistoryStageLog(name string, id int, stageName string, start int) (*model.JobStageLog, error) {
 	return jober.GetJobStageLog(name, id, stageName, start)
 }
@@ -188,6 +250,32 @@ func (e *engine) TerminalJob(name string, id int) error {
 	return e.master.cancelJob(name, id)
 }
 
+// ApproveStage 审批通过等待中的 stage，job 会继续执行
+func (e *engine) ApproveStage(name string, id int, stageName, approver, comment string) error {
+	if e.role != RoleMaster {
+		return fmt.Errorf("only master can approve stage")
+	}
+	return e.master.approveStage(name, id, model.ApprovalRecord{
+		Stage:    stageName,
+		Approver: approver,
+		Approved: true,
+		Comment:  comment,
+	})
+}
+
+// RejectStage 拒绝等待中的 stage，job 会以失败结束
+func (e *engine) RejectStage(name string, id int, stageName, approver, comment string) error {
+	if e.role != RoleMaster {
+		return fmt.Errorf("only master can reject stage")
+	}
+	return e.master.approveStage(name, id, model.ApprovalRecord{
+		Stage:    stageName,
+		Approver: approver,
+		Approved: false,
+		Comment:  comment,
+	})
+}
+
 func readLogLevelFromEnv() logrus.Level {
 	levelStr := os.Getenv("ALINE_LOG_LEVEL")
 	if levelStr == "" {
@@ -200,6 +288,162 @@ func readLogLevelFromEnv() logrus.Level {
 	return level
 }
 
+// readWorkerConcurrencyFromEnv worker 最多同时执行的任务数，默认为 CPU 核数
+func readWorkerConcurrencyFromEnv() int {
+	concurrencyStr := os.Getenv("ALINE_WORKER_CONCURRENCY")
+	if concurrencyStr == "" {
+		return runtime.NumCPU()
+	}
+	concurrency, err := strconv.Atoi(concurrencyStr)
+	if err != nil || concurrency < 1 {
+		logger.Warnf("invalid ALINE_WORKER_CONCURRENCY: %s, use default", concurrencyStr)
+		return runtime.NumCPU()
+	}
+	return concurrency
+}
+
+// readWorkspaceManagerFromEnv 工作目录的根目录和回收策略，没有配置时使用默认值
+// ALINE_WORKSPACE_ROOT: 根目录
+// ALINE_WORKSPACE_DELETE_ON_SUCCESS: 执行成功后是否删除工作目录，true/false
+// ALINE_WORKSPACE_KEEP_FAILED_HOURS: 执行失败的工作目录保留多少小时
+// ALINE_WORKSPACE_KEEP_LAST: 每个 job 最多保留多少个工作目录
+func readWorkspaceManagerFromEnv() *workspace.Manager {
+	root := os.Getenv("ALINE_WORKSPACE_ROOT")
+	if root == "" {
+		root = workspace.DefaultRoot()
+	}
+	policy := workspace.DefaultPolicy()
+	if v := os.Getenv("ALINE_WORKSPACE_DELETE_ON_SUCCESS"); v != "" {
+		deleteOnSuccess, err := strconv.ParseBool(v)
+		if err != nil {
+			logger.Warnf("invalid ALINE_WORKSPACE_DELETE_ON_SUCCESS: %s, use default", v)
+		} else {
+			policy.DeleteOnSuccess = deleteOnSuccess
+		}
+	}
+	if v := os.Getenv("ALINE_WORKSPACE_KEEP_FAILED_HOURS"); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err != nil || hours < 0 {
+			logger.Warnf("invalid ALINE_WORKSPACE_KEEP_FAILED_HOURS: %s, use default", v)
+		} else {
+			policy.KeepFailedFor = time.Duration(hours) * time.Hour
+		}
+	}
+	if v := os.Getenv("ALINE_WORKSPACE_KEEP_LAST"); v != "" {
+		keepLast, err := strconv.Atoi(v)
+		if err != nil || keepLast < 0 {
+			logger.Warnf("invalid ALINE_WORKSPACE_KEEP_LAST: %s, use default", v)
+		} else {
+			policy.KeepLast = keepLast
+		}
+	}
+	manager := workspace.NewManager(root, policy)
+	homeDir, _ := os.UserHomeDir()
+	manager.SetLegacyRoot(filepath.Join(homeDir, "workdir"))
+	return manager
+}
+
+// readCgroupRootFromEnv ALINE_CGROUP_ROOT: 为 step 创建 cgroup 的父目录，需要 worker 有写权限，为空时使用默认值
+func readCgroupRootFromEnv() string {
+	return os.Getenv("ALINE_CGROUP_ROOT")
+}
+
+// readSandboxConfigFromEnv 在宿主机上执行的 shell step 是否放进沙箱
+// ALINE_SANDBOX: true/false，默认不开启
+// ALINE_SANDBOX_ALLOW_NETWORK: 沙箱中允许访问的地址，逗号分隔，支持 host、host:port、*.domain、CIDR，为空时没有网络
+func readSandboxConfigFromEnv() sandbox.Config {
+	var config sandbox.Config
+	if v := os.Getenv("ALINE_SANDBOX"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			logger.Warnf("invalid ALINE_SANDBOX: %s, sandbox disabled", v)
+		}
+		config.Enabled = enabled
+	}
+	for _, rule := range strings.Split(os.Getenv("ALINE_SANDBOX_ALLOW_NETWORK"), ",") {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			config.AllowNetwork = append(config.AllowNetwork, rule)
+		}
+	}
+	return config
+}
+
+// readContainerRuntimeFromEnv 需要容器的 step 使用的容器运行时
+// ALINE_CONTAINER_RUNTIME: docker 或 podman，默认 docker
+// ALINE_CONTAINER_HOST: docker 为 Engine API 的 unix socket，默认使用 DOCKER_HOST 或 /var/run/docker.sock；podman 为可执行文件的路径
+func readContainerRuntimeFromEnv() container.Runtime {
+	config := container.Config{
+		Runtime: os.Getenv("ALINE_CONTAINER_RUNTIME"),
+		Host:    os.Getenv("ALINE_CONTAINER_HOST"),
+	}
+	rt, err := container.New(config)
+	if err != nil {
+		logger.Warnf("invalid ALINE_CONTAINER_RUNTIME: %s, use docker", err)
+		return container.NewDocker(config.Host)
+	}
+	return rt
+}
+
+// readSecretStoreFromEnv step 引用的密钥所在的目录
+// ALINE_SECRETS_DIR: 每个密钥一个文件，默认 ~/.aline/secrets
+func readSecretStoreFromEnv() secret.Store {
+	return secret.NewDirStore(os.Getenv("ALINE_SECRETS_DIR"))
+}
+
+// readRemoteActionConfigFromEnv remote action 的配置
+// ALINE_ACTION_CACHE_DIR: 仓库的缓存目录，默认 ~/.aline/actions
+// ALINE_ACTION_HOST: owner/repo 形式的 action 所在的 git 服务，默认 https://github.com
+// ALINE_ACTION_ALLOWLIST: 允许使用的 action 来源，逗号分隔，例如 github.com/hamster-shared/,gitlab.example.com/,./
+func readRemoteActionConfigFromEnv() action.RemoteActionConfig {
+	config := action.RemoteActionConfig{
+		CacheDir:    os.Getenv("ALINE_ACTION_CACHE_DIR"),
+		DefaultHost: os.Getenv("ALINE_ACTION_HOST"),
+	}
+	for _, entry := range strings.Split(os.Getenv("ALINE_ACTION_ALLOWLIST"), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			config.Allowlist = append(config.Allowlist, entry)
+		}
+	}
+	return config
+}
+
+// readMaxLogSizeFromEnv 每次执行最多保存的日志大小
+// ALINE_MAX_LOG_SIZE: 例如 512M、1G，0 表示不限制，默认 100M
+func readMaxLogSizeFromEnv() int64 {
+	v := os.Getenv("ALINE_MAX_LOG_SIZE")
+	if v == "" {
+		return output.DefaultMaxLogSize
+	}
+	if v == "0" {
+		return 0
+	}
+	size, err := model.ParseBytes(v)
+	if err != nil {
+		logger.Warnf("invalid ALINE_MAX_LOG_SIZE: %s, use default", v)
+		return output.DefaultMaxLogSize
+	}
+	return size
+}
+
+// readLogSearchIndexFromEnv 是否在日志写完后更新日志索引，加快搜索日志
+// ALINE_LOG_SEARCH_INDEX: true/false，默认不开启
+func readLogSearchIndexFromEnv() bool {
+	v := os.Getenv("ALINE_LOG_SEARCH_INDEX")
+	if v == "" {
+		return false
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		logger.Warnf("invalid ALINE_LOG_SEARCH_INDEX: %s, log search index disabled", v)
+	}
+	return enabled
+}
+
+// readPluginsDirFromEnv 插件 action 所在的目录，ALINE_PLUGINS_DIR 为空时使用 ~/.aline/plugins
+func readPluginsDirFromEnv() string {
+	return os.Getenv("ALINE_PLUGINS_DIR")
+}
+
 // GetCurrentJobStatus 获取当前任务的状态，不能获取历史任务的状态
 func (e *engine) GetCurrentJobStatus(jobName string, jobID int) (model.Status, error) {
 	if e.role == RoleWorker {
@@ -220,6 +464,23 @@ func (e *engine) GetJobHistoryStepLog(name string, id int, stageName string, ste
 	return jober.GetJobStepLog(name, id, stageName, stepName)
 }
 
+// StreamJobLog 实时日志，从日志文件的 fromOffset 处开始推送新写入的行，job 结束后推送结束消息并关闭 channel
+// ctx 取消后停止推送
+func (e *engine) StreamJobLog(ctx context.Context, name string, id int, fromOffset int64) (<-chan model.JobLogEvent, error) {
+	return jober.StreamJobLog(ctx, name, id, fromOffset)
+}
+
+// SearchLogs 在执行日志中搜索 query，返回匹配的行所在的执行记录、stage、step、行号和前后的内容
+func (e *engine) SearchLogs(query string, filter model.LogSearchFilter) ([]model.LogSearchResult, error) {
+	return jober.SearchLogs(query, filter)
+}
+
+// ExportJobLog 导出日志，format 为 output.ExportText（去掉 ANSI 控制序列的文本）、
+// output.ExportHTML（带颜色的 html）或 output.ExportArchive（包含原始日志、文本和 html 的 zip）
+func (e *engine) ExportJobLog(name string, id int, format string, w io.Writer) error {
+	return jober.ExportJobLog(name, id, format, w)
+}
+
 func (e *engine) GetWorkRootPath() string {
 	return utils.DefaultConfigDir()
 }
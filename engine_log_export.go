@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/hamster-shared/aline-engine/logger"
+	"github.com/hamster-shared/aline-engine/output"
+)
+
+// JobLogExporter 导出日志，Engine 实现了这个接口
+type JobLogExporter interface {
+	ExportJobLog(name string, id int, format string, w io.Writer) error
+}
+
+// NewJobLogExportHandler 下载日志的 http handler
+// 请求参数为 name、id 和 format，format 为 text、html 或 zip，默认为 html
+// zip 和带有 download 参数的请求作为附件下载，其他的直接在浏览器中显示
+func NewJobLogExportHandler(e JobLogExporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		name := query.Get("name")
+		id, err := strconv.Atoi(query.Get("id"))
+		if name == "" || err != nil {
+			http.Error(w, "name and id are required", http.StatusBadRequest)
+			return
+		}
+		format := query.Get("format")
+		if format == "" {
+			format = output.ExportHTML
+		}
+		switch format {
+		case output.ExportText, output.ExportHTML, output.ExportArchive:
+		default:
+			http.Error(w, "unsupported format: "+format, http.StatusBadRequest)
+			return
+		}
+
+		disposition := "inline"
+		if format == output.ExportArchive || query.Has("download") {
+			disposition = "attachment"
+		}
+		ew := &exportWriter{
+			ResponseWriter: w,
+			contentType:    output.ExportContentType(format),
+			disposition:    fmt.Sprintf("%s; filename=%q", disposition, output.ExportFilename(fmt.Sprintf("%s-%d.log", name, id), format)),
+		}
+		if err := e.ExportJobLog(name, id, format, ew); err != nil {
+			if !ew.wrote {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			logger.Errorf("export job log %s(%d) failed: %v", name, id, err)
+		}
+	})
+}
+
+// exportWriter 第一次写入时才设置响应头，导出失败时还可以返回错误信息
+type exportWriter struct {
+	http.ResponseWriter
+	contentType string
+	disposition string
+	wrote       bool
+}
+
+func (w *exportWriter) Write(p []byte) (int, error) {
+	if !w.wrote {
+		w.wrote = true
+		w.Header().Set("Content-Type", w.contentType)
+		w.Header().Set("Content-Disposition", w.disposition)
+	}
+	return w.ResponseWriter.Write(p)
+}
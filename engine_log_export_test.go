@@ -0,0 +1,56 @@
+package engine
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hamster-shared/aline-engine/output"
+	"gotest.tools/v3/assert"
+)
+
+type fakeLogExporter struct{}
+
+func (fakeLogExporter) ExportJobLog(name string, id int, format string, w io.Writer) error {
+	if name != "hello" {
+		return errors.New("log not found")
+	}
+	_, err := io.WriteString(w, format+" log")
+	return err
+}
+
+func TestJobLogExportHandler(t *testing.T) {
+	server := httptest.NewServer(NewJobLogExportHandler(fakeLogExporter{}))
+	defer server.Close()
+
+	get := func(query string) (*http.Response, string) {
+		resp, err := http.Get(server.URL + "?" + query)
+		assert.NilError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		assert.NilError(t, err)
+		return resp, string(body)
+	}
+
+	resp, body := get("name=hello&id=1")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "html log", body)
+	assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `inline; filename="hello-1.html"`, resp.Header.Get("Content-Disposition"))
+
+	resp, body = get("name=hello&id=1&format=" + output.ExportArchive)
+	assert.Equal(t, "zip log", body)
+	assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="hello-1.zip"`, resp.Header.Get("Content-Disposition"))
+
+	resp, _ = get("name=hello&id=1&format=text&download")
+	assert.Equal(t, `attachment; filename="hello-1.txt"`, resp.Header.Get("Content-Disposition"))
+
+	resp, _ = get("name=hello&id=1&format=pdf")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = get("name=missing&id=1")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get("Content-Disposition"))
+}
@@ -0,0 +1,101 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/model"
+)
+
+// JobLogStreamer 提供实时日志，Engine 实现了这个接口
+type JobLogStreamer interface {
+	StreamJobLog(ctx context.Context, name string, id int, fromOffset int64) (<-chan model.JobLogEvent, error)
+}
+
+// logStreamKeepAlive 没有新日志时发送注释行的间隔，避免连接被代理断开
+var logStreamKeepAlive = 15 * time.Second
+
+// NewJobLogHandler 以 Server-Sent Events 推送实时日志的 http handler
+// 请求参数为 name、id 和可选的 offset，浏览器断开重连时带上的 Last-Event-ID 优先于 offset
+// 每行日志是一条 log 事件，事件的 id 为下一行在日志中的位置；日志重新开始时是一条 reset 事件，之前收到的内容应当丢弃
+// job 结束后是一条 end 事件，data 中包含 job 的状态，之后服务端关闭连接
+func NewJobLogHandler(s JobLogStreamer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		name := query.Get("name")
+		id, err := strconv.Atoi(query.Get("id"))
+		if name == "" || err != nil {
+			http.Error(w, "name and id are required", http.StatusBadRequest)
+			return
+		}
+		offset := query.Get("offset")
+		if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+			offset = lastEventID
+		}
+		var from int64
+		if offset != "" {
+			if from, err = strconv.ParseInt(offset, 10, 64); err != nil {
+				http.Error(w, "invalid offset", http.StatusBadRequest)
+				return
+			}
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		events, err := s.StreamJobLog(r.Context(), name, id, from)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		header := w.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		header.Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(logStreamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				writeLogEvent(w, event)
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+func writeLogEvent(w http.ResponseWriter, event model.JobLogEvent) {
+	var name string
+	var data interface{}
+	switch {
+	case event.End:
+		name = "end"
+		data = map[string]interface{}{"status": event.Status.ToString(), "offset": event.Offset}
+	case event.Reset:
+		name = "reset"
+		data = map[string]interface{}{"offset": event.Offset}
+	default:
+		name = "log"
+		data = event.Line
+	}
+	payload, _ := json.Marshal(data)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Offset, name, payload)
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+	"gotest.tools/v3/assert"
+)
+
+type fakeLogStreamer struct {
+	from int64
+}
+
+func (s *fakeLogStreamer) StreamJobLog(ctx context.Context, name string, id int, fromOffset int64) (<-chan model.JobLogEvent, error) {
+	s.from = fromOffset
+	events := make(chan model.JobLogEvent, 3)
+	ts := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	events <- model.JobLogEvent{Line: &output.Entry{Time: ts, Stage: "build", Step: "echo", Stream: output.StreamStdout, Text: "hello"}, Offset: 120}
+	events <- model.JobLogEvent{Offset: 0, Reset: true}
+	events <- model.JobLogEvent{Offset: 200, End: true, Status: model.STATUS_SUCCESS}
+	close(events)
+	return events, nil
+}
+
+func TestJobLogHandler(t *testing.T) {
+	streamer := &fakeLogStreamer{}
+	server := httptest.NewServer(NewJobLogHandler(streamer))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"?name=hello&id=1&offset=10", nil)
+	req.Header.Set("Last-Event-ID", "100")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NilError(t, err)
+
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, int64(100), streamer.from)
+	assert.Equal(t, strings.Join([]string{
+		`id: 120`,
+		`event: log`,
+		`data: {"ts":"2023-01-01T00:00:00Z","stage":"build","step":"echo","stream":"stdout","text":"hello"}`,
+		``,
+		`id: 0`,
+		`event: reset`,
+		`data: {"offset":0}`,
+		``,
+		`id: 200`,
+		`event: end`,
+		`data: {"offset":200,"status":"success"}`,
+		``,
+		``,
+	}, "\n"), string(body))
+
+	resp, err = http.Get(server.URL + "?name=hello")
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"errors"
 	"fmt"
 	"path/filepath"
 	"sync"
@@ -20,8 +21,12 @@ type masterEngine struct {
 	rpcServer        *server.AlineGrpcServer
 	statusChangeChan chan model.StatusChangeMessage
 	jobStatusMap     sync.Map // key: jobname(id), value: jobStatus
+	pendingJobs      sync.Map // key: jobname(id), value: struct{} // 所有节点都满载时在 master 上等待分发的任务
 }
 
+// pendingDispatchInterval 所有节点都满载时，每隔多久重新尝试分发
+const pendingDispatchInterval = time.Second * 3
+
 func newMasterEngine(listenAddress string) (*masterEngine, error) {
 	e := &masterEngine{}
 	e.statusChangeChan = make(chan model.StatusChangeMessage, 100)
@@ -55,8 +60,9 @@ func (e *masterEngine) handleGrpcServerMessage() {
 			case api.MessageType_REGISTER:
 				// 1 注册
 				err := e.dispatch.Register(&model.Node{
-					Name:    msg.Name,
-					Address: msg.Address,
+					Name:     msg.Name,
+					Address:  msg.Address,
+					Capacity: nodeCapacity(msg.Capacity),
 				})
 				if err != nil {
 					logger.Errorf("register node error: %v", err)
@@ -78,10 +84,12 @@ func (e *masterEngine) handleGrpcServerMessage() {
 
 			case api.MessageType_HEARTBEAT:
 				// 3 心跳
-				err := e.dispatch.Ping(&model.Node{
-					Name:    msg.Name,
-					Address: msg.Address,
-				})
+				node := &model.Node{
+					Name:     msg.Name,
+					Address:  msg.Address,
+					Capacity: nodeCapacity(msg.Capacity),
+				}
+				err := e.dispatch.Ping(node)
 				if err != nil {
 					logger.Errorf("node ping error: %v", err)
 				} else {
@@ -98,11 +106,27 @@ func (e *masterEngine) handleGrpcServerMessage() {
 				if err != nil {
 					logger.Errorf("IntToStatus error: %v", err)
 				}
+				if status == model.STATUS_QUEUED {
+					e.markJobQueued(msg.Result.JobName, int(msg.Result.JobID))
+				}
+				if status == model.STATUS_FAIL && msg.Result.Error == model.WorkerRestartedError {
+					if e.retryInterruptedJob(msg.Result.JobName, int(msg.Result.JobID)) {
+						continue
+					}
+				}
+				if status.IsTerminal() {
+					// 远程 worker 的日志在结果之前已经回传
+					go func(name string, id int) {
+						if err := jober.IndexJobLog(name, id); err != nil {
+							logger.Errorf("index job log error: %v", err)
+						}
+					}(msg.Result.JobName, int(msg.Result.JobID))
+				}
 				e.statusChangeChan <- model.NewStatusChangeMsg(msg.Result.JobName, int(msg.Result.JobID), status)
 
 			case api.MessageType_LOG:
-				// 7 接收到任务的执行日志和修改了的 job detail，保存起来
-				err := jober.SaveJobLogString(msg.ExecReq.Name, int(msg.ExecReq.JobDetailId), msg.Log)
+				// 7 接收到任务新增的执行日志和修改了的 job detail，保存起来
+				size, err := jober.AppendJobLog(msg.ExecReq.Name, int(msg.ExecReq.JobDetailId), msg.LogOffset, msg.Log)
 				if err != nil {
 					logger.Errorf("save job log error: %s", err)
 				}
@@ -110,9 +134,23 @@ func (e *masterEngine) handleGrpcServerMessage() {
 				if err != nil {
 					logger.Errorf("save job detail error: %s", err)
 				}
+				// 告诉 worker 已经保存的日志长度，worker 从这里继续发送
+				e.rpcServer.SendMsgChan <- &api.AlineMessage{
+					Type:    api.MessageType_LOG,
+					Name:    msg.Name,
+					Address: msg.Address,
+					ExecReq: &api.ExecuteReq{
+						Name:        msg.ExecReq.Name,
+						JobDetailId: msg.ExecReq.JobDetailId,
+					},
+					LogOffset: size,
+				}
 			case api.MessageType_ERROR:
 				// 8 接收到任务的执行错误信息
 				logger.Debugf("grpc server recv message: %v", msg)
+				if msg.Error != "" {
+					logger.Errorf("worker %s@%s error: %s", msg.Name, msg.Address, msg.Error)
+				}
 			case api.MessageType_FILE:
 				// 9 接收到文件
 				logger.Debugf("grpc server recv file message, file name: %s", msg.File.Path)
@@ -131,6 +169,56 @@ func (e *masterEngine) handleGrpcServerMessage() {
 	}()
 }
 
+// markJobQueued worker 没有空闲的槽位，任务在 worker 本地排队
+func (e *masterEngine) markJobQueued(name string, id int) {
+	jobDetail, err := jober.GetJobDetail(name, id)
+	if err != nil {
+		logger.Errorf("get job detail error: %s", err)
+		return
+	}
+	if jobDetail.Status != model.STATUS_NOTRUN {
+		return
+	}
+	jobDetail.Status = model.STATUS_QUEUED
+	err = jober.SaveJobDetail(name, jobDetail)
+	if err != nil {
+		logger.Errorf("save job detail error: %s", err)
+	}
+}
+
+// retryInterruptedJob worker 重启导致任务失败，按照 job 的重试策略重新分发，返回是否重新分发了
+func (e *masterEngine) retryInterruptedJob(name string, id int) bool {
+	// 只在 worker 上排过队的任务，worker 那边没有 job detail，在这里标记为失败
+	jobDetail, err := jober.GetJobDetail(name, id)
+	if err != nil {
+		logger.Errorf("get job detail error: %s", err)
+		return false
+	}
+	if !jobDetail.Status.IsTerminal() {
+		jobDetail, err = jober.MakeJobFail(name, id, model.WorkerRestartedError)
+		if err != nil {
+			logger.Errorf("mark job fail error: %s", err)
+			return false
+		}
+	}
+	job, err := jober.GetJobObject(name)
+	if err != nil {
+		logger.Errorf("get job error: %s", err)
+		return false
+	}
+	if !job.Retry.ShouldRetry(jobDetail.CurrentAttempt(), model.WorkerRestartedError) {
+		return false
+	}
+	logger.Infof("job %s(%d) interrupted by worker restart, retry attempt %d", name, id, jobDetail.CurrentAttempt()+1)
+	go func() {
+		if err := e.dispatchJob(name, id); err != nil {
+			logger.Errorf("retry job %s(%d) error: %s", name, id, err)
+			e.statusChangeChan <- model.NewStatusChangeMsg(name, id, model.STATUS_FAIL)
+		}
+	}()
+	return true
+}
+
 func (e *masterEngine) saveFile(msg *api.File) error {
 	jobsDir := jober.GetJobsDir()
 	return jober.SaveFile(filepath.Join(jobsDir, msg.Path), msg.Data)
@@ -161,10 +249,45 @@ func (e *masterEngine) handleGrpcServerError() {
 
 // dispatchJob 分发任务
 func (e *masterEngine) dispatchJob(name string, id int) error {
+	return e.dispatchJobWith(name, id, nil)
+}
+
+// resumeJob 从上一次执行失败的 stage 恢复执行，上一次执行的 job detail 会一起发给 worker
+func (e *masterEngine) resumeJob(name string, id int, fromStage string) error {
+	previous, err := jober.GetJobDetail(name, id)
+	if err != nil {
+		return err
+	}
+	if !previous.Status.IsTerminal() || previous.Status == model.STATUS_SUCCESS {
+		return fmt.Errorf("job %s(%d) can not resume, status: %s", name, id, previous.Status.ToString())
+	}
+	job, err := jober.GetJobObject(name)
+	if err != nil {
+		return err
+	}
+	// 先在 master 上校验能否恢复，避免发给 worker 之后才失败
+	if _, _, err := job.ResumeStages(previous, fromStage); err != nil {
+		return err
+	}
+	previousString, err := jober.ReadStringJobDetail(name, id)
+	if err != nil {
+		return err
+	}
+	return e.dispatchJobWith(name, id, func(req *api.ExecuteReq) {
+		req.ResumeStage = fromStage
+		req.PreviousDetail = previousString
+	})
+}
+
+func (e *masterEngine) dispatchJobWith(name string, id int, decorate func(req *api.ExecuteReq)) error {
 	var node *model.Node
 	var err error
 	for retry := 0; retry < 3; retry++ {
 		node, err = e.dispatch.DispatchNode()
+		if errors.Is(err, dispatcher.ErrAllNodesFull) {
+			e.waitForNode(name, id, decorate)
+			return nil
+		}
 		if err != nil {
 			logger.Errorf("dispatch node error: %s, retry counter: %d", err.Error(), retry)
 			time.Sleep(time.Second * 3)
@@ -176,16 +299,70 @@ func (e *masterEngine) dispatchJob(name string, id int) error {
 	if err != nil {
 		return err
 	}
+	return e.sendJob(name, id, node, decorate)
+}
+
+// sendJob 把任务发给选好的节点
+func (e *masterEngine) sendJob(name string, id int, node *model.Node, decorate func(req *api.ExecuteReq)) error {
 	jobYamlString, err := jober.GetJob(name)
 	if err != nil {
 		return err
 	}
-	e.rpcServer.SendMsgChan <- e.dispatch.SendJob(name, jobYamlString, id, node)
+	// 同一个 job detail 每次分发都是新的一次执行，之前的执行记录会被归档
+	attempt, err := jober.NewJobAttempt(name, id)
+	if err != nil {
+		return err
+	}
+	msg := e.dispatch.SendJob(name, jobYamlString, id, node)
+	msg.ExecReq.Attempt = int64(attempt)
+	if decorate != nil {
+		decorate(msg.ExecReq)
+	}
+	e.rpcServer.SendMsgChan <- msg
 	return nil
 }
 
+// waitForNode 所有节点都满载，任务留在 master 上，等有节点空闲后再分发
+func (e *masterEngine) waitForNode(name string, id int, decorate func(req *api.ExecuteReq)) {
+	key := utils.FormatJobToString(name, id)
+	if _, loaded := e.pendingJobs.LoadOrStore(key, struct{}{}); !loaded {
+		logger.Infof("all nodes are full, job %s(%d) is waiting on master", name, id)
+	}
+	go func() {
+		time.Sleep(pendingDispatchInterval)
+		// 等待的过程中被取消了
+		if _, ok := e.pendingJobs.Load(key); !ok {
+			return
+		}
+		node, err := e.dispatch.DispatchNode()
+		if errors.Is(err, dispatcher.ErrAllNodesFull) {
+			e.waitForNode(name, id, decorate)
+			return
+		}
+		e.pendingJobs.Delete(key)
+		if err == nil {
+			err = e.sendJob(name, id, node, decorate)
+		}
+		if err != nil {
+			logger.Errorf("dispatch waiting job %s(%d) error: %s", name, id, err)
+			if _, err := jober.MakeJobFail(name, id, err.Error()); err != nil {
+				logger.Errorf("mark job fail error: %s", err)
+			}
+			e.statusChangeChan <- model.NewStatusChangeMsg(name, id, model.STATUS_FAIL)
+		}
+	}()
+}
+
 // 取消任务
 func (e *masterEngine) cancelJob(name string, id int) error {
+	// 还没有分发给 worker 的任务直接在 master 上停止
+	if _, ok := e.pendingJobs.LoadAndDelete(utils.FormatJobToString(name, id)); ok {
+		if err := jober.MakeJobStop(name, id, "cancelled before dispatch"); err != nil {
+			return err
+		}
+		e.statusChangeChan <- model.NewStatusChangeMsg(name, id, model.STATUS_STOP)
+		return nil
+	}
 	msg, err := e.dispatch.CancelJob(name, id)
 	if err != nil {
 		return err
@@ -194,6 +371,40 @@ func (e *masterEngine) cancelJob(name string, id int) error {
 	return nil
 }
 
+// 审批等待中的 stage，先在 master 上校验，再发给执行该任务的 worker
+func (e *masterEngine) approveStage(name string, id int, record model.ApprovalRecord) error {
+	jobDetail, err := jober.GetJobDetail(name, id)
+	if err != nil {
+		return err
+	}
+	if jobDetail.Status != model.STATUS_WAITING_APPROVAL {
+		return &model.ApprovalError{JobName: name, JobID: id, Stage: record.Stage, Reason: "job is not waiting for approval"}
+	}
+	var stage *model.StageDetail
+	for i := range jobDetail.Stages {
+		if jobDetail.Stages[i].Name == record.Stage {
+			stage = &jobDetail.Stages[i]
+		}
+	}
+	if stage == nil || stage.Status != model.STATUS_WAITING_APPROVAL || stage.Stage.Approval == nil {
+		return &model.ApprovalError{JobName: name, JobID: id, Stage: record.Stage, Reason: "stage is not waiting for approval"}
+	}
+	if !stage.Stage.Approval.CanApprove(record.Approver) {
+		return &model.ApprovalError{JobName: name, JobID: id, Stage: record.Stage, Reason: fmt.Sprintf("%s is not an approver", record.Approver)}
+	}
+	msg, err := e.dispatch.ApproveJob(name, id, &api.Approval{
+		Stage:    record.Stage,
+		Approver: record.Approver,
+		Approved: record.Approved,
+		Comment:  record.Comment,
+	})
+	if err != nil {
+		return err
+	}
+	e.rpcServer.SendMsgChan <- msg
+	return nil
+}
+
 func (e *masterEngine) registerStatusChangeHook(hook func(message model.StatusChangeMessage)) {
 	if hook != nil {
 		logger.Debugf("register status change hook")
@@ -224,6 +435,19 @@ func (e *masterEngine) getJobStatus(name string, id int) (model.Status, error) {
 	return model.STATUS_NOTRUN, fmt.Errorf("get job status timeout")
 }
 
+// nodeCapacity 转换 worker 上报的执行能力，没有上报时返回 nil
+func nodeCapacity(capacity *api.Capacity) *model.NodeCapacity {
+	if capacity == nil {
+		return nil
+	}
+	return &model.NodeCapacity{
+		Max:     int(capacity.Max),
+		Running: int(capacity.Running),
+		Queued:  int(capacity.Queued),
+		Actions: capacity.Actions,
+	}
+}
+
 func convertJobStatus(status api.JobStatus) model.Status {
 	switch status {
 	case api.JobStatus_NOTRUN:
@@ -236,6 +460,16 @@ func convertJobStatus(status api.JobStatus) model.Status {
 		return model.STATUS_SUCCESS
 	case api.JobStatus_STOP:
 		return model.STATUS_STOP
+	case api.JobStatus_QUEUED:
+		return model.STATUS_QUEUED
+	case api.JobStatus_SKIPPED:
+		return model.STATUS_SKIPPED
+	case api.JobStatus_CANCELLED:
+		return model.STATUS_CANCELLED
+	case api.JobStatus_TIMEOUT:
+		return model.STATUS_TIMEOUT
+	case api.JobStatus_WAITING_APPROVAL:
+		return model.STATUS_WAITING_APPROVAL
 	default:
 		return model.STATUS_NOTRUN
 	}
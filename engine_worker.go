@@ -1,17 +1,22 @@
 package engine
 
 import (
-	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/hamster-shared/aline-engine/action"
+	"github.com/hamster-shared/aline-engine/container"
 	"github.com/hamster-shared/aline-engine/executor"
 	"github.com/hamster-shared/aline-engine/grpc/api"
 	grpcClient "github.com/hamster-shared/aline-engine/grpc/client"
 	jober "github.com/hamster-shared/aline-engine/job"
 	"github.com/hamster-shared/aline-engine/logger"
 	"github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+	"github.com/hamster-shared/aline-engine/sandbox"
+	"github.com/hamster-shared/aline-engine/secret"
 	"github.com/hamster-shared/aline-engine/utils"
 )
 
@@ -20,7 +25,10 @@ type workerEngine struct {
 	masterAddress string
 	executeClient *executor.ExecutorClient
 	rpcClient     *grpcClient.AlineGrpcClient
-	doneJobList   sync.Map
+	// 正在回传日志的任务，key 为 utils.FormatJobToString，value 为 *jobLogSender
+	logSenders sync.Map
+	// worker 上注册的插件 action，注册和心跳时上报给 master
+	actions []string
 }
 
 func newWorkerEngine(masterAddress string) (*workerEngine, error) {
@@ -32,7 +40,21 @@ func newWorkerEngine(masterAddress string) (*workerEngine, error) {
 		e.address, _ = utils.GetMyIP()
 	}
 	e.masterAddress = masterAddress
-	e.executeClient = executor.NewExecutorClient()
+	utils.SetCgroupRoot(readCgroupRootFromEnv())
+	sandbox.Configure(readSandboxConfigFromEnv())
+	container.SetDefault(readContainerRuntimeFromEnv())
+	secret.Configure(readSecretStoreFromEnv())
+	output.SetMaxLogSize(readMaxLogSizeFromEnv())
+	jober.SetLogSearchIndex(readLogSearchIndexFromEnv())
+	action.ConfigureRemoteActions(readRemoteActionConfigFromEnv())
+	actions, err := action.LoadPlugins(readPluginsDirFromEnv())
+	if err != nil {
+		logger.Errorf("load plugins error: %v", err)
+	} else if len(actions) > 0 {
+		logger.Infof("loaded plugins: %s", strings.Join(actions, ", "))
+	}
+	e.actions = actions
+	e.executeClient = executor.NewExecutorClient(readWorkerConcurrencyFromEnv(), readWorkspaceManagerFromEnv())
 
 	rpcClient, err := grpcClient.GrpcClientStart(masterAddress)
 	if err != nil {
@@ -43,6 +65,8 @@ func newWorkerEngine(masterAddress string) (*workerEngine, error) {
 	e.handleGrpcMessage()
 	e.register()
 	e.keepAlive()
+	e.reportJobProgress()
+	e.reportRecoveredJobs()
 
 	e.executeClient.Main()
 	e.handleDoneJob()
@@ -61,8 +85,12 @@ func (e *workerEngine) handleGrpcMessage() {
 			case api.MessageType_EXECUTE:
 				// 4 接收到 master 节点的执行任务
 				logger.Tracef("worker engine receive execute job message: %v", msg)
-				e.executeClient.QueueChan <- model.NewStartQueueMsg(msg.ExecReq.Name, msg.ExecReq.PipelineFile, int(msg.ExecReq.JobDetailId))
-				e.sendLogJobDetail(msg)
+				queueMsg := model.NewStartQueueMsg(msg.ExecReq.Name, msg.ExecReq.PipelineFile, int(msg.ExecReq.JobDetailId))
+				queueMsg.PreviousDetail = msg.ExecReq.PreviousDetail
+				queueMsg.ResumeStage = msg.ExecReq.ResumeStage
+				queueMsg.Attempt = int(msg.ExecReq.Attempt)
+				queueMsg.Worker = msg.Name + "@" + msg.Address
+				e.executeClient.QueueChan <- queueMsg
 
 			case api.MessageType_CANCEL:
 				// 5 接收到 master 节点的取消任务
@@ -73,6 +101,17 @@ func (e *workerEngine) handleGrpcMessage() {
 				// master 询问 job 状态
 				logger.Tracef("worker engine receive status job message: %v", msg)
 				e.sendJobStatus(msg)
+
+			case api.MessageType_APPROVAL:
+				// master 发来的 stage 审批结果
+				logger.Tracef("worker engine receive approval message: %v", msg)
+				e.approve(msg)
+
+			case api.MessageType_LOG:
+				// master 已经保存的日志长度
+				if value, ok := e.logSenders.Load(utils.FormatJobToString(msg.ExecReq.Name, int(msg.ExecReq.JobDetailId))); ok {
+					value.(*jobLogSender).ack(msg.LogOffset)
+				}
 			}
 		}
 	}()
@@ -81,27 +120,69 @@ func (e *workerEngine) handleGrpcMessage() {
 // 向 master 注册自己
 func (e *workerEngine) register() {
 	e.rpcClient.SendMsgChan <- &api.AlineMessage{
-		Type:    api.MessageType_REGISTER,
-		Name:    e.name,
-		Address: e.address,
+		Type:     api.MessageType_REGISTER,
+		Name:     e.name,
+		Address:  e.address,
+		Capacity: e.capacityMessage(e.executeClient.Capacity()),
 	}
 	logger.Trace("worker engine register success")
 }
 
-// 向 master 定时发送心跳
+// 向 master 定时发送心跳，执行能力变化时也会立即发送
 func (e *workerEngine) keepAlive() {
+	e.executeClient.OnCapacityChange(func(capacity model.NodeCapacity) {
+		e.sendHeartbeat(capacity)
+	})
 	go func() {
 		for {
 			time.Sleep(time.Second * 30)
+			e.sendHeartbeat(e.executeClient.Capacity())
+			logger.Trace("worker engine send ping message")
+			logger.Tracef("length of send message channel: %d", len(e.rpcClient.SendMsgChan))
+		}
+	}()
+}
+
+func (e *workerEngine) sendHeartbeat(capacity model.NodeCapacity) {
+	e.rpcClient.SendMsgChan <- &api.AlineMessage{
+		Type:     api.MessageType_HEARTBEAT,
+		Name:     e.name,
+		Address:  e.address,
+		Capacity: e.capacityMessage(capacity),
+	}
+}
+
+func (e *workerEngine) capacityMessage(capacity model.NodeCapacity) *api.Capacity {
+	return &api.Capacity{
+		Max:     int64(capacity.Max),
+		Running: int64(capacity.Running),
+		Queued:  int64(capacity.Queued),
+		Actions: e.actions,
+	}
+}
+
+// reportJobProgress 任务排队时通知 master，任务开始执行后开始回传日志
+func (e *workerEngine) reportJobProgress() {
+	e.executeClient.OnTransition(func(event model.TransitionEvent) {
+		if event.Kind != model.TransitionJob {
+			return
+		}
+		switch {
+		case event.To == model.STATUS_QUEUED:
 			e.rpcClient.SendMsgChan <- &api.AlineMessage{
-				Type:    api.MessageType_HEARTBEAT,
+				Type:    api.MessageType_RESULT,
 				Name:    e.name,
 				Address: e.address,
+				Result: &api.ExecuteResult{
+					JobName:   event.JobName,
+					JobID:     int64(event.JobId),
+					JobStatus: int64(model.STATUS_QUEUED),
+				},
 			}
-			logger.Trace("worker engine send ping message")
-			logger.Tracef("length of send message channel: %d", len(e.rpcClient.SendMsgChan))
+		case event.To == model.STATUS_RUNNING && event.From != model.STATUS_WAITING_APPROVAL:
+			e.sendLogJobDetail(event.JobName, event.JobId)
 		}
-	}()
+	})
 }
 
 func (e *workerEngine) handleDoneJob() {
@@ -109,12 +190,15 @@ func (e *workerEngine) handleDoneJob() {
 		for {
 			statusChan := e.executeClient.GetStatusChangeChan()
 			jobResultStatus := <-statusChan
-			e.doneJobList.Store(utils.FormatJobToString(jobResultStatus.JobName, jobResultStatus.JobId), struct{}{})
 			logger.Debugf("job %s-%d done, status: %s", jobResultStatus.JobName, jobResultStatus.JobId, jobResultStatus.Status.ToString())
 			if e.address != "127.0.0.1" {
-				// 回传日志
-				logMsg, _ := e.getLogAndJobDetailMessage(jobResultStatus.JobName, jobResultStatus.JobId)
-				e.rpcClient.SendMsgChan <- logMsg
+				// 回传剩余的日志
+				// 排队中被取消的任务没有开始回传日志，也没有日志
+				key := utils.FormatJobToString(jobResultStatus.JobName, jobResultStatus.JobId)
+				if value, ok := e.logSenders.Load(key); ok {
+					e.flushJobLog(value.(*jobLogSender))
+					e.logSenders.Delete(key)
+				}
 
 				// 回传 report
 				reports, err := jober.GetJobCheckFilesData(jobResultStatus.JobName, strconv.Itoa(jobResultStatus.JobId))
@@ -160,68 +244,122 @@ func (e *workerEngine) handleDoneJob() {
 	}()
 }
 
-// 回传日志和 job detail
-func (e *workerEngine) sendLogJobDetail(msg *api.AlineMessage) {
+// reportRecoveredJobs 上一次运行时 worker 异常退出，把被中断的任务标记为失败并通知 master
+func (e *workerEngine) reportRecoveredJobs() {
+	for _, recovered := range e.executeClient.Reconcile() {
+		if e.address != "127.0.0.1" {
+			// 不知道 master 已经保存了多少，从头开始，master 会跳过已经保存的部分
+			key := utils.FormatJobToString(recovered.JobName, recovered.JobId)
+			sender := newJobLogSender(recovered.JobName, recovered.JobId)
+			e.logSenders.Store(key, sender)
+			e.flushJobLog(sender)
+			e.logSenders.Delete(key)
+		}
+		e.rpcClient.SendMsgChan <- &api.AlineMessage{
+			Type:    api.MessageType_RESULT,
+			Name:    e.name,
+			Address: e.address,
+			Result: &api.ExecuteResult{
+				JobName:   recovered.JobName,
+				JobID:     int64(recovered.JobId),
+				JobStatus: int64(recovered.Status),
+				Error:     model.WorkerRestartedError,
+			},
+		}
+		logger.Infof("recovered job %s-%d reported to master", recovered.JobName, recovered.JobId)
+	}
+}
+
+// 回传日志和 job detail，每次只发送 master 确认保存的位置之后新增的日志
+func (e *workerEngine) sendLogJobDetail(jobName string, jobID int) {
 	if e.address == "127.0.0.1" {
 		return
 	}
+	sender := newJobLogSender(jobName, jobID)
+	e.logSenders.Store(utils.FormatJobToString(jobName, jobID), sender)
 	go func() {
 		errorCounter := 0
+		ticker := time.NewTicker(logSendInterval)
+		defer ticker.Stop()
 		for {
-			// 检查是否已经完成
-			doneJobKey := utils.FormatJobToString(msg.ExecReq.Name, int(msg.ExecReq.JobDetailId))
-			if _, ok := e.doneJobList.Load(doneJobKey); ok {
-				e.doneJobList.Delete(doneJobKey)
+			select {
+			case <-sender.done:
 				return
+			case <-ticker.C:
 			}
 
-			logMsg, err := e.getLogAndJobDetailMessage(msg.ExecReq.Name, int(msg.ExecReq.JobDetailId))
+			logMsg, err := sender.next(e.name, e.address)
 			if err != nil {
 				if errorCounter > 10 {
-					logger.Errorf("get job log string error: %v", err)
+					logger.Errorf("get job log error: %v", err)
 					return
 				}
-				// 刚建立任务的时候，可能日志还没出来，错误是正常的，先等一会儿
+				// 刚建立任务的时候，job detail 可能还没有保存，错误是正常的，等下一次
 				errorCounter++
-				time.Sleep(time.Millisecond * 500)
 				continue
 			}
-			e.rpcClient.SendMsgChan <- logMsg
-
-			// 0.5s 发送一次日志
-			time.Sleep(time.Millisecond * 500)
+			if logMsg != nil {
+				e.rpcClient.SendMsgChan <- logMsg
+			}
 		}
 	}()
 }
 
-func (e *workerEngine) getLogAndJobDetailMessage(jobName string, jobID int) (*api.AlineMessage, error) {
-	logString, err := jober.GetJobLogString(jobName, jobID)
-	if err != nil {
-		logger.Errorf("get job log string error: %v", err)
-		return nil, fmt.Errorf("get job log string error: %v", err)
-	}
-	jobDetailString, err := jober.ReadStringJobDetail(jobName, jobID)
-	if err != nil {
-		logger.Errorf("get job detail string failed: %s", err)
-		return nil, fmt.Errorf("get job detail string failed: %s", err)
+// flushJobLog 停止定时回传，把剩余的日志和最终的 job detail 发给 master，等到 master 全部保存或者超时
+func (e *workerEngine) flushJobLog(sender *jobLogSender) {
+	close(sender.done)
+	deadline := time.Now().Add(logFlushTimeout)
+	sent := false
+	for !sent || !sender.synced() {
+		if time.Now().After(deadline) {
+			logger.Warnf("flush job %s-%d log timeout", sender.jobName, sender.jobID)
+			return
+		}
+		logMsg, err := sender.next(e.name, e.address)
+		if err != nil {
+			logger.Errorf("get job log error: %v", err)
+			return
+		}
+		if logMsg != nil {
+			e.rpcClient.SendMsgChan <- logMsg
+			sent = true
+		}
+		select {
+		case <-sender.acks:
+		case <-time.After(logSendInterval):
+		}
 	}
-	return &api.AlineMessage{
-		Type:    api.MessageType_LOG,
-		Name:    e.name,
-		Address: e.address,
-		ExecReq: &api.ExecuteReq{
-			Name:         jobName,
-			JobDetailId:  int64(jobID),
-			PipelineFile: jobDetailString,
-		},
-		Log: logString,
-	}, nil
 }
 
 func (e *workerEngine) GetJobStatus(jobName string, jobID int) (model.Status, error) {
 	return e.executeClient.GetJobStatus(jobName, jobID)
 }
 
+func (e *workerEngine) approve(msg *api.AlineMessage) {
+	err := e.executeClient.Approve(msg.ExecReq.Name, int(msg.ExecReq.JobDetailId), model.ApprovalRecord{
+		Stage:    msg.Approval.Stage,
+		Approver: msg.Approval.Approver,
+		Approved: msg.Approval.Approved,
+		Comment:  msg.Approval.Comment,
+	})
+	if err != nil {
+		logger.Errorf("approve job %s-%d stage %s error: %s", msg.ExecReq.Name, msg.ExecReq.JobDetailId, msg.Approval.Stage, err)
+		e.rpcClient.SendMsgChan <- &api.AlineMessage{
+			Type:    api.MessageType_ERROR,
+			Name:    e.name,
+			Address: e.address,
+			ExecReq: msg.ExecReq,
+			Error:   err.Error(),
+		}
+	}
+}
+
+func (e *workerEngine) registerTransitionHook(hook func(event model.TransitionEvent)) {
+	if hook != nil {
+		e.executeClient.OnTransition(hook)
+	}
+}
+
 func (e *workerEngine) sendJobStatus(msg *api.AlineMessage) {
 	status, err := e.GetJobStatus(msg.ExecReq.Name, int(msg.ExecReq.JobDetailId))
 	if err != nil {
@@ -252,6 +390,16 @@ func convertStatus(status model.Status) api.JobStatus {
 		return api.JobStatus_SUCCESS
 	case model.STATUS_STOP:
 		return api.JobStatus_STOP
+	case model.STATUS_QUEUED:
+		return api.JobStatus_QUEUED
+	case model.STATUS_SKIPPED:
+		return api.JobStatus_SKIPPED
+	case model.STATUS_CANCELLED:
+		return api.JobStatus_CANCELLED
+	case model.STATUS_TIMEOUT:
+		return api.JobStatus_TIMEOUT
+	case model.STATUS_WAITING_APPROVAL:
+		return api.JobStatus_WAITING_APPROVAL
 	}
 	return api.JobStatus_NOTRUN
 }
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/grpc/api"
+	jober "github.com/hamster-shared/aline-engine/job"
+)
+
+// maxLogChunk 一条日志消息最多携带的日志字节数
+const maxLogChunk = 1 << 20
+
+var (
+	// logSendInterval 回传日志的间隔
+	logSendInterval = 500 * time.Millisecond
+	// logAckTimeout 超过这个时间没有收到 master 的回复，重新发送
+	logAckTimeout = 10 * time.Second
+	// logFlushTimeout 任务结束后等待 master 保存剩余日志的最长时间
+	logFlushTimeout = 30 * time.Second
+)
+
+// jobLogSender 从 master 确认保存的位置开始增量回传一个任务的日志
+// 同一时间只有一条日志消息等待 master 确认
+type jobLogSender struct {
+	mu      sync.Mutex
+	jobName string
+	jobID   int
+	// master 已经保存的日志长度
+	acked int64
+	// 上次读取时 worker 日志文件的长度
+	size int64
+	// 等待确认的消息的发送时间，零值表示没有等待确认的消息
+	sentAt time.Time
+	acks   chan struct{}
+	// 任务结束后关闭，停止定时回传
+	done chan struct{}
+}
+
+func newJobLogSender(jobName string, jobID int) *jobLogSender {
+	return &jobLogSender{
+		jobName: jobName,
+		jobID:   jobID,
+		acks:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// next 返回 master 确认的位置之后的日志和当前的 job detail，上一条消息还在等待确认时返回 nil
+func (s *jobLogSender) next(name, address string) (*api.AlineMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.sentAt.IsZero() && time.Since(s.sentAt) < logAckTimeout {
+		return nil, nil
+	}
+	chunk, size, err := jober.ReadJobLogChunk(s.jobName, s.jobID, s.acked, maxLogChunk)
+	if err != nil {
+		return nil, err
+	}
+	jobDetailString, err := jober.ReadStringJobDetail(s.jobName, s.jobID)
+	if err != nil {
+		return nil, err
+	}
+	s.size = size
+	s.sentAt = time.Now()
+	return &api.AlineMessage{
+		Type:    api.MessageType_LOG,
+		Name:    name,
+		Address: address,
+		ExecReq: &api.ExecuteReq{
+			Name:         s.jobName,
+			JobDetailId:  int64(s.jobID),
+			PipelineFile: jobDetailString,
+		},
+		Log:       chunk,
+		LogOffset: s.acked,
+	}, nil
+}
+
+// ack 处理 master 的回复，offset 为 master 已经保存的日志长度
+func (s *jobLogSender) ack(offset int64) {
+	s.mu.Lock()
+	s.acked = offset
+	s.sentAt = time.Time{}
+	s.mu.Unlock()
+	select {
+	case s.acks <- struct{}{}:
+	default:
+	}
+}
+
+// synced 上次读取到的日志已经全部被 master 保存
+func (s *jobLogSender) synced() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sentAt.IsZero() && s.acked >= s.size
+}
@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	jober "github.com/hamster-shared/aline-engine/job"
+	"github.com/hamster-shared/aline-engine/logger"
+	"gotest.tools/v3/assert"
+)
+
+func TestJobLogSender(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	assert.NilError(t, jober.SaveJob("sender", "version: \"1\"\nname: sender\nstages:\n  build:\n    steps:\n      - name: echo\n        run: echo hello\n"))
+	_, err := jober.CreateJobDetail("sender", 1)
+	assert.NilError(t, err)
+	assert.NilError(t, jober.SaveJobLogString("sender", 1, "line 1\n"))
+
+	sender := newJobLogSender("sender", 1)
+	msg, err := sender.next("worker", "10.0.0.1")
+	assert.NilError(t, err)
+	assert.Equal(t, "line 1\n", msg.Log)
+	assert.Equal(t, int64(0), msg.LogOffset)
+	assert.Equal(t, "sender", msg.ExecReq.Name)
+	assert.Assert(t, msg.ExecReq.PipelineFile != "")
+
+	// 上一条消息还没有确认，不发送
+	msg, err = sender.next("worker", "10.0.0.1")
+	assert.NilError(t, err)
+	assert.Assert(t, msg == nil)
+	assert.Assert(t, !sender.synced())
+
+	sender.ack(7)
+	assert.Assert(t, sender.synced())
+
+	// 只发送确认的位置之后新增的日志
+	_, err = jober.AppendJobLog("sender", 1, 7, "line 2\n")
+	assert.NilError(t, err)
+	msg, err = sender.next("worker", "10.0.0.1")
+	assert.NilError(t, err)
+	assert.Equal(t, "line 2\n", msg.Log)
+	assert.Equal(t, int64(7), msg.LogOffset)
+
+	// 超时没有收到确认，从确认的位置重新发送
+	logAckTimeout = 10 * time.Millisecond
+	defer func() { logAckTimeout = 10 * time.Second }()
+	time.Sleep(20 * time.Millisecond)
+	msg, err = sender.next("worker", "10.0.0.1")
+	assert.NilError(t, err)
+	assert.Equal(t, "line 2\n", msg.Log)
+	assert.Equal(t, int64(7), msg.LogOffset)
+	sender.ack(14)
+	assert.Assert(t, sender.synced())
+}
@@ -0,0 +1,163 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	jober "github.com/hamster-shared/aline-engine/job"
+	"github.com/hamster-shared/aline-engine/logger"
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+	"github.com/hamster-shared/aline-engine/workspace"
+	"github.com/stretchr/testify/assert"
+)
+
+func newApprovalExecutor(t *testing.T) *Executor {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	return &Executor{
+		cancelMap:  make(map[string]func()),
+		StatusChan: make(chan model.StatusChangeMessage, 10),
+		workspaces: workspace.NewManager(t.TempDir(), workspace.Policy{}),
+	}
+}
+
+func newApprovalJob(timeout string) *model.Job {
+	return &model.Job{
+		Version: "1",
+		Name:    "approval",
+		Stages: map[string]model.Stage{
+			"build": {Steps: []model.Step{{Name: "compile", Run: "echo build"}}},
+			"deploy": {
+				Needs:    []string{"build"},
+				Approval: &model.Approval{Approvers: []string{"alice"}, Timeout: timeout},
+				Steps:    []model.Step{{Name: "release", Run: "echo deployed"}},
+			},
+		},
+	}
+}
+
+// runUntilApproval 在后台执行 job，等到 deploy 开始等待审批
+func runUntilApproval(t *testing.T, e *Executor, job *model.Job) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Run(1, job, RunOptions{})
+	}()
+	assert.Eventually(t, func() bool {
+		_, ok := e.approvalMap.Load("approval/1")
+		return ok
+	}, 10*time.Second, 10*time.Millisecond)
+	return done
+}
+
+func waitRun(t *testing.T, e *Executor, done chan error) (*model.JobDetail, model.Status) {
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("job is still running")
+	}
+	msg := <-e.StatusChan
+	detail, err := jober.GetJobDetail("approval", 1)
+	assert.NoError(t, err)
+	return detail, msg.Status
+}
+
+func stageOf(detail *model.JobDetail, name string) model.StageDetail {
+	for _, stage := range detail.Stages {
+		if stage.Name == name {
+			return stage
+		}
+	}
+	return model.StageDetail{}
+}
+
+func TestApprovalApproved(t *testing.T) {
+	e := newApprovalExecutor(t)
+	done := runUntilApproval(t, e, newApprovalJob("1h"))
+
+	detail, err := jober.GetJobDetail("approval", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, model.STATUS_WAITING_APPROVAL, detail.Status)
+	assert.Equal(t, model.STATUS_SUCCESS, stageOf(detail, "build").Status)
+	assert.Equal(t, model.STATUS_WAITING_APPROVAL, stageOf(detail, "deploy").Status)
+
+	assert.NoError(t, e.Approve("approval", 1, model.ApprovalRecord{Stage: "deploy", Approver: "alice", Approved: true, Comment: "ship it"}))
+	detail, status := waitRun(t, e, done)
+	assert.Equal(t, model.STATUS_SUCCESS, status)
+	assert.Equal(t, model.STATUS_SUCCESS, stageOf(detail, "deploy").Status)
+	// 审批记录保存在执行记录中
+	assert.Len(t, detail.Approvals, 1)
+	assert.Equal(t, "deploy", detail.Approvals[0].Stage)
+	assert.Equal(t, "alice", detail.Approvals[0].Approver)
+	assert.True(t, detail.Approvals[0].Approved)
+	assert.Equal(t, "ship it", detail.Approvals[0].Comment)
+	assert.False(t, detail.Approvals[0].Time.IsZero())
+}
+
+func TestApprovalRejected(t *testing.T) {
+	e := newApprovalExecutor(t)
+	done := runUntilApproval(t, e, newApprovalJob("1h"))
+
+	assert.NoError(t, e.Approve("approval", 1, model.ApprovalRecord{Stage: "deploy", Approver: "alice", Comment: "not today"}))
+	// 已经有了结果，不能再审批
+	err := e.Approve("approval", 1, model.ApprovalRecord{Stage: "deploy", Approver: "alice", Approved: true})
+	var approvalErr *model.ApprovalError
+	assert.True(t, errors.As(err, &approvalErr))
+
+	detail, status := waitRun(t, e, done)
+	assert.Equal(t, model.STATUS_FAIL, status)
+	assert.Equal(t, model.STATUS_FAIL, stageOf(detail, "deploy").Status)
+	assert.Equal(t, model.STATUS_SKIPPED, stageOf(detail, "deploy").Stage.Steps[0].Status)
+	assert.Len(t, detail.Approvals, 1)
+	assert.False(t, detail.Approvals[0].Approved)
+	assert.Equal(t, "not today", detail.Approvals[0].Comment)
+}
+
+func TestApprovalTimeout(t *testing.T) {
+	e := newApprovalExecutor(t)
+	job := newApprovalJob("100ms")
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Run(1, job, RunOptions{})
+	}()
+
+	detail, status := waitRun(t, e, done)
+	assert.Equal(t, model.STATUS_TIMEOUT, status)
+	assert.Equal(t, model.STATUS_TIMEOUT, stageOf(detail, "deploy").Status)
+	assert.Empty(t, detail.Approvals)
+
+	// 超时返回 approvalTimeoutError，和拒绝区分开
+	jobDetail := &model.JobDetail{Id: 2, Job: *job, Stages: []model.StageDetail{{Name: "deploy", Stage: job.Stages["deploy"]}}}
+	jobDetail.Output = output.New(job.Name, 2)
+	stage := &jobDetail.Stages[0]
+	err := e.waitApproval(context.Background(), jobDetail, e.newJobMachine(jobDetail), stage, e.newStageMachine(jobDetail, stage))
+	var timeoutErr *approvalTimeoutError
+	assert.True(t, errors.As(err, &timeoutErr))
+	assert.Equal(t, "deploy", timeoutErr.stage)
+}
+
+func TestApprovalNotApprover(t *testing.T) {
+	e := newApprovalExecutor(t)
+	done := runUntilApproval(t, e, newApprovalJob("1h"))
+
+	err := e.Approve("approval", 1, model.ApprovalRecord{Stage: "deploy", Approver: "mallory", Approved: true})
+	var approvalErr *model.ApprovalError
+	assert.True(t, errors.As(err, &approvalErr))
+	assert.Contains(t, approvalErr.Reason, "mallory is not an approver")
+	err = e.Approve("approval", 1, model.ApprovalRecord{Stage: "build", Approver: "alice", Approved: true})
+	assert.True(t, errors.As(err, &approvalErr))
+	err = e.Approve("approval", 2, model.ApprovalRecord{Stage: "deploy", Approver: "alice", Approved: true})
+	assert.True(t, errors.As(err, &approvalErr))
+
+	// 没有权限的审批不影响等待中的 stage
+	detail, err := jober.GetJobDetail("approval", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, model.STATUS_WAITING_APPROVAL, detail.Status)
+	assert.Empty(t, detail.Approvals)
+
+	assert.NoError(t, e.Cancel("approval", 1))
+	_, status := waitRun(t, e, done)
+	assert.Equal(t, model.STATUS_STOP, status)
+}
@@ -1,37 +1,89 @@
 package executor
 
 import (
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/action"
+	"github.com/hamster-shared/aline-engine/consts"
 	jober "github.com/hamster-shared/aline-engine/job"
 	"github.com/hamster-shared/aline-engine/logger"
 	"github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/utils"
+	"github.com/hamster-shared/aline-engine/workspace"
 )
 
-func NewExecutorClient() *ExecutorClient {
+// NewExecutorClient 创建执行器客户端，concurrency 为最多同时执行的任务数，超出的任务在本地排队
+// workspaces 管理每次执行的工作目录
+func NewExecutorClient(concurrency int, workspaces *workspace.Manager) *ExecutorClient {
 	statusChan := make(chan model.StatusChangeMessage, 100)
-	return &ExecutorClient{
+	c := &ExecutorClient{
 		executor: &Executor{
 			cancelMap:  make(map[string]func()),
 			StatusChan: statusChan,
+			workspaces: workspaces,
+			journal:    newJournal(filepath.Join(utils.DefaultConfigDir(), consts.WORKER_JOURNAL_DIR_NAME)),
 		},
 		QueueChan: make(chan *model.QueueMessage, 100),
+		queue:     newRunQueue(concurrency),
 	}
+	// 等待审批的任务不占用执行槽位，审批通过后要等到有空闲槽位才继续执行
+	c.executor.OnTransition(func(event model.TransitionEvent) {
+		if event.Kind != model.TransitionJob {
+			return
+		}
+		key := utils.FormatJobToString(event.JobName, event.JobId)
+		if event.To == model.STATUS_WAITING_APPROVAL {
+			c.queue.park(key)
+		} else if event.From == model.STATUS_WAITING_APPROVAL && event.To == model.STATUS_RUNNING {
+			c.queue.unpark(key)
+		}
+	})
+	return c
 }
 
 type ExecutorClient struct {
 	executor  *Executor
 	QueueChan chan *model.QueueMessage
+	queue     *runQueue
+}
+
+// OnCapacityChange 注册执行能力变化的回调，任务开始、结束、排队时都会调用
+func (c *ExecutorClient) OnCapacityChange(hook func(capacity model.NodeCapacity)) {
+	c.queue.changed = hook
+}
+
+// Capacity 返回当前的执行能力
+func (c *ExecutorClient) Capacity() model.NodeCapacity {
+	return c.queue.capacity()
 }
 
 func (c *ExecutorClient) Main() {
 	// 持续监听任务队列
 	go c.handleJobQueue()
+	// 定时回收工作目录
+	c.executor.workspaces.StartJanitor()
 }
 
 func (c *ExecutorClient) GetStatusChangeChan() chan model.StatusChangeMessage {
 	return c.executor.StatusChan
 }
 
+// OnTransition 注册 job、stage、step 状态变化的回调
+func (c *ExecutorClient) OnTransition(hook func(event model.TransitionEvent)) {
+	c.executor.OnTransition(hook)
+}
+
+// Approve 审批正在等待的 stage
+func (c *ExecutorClient) Approve(jobName string, jobID int, record model.ApprovalRecord) error {
+	return c.executor.Approve(jobName, jobID, record)
+}
+
 func (c *ExecutorClient) GetJobStatus(jobName string, jobID int) (model.Status, error) {
+	if c.queue.isQueued(utils.FormatJobToString(jobName, jobID)) {
+		return model.STATUS_QUEUED, nil
+	}
 	return c.executor.GetJobStatus(jobName, jobID)
 }
 
@@ -47,6 +99,11 @@ func (c *ExecutorClient) handleJobQueue() {
 
 		// 如果收到了停止任务的消息，那么就取消任务，结束本次循环
 		if queueMessage.Command == model.Command_Stop {
+			// 还在排队的任务直接从队列中移除
+			if c.queue.cancel(utils.FormatJobToString(queueMessage.JobName, queueMessage.JobId)) {
+				c.cancelQueued(queueMessage.JobName, queueMessage.JobId)
+				continue
+			}
 			err := c.executor.Cancel(queueMessage.JobName, queueMessage.JobId)
 			if err != nil {
 				logger.Errorf("cancel job error: %v", err)
@@ -70,14 +127,88 @@ func (c *ExecutorClient) handleJobQueue() {
 			continue
 		}
 
-		//6. 异步执行 pipeline
-		go func() {
-			err := c.executor.Execute(jobId, job)
+		// 恢复执行时，解析上一次执行的 job detail
+		var previous *model.JobDetail
+		if queueMessage.PreviousDetail != "" {
+			previous, err = jober.ParseJobDetail(queueMessage.PreviousDetail)
 			if err != nil {
+				logger.Errorf("parse previous job detail error: %v", err)
+				continue
+			}
+		}
+		opts := RunOptions{
+			Attempt:     queueMessage.Attempt,
+			Worker:      queueMessage.Worker,
+			Previous:    previous,
+			ResumeStage: queueMessage.ResumeStage,
+		}
+
+		//6. 异步执行 pipeline，没有空闲槽位时排队
+		key := utils.FormatJobToString(jobName, jobId)
+		// 排队的任务也要记录下来，worker 异常退出后才能通知 master
+		c.executor.journal.begin(jobName, jobId, queueMessage.Attempt)
+		c.queue.submit(key, func(queued bool) {
+			defer c.queue.done(key)
+			// Run 在排序 stage、创建工作目录失败时提前返回，不会结束记录，这里保证记录一定被删除
+			defer c.executor.journal.end(jobName, jobId)
+			opts.Queued = queued
+			// 最终状态由 Run 中的 defer 发送，包括被中断时的 STOP、TIMEOUT，这里不能再发送
+			if err := c.executor.Run(jobId, job, opts); err != nil {
 				logger.Errorf("execute job error: %v", err)
-				// 在这里再次同步一次状态
-				c.executor.StatusChan <- model.NewStatusChangeMsg(jobName, jobId, model.STATUS_FAIL)
 			}
-		}()
+		}, func() {
+			logger.Infof("job %s is queued, no free slot", key)
+			c.executor.emitTransition(model.TransitionEvent{
+				Kind:    model.TransitionJob,
+				JobName: jobName,
+				JobId:   jobId,
+				From:    model.STATUS_NOTRUN,
+				To:      model.STATUS_QUEUED,
+				Time:    time.Now(),
+			})
+		})
+	}
+}
+
+// cancelQueued 取消还在排队的任务
+func (c *ExecutorClient) cancelQueued(jobName string, jobID int) {
+	logger.Infof("cancel queued job %s", utils.FormatJobToString(jobName, jobID))
+	c.executor.emitTransition(model.TransitionEvent{
+		Kind:    model.TransitionJob,
+		JobName: jobName,
+		JobId:   jobID,
+		From:    model.STATUS_QUEUED,
+		To:      model.STATUS_CANCELLED,
+		Time:    time.Now(),
+	})
+	c.executor.journal.end(jobName, jobID)
+	c.executor.StatusChan <- model.NewStatusChangeMsg(jobName, jobID, model.STATUS_CANCELLED)
+}
+
+// Reconcile 处理上一次运行遗留的任务，需要在 Main 之前调用
+// worker 在执行过程中异常退出时，任务的状态会一直停留在 RUNNING，这里结束它们遗留的进程和容器，并把任务标记为失败
+// 返回被标记为失败的任务，由调用方通知 master
+func (c *ExecutorClient) Reconcile() []model.StatusChangeMessage {
+	j := c.executor.journal
+	bootID := utils.BootID()
+	var recovered []model.StatusChangeMessage
+	for _, entry := range j.orphans() {
+		logger.Warnf("job %s was interrupted by worker restart, attempt: %d", utils.FormatJobToString(entry.JobName, entry.JobID), entry.Attempt)
+		// 重启过机器的话进程肯定已经不在了，进程号也可能被别的进程复用，不能再去结束
+		if bootID != "" && entry.BootID == bootID {
+			for _, pgid := range entry.Processes {
+				if err := utils.KillProcessGroup(pgid); err != nil {
+					logger.Warnf("kill process group %d of job %s failed: %s", pgid, utils.FormatJobToString(entry.JobName, entry.JobID), err)
+				}
+			}
+		}
+		action.StopJobContainers(entry.JobName, strconv.Itoa(entry.JobID))
+		// 只在本地排过队的任务没有 job detail，由 master 处理
+		if _, err := jober.MakeJobFail(entry.JobName, entry.JobID, model.WorkerRestartedError); err != nil {
+			logger.Warnf("mark job %s fail error: %s", utils.FormatJobToString(entry.JobName, entry.JobID), err)
+		}
+		j.end(entry.JobName, entry.JobID)
+		recovered = append(recovered, model.NewStatusChangeMsg(entry.JobName, entry.JobID, model.STATUS_FAIL))
 	}
+	return recovered
 }
@@ -3,13 +3,12 @@ package executor
 import (
 	"context"
 	"fmt"
-	aline_context "github.com/hamster-shared/aline-engine/ctx"
 	"os"
 	"path"
-	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hamster-shared/aline-engine/action"
@@ -18,7 +17,9 @@ import (
 	"github.com/hamster-shared/aline-engine/logger"
 	"github.com/hamster-shared/aline-engine/model"
 	"github.com/hamster-shared/aline-engine/output"
+	"github.com/hamster-shared/aline-engine/pipeline"
 	"github.com/hamster-shared/aline-engine/utils"
+	"github.com/hamster-shared/aline-engine/workspace"
 )
 
 type IExecutor interface {
@@ -30,26 +31,132 @@ type IExecutor interface {
 }
 
 type Executor struct {
-	cancelMap    map[string]func() // key: jobName/jobID, value: cancelFunc
-	StatusChan   chan model.StatusChangeMessage
-	stepTimerMap sync.Map // key: jobName/jobID, value: stepTimer
+	workspaces      *workspace.Manager
+	journal         *journal
+	cancelMap       map[string]func() // key: jobName/jobID, value: cancelFunc
+	cancelMu        sync.Mutex
+	StatusChan      chan model.StatusChangeMessage
+	stepTimerMap    sync.Map // key: jobName/jobID, value: stepTimer
+	stopReasonMap   sync.Map // key: jobName/jobID, value: model.Status，记录任务被中断的原因，STOP 或 TIMEOUT
+	approvalMap     sync.Map // key: jobName/jobID, value: *pendingApproval，正在等待审批的 stage
+	transitionHooks []func(event model.TransitionEvent)
+	hookMu          sync.RWMutex
+}
+
+// OnTransition 注册状态变化的回调，job、stage、step 的每一次状态变化都会调用
+func (e *Executor) OnTransition(hook func(event model.TransitionEvent)) {
+	e.hookMu.Lock()
+	defer e.hookMu.Unlock()
+	e.transitionHooks = append(e.transitionHooks, hook)
+}
+
+func (e *Executor) emitTransition(event model.TransitionEvent) {
+	logger.Debugf("%s %s(%d) %s%s status: %s -> %s", event.Kind, event.JobName, event.JobId, event.Stage, event.Step, event.From.ToString(), event.To.ToString())
+	e.hookMu.RLock()
+	defer e.hookMu.RUnlock()
+	for _, hook := range e.transitionHooks {
+		hook(event)
+	}
+}
+
+func (e *Executor) newJobMachine(job *model.JobDetail) *pipeline.StateMachine {
+	return pipeline.NewStateMachine(&job.Status, model.TransitionEvent{
+		Kind:    model.TransitionJob,
+		JobName: job.Name,
+		JobId:   job.Id,
+	}, e.emitTransition)
+}
+
+func (e *Executor) newStageMachine(job *model.JobDetail, stage *model.StageDetail) *pipeline.StateMachine {
+	return pipeline.NewStateMachine(&stage.Status, model.TransitionEvent{
+		Kind:    model.TransitionStage,
+		JobName: job.Name,
+		JobId:   job.Id,
+		Stage:   stage.Name,
+	}, e.emitTransition)
+}
+
+func (e *Executor) newStepMachine(job *model.JobDetail, stage *model.StageDetail, step *model.Step) *pipeline.StateMachine {
+	return pipeline.NewStateMachine(&step.Status, model.TransitionEvent{
+		Kind:    model.TransitionStep,
+		JobName: job.Name,
+		JobId:   job.Id,
+		Stage:   stage.Name,
+		Step:    step.Name,
+	}, e.emitTransition)
+}
+
+// transition 执行状态迁移，非法迁移只记录日志，不中断执行
+func transition(machine *pipeline.StateMachine, to model.Status) {
+	if err := machine.Transition(to); err != nil {
+		logger.Warn(err.Error())
+	}
+}
+
+// interruptedStatus 任务被中断时，job 与正在执行的 step 应该处于的状态
+func (e *Executor) interruptedStatus(name string, id int) (jobStatus, stepStatus model.Status) {
+	reason, ok := e.stopReasonMap.Load(strings.Join([]string{name, strconv.Itoa(id)}, "/"))
+	if ok && reason.(model.Status) == model.STATUS_TIMEOUT {
+		return model.STATUS_TIMEOUT, model.STATUS_TIMEOUT
+	}
+	return model.STATUS_STOP, model.STATUS_CANCELLED
+}
+
+// RunOptions 执行任务的可选参数
+type RunOptions struct {
+	// 第几次执行，为 0 时视为第 1 次
+	Attempt int
+	// 执行该任务的节点
+	Worker string
+	// 恢复执行时上一次执行的 job detail，以及从哪个 stage 开始恢复
+	Previous    *model.JobDetail
+	ResumeStage string
+	// 是否在本地排过队，排过队的任务从 QUEUED 开始
+	Queued bool
 }
 
 // Execute 执行任务
 func (e *Executor) Execute(id int, job *model.Job) error {
+	return e.Run(id, job, RunOptions{})
+}
+
+// Resume 从上一次执行失败的 stage 恢复执行，之前成功的 stage 不再执行，保留它们的结果
+func (e *Executor) Resume(id int, job *model.Job, previous *model.JobDetail, fromStage string) error {
+	return e.Run(id, job, RunOptions{Previous: previous, ResumeStage: fromStage})
+}
+
+// Run 按照 opts 执行任务
+func (e *Executor) Run(id int, job *model.Job, opts RunOptions) error {
+	previous := opts.Previous
 
-	// 1. 解析对 pipeline 进行任务排序
-	stages, err := job.StageSort()
+	// 1. 解析对 pipeline 进行任务排序，恢复执行时沿用上一次的 stage 顺序
+	var stages []model.StageDetail
+	var err error
+	resumeIndex := 0
+	if previous != nil {
+		stages, resumeIndex, err = job.ResumeStages(previous, opts.ResumeStage)
+	} else {
+		stages, err = job.StageSort()
+	}
 	jobWrapper := &model.JobDetail{
-		Id:     id,
-		Job:    *job,
-		Status: model.STATUS_NOTRUN,
-		Stages: stages,
+		Id:      id,
+		Job:     *job,
+		Status:  model.STATUS_NOTRUN,
+		Stages:  stages,
+		Attempt: opts.Attempt,
+		Worker:  opts.Worker,
 		ActionResult: model.ActionResult{
 			Artifactorys: make([]model.Artifactory, 0),
 			Reports:      make([]model.Report, 0),
 		},
 	}
+	if opts.Queued {
+		jobWrapper.Status = model.STATUS_QUEUED
+	}
+	if previous != nil && err == nil {
+		keepResumedResult(jobWrapper, previous, resumeIndex)
+	}
+	jobMachine := e.newJobMachine(jobWrapper)
 
 	// 分支太多，不确定会从哪个分支 return，所以使用 defer，保证一定会将最终结果发送到 StatusChan
 	defer func() {
@@ -58,9 +165,11 @@ func (e *Executor) Execute(id int, job *model.Job) error {
 		logger.Infof("send status change message to chan, job name: %s, job id: %d, status: %d", jobWrapper.Name, jobWrapper.Id, jobWrapper.Status)
 		// step 定时器也需要删除，避免出现意料之外的报错
 		e.stepTimerMap.Delete(utils.FormatJobToString(jobWrapper.Name, jobWrapper.Id))
+		e.stopReasonMap.Delete(strings.Join([]string{jobWrapper.Name, strconv.Itoa(jobWrapper.Id)}, "/"))
 	}()
 
 	if err != nil {
+		transition(jobMachine, model.STATUS_FAIL)
 		return err
 	}
 	go e.handleTimerListener()
@@ -75,10 +184,20 @@ func (e *Executor) Execute(id int, job *model.Job) error {
 
 	engineContext := make(map[string]any)
 	engineContext["hamsterRoot"] = path.Join(homeDir, "workdir")
-	workdir := path.Join(engineContext["hamsterRoot"].(string), job.Name)
-	engineContext["workdir"] = workdir
 
-	err = os.MkdirAll(workdir, os.ModePerm)
+	// 每次执行都使用独立的工作目录，执行结束后按照回收策略处理
+	ws, err := e.workspaces.Create(job.Name, id, jobWrapper.CurrentAttempt())
+	if err != nil {
+		logger.Errorf("create workspace error: %s", err)
+		jobWrapper.Error = err.Error()
+		transition(jobMachine, model.STATUS_FAIL)
+		return err
+	}
+	defer func() {
+		e.workspaces.Finish(ws, jobWrapper.Status)
+	}()
+	engineContext["workdir"] = ws.Dir
+	engineContext["workdirTmp"] = ws.TmpDir
 
 	engineContext["name"] = job.Name
 	engineContext["id"] = fmt.Sprintf("%d", id)
@@ -94,29 +213,35 @@ func (e *Executor) Execute(id int, job *model.Job) error {
 	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), "stack", engineContext))
 
 	// 将取消 hook 记录到内存中，用于中断程序
+	e.cancelMu.Lock()
 	e.cancelMap[strings.Join([]string{job.Name, strconv.Itoa(id)}, "/")] = cancel
+	e.cancelMu.Unlock()
+	defer cancel()
+
+	// 记录正在执行的任务和它启动的进程，worker 异常退出重启后据此清理
+	if e.journal != nil {
+		engineContext["processRecorder"] = e.journal.begin(job.Name, id, jobWrapper.CurrentAttempt())
+		defer e.journal.end(job.Name, id)
+	}
 
 	// 队列堆栈
 	var stack utils.Stack[action.ActionHandler]
 
-	jobWrapper.Status = model.STATUS_RUNNING
 	jobWrapper.StartTime = time.Now()
+	transition(jobMachine, model.STATUS_RUNNING)
 
-	executeAction := func(ah action.ActionHandler, job *model.JobDetail) (err error) {
+	executeAction := func(ah action.ActionHandler, job *model.JobDetail, stage *model.StageDetail) (err error) {
 		// 延迟处理的函数
 		defer func() {
 			// 发生宕机时，获取 panic 传递的上下文并打印
 			rErr := recover()
-			switch rErr.(type) {
-			case runtime.Error: // 运行时错误
+			if rErr != nil {
 				fmt.Println("runtime error:", rErr)
 				logger.Errorf("runtime error: %s", rErr)
-				err = fmt.Errorf("runtime error: %s", rErr)
-			default: // 非运行时错误
-				// do nothing
+				err = &panicError{value: rErr}
 			}
 		}()
-		if jobWrapper.Status != model.STATUS_RUNNING {
+		if jobMachine.State() != model.STATUS_RUNNING {
 			return nil
 		}
 		if ah == nil {
@@ -125,39 +250,29 @@ func (e *Executor) Execute(id int, job *model.Job) error {
 		}
 		err = ah.Pre()
 		if err != nil {
-			job.Status = model.STATUS_FAIL
 			logger.Errorf("action pre hook error, job name: %s, job id: %d, error: %s", job.Name, job.Id, err.Error())
 			fmt.Println(err)
-			return err
+			return &preCheckError{err: err}
 		}
 		logger.Infof("action pre hook success, job name: %s, job id: %d", job.Name, job.Id)
 		stack.Push(ah)
 		actionResult, err := ah.Hook()
-		if actionResult != nil && len(actionResult.Artifactorys) > 0 {
-			jobWrapper.Artifactorys = append(jobWrapper.Artifactorys, actionResult.Artifactorys...)
-		}
-		if actionResult != nil && len(actionResult.Reports) > 0 {
-			jobWrapper.Reports = append(jobWrapper.Reports, actionResult.Reports...)
-		}
-		if actionResult != nil && actionResult.CodeInfo.CommitId != "" {
-			jobWrapper.CodeInfo = actionResult.CodeInfo
-		}
-		if actionResult != nil && len(actionResult.Deploys) > 0 {
-			jobWrapper.Deploys = append(jobWrapper.Deploys, actionResult.Deploys...)
-		}
-		if actionResult != nil && len(actionResult.BuildData) > 0 {
-			jobWrapper.BuildData = append(jobWrapper.BuildData, actionResult.BuildData...)
-		}
-		if actionResult != nil && len(actionResult.MetaScanData) > 0 {
-			jobWrapper.MetaScanData = append(jobWrapper.MetaScanData, actionResult.MetaScanData...)
-		}
-		if err != nil {
-			job.Status = model.STATUS_FAIL
-			return err
+		if actionResult != nil {
+			jobWrapper.Merge(actionResult)
+			if stage.Result == nil {
+				stage.Result = &model.ActionResult{}
+			}
+			stage.Result.Merge(actionResult)
 		}
 		return err
 	}
 
+	// 重新执行时，日志从头开始写，之前的日志已经在 master 上归档
+	if jobWrapper.CurrentAttempt() > 1 {
+		if err := jober.ResetJobLog(job.Name, jobWrapper.Id); err != nil {
+			logger.Errorf("reset job log failed: %s", err)
+		}
+	}
 	jobWrapper.Output = output.New(job.Name, jobWrapper.Id)
 
 	var jobDone = make(chan struct{})
@@ -184,124 +299,166 @@ func (e *Executor) Execute(id int, job *model.Job) error {
 		}
 	}(jobWrapper)
 
-	for index, stageWapper := range jobWrapper.Stages {
+	// 被中断时 job 和 step 的最终状态
+	jobInterrupted, stepInterrupted := model.STATUS_STOP, model.STATUS_CANCELLED
+	interrupted := false
+
+	for index := range jobWrapper.Stages {
+		stageWapper := &jobWrapper.Stages[index]
+		stageMachine := e.newStageMachine(jobWrapper, stageWapper)
+
+		// 恢复执行时，之前成功的 stage 不再执行，只重放会影响上下文的 step
+		if index < resumeIndex {
+			err = e.replayStage(ctx, jobWrapper, stageWapper, previous, func(ah action.ActionHandler) error {
+				err := executeAction(ah, jobWrapper, stageWapper)
+				for !stack.IsEmpty() {
+					ah, _ := stack.Pop()
+					_ = ah.Post()
+				}
+				return err
+			})
+			if err != nil {
+				cancel()
+			}
+			continue
+		}
+
+		// 前面的 stage 已经失败或被中断，剩下的 stage 和 step 都不再执行
+		if err != nil {
+			transition(stageMachine, model.STATUS_SKIPPED)
+			for stepIndex := range stageWapper.Stage.Steps {
+				transition(e.newStepMachine(jobWrapper, stageWapper, &stageWapper.Stage.Steps[stepIndex]), model.STATUS_SKIPPED)
+			}
+			continue
+		}
+
+		// 需要审批的 stage，在审批通过前不会开始执行
+		if stageWapper.Stage.Approval != nil {
+			err = e.waitApproval(ctx, jobWrapper, jobMachine, stageWapper, stageMachine)
+			if err != nil {
+				if ctx.Err() != nil {
+					interrupted = true
+					jobInterrupted, stepInterrupted = e.interruptedStatus(job.Name, id)
+					transition(stageMachine, stepInterrupted)
+				} else if _, ok := err.(*approvalTimeoutError); ok {
+					interrupted = true
+					jobInterrupted, stepInterrupted = model.STATUS_TIMEOUT, model.STATUS_TIMEOUT
+					transition(stageMachine, model.STATUS_TIMEOUT)
+				} else {
+					transition(stageMachine, model.STATUS_FAIL)
+				}
+				for stepIndex := range stageWapper.Stage.Steps {
+					transition(e.newStepMachine(jobWrapper, stageWapper, &stageWapper.Stage.Steps[stepIndex]), model.STATUS_SKIPPED)
+				}
+				jober.SaveJobDetail(jobWrapper.Name, jobWrapper)
+				cancel()
+				continue
+			}
+		}
+
 		//TODO ... stage 的输出也需要换成堆栈方式
 		logger.Info("stage: {")
 		logger.Infof("   // %s", stageWapper.Name)
-		stageWapper.Status = model.STATUS_RUNNING
 		stageWapper.StartTime = time.Now()
-		jobWrapper.Stages[index] = stageWapper
+		transition(stageMachine, model.STATUS_RUNNING)
 		jobWrapper.Output.NewStage(stageWapper.Name)
 		jober.SaveJobDetail(jobWrapper.Name, jobWrapper)
 
-		for index, step := range stageWapper.Stage.Steps {
+		for stepIndex := range stageWapper.Stage.Steps {
+			step := stageWapper.Stage.Steps[stepIndex]
+			model.ApplyDefaults(&step, jobWrapper.Defaults, stageWapper.Stage.Defaults)
+			step.Resources = model.MergeResources(stageWapper.Stage.Resources, step.Resources)
+			step.Container = model.MergeContainerOptions(stageWapper.Stage.Container, step.Container)
+			stepMachine := e.newStepMachine(jobWrapper, stageWapper, &stageWapper.Stage.Steps[stepIndex])
+			if err != nil {
+				transition(stepMachine, model.STATUS_SKIPPED)
+				continue
+			}
+
 			var ah action.ActionHandler
+			stageWapper.Stage.Steps[stepIndex].StartTime = time.Now()
+			transition(stepMachine, model.STATUS_RUNNING)
+			jober.SaveJobDetail(jobWrapper.Name, jobWrapper)
+			// 记录 step 开始时的输出位置，失败时保存这之后的最后几行
+			outputMark := jobWrapper.Output.Len()
 			if step.RunsOn != "" {
 				ah = action.NewDockerEnv(step, ctx, jobWrapper.Output)
-				err = executeAction(ah, jobWrapper)
+				err = executeAction(ah, jobWrapper, stageWapper)
 				if err != nil {
-					break
+					err = &infrastructureError{err: err}
 				}
 			}
-			stageWapper.Stage.Steps[index].StartTime = time.Now()
-			stageWapper.Stage.Steps[index].Status = model.STATUS_RUNNING
-			jober.SaveJobDetail(jobWrapper.Name, jobWrapper)
-			actionContext := aline_context.NewActionContext(step, ctx, jobWrapper.Output)
-			// 如果 step 超时，则调用 cancel，在这里存储该 job 的计时器
-			// 每次新 step 时，都会重新设置该计时器，所以不需要存储到底是哪个 step
-			e.stepTimerMap.Store(utils.FormatJobToString(jobWrapper.Name, jobWrapper.Id), newStepTimer())
-			if step.Uses == "" || step.Uses == "shell" {
-				ah = action.NewShellAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "git-checkout" {
-				ah = action.NewGitAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "hamster-ipfs" {
-				ah = action.NewIpfsAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "hamster-pinata-ipfs" {
-				ah = action.NewPinataIpfsAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "hamster-artifactory" {
-				ah = action.NewArtifactoryAction(step, ctx, jobWrapper.Output)
-				//} else if step.Uses == "deploy-contract" {
-				//	ah = action.NewTruffleDeployAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "image-build" {
-				ah = action.NewImageBuildAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "image-push" {
-				ah = action.NewImagePushAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "k8s-frontend-deploy" {
-				ah = action.NewK8sDeployAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "k8s-assign-domain" {
-				ah = action.NewK8sIngressAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "metascan_action" {
-				ah = action.NewMetaScanCheckAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "sol-profiler-check" {
-				ah = action.NewSolProfilerAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "solhint-check" {
-				ah = action.NewSolHintAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "mythril-check" {
-				ah = action.NewMythRilAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "slither-check" {
-				ah = action.NewSlitherAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "check-aggregation" {
-				ah = action.NewCheckAggregationAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "deploy-ink-contract" {
-				ah = action.NewInkAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "frontend-check" {
-				ah = action.NewEslintAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "eth-gas-reporter" {
-				ah = action.NewEthGasReporterAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "aptos-check" {
-				ah = action.NewMoveProverAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "workdir" {
-				ah = action.NewWorkdirAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "openai" {
-				ah = action.NewOpenaiAction(step, ctx, jobWrapper.Output)
-			} else if step.Uses == "icp-build" {
-				ah = action.NewICPBuildAction(actionContext)
-			} else if step.Uses == "icp-deploy" {
-				ah = action.NewICPDeployAction(actionContext)
-			} else if strings.Contains(step.Uses, "/") {
-				ah = action.NewRemoteAction(step, ctx)
+			if err == nil {
+				// 如果 step 超时，则调用 cancel，在这里存储该 job 的计时器
+				// 每次新 step 时，都会重新设置该计时器，所以不需要存储到底是哪个 step
+				e.stepTimerMap.Store(utils.FormatJobToString(jobWrapper.Name, jobWrapper.Id), newStepTimer())
+				ah = action.New(step, ctx, jobWrapper.Output)
+				jobWrapper.Output.NewStep(step.Name)
+				err = executeAction(ah, jobWrapper, stageWapper)
 			}
-			jobWrapper.Output.NewStep(step.Name)
-			err = executeAction(ah, jobWrapper)
-			dataTime := time.Since(stageWapper.Stage.Steps[index].StartTime)
-			stageWapper.Stage.Steps[index].Duration = dataTime.Milliseconds()
+			stepDetail := &stageWapper.Stage.Steps[stepIndex]
+			stepDetail.EndTime = time.Now()
+			stepDetail.Duration = stepDetail.EndTime.Sub(stepDetail.StartTime).Milliseconds()
 			for !stack.IsEmpty() {
 				ah, _ := stack.Pop()
 				_ = ah.Post()
 			}
-			if err != nil {
-				stageWapper.Stage.Steps[index].Status = model.STATUS_FAIL
+			if err != nil && ctx.Err() != nil {
+				// 被取消或超时，而不是 step 自身失败
+				interrupted = true
+				jobInterrupted, stepInterrupted = e.interruptedStatus(job.Name, id)
+				e.recordStepFailure(jobWrapper, stepDetail, err, stepInterrupted, outputMark)
+				transition(stepMachine, stepInterrupted)
+			} else if err != nil {
+				e.recordStepFailure(jobWrapper, stepDetail, err, model.STATUS_NOTRUN, outputMark)
+				transition(stepMachine, model.STATUS_FAIL)
 			} else {
-				stageWapper.Stage.Steps[index].Status = model.STATUS_SUCCESS
+				if provider, ok := ah.(action.OutputsProvider); ok {
+					stepDetail.Outputs = provider.Outputs()
+				}
+				transition(stepMachine, model.STATUS_SUCCESS)
 			}
+			jobWrapper.Output.EndStep(stepDetail.Status.ToString())
 			err := jober.SaveJobDetail(jobWrapper.Name, jobWrapper)
 			if err != nil {
 				logger.Error("SaveJobDetail error: ", err)
 			}
 		}
 
-		if err != nil {
-			stageWapper.Status = model.STATUS_FAIL
+		if interrupted {
+			transition(stageMachine, stepInterrupted)
+		} else if err != nil {
+			transition(stageMachine, model.STATUS_FAIL)
 		} else {
-			stageWapper.Status = model.STATUS_SUCCESS
+			transition(stageMachine, model.STATUS_SUCCESS)
 		}
 		dataTime := time.Since(stageWapper.StartTime)
 		stageWapper.Duration = dataTime.Milliseconds()
-		jobWrapper.Stages[index] = stageWapper
 		jober.SaveJobDetail(jobWrapper.Name, jobWrapper)
 		logger.Info("}")
 		if err != nil {
 			cancel()
-			break
 		}
 	}
+	// 被中断时，step 的进程组已经结束，Post 也已经执行，再清理任务启动的容器
+	if ctx.Err() != nil {
+		action.StopJobContainers(job.Name, strconv.Itoa(id))
+	}
 	jobWrapper.Output.Done()
+	if err := jober.IndexJobLog(job.Name, id); err != nil {
+		logger.Errorf("index job log error: %v", err)
+	}
 
-	delete(e.cancelMap, job.Name)
+	e.cancelMu.Lock()
+	delete(e.cancelMap, strings.Join([]string{job.Name, strconv.Itoa(id)}, "/"))
+	e.cancelMu.Unlock()
 	if err == nil {
-		jobWrapper.Status = model.STATUS_SUCCESS
+		transition(jobMachine, model.STATUS_SUCCESS)
+	} else if interrupted {
+		transition(jobMachine, jobInterrupted)
+		jobWrapper.Error = err.Error()
 	} else {
-		jobWrapper.Status = model.STATUS_FAIL
+		transition(jobMachine, model.STATUS_FAIL)
 		jobWrapper.Error = err.Error()
 	}
 
@@ -312,22 +469,194 @@ func (e *Executor) Execute(id int, job *model.Job) error {
 	return err
 }
 
+// recordStepFailure 记录 step 失败的详细信息，job 的失败原因使用第一个失败的 step 的
+func (e *Executor) recordStepFailure(job *model.JobDetail, step *model.Step, err error, interrupted model.Status, outputMark int) {
+	step.Failure = newStepFailure(err, interrupted)
+	step.Failure.OutputTail = job.Output.Tail(outputMark, failureTailLines)
+	if job.FailureReason == "" {
+		job.FailureReason = step.Failure.Reason
+	}
+}
+
+// keepResumedResult 恢复执行时，保留之前成功的 stage 的结果和审批记录
+func keepResumedResult(jobWrapper *model.JobDetail, previous *model.JobDetail, resumeIndex int) {
+	jobWrapper.ResumedFrom = jobWrapper.Stages[resumeIndex].Name
+	kept := make(map[string]bool)
+	hasStageResult := false
+	for _, stage := range jobWrapper.Stages[:resumeIndex] {
+		kept[stage.Name] = true
+		if stage.Result != nil {
+			hasStageResult = true
+			jobWrapper.Merge(stage.Result)
+		}
+	}
+	// 旧版本没有记录每个 stage 的结果，只能保留上一次的全部结果
+	if !hasStageResult {
+		jobWrapper.Merge(&previous.ActionResult)
+	}
+	if jobWrapper.CodeInfo.CommitId == "" {
+		jobWrapper.CodeInfo = previous.CodeInfo
+	}
+	for _, record := range previous.Approvals {
+		if kept[record.Stage] {
+			jobWrapper.Approvals = append(jobWrapper.Approvals, record)
+		}
+	}
+}
+
+// replayStage 重放已经成功的 stage 中会改变执行上下文的 step：
+// workdir 总是重放；每次执行都是新的工作目录，git-checkout 按上一次的 commit 重新检出
+func (e *Executor) replayStage(ctx context.Context, jobWrapper *model.JobDetail, stage *model.StageDetail, previous *model.JobDetail, execute func(ah action.ActionHandler) error) error {
+	replayed := false
+	for _, step := range stage.Stage.Steps {
+		var ah action.ActionHandler
+		switch {
+		case step.Uses == "workdir":
+			ah = action.NewWorkdirAction(step, ctx, jobWrapper.Output)
+		case step.Uses == "git-checkout":
+			with := make(map[string]string)
+			for k, v := range step.With {
+				with[k] = v
+			}
+			// 旧版本只记录了短的 commit id
+			with["commit"] = previous.CodeInfo.CommitSha
+			if with["commit"] == "" {
+				with["commit"] = previous.CodeInfo.CommitId
+			}
+			step.With = with
+			ah = action.NewGitAction(step, ctx, jobWrapper.Output)
+		default:
+			continue
+		}
+		if !replayed {
+			jobWrapper.Output.NewStage(stage.Name)
+			replayed = true
+		}
+		jobWrapper.Output.NewStep(step.Name)
+		jobWrapper.Output.WriteLine(fmt.Sprintf("[Resume] replay step %s of succeeded stage %s", step.Name, stage.Name))
+		if err := execute(ah); err != nil {
+			jobWrapper.Output.EndStep(model.STATUS_FAIL.ToString())
+			return fmt.Errorf("replay step %s of stage %s failed: %s", step.Name, stage.Name, err)
+		}
+		jobWrapper.Output.EndStep(model.STATUS_SUCCESS.ToString())
+	}
+	return nil
+}
+
+type pendingApproval struct {
+	stage    string
+	approval *model.Approval
+	decision chan model.ApprovalRecord
+	// 只接受第一次审批，之后的审批即使 decision 已经被取走也要报错
+	decided int32
+}
+
+type approvalTimeoutError struct {
+	stage   string
+	timeout time.Duration
+}
+
+func (e *approvalTimeoutError) Error() string {
+	return fmt.Sprintf("stage %s approval timeout after %s", e.stage, e.timeout)
+}
+
+// waitApproval 将 job 挂起在 WAITING_APPROVAL 状态，直到 stage 被审批通过、拒绝、超时或者任务被取消
+// 审批通过返回 nil，此时 job 回到 RUNNING，stage 仍为 WAITING_APPROVAL，由调用者迁移到 RUNNING
+func (e *Executor) waitApproval(ctx context.Context, job *model.JobDetail, jobMachine *pipeline.StateMachine, stage *model.StageDetail, stageMachine *pipeline.StateMachine) error {
+	timeout := time.Hour * consts.APPROVAL_TIMEOUT_HOUR
+	if stage.Stage.Approval.Timeout != "" {
+		d, err := time.ParseDuration(stage.Stage.Approval.Timeout)
+		if err != nil {
+			return fmt.Errorf("stage %s approval timeout is invalid: %s", stage.Name, err)
+		}
+		timeout = d
+	}
+
+	key := strings.Join([]string{job.Name, strconv.Itoa(job.Id)}, "/")
+	pending := &pendingApproval{
+		stage:    stage.Name,
+		approval: stage.Stage.Approval,
+		decision: make(chan model.ApprovalRecord, 1),
+	}
+	e.approvalMap.Store(key, pending)
+	defer e.approvalMap.Delete(key)
+	// 等待审批的时间不计入 step 超时
+	e.stepTimerMap.Delete(utils.FormatJobToString(job.Name, job.Id))
+
+	transition(stageMachine, model.STATUS_WAITING_APPROVAL)
+	transition(jobMachine, model.STATUS_WAITING_APPROVAL)
+	job.Output.WriteLine(fmt.Sprintf("[Approval] stage %s is waiting for approval, approvers: %v, timeout: %s", stage.Name, stage.Stage.Approval.Approvers, timeout))
+	jober.SaveJobDetail(job.Name, job)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case record := <-pending.decision:
+		job.Approvals = append(job.Approvals, record)
+		if !record.Approved {
+			job.Output.WriteLine(fmt.Sprintf("[Approval] stage %s rejected by %s: %s", stage.Name, record.Approver, record.Comment))
+			return fmt.Errorf("stage %s rejected by %s", stage.Name, record.Approver)
+		}
+		job.Output.WriteLine(fmt.Sprintf("[Approval] stage %s approved by %s", stage.Name, record.Approver))
+		transition(jobMachine, model.STATUS_RUNNING)
+		return nil
+	case <-timer.C:
+		job.Output.WriteLine(fmt.Sprintf("[Approval] stage %s approval timeout", stage.Name))
+		return &approvalTimeoutError{stage: stage.Name, timeout: timeout}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Approve 审批正在等待的 stage，approved 为 false 时表示拒绝
+func (e *Executor) Approve(jobName string, id int, record model.ApprovalRecord) error {
+	value, ok := e.approvalMap.Load(strings.Join([]string{jobName, strconv.Itoa(id)}, "/"))
+	if !ok {
+		return &model.ApprovalError{JobName: jobName, JobID: id, Stage: record.Stage, Reason: "job is not waiting for approval"}
+	}
+	pending := value.(*pendingApproval)
+	if pending.stage != record.Stage {
+		return &model.ApprovalError{JobName: jobName, JobID: id, Stage: record.Stage, Reason: fmt.Sprintf("waiting stage is %s", pending.stage)}
+	}
+	if !pending.approval.CanApprove(record.Approver) {
+		return &model.ApprovalError{JobName: jobName, JobID: id, Stage: record.Stage, Reason: fmt.Sprintf("%s is not an approver", record.Approver)}
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	if !atomic.CompareAndSwapInt32(&pending.decided, 0, 1) {
+		return &model.ApprovalError{JobName: jobName, JobID: id, Stage: record.Stage, Reason: "stage has already been approved or rejected"}
+	}
+	pending.decision <- record
+	return nil
+}
+
 // Cancel 取消
 func (e *Executor) Cancel(jobName string, id int) error {
+	return e.cancel(jobName, id, model.STATUS_STOP)
+}
+
+// cancel 以指定的原因中断任务，reason 为 STOP 或 TIMEOUT
+// 这里只发出中断信号，任务真正结束后由 Run 上报最终状态
+func (e *Executor) cancel(jobName string, id int, reason model.Status) error {
+	e.cancelMu.Lock()
 	cancel, ok := e.cancelMap[strings.Join([]string{jobName, strconv.Itoa(id)}, "/")]
-	if ok {
-		cancel()
-		// 删除
-		delete(e.cancelMap, strings.Join([]string{jobName, strconv.Itoa(id)}, "/"))
-	} else {
+	e.cancelMu.Unlock()
+	if !ok {
+		// 任务不在这个节点上运行，没有人会上报状态，直接上报
 		logger.Errorf("job cancel function not found: %s/%d", jobName, id)
+		e.StatusChan <- model.NewStatusChangeMsg(jobName, id, reason)
+		return nil
 	}
-	e.StatusChan <- model.NewStatusChangeMsg(jobName, id, model.STATUS_STOP)
+	e.stopReasonMap.LoadOrStore(strings.Join([]string{jobName, strconv.Itoa(id)}, "/"), reason)
+	cancel()
 	return nil
 }
 
 func (e *Executor) GetJobStatus(jobName string, jobID int) (model.Status, error) {
+	e.cancelMu.Lock()
 	_, ok := e.cancelMap[strings.Join([]string{jobName, strconv.Itoa(jobID)}, "/")]
+	e.cancelMu.Unlock()
 	if ok {
 		return model.STATUS_RUNNING, nil
 	}
@@ -345,7 +674,7 @@ func (e *Executor) handleTimerListener() {
 					logger.Errorf("get job name and id from format string error: %v, key: %s", err, key.(string))
 					return true
 				}
-				err = e.Cancel(name, id)
+				err = e.cancel(name, id, model.STATUS_TIMEOUT)
 				if err != nil {
 					logger.Errorf("cancel job error: %v, key: %s", err, key.(string))
 				}
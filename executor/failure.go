@@ -0,0 +1,80 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"syscall"
+
+	"github.com/hamster-shared/aline-engine/container"
+	"github.com/hamster-shared/aline-engine/model"
+)
+
+// 失败的 step 记录最后多少行输出
+const failureTailLines = 20
+
+// preCheckError action 的 Pre 阶段失败
+type preCheckError struct {
+	err error
+}
+
+func (e *preCheckError) Error() string { return e.err.Error() }
+func (e *preCheckError) Unwrap() error { return e.err }
+
+// infrastructureError 执行环境的问题，例如 runs-on 的容器启动失败
+type infrastructureError struct {
+	err error
+}
+
+func (e *infrastructureError) Error() string { return e.err.Error() }
+func (e *infrastructureError) Unwrap() error { return e.err }
+
+// panicError action 执行过程中发生 panic
+type panicError struct {
+	value any
+}
+
+func (e *panicError) Error() string { return fmt.Sprintf("runtime error: %v", e.value) }
+
+// newStepFailure 根据 step 返回的错误生成失败详情，interrupted 为被中断时 step 的状态，没有被中断时为 STATUS_NOTRUN
+func newStepFailure(err error, interrupted model.Status) *model.StepFailure {
+	failure := &model.StepFailure{Reason: model.FAILURE_COMMAND_FAILED, Message: err.Error()}
+
+	var exitErr *exec.ExitError
+	var containerExitErr *container.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			failure.Signal = status.Signal().String()
+		} else {
+			failure.ExitCode = exitErr.ExitCode()
+		}
+	} else if errors.As(err, &containerExitErr) {
+		failure.ExitCode = containerExitErr.Code
+	}
+
+	var panicErr *panicError
+	var infraErr *infrastructureError
+	var limitErr *model.ResourceLimitError
+	var preErr *preCheckError
+	var execErr *exec.Error
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &panicErr):
+		failure.Reason = model.FAILURE_PANIC
+	case interrupted == model.STATUS_TIMEOUT:
+		failure.Reason = model.FAILURE_TIMEOUT
+	case interrupted == model.STATUS_CANCELLED:
+		failure.Reason = model.FAILURE_CANCELLED
+	case errors.As(err, &infraErr) || errors.Is(err, container.ErrUnavailable):
+		failure.Reason = model.FAILURE_INFRASTRUCTURE
+	case errors.As(err, &limitErr):
+		failure.Reason = model.FAILURE_RESOURCE_LIMIT
+	case errors.As(err, &preErr):
+		failure.Reason = model.FAILURE_PRECHECK_FAILED
+	case errors.As(err, &execErr) || (errors.As(err, &pathErr) && pathErr.Op == "fork/exec"):
+		// 命令没有启动起来，例如可执行文件不存在
+		failure.Reason = model.FAILURE_INFRASTRUCTURE
+	}
+	return failure
+}
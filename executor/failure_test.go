@@ -0,0 +1,34 @@
+package executor
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStepFailure(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	failure := newStepFailure(exitErr, model.STATUS_NOTRUN)
+	assert.Equal(t, model.FAILURE_COMMAND_FAILED, failure.Reason)
+	assert.Equal(t, 3, failure.ExitCode)
+	assert.Equal(t, "exit status 3", failure.Message)
+
+	signalErr := exec.Command("sh", "-c", "kill -KILL $$").Run()
+	failure = newStepFailure(signalErr, model.STATUS_CANCELLED)
+	assert.Equal(t, model.FAILURE_CANCELLED, failure.Reason)
+	assert.Equal(t, "killed", failure.Signal)
+	assert.Equal(t, 0, failure.ExitCode)
+
+	assert.Equal(t, model.FAILURE_TIMEOUT, newStepFailure(exitErr, model.STATUS_TIMEOUT).Reason)
+	assert.Equal(t, model.FAILURE_PRECHECK_FAILED, newStepFailure(&preCheckError{err: errors.New("workdir error")}, model.STATUS_NOTRUN).Reason)
+	assert.Equal(t, model.FAILURE_INFRASTRUCTURE, newStepFailure(&infrastructureError{err: &preCheckError{err: errors.New("no image")}}, model.STATUS_NOTRUN).Reason)
+	assert.Equal(t, model.FAILURE_PANIC, newStepFailure(&panicError{value: "boom"}, model.STATUS_NOTRUN).Reason)
+	assert.Equal(t, model.FAILURE_RESOURCE_LIMIT, newStepFailure(&model.ResourceLimitError{Resource: "memory", Limit: "1G", Err: exitErr}, model.STATUS_NOTRUN).Reason)
+
+	notFound := exec.Command("/nonexistent/command").Run()
+	assert.Equal(t, model.FAILURE_INFRASTRUCTURE, newStepFailure(notFound, model.STATUS_NOTRUN).Reason)
+	assert.Equal(t, model.FAILURE_COMMAND_FAILED, newStepFailure(errors.New("check failed"), model.STATUS_NOTRUN).Reason)
+}
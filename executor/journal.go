@@ -0,0 +1,149 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/logger"
+	"github.com/hamster-shared/aline-engine/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// journalEntry 一个正在执行的任务，任务正常结束后删除
+// worker 异常退出后，重启时根据遗留的记录清理任务
+type journalEntry struct {
+	JobName string `yaml:"jobName"`
+	JobID   int    `yaml:"jobId"`
+	Attempt int    `yaml:"attempt"`
+	// 记录时的开机标识，重启过机器的话，之前的进程肯定已经不在了
+	BootID string `yaml:"bootId,omitempty"`
+	// 正在运行的进程组
+	Processes []int     `yaml:"processes,omitempty"`
+	StartTime time.Time `yaml:"startTime"`
+}
+
+// journal worker 本地的执行记录，每个任务一个文件
+type journal struct {
+	dir     string
+	mu      sync.Mutex
+	entries map[string]*journalEntry // key: jobName(jobID)
+}
+
+func newJournal(dir string) *journal {
+	return &journal{
+		dir:     dir,
+		entries: make(map[string]*journalEntry),
+	}
+}
+
+func (j *journal) filePath(key string) string {
+	return filepath.Join(j.dir, key+".yml")
+}
+
+// begin 记录任务开始执行（包括在本地排队），返回的 recorder 用来记录任务启动的进程
+// 排队的任务开始执行时会再次调用，沿用排队时的记录
+func (j *journal) begin(jobName string, jobID, attempt int) *journalRecorder {
+	key := utils.FormatJobToString(jobName, jobID)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.entries[key]; !ok {
+		entry := &journalEntry{
+			JobName:   jobName,
+			JobID:     jobID,
+			Attempt:   attempt,
+			BootID:    utils.BootID(),
+			StartTime: time.Now(),
+		}
+		j.entries[key] = entry
+		j.save(key, entry)
+	}
+	return &journalRecorder{journal: j, key: key}
+}
+
+// end 任务正常结束，删除记录
+func (j *journal) end(jobName string, jobID int) {
+	key := utils.FormatJobToString(jobName, jobID)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.entries, key)
+	if err := os.Remove(j.filePath(key)); err != nil && !os.IsNotExist(err) {
+		logger.Warnf("remove journal %s failed: %s", key, err)
+	}
+}
+
+// update 修改任务的记录并保存
+func (j *journal) update(key string, change func(entry *journalEntry)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, ok := j.entries[key]
+	if !ok {
+		return
+	}
+	change(entry)
+	j.save(key, entry)
+}
+
+// save 调用方需要持有锁
+func (j *journal) save(key string, entry *journalEntry) {
+	data, err := yaml.Marshal(entry)
+	if err != nil {
+		logger.Errorf("marshal journal %s failed: %s", key, err)
+		return
+	}
+	if err := os.MkdirAll(j.dir, os.ModePerm); err != nil {
+		logger.Errorf("create journal dir failed: %s", err)
+		return
+	}
+	if err := os.WriteFile(j.filePath(key), data, 0644); err != nil {
+		logger.Errorf("write journal %s failed: %s", key, err)
+	}
+}
+
+// orphans 读取上一次运行遗留的记录，这些任务没有正常结束
+func (j *journal) orphans() []*journalEntry {
+	files, err := filepath.Glob(filepath.Join(j.dir, "*.yml"))
+	if err != nil {
+		return nil
+	}
+	entries := make([]*journalEntry, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			logger.Warnf("read journal %s failed: %s", file, err)
+			continue
+		}
+		var entry journalEntry
+		if err := yaml.Unmarshal(data, &entry); err != nil {
+			logger.Warnf("unmarshal journal %s failed: %s", file, err)
+			_ = os.Remove(file)
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+	return entries
+}
+
+// journalRecorder 把任务启动的进程组记录到 journal 中
+type journalRecorder struct {
+	journal *journal
+	key     string
+}
+
+func (r *journalRecorder) ProcessStarted(pgid int) {
+	r.journal.update(r.key, func(entry *journalEntry) {
+		entry.Processes = append(entry.Processes, pgid)
+	})
+}
+
+func (r *journalRecorder) ProcessExited(pgid int) {
+	r.journal.update(r.key, func(entry *journalEntry) {
+		for i, p := range entry.Processes {
+			if p == pgid {
+				entry.Processes = append(entry.Processes[:i], entry.Processes[i+1:]...)
+				break
+			}
+		}
+	})
+}
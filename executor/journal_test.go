@@ -0,0 +1,32 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	j := newJournal(dir)
+
+	recorder := j.begin("build", 1, 2)
+	recorder.ProcessStarted(100)
+	recorder.ProcessStarted(200)
+	recorder.ProcessExited(100)
+	// 排队的任务开始执行时沿用原来的记录
+	j.begin("build", 1, 2).ProcessStarted(300)
+	j.begin("deploy", 3, 1)
+	j.end("deploy", 3)
+
+	// worker 重启后读取遗留的记录
+	orphans := newJournal(dir).orphans()
+	assert.Len(t, orphans, 1)
+	assert.Equal(t, "build", orphans[0].JobName)
+	assert.Equal(t, 1, orphans[0].JobID)
+	assert.Equal(t, 2, orphans[0].Attempt)
+	assert.Equal(t, []int{200, 300}, orphans[0].Processes)
+
+	j.end("build", 1)
+	assert.Empty(t, newJournal(dir).orphans())
+}
@@ -0,0 +1,113 @@
+package executor
+
+import (
+	"strings"
+
+	"github.com/hamster-shared/aline-engine/action"
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/utils"
+)
+
+// secretMask 敏感信息在执行计划中显示的内容
+const secretMask = "***"
+
+// 名称中包含这些词的参数和 with 视为敏感信息
+var secretKeywords = []string{"secret", "token", "password", "passwd", "private", "credential", "apikey", "api_key", "access_key"}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, keyword := range secretKeywords {
+		if strings.Contains(key, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// Plan 生成任务的执行计划，params 覆盖 job 中保存的参数
+// 与 Run 使用相同的 stage 排序、资源合并和 action 解析，但不会创建工作目录或执行任何命令
+func Plan(job *model.Job, params map[string]string) (*model.JobPlan, error) {
+	groups, err := job.StageGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	parameter := make(map[string]string, len(job.Parameter)+len(params))
+	for k, v := range job.Parameter {
+		parameter[k] = v
+	}
+	for k, v := range params {
+		parameter[k] = v
+	}
+	// 使用脱敏后的参数渲染，敏感参数的值不会出现在计划中
+	masked := make(map[string]string, len(parameter))
+	for k, v := range parameter {
+		if isSecretKey(k) {
+			v = secretMask
+		}
+		masked[k] = v
+	}
+
+	plan := &model.JobPlan{
+		Name:      job.Name,
+		Parameter: masked,
+		Groups:    make([][]string, 0, len(groups)),
+		Stages:    make([]model.StagePlan, 0, len(job.Stages)),
+	}
+	for index, group := range groups {
+		names := make([]string, 0, len(group))
+		for _, stage := range group {
+			names = append(names, stage.Name)
+			plan.Stages = append(plan.Stages, planStage(index, stage, job.Defaults, masked))
+		}
+		plan.Groups = append(plan.Groups, names)
+	}
+	return plan, nil
+}
+
+func planStage(group int, stage model.StageDetail, defaults *model.Defaults, params map[string]string) model.StagePlan {
+	stagePlan := model.StagePlan{
+		Name:     stage.Name,
+		Group:    group,
+		Needs:    stage.Stage.Needs,
+		Approval: stage.Stage.Approval != nil,
+		Steps:    make([]model.StepPlan, 0, len(stage.Stage.Steps)),
+	}
+	for _, step := range stage.Stage.Steps {
+		model.ApplyDefaults(&step, defaults, stage.Stage.Defaults)
+		kind, source := action.Resolve(step.Uses)
+		stepPlan := model.StepPlan{
+			Name:      step.Name,
+			Uses:      step.Uses,
+			Action:    string(kind),
+			Source:    source,
+			Image:     step.RunsOn,
+			Volumes:   step.Volumes,
+			Resources: model.MergeResources(stage.Stage.Resources, step.Resources),
+			Container: model.MergeContainerOptions(stage.Stage.Container, step.Container),
+		}
+		if kind == action.KindShell {
+			stepPlan.Run = utils.ReplaceWithParam(step.Run, params)
+			stepPlan.Shell = step.Shell
+			stepPlan.WorkingDirectory = utils.ReplaceWithParam(step.WorkingDirectory, params)
+		}
+		if len(step.With) > 0 {
+			stepPlan.With = make(map[string]string, len(step.With))
+			for k, v := range step.With {
+				if isSecretKey(k) {
+					v = secretMask
+				} else {
+					v = utils.ReplaceWithParam(v, params)
+				}
+				stepPlan.With[k] = v
+			}
+		}
+		// 执行时找不到 action 的 step 不会做任何事情，插件只在 worker 上注册，这里也会显示为 unknown
+		if kind == action.KindUnknown {
+			stepPlan.Skipped = true
+			stepPlan.SkipReason = "unknown action " + step.Uses
+		}
+		stagePlan.Steps = append(stagePlan.Steps, stepPlan)
+	}
+	return stagePlan
+}
@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan(t *testing.T) {
+	job := &model.Job{
+		Name:      "plan",
+		Parameter: map[string]string{"branch": "main", "deploy_token": "abc"},
+		Stages: map[string]model.Stage{
+			"checkout": {Steps: []model.Step{{Name: "git", Uses: "git-checkout", With: map[string]string{"url": "https://example.com/repo.git", "branch": "${{ param.branch }}"}}}},
+			"build": {
+				Needs:     []string{"checkout"},
+				Resources: &model.Resources{Memory: "1G"},
+				Steps: []model.Step{
+					{Name: "compile", RunsOn: "node:18", Volumes: []string{"/cache:/cache"}, Run: "npm run build --token ${{ param.deploy_token }}"},
+					{Name: "remote", Uses: "hamster-shared/some-action", With: map[string]string{"api_key": "plain"}},
+					{Name: "typo", Uses: "not-exist"},
+				},
+			},
+		},
+	}
+
+	plan, err := Plan(job, map[string]string{"branch": "release"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"checkout"}, {"build"}}, plan.Groups)
+	assert.Equal(t, "***", plan.Parameter["deploy_token"])
+	assert.Equal(t, "release", plan.Parameter["branch"])
+
+	checkout := plan.Stages[0].Steps[0]
+	assert.Equal(t, "builtin", checkout.Action)
+	assert.Equal(t, "release", checkout.With["branch"])
+
+	build := plan.Stages[1]
+	assert.Equal(t, 1, build.Group)
+	assert.Equal(t, "shell", build.Steps[0].Action)
+	assert.Equal(t, "npm run build --token ***", build.Steps[0].Run)
+	assert.Equal(t, "node:18", build.Steps[0].Image)
+	assert.Equal(t, "1G", build.Steps[0].Resources.Memory)
+	assert.Equal(t, "remote", build.Steps[1].Action)
+	assert.Equal(t, "https://github.com/hamster-shared/some-action", build.Steps[1].Source)
+	assert.Equal(t, "***", build.Steps[1].With["api_key"])
+	assert.True(t, build.Steps[2].Skipped)
+	assert.False(t, build.Steps[0].Skipped)
+}
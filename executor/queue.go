@@ -0,0 +1,158 @@
+package executor
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/hamster-shared/aline-engine/model"
+)
+
+// runQueue 限制同时执行的任务数，超出的任务按先进先出的顺序在本地排队
+type runQueue struct {
+	mu      sync.Mutex
+	max     int
+	running map[string]struct{} // key: jobName/jobID，正在占用执行槽位的任务
+	pending *list.List          // value: *queuedRun，排队中的任务
+	changed func(capacity model.NodeCapacity)
+}
+
+type queuedRun struct {
+	key   string
+	start func(queued bool)
+	// 审批通过后等待重新占用槽位的任务，已经开始执行，不能被 cancel 移除
+	resume bool
+}
+
+func newRunQueue(max int) *runQueue {
+	if max < 1 {
+		max = 1
+	}
+	return &runQueue{
+		max:     max,
+		running: make(map[string]struct{}),
+		pending: list.New(),
+	}
+}
+
+// submit 提交任务，有空闲槽位时立即在新的 goroutine 中执行 start，否则排队并调用 onQueued，返回是否排队
+// onQueued 在任务可能开始执行之前调用，保证排队的通知先于开始执行
+func (q *runQueue) submit(key string, start func(queued bool), onQueued func()) bool {
+	q.mu.Lock()
+	queued := len(q.running) >= q.max
+	if queued {
+		q.pending.PushBack(&queuedRun{key: key, start: start})
+		if onQueued != nil {
+			onQueued()
+		}
+	} else {
+		q.running[key] = struct{}{}
+	}
+	q.mu.Unlock()
+
+	if !queued {
+		go start(false)
+	}
+	q.notify()
+	return queued
+}
+
+// done 任务执行结束，释放槽位并启动排在最前面的任务
+func (q *runQueue) done(key string) {
+	q.release(key)
+}
+
+// park 任务暂时不占用槽位，比如在等待人工审批
+func (q *runQueue) park(key string) {
+	q.release(key)
+}
+
+// unpark 暂停的任务继续执行，重新占用槽位
+// 没有空闲槽位时排在其他恢复执行的任务之后、新任务之前，阻塞到有槽位释放，不会超过最大并发数
+func (q *runQueue) unpark(key string) {
+	q.mu.Lock()
+	if len(q.running) < q.max {
+		q.running[key] = struct{}{}
+		q.mu.Unlock()
+		q.notify()
+		return
+	}
+	ready := make(chan struct{})
+	run := &queuedRun{key: key, start: func(bool) { close(ready) }, resume: true}
+	var last *list.Element
+	for e := q.pending.Front(); e != nil && e.Value.(*queuedRun).resume; e = e.Next() {
+		last = e
+	}
+	if last != nil {
+		q.pending.InsertAfter(run, last)
+	} else {
+		q.pending.PushFront(run)
+	}
+	q.mu.Unlock()
+	q.notify()
+	<-ready
+}
+
+func (q *runQueue) release(key string) {
+	q.mu.Lock()
+	delete(q.running, key)
+	var next []*queuedRun
+	for len(q.running) < q.max && q.pending.Len() > 0 {
+		run := q.pending.Remove(q.pending.Front()).(*queuedRun)
+		q.running[run.key] = struct{}{}
+		next = append(next, run)
+	}
+	q.mu.Unlock()
+
+	for _, run := range next {
+		go run.start(true)
+	}
+	q.notify()
+}
+
+// cancel 从队列中移除还没开始执行的任务，返回任务是否在排队
+func (q *runQueue) cancel(key string) bool {
+	q.mu.Lock()
+	removed := false
+	for e := q.pending.Front(); e != nil; e = e.Next() {
+		if run := e.Value.(*queuedRun); run.key == key && !run.resume {
+			q.pending.Remove(e)
+			removed = true
+			break
+		}
+	}
+	q.mu.Unlock()
+
+	if removed {
+		q.notify()
+	}
+	return removed
+}
+
+// isQueued 任务是否在排队
+func (q *runQueue) isQueued(key string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for e := q.pending.Front(); e != nil; e = e.Next() {
+		if run := e.Value.(*queuedRun); run.key == key && !run.resume {
+			return true
+		}
+	}
+	return false
+}
+
+// capacity 返回当前的执行能力
+func (q *runQueue) capacity() model.NodeCapacity {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return model.NodeCapacity{
+		Max:     q.max,
+		Running: len(q.running),
+		Queued:  q.pending.Len(),
+	}
+}
+
+func (q *runQueue) notify() {
+	if q.changed != nil {
+		q.changed(q.capacity())
+	}
+}
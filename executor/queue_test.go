@@ -0,0 +1,91 @@
+package executor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunQueue(t *testing.T) {
+	q := newRunQueue(1)
+	started := make(chan string, 3)
+	start := func(key string) func(queued bool) {
+		return func(queued bool) {
+			started <- key
+		}
+	}
+
+	assert.False(t, q.submit("a/1", start("a/1"), nil))
+	assert.Equal(t, "a/1", <-started)
+
+	queued := 0
+	assert.True(t, q.submit("b/1", start("b/1"), func() { queued++ }))
+	assert.True(t, q.submit("c/1", start("c/1"), func() { queued++ }))
+	assert.Equal(t, 2, queued)
+	assert.True(t, q.isQueued("b/1"))
+
+	capacity := q.capacity()
+	assert.Equal(t, 1, capacity.Max)
+	assert.Equal(t, 1, capacity.Running)
+	assert.Equal(t, 2, capacity.Queued)
+	assert.True(t, capacity.IsFull())
+
+	// 排队中的任务可以直接取消
+	assert.True(t, q.cancel("b/1"))
+	assert.False(t, q.cancel("b/1"))
+
+	// 先进先出，释放槽位后启动排在最前面的任务
+	q.done("a/1")
+	assert.Equal(t, "c/1", <-started)
+	assert.False(t, q.isQueued("c/1"))
+
+	// 等待审批的任务不占用槽位
+	q.park("c/1")
+	assert.Equal(t, 0, q.capacity().Running)
+	q.unpark("c/1")
+	assert.Equal(t, 1, q.capacity().Running)
+}
+
+func TestRunQueueUnparkWhenFull(t *testing.T) {
+	q := newRunQueue(1)
+	started := make(chan string, 3)
+	start := func(key string) func(queued bool) {
+		return func(queued bool) {
+			started <- key
+		}
+	}
+	assert.False(t, q.submit("a/1", start("a/1"), nil))
+	assert.Equal(t, "a/1", <-started)
+
+	// a 等待审批时槽位被新的任务占用
+	q.park("a/1")
+	assert.False(t, q.submit("b/1", start("b/1"), nil))
+	assert.Equal(t, "b/1", <-started)
+	assert.True(t, q.submit("c/1", start("c/1"), nil))
+
+	// 审批通过后等待槽位，不能超过最大并发数，也不能被当作排队的任务取消
+	resumed := make(chan struct{})
+	go func() {
+		q.unpark("a/1")
+		close(resumed)
+	}()
+	assert.Eventually(t, func() bool { return q.capacity().Queued == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, 1, q.capacity().Running)
+	assert.False(t, q.isQueued("a/1"))
+	assert.False(t, q.cancel("a/1"))
+	select {
+	case <-resumed:
+		t.Fatal("unpark should wait for a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 恢复执行的任务排在新任务之前
+	q.done("b/1")
+	<-resumed
+	assert.Equal(t, 1, q.capacity().Running)
+	assert.True(t, q.isQueued("c/1"))
+	q.done("a/1")
+	assert.Equal(t, "c/1", <-started)
+	assert.Equal(t, 1, q.capacity().Running)
+}
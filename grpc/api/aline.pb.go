@@ -33,21 +33,23 @@ const (
 	MessageType_ERROR      MessageType = 7
 	MessageType_FILE       MessageType = 8
 	MessageType_STATUS     MessageType = 9
+	MessageType_APPROVAL   MessageType = 10
 )
 
 // Enum value maps for MessageType.
 var (
 	MessageType_name = map[int32]string{
-		0: "REGISTER",
-		1: "UNREGISTER",
-		2: "HEARTBEAT",
-		3: "EXECUTE",
-		4: "CANCEL",
-		5: "RESULT",
-		6: "LOG",
-		7: "ERROR",
-		8: "FILE",
-		9: "STATUS",
+		0:  "REGISTER",
+		1:  "UNREGISTER",
+		2:  "HEARTBEAT",
+		3:  "EXECUTE",
+		4:  "CANCEL",
+		5:  "RESULT",
+		6:  "LOG",
+		7:  "ERROR",
+		8:  "FILE",
+		9:  "STATUS",
+		10: "APPROVAL",
 	}
 	MessageType_value = map[string]int32{
 		"REGISTER":   0,
@@ -60,6 +62,7 @@ var (
 		"ERROR":      7,
 		"FILE":       8,
 		"STATUS":     9,
+		"APPROVAL":   10,
 	}
 )
 
@@ -93,11 +96,16 @@ func (MessageType) EnumDescriptor() ([]byte, []int) {
 type JobStatus int32
 
 const (
-	JobStatus_NOTRUN  JobStatus = 0
-	JobStatus_RUNNING JobStatus = 1
-	JobStatus_FAIL    JobStatus = 2
-	JobStatus_SUCCESS JobStatus = 3
-	JobStatus_STOP    JobStatus = 4
+	JobStatus_NOTRUN           JobStatus = 0
+	JobStatus_RUNNING          JobStatus = 1
+	JobStatus_FAIL             JobStatus = 2
+	JobStatus_SUCCESS          JobStatus = 3
+	JobStatus_STOP             JobStatus = 4
+	JobStatus_QUEUED           JobStatus = 5
+	JobStatus_SKIPPED          JobStatus = 6
+	JobStatus_CANCELLED        JobStatus = 7
+	JobStatus_TIMEOUT          JobStatus = 8
+	JobStatus_WAITING_APPROVAL JobStatus = 9
 )
 
 // Enum value maps for JobStatus.
@@ -108,13 +116,23 @@ var (
 		2: "FAIL",
 		3: "SUCCESS",
 		4: "STOP",
+		5: "QUEUED",
+		6: "SKIPPED",
+		7: "CANCELLED",
+		8: "TIMEOUT",
+		9: "WAITING_APPROVAL",
 	}
 	JobStatus_value = map[string]int32{
-		"NOTRUN":  0,
-		"RUNNING": 1,
-		"FAIL":    2,
-		"SUCCESS": 3,
-		"STOP":    4,
+		"NOTRUN":           0,
+		"RUNNING":          1,
+		"FAIL":             2,
+		"SUCCESS":          3,
+		"STOP":             4,
+		"QUEUED":           5,
+		"SKIPPED":          6,
+		"CANCELLED":        7,
+		"TIMEOUT":          8,
+		"WAITING_APPROVAL": 9,
 	}
 )
 
@@ -156,9 +174,11 @@ type AlineMessage struct {
 	// 3: execute 服务端发的执行命令
 	// 4: cancel 服务端发的取消执行命令
 	// 5: executeResultNotify 客户端发的执行结果通知
-	// 6: log 客户端发的日志
+	// 6: log 客户端发的日志，服务端回复已经保存的日志长度
 	// 7: 错误
 	// 8: 文件
+	// 9: 任务状态
+	// 10: approval 服务端发的审批结果
 	Type MessageType `protobuf:"varint,1,opt,name=type,proto3,enum=api.MessageType" json:"type,omitempty"`
 	// registry
 	Name    string      `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
@@ -167,10 +187,15 @@ type AlineMessage struct {
 	// execute result
 	Result *ExecuteResult `protobuf:"bytes,5,opt,name=result,proto3" json:"result,omitempty"`
 	// log
-	Log    string    `protobuf:"bytes,6,opt,name=log,proto3" json:"log,omitempty"`
-	Error  string    `protobuf:"bytes,7,opt,name=error,proto3" json:"error,omitempty"`
-	File   *File     `protobuf:"bytes,8,opt,name=file,proto3" json:"file,omitempty"`
-	Status JobStatus `protobuf:"varint,9,opt,name=status,proto3,enum=api.JobStatus" json:"status,omitempty"`
+	Log      string    `protobuf:"bytes,6,opt,name=log,proto3" json:"log,omitempty"`
+	Error    string    `protobuf:"bytes,7,opt,name=error,proto3" json:"error,omitempty"`
+	File     *File     `protobuf:"bytes,8,opt,name=file,proto3" json:"file,omitempty"`
+	Status   JobStatus `protobuf:"varint,9,opt,name=status,proto3,enum=api.JobStatus" json:"status,omitempty"`
+	Approval *Approval `protobuf:"bytes,10,opt,name=approval,proto3" json:"approval,omitempty"`
+	// register 和 heartbeat 时上报的执行能力
+	Capacity *Capacity `protobuf:"bytes,11,opt,name=capacity,proto3" json:"capacity,omitempty"`
+	// log 在 worker 日志文件中的位置，master 回复时为已经保存的日志长度
+	LogOffset int64 `protobuf:"varint,12,opt,name=logOffset,proto3" json:"logOffset,omitempty"`
 }
 
 func (x *AlineMessage) Reset() {
@@ -268,6 +293,103 @@ func (x *AlineMessage) GetStatus() JobStatus {
 	return JobStatus_NOTRUN
 }
 
+func (x *AlineMessage) GetApproval() *Approval {
+	if x != nil {
+		return x.Approval
+	}
+	return nil
+}
+
+func (x *AlineMessage) GetCapacity() *Capacity {
+	if x != nil {
+		return x.Capacity
+	}
+	return nil
+}
+
+func (x *AlineMessage) GetLogOffset() int64 {
+	if x != nil {
+		return x.LogOffset
+	}
+	return 0
+}
+
+// worker 的执行能力
+type Capacity struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// 最多同时执行多少个任务
+	Max int64 `protobuf:"varint,1,opt,name=max,proto3" json:"max,omitempty"`
+	// 正在执行的任务数
+	Running int64 `protobuf:"varint,2,opt,name=running,proto3" json:"running,omitempty"`
+	// 本地排队的任务数
+	Queued int64 `protobuf:"varint,3,opt,name=queued,proto3" json:"queued,omitempty"`
+	// worker 上安装的插件 action
+	Actions []string `protobuf:"bytes,4,rep,name=actions,proto3" json:"actions,omitempty"`
+}
+
+func (x *Capacity) Reset() {
+	*x = Capacity{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_grpc_api_aline_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Capacity) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Capacity) ProtoMessage() {}
+
+func (x *Capacity) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_api_aline_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Capacity.ProtoReflect.Descriptor instead.
+func (*Capacity) Descriptor() ([]byte, []int) {
+	return file_grpc_api_aline_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Capacity) GetMax() int64 {
+	if x != nil {
+		return x.Max
+	}
+	return 0
+}
+
+func (x *Capacity) GetRunning() int64 {
+	if x != nil {
+		return x.Running
+	}
+	return 0
+}
+
+func (x *Capacity) GetQueued() int64 {
+	if x != nil {
+		return x.Queued
+	}
+	return 0
+}
+
+func (x *Capacity) GetActions() []string {
+	if x != nil {
+		return x.Actions
+	}
+	return nil
+}
+
 type ExecuteReq struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -278,12 +400,18 @@ type ExecuteReq struct {
 	PipelineFile string `protobuf:"bytes,2,opt,name=pipelineFile,proto3" json:"pipelineFile,omitempty"`
 	// job exec id
 	JobDetailId int64 `protobuf:"varint,3,opt,name=jobDetailId,proto3" json:"jobDetailId,omitempty"`
+	// resume from stage, previousDetail 不为空时表示恢复执行，为空时从第一个失败的 stage 开始
+	ResumeStage string `protobuf:"bytes,4,opt,name=resumeStage,proto3" json:"resumeStage,omitempty"`
+	// previous job detail yaml
+	PreviousDetail string `protobuf:"bytes,5,opt,name=previousDetail,proto3" json:"previousDetail,omitempty"`
+	// 第几次执行，从 1 开始
+	Attempt int64 `protobuf:"varint,6,opt,name=attempt,proto3" json:"attempt,omitempty"`
 }
 
 func (x *ExecuteReq) Reset() {
 	*x = ExecuteReq{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_grpc_api_aline_proto_msgTypes[1]
+		mi := &file_grpc_api_aline_proto_msgTypes[2]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -296,7 +424,7 @@ func (x *ExecuteReq) String() string {
 func (*ExecuteReq) ProtoMessage() {}
 
 func (x *ExecuteReq) ProtoReflect() protoreflect.Message {
-	mi := &file_grpc_api_aline_proto_msgTypes[1]
+	mi := &file_grpc_api_aline_proto_msgTypes[2]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -309,7 +437,7 @@ func (x *ExecuteReq) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ExecuteReq.ProtoReflect.Descriptor instead.
 func (*ExecuteReq) Descriptor() ([]byte, []int) {
-	return file_grpc_api_aline_proto_rawDescGZIP(), []int{1}
+	return file_grpc_api_aline_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *ExecuteReq) GetName() string {
@@ -333,6 +461,27 @@ func (x *ExecuteReq) GetJobDetailId() int64 {
 	return 0
 }
 
+func (x *ExecuteReq) GetResumeStage() string {
+	if x != nil {
+		return x.ResumeStage
+	}
+	return ""
+}
+
+func (x *ExecuteReq) GetPreviousDetail() string {
+	if x != nil {
+		return x.PreviousDetail
+	}
+	return ""
+}
+
+func (x *ExecuteReq) GetAttempt() int64 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
 type ExecuteResult struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -347,7 +496,7 @@ type ExecuteResult struct {
 func (x *ExecuteResult) Reset() {
 	*x = ExecuteResult{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_grpc_api_aline_proto_msgTypes[2]
+		mi := &file_grpc_api_aline_proto_msgTypes[3]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -360,7 +509,7 @@ func (x *ExecuteResult) String() string {
 func (*ExecuteResult) ProtoMessage() {}
 
 func (x *ExecuteResult) ProtoReflect() protoreflect.Message {
-	mi := &file_grpc_api_aline_proto_msgTypes[2]
+	mi := &file_grpc_api_aline_proto_msgTypes[3]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -373,7 +522,7 @@ func (x *ExecuteResult) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ExecuteResult.ProtoReflect.Descriptor instead.
 func (*ExecuteResult) Descriptor() ([]byte, []int) {
-	return file_grpc_api_aline_proto_rawDescGZIP(), []int{2}
+	return file_grpc_api_aline_proto_rawDescGZIP(), []int{3}
 }
 
 func (x *ExecuteResult) GetJobName() string {
@@ -404,6 +553,77 @@ func (x *ExecuteResult) GetError() string {
 	return ""
 }
 
+type Approval struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Stage    string `protobuf:"bytes,1,opt,name=stage,proto3" json:"stage,omitempty"`
+	Approver string `protobuf:"bytes,2,opt,name=approver,proto3" json:"approver,omitempty"`
+	Approved bool   `protobuf:"varint,3,opt,name=approved,proto3" json:"approved,omitempty"`
+	Comment  string `protobuf:"bytes,4,opt,name=comment,proto3" json:"comment,omitempty"`
+}
+
+func (x *Approval) Reset() {
+	*x = Approval{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_grpc_api_aline_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Approval) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Approval) ProtoMessage() {}
+
+func (x *Approval) ProtoReflect() protoreflect.Message {
+	mi := &file_grpc_api_aline_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Approval.ProtoReflect.Descriptor instead.
+func (*Approval) Descriptor() ([]byte, []int) {
+	return file_grpc_api_aline_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Approval) GetStage() string {
+	if x != nil {
+		return x.Stage
+	}
+	return ""
+}
+
+func (x *Approval) GetApprover() string {
+	if x != nil {
+		return x.Approver
+	}
+	return ""
+}
+
+func (x *Approval) GetApproved() bool {
+	if x != nil {
+		return x.Approved
+	}
+	return false
+}
+
+func (x *Approval) GetComment() string {
+	if x != nil {
+		return x.Comment
+	}
+	return ""
+}
+
 type File struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -416,7 +636,7 @@ type File struct {
 func (x *File) Reset() {
 	*x = File{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_grpc_api_aline_proto_msgTypes[3]
+		mi := &file_grpc_api_aline_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -429,7 +649,7 @@ func (x *File) String() string {
 func (*File) ProtoMessage() {}
 
 func (x *File) ProtoReflect() protoreflect.Message {
-	mi := &file_grpc_api_aline_proto_msgTypes[3]
+	mi := &file_grpc_api_aline_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -442,7 +662,7 @@ func (x *File) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use File.ProtoReflect.Descriptor instead.
 func (*File) Descriptor() ([]byte, []int) {
-	return file_grpc_api_aline_proto_rawDescGZIP(), []int{3}
+	return file_grpc_api_aline_proto_rawDescGZIP(), []int{5}
 }
 
 func (x *File) GetPath() string {
@@ -463,7 +683,7 @@ var File_grpc_api_aline_proto protoreflect.FileDescriptor
 
 var file_grpc_api_aline_proto_rawDesc = []byte{
 	0x0a, 0x14, 0x67, 0x72, 0x70, 0x63, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x61, 0x6c, 0x69, 0x6e, 0x65,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x03, 0x61, 0x70, 0x69, 0x22, 0xa8, 0x02, 0x0a, 0x0c,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x03, 0x61, 0x70, 0x69, 0x22, 0x9c, 0x03, 0x0a, 0x0c,
 	0x41, 0x6c, 0x69, 0x6e, 0x65, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x24, 0x0a, 0x04,
 	0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x10, 0x2e, 0x61, 0x70, 0x69,
 	0x2e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79,
@@ -482,45 +702,79 @@ var file_grpc_api_aline_proto_rawDesc = []byte{
 	0x61, 0x70, 0x69, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x52, 0x04, 0x66, 0x69, 0x6c, 0x65, 0x12, 0x26,
 	0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0e,
 	0x2e, 0x61, 0x70, 0x69, 0x2e, 0x4a, 0x6f, 0x62, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x06,
-	0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x22, 0x66, 0x0a, 0x0a, 0x45, 0x78, 0x65, 0x63, 0x75, 0x74,
-	0x65, 0x52, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x70, 0x69, 0x70, 0x65,
-	0x6c, 0x69, 0x6e, 0x65, 0x46, 0x69, 0x6c, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c,
-	0x70, 0x69, 0x70, 0x65, 0x6c, 0x69, 0x6e, 0x65, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x20, 0x0a, 0x0b,
-	0x6a, 0x6f, 0x62, 0x44, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x49, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28,
-	0x03, 0x52, 0x0b, 0x6a, 0x6f, 0x62, 0x44, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x49, 0x64, 0x22, 0x73,
-	0x0a, 0x0d, 0x45, 0x78, 0x65, 0x63, 0x75, 0x74, 0x65, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12,
-	0x18, 0x0a, 0x07, 0x6a, 0x6f, 0x62, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x07, 0x6a, 0x6f, 0x62, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x6a, 0x6f, 0x62,
-	0x49, 0x44, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x6a, 0x6f, 0x62, 0x49, 0x44, 0x12,
-	0x1c, 0x0a, 0x09, 0x6a, 0x6f, 0x62, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x03, 0x20, 0x01,
-	0x28, 0x03, 0x52, 0x09, 0x6a, 0x6f, 0x62, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x14, 0x0a,
-	0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x65, 0x72,
-	0x72, 0x6f, 0x72, 0x22, 0x2e, 0x0a, 0x04, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x70,
-	0x61, 0x74, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x12,
-	0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64,
-	0x61, 0x74, 0x61, 0x2a, 0x89, 0x01, 0x0a, 0x0b, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54,
-	0x79, 0x70, 0x65, 0x12, 0x0c, 0x0a, 0x08, 0x52, 0x45, 0x47, 0x49, 0x53, 0x54, 0x45, 0x52, 0x10,
-	0x00, 0x12, 0x0e, 0x0a, 0x0a, 0x55, 0x4e, 0x52, 0x45, 0x47, 0x49, 0x53, 0x54, 0x45, 0x52, 0x10,
-	0x01, 0x12, 0x0d, 0x0a, 0x09, 0x48, 0x45, 0x41, 0x52, 0x54, 0x42, 0x45, 0x41, 0x54, 0x10, 0x02,
-	0x12, 0x0b, 0x0a, 0x07, 0x45, 0x58, 0x45, 0x43, 0x55, 0x54, 0x45, 0x10, 0x03, 0x12, 0x0a, 0x0a,
-	0x06, 0x43, 0x41, 0x4e, 0x43, 0x45, 0x4c, 0x10, 0x04, 0x12, 0x0a, 0x0a, 0x06, 0x52, 0x45, 0x53,
-	0x55, 0x4c, 0x54, 0x10, 0x05, 0x12, 0x07, 0x0a, 0x03, 0x4c, 0x4f, 0x47, 0x10, 0x06, 0x12, 0x09,
-	0x0a, 0x05, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x10, 0x07, 0x12, 0x08, 0x0a, 0x04, 0x46, 0x49, 0x4c,
-	0x45, 0x10, 0x08, 0x12, 0x0a, 0x0a, 0x06, 0x53, 0x54, 0x41, 0x54, 0x55, 0x53, 0x10, 0x09, 0x2a,
-	0x45, 0x0a, 0x09, 0x4a, 0x6f, 0x62, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x0a, 0x0a, 0x06,
-	0x4e, 0x4f, 0x54, 0x52, 0x55, 0x4e, 0x10, 0x00, 0x12, 0x0b, 0x0a, 0x07, 0x52, 0x55, 0x4e, 0x4e,
-	0x49, 0x4e, 0x47, 0x10, 0x01, 0x12, 0x08, 0x0a, 0x04, 0x46, 0x41, 0x49, 0x4c, 0x10, 0x02, 0x12,
-	0x0b, 0x0a, 0x07, 0x53, 0x55, 0x43, 0x43, 0x45, 0x53, 0x53, 0x10, 0x03, 0x12, 0x08, 0x0a, 0x04,
-	0x53, 0x54, 0x4f, 0x50, 0x10, 0x04, 0x32, 0x43, 0x0a, 0x08, 0x41, 0x6c, 0x69, 0x6e, 0x65, 0x52,
-	0x50, 0x43, 0x12, 0x37, 0x0a, 0x09, 0x41, 0x6c, 0x69, 0x6e, 0x65, 0x43, 0x68, 0x61, 0x74, 0x12,
-	0x11, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x41, 0x6c, 0x69, 0x6e, 0x65, 0x4d, 0x65, 0x73, 0x73, 0x61,
-	0x67, 0x65, 0x1a, 0x11, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x41, 0x6c, 0x69, 0x6e, 0x65, 0x4d, 0x65,
-	0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x00, 0x28, 0x01, 0x30, 0x01, 0x42, 0x3b, 0x0a, 0x1f, 0x63,
-	0x6f, 0x6d, 0x2e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x68, 0x61, 0x6d, 0x73, 0x74, 0x65,
-	0x72, 0x2d, 0x73, 0x68, 0x61, 0x72, 0x65, 0x64, 0x2e, 0x61, 0x6c, 0x69, 0x6e, 0x65, 0x42, 0x0a,
-	0x41, 0x6c, 0x69, 0x6e, 0x65, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x50, 0x01, 0x5a, 0x0a, 0x2e, 0x2f,
-	0x67, 0x72, 0x70, 0x63, 0x2f, 0x61, 0x70, 0x69, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x29, 0x0a, 0x08, 0x61, 0x70, 0x70, 0x72, 0x6f, 0x76,
+	0x61, 0x6c, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0d, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x41,
+	0x70, 0x70, 0x72, 0x6f, 0x76, 0x61, 0x6c, 0x52, 0x08, 0x61, 0x70, 0x70, 0x72, 0x6f, 0x76, 0x61,
+	0x6c, 0x12, 0x29, 0x0a, 0x08, 0x63, 0x61, 0x70, 0x61, 0x63, 0x69, 0x74, 0x79, 0x18, 0x0b, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x0d, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x43, 0x61, 0x70, 0x61, 0x63, 0x69,
+	0x74, 0x79, 0x52, 0x08, 0x63, 0x61, 0x70, 0x61, 0x63, 0x69, 0x74, 0x79, 0x12, 0x1c, 0x0a, 0x09,
+	0x6c, 0x6f, 0x67, 0x4f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x09, 0x6c, 0x6f, 0x67, 0x4f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x22, 0x68, 0x0a, 0x08, 0x43, 0x61,
+	0x70, 0x61, 0x63, 0x69, 0x74, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6d, 0x61, 0x78, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x03, 0x6d, 0x61, 0x78, 0x12, 0x18, 0x0a, 0x07, 0x72, 0x75, 0x6e, 0x6e,
+	0x69, 0x6e, 0x67, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x72, 0x75, 0x6e, 0x6e, 0x69,
+	0x6e, 0x67, 0x12, 0x16, 0x0a, 0x06, 0x71, 0x75, 0x65, 0x75, 0x65, 0x64, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x06, 0x71, 0x75, 0x65, 0x75, 0x65, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x61, 0x63,
+	0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x61, 0x63, 0x74,
+	0x69, 0x6f, 0x6e, 0x73, 0x22, 0xca, 0x01, 0x0a, 0x0a, 0x45, 0x78, 0x65, 0x63, 0x75, 0x74, 0x65,
+	0x52, 0x65, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x70, 0x69, 0x70, 0x65, 0x6c,
+	0x69, 0x6e, 0x65, 0x46, 0x69, 0x6c, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x70,
+	0x69, 0x70, 0x65, 0x6c, 0x69, 0x6e, 0x65, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x20, 0x0a, 0x0b, 0x6a,
+	0x6f, 0x62, 0x44, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x49, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0b, 0x6a, 0x6f, 0x62, 0x44, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x49, 0x64, 0x12, 0x20, 0x0a,
+	0x0b, 0x72, 0x65, 0x73, 0x75, 0x6d, 0x65, 0x53, 0x74, 0x61, 0x67, 0x65, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x0b, 0x72, 0x65, 0x73, 0x75, 0x6d, 0x65, 0x53, 0x74, 0x61, 0x67, 0x65, 0x12,
+	0x26, 0x0a, 0x0e, 0x70, 0x72, 0x65, 0x76, 0x69, 0x6f, 0x75, 0x73, 0x44, 0x65, 0x74, 0x61, 0x69,
+	0x6c, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x70, 0x72, 0x65, 0x76, 0x69, 0x6f, 0x75,
+	0x73, 0x44, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x12, 0x18, 0x0a, 0x07, 0x61, 0x74, 0x74, 0x65, 0x6d,
+	0x70, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x61, 0x74, 0x74, 0x65, 0x6d, 0x70,
+	0x74, 0x22, 0x73, 0x0a, 0x0d, 0x45, 0x78, 0x65, 0x63, 0x75, 0x74, 0x65, 0x52, 0x65, 0x73, 0x75,
+	0x6c, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x6a, 0x6f, 0x62, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x07, 0x6a, 0x6f, 0x62, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05,
+	0x6a, 0x6f, 0x62, 0x49, 0x44, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x6a, 0x6f, 0x62,
+	0x49, 0x44, 0x12, 0x1c, 0x0a, 0x09, 0x6a, 0x6f, 0x62, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x6a, 0x6f, 0x62, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73,
+	0x12, 0x14, 0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x22, 0x72, 0x0a, 0x08, 0x41, 0x70, 0x70, 0x72, 0x6f, 0x76,
+	0x61, 0x6c, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x73, 0x74, 0x61, 0x67, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x61, 0x70, 0x70, 0x72,
+	0x6f, 0x76, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x61, 0x70, 0x70, 0x72,
+	0x6f, 0x76, 0x65, 0x72, 0x12, 0x1a, 0x0a, 0x08, 0x61, 0x70, 0x70, 0x72, 0x6f, 0x76, 0x65, 0x64,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x61, 0x70, 0x70, 0x72, 0x6f, 0x76, 0x65, 0x64,
+	0x12, 0x18, 0x0a, 0x07, 0x63, 0x6f, 0x6d, 0x6d, 0x65, 0x6e, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x07, 0x63, 0x6f, 0x6d, 0x6d, 0x65, 0x6e, 0x74, 0x22, 0x2e, 0x0a, 0x04, 0x46, 0x69,
+	0x6c, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x2a, 0x97, 0x01, 0x0a, 0x0b, 0x4d,
+	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0c, 0x0a, 0x08, 0x52, 0x45,
+	0x47, 0x49, 0x53, 0x54, 0x45, 0x52, 0x10, 0x00, 0x12, 0x0e, 0x0a, 0x0a, 0x55, 0x4e, 0x52, 0x45,
+	0x47, 0x49, 0x53, 0x54, 0x45, 0x52, 0x10, 0x01, 0x12, 0x0d, 0x0a, 0x09, 0x48, 0x45, 0x41, 0x52,
+	0x54, 0x42, 0x45, 0x41, 0x54, 0x10, 0x02, 0x12, 0x0b, 0x0a, 0x07, 0x45, 0x58, 0x45, 0x43, 0x55,
+	0x54, 0x45, 0x10, 0x03, 0x12, 0x0a, 0x0a, 0x06, 0x43, 0x41, 0x4e, 0x43, 0x45, 0x4c, 0x10, 0x04,
+	0x12, 0x0a, 0x0a, 0x06, 0x52, 0x45, 0x53, 0x55, 0x4c, 0x54, 0x10, 0x05, 0x12, 0x07, 0x0a, 0x03,
+	0x4c, 0x4f, 0x47, 0x10, 0x06, 0x12, 0x09, 0x0a, 0x05, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x10, 0x07,
+	0x12, 0x08, 0x0a, 0x04, 0x46, 0x49, 0x4c, 0x45, 0x10, 0x08, 0x12, 0x0a, 0x0a, 0x06, 0x53, 0x54,
+	0x41, 0x54, 0x55, 0x53, 0x10, 0x09, 0x12, 0x0c, 0x0a, 0x08, 0x41, 0x50, 0x50, 0x52, 0x4f, 0x56,
+	0x41, 0x4c, 0x10, 0x0a, 0x2a, 0x90, 0x01, 0x0a, 0x09, 0x4a, 0x6f, 0x62, 0x53, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x12, 0x0a, 0x0a, 0x06, 0x4e, 0x4f, 0x54, 0x52, 0x55, 0x4e, 0x10, 0x00, 0x12, 0x0b,
+	0x0a, 0x07, 0x52, 0x55, 0x4e, 0x4e, 0x49, 0x4e, 0x47, 0x10, 0x01, 0x12, 0x08, 0x0a, 0x04, 0x46,
+	0x41, 0x49, 0x4c, 0x10, 0x02, 0x12, 0x0b, 0x0a, 0x07, 0x53, 0x55, 0x43, 0x43, 0x45, 0x53, 0x53,
+	0x10, 0x03, 0x12, 0x08, 0x0a, 0x04, 0x53, 0x54, 0x4f, 0x50, 0x10, 0x04, 0x12, 0x0a, 0x0a, 0x06,
+	0x51, 0x55, 0x45, 0x55, 0x45, 0x44, 0x10, 0x05, 0x12, 0x0b, 0x0a, 0x07, 0x53, 0x4b, 0x49, 0x50,
+	0x50, 0x45, 0x44, 0x10, 0x06, 0x12, 0x0d, 0x0a, 0x09, 0x43, 0x41, 0x4e, 0x43, 0x45, 0x4c, 0x4c,
+	0x45, 0x44, 0x10, 0x07, 0x12, 0x0b, 0x0a, 0x07, 0x54, 0x49, 0x4d, 0x45, 0x4f, 0x55, 0x54, 0x10,
+	0x08, 0x12, 0x14, 0x0a, 0x10, 0x57, 0x41, 0x49, 0x54, 0x49, 0x4e, 0x47, 0x5f, 0x41, 0x50, 0x50,
+	0x52, 0x4f, 0x56, 0x41, 0x4c, 0x10, 0x09, 0x32, 0x43, 0x0a, 0x08, 0x41, 0x6c, 0x69, 0x6e, 0x65,
+	0x52, 0x50, 0x43, 0x12, 0x37, 0x0a, 0x09, 0x41, 0x6c, 0x69, 0x6e, 0x65, 0x43, 0x68, 0x61, 0x74,
+	0x12, 0x11, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x41, 0x6c, 0x69, 0x6e, 0x65, 0x4d, 0x65, 0x73, 0x73,
+	0x61, 0x67, 0x65, 0x1a, 0x11, 0x2e, 0x61, 0x70, 0x69, 0x2e, 0x41, 0x6c, 0x69, 0x6e, 0x65, 0x4d,
+	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x00, 0x28, 0x01, 0x30, 0x01, 0x42, 0x3b, 0x0a, 0x1f,
+	0x63, 0x6f, 0x6d, 0x2e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x68, 0x61, 0x6d, 0x73, 0x74,
+	0x65, 0x72, 0x2d, 0x73, 0x68, 0x61, 0x72, 0x65, 0x64, 0x2e, 0x61, 0x6c, 0x69, 0x6e, 0x65, 0x42,
+	0x0a, 0x41, 0x6c, 0x69, 0x6e, 0x65, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x50, 0x01, 0x5a, 0x0a, 0x2e,
+	0x2f, 0x67, 0x72, 0x70, 0x63, 0x2f, 0x61, 0x70, 0x69, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x33,
 }
 
 var (
@@ -536,28 +790,32 @@ func file_grpc_api_aline_proto_rawDescGZIP() []byte {
 }
 
 var file_grpc_api_aline_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
-var file_grpc_api_aline_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_grpc_api_aline_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_grpc_api_aline_proto_goTypes = []interface{}{
 	(MessageType)(0),      // 0: api.MessageType
 	(JobStatus)(0),        // 1: api.JobStatus
 	(*AlineMessage)(nil),  // 2: api.AlineMessage
-	(*ExecuteReq)(nil),    // 3: api.ExecuteReq
-	(*ExecuteResult)(nil), // 4: api.ExecuteResult
-	(*File)(nil),          // 5: api.File
+	(*Capacity)(nil),      // 3: api.Capacity
+	(*ExecuteReq)(nil),    // 4: api.ExecuteReq
+	(*ExecuteResult)(nil), // 5: api.ExecuteResult
+	(*Approval)(nil),      // 6: api.Approval
+	(*File)(nil),          // 7: api.File
 }
 var file_grpc_api_aline_proto_depIdxs = []int32{
 	0, // 0: api.AlineMessage.type:type_name -> api.MessageType
-	3, // 1: api.AlineMessage.execReq:type_name -> api.ExecuteReq
-	4, // 2: api.AlineMessage.result:type_name -> api.ExecuteResult
-	5, // 3: api.AlineMessage.file:type_name -> api.File
+	4, // 1: api.AlineMessage.execReq:type_name -> api.ExecuteReq
+	5, // 2: api.AlineMessage.result:type_name -> api.ExecuteResult
+	7, // 3: api.AlineMessage.file:type_name -> api.File
 	1, // 4: api.AlineMessage.status:type_name -> api.JobStatus
-	2, // 5: api.AlineRPC.AlineChat:input_type -> api.AlineMessage
-	2, // 6: api.AlineRPC.AlineChat:output_type -> api.AlineMessage
-	6, // [6:7] is the sub-list for method output_type
-	5, // [5:6] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	5, // [5:5] is the sub-list for extension extendee
-	0, // [0:5] is the sub-list for field type_name
+	6, // 5: api.AlineMessage.approval:type_name -> api.Approval
+	3, // 6: api.AlineMessage.capacity:type_name -> api.Capacity
+	2, // 7: api.AlineRPC.AlineChat:input_type -> api.AlineMessage
+	2, // 8: api.AlineRPC.AlineChat:output_type -> api.AlineMessage
+	8, // [8:9] is the sub-list for method output_type
+	7, // [7:8] is the sub-list for method input_type
+	7, // [7:7] is the sub-list for extension type_name
+	7, // [7:7] is the sub-list for extension extendee
+	0, // [0:7] is the sub-list for field type_name
 }
 
 func init() { file_grpc_api_aline_proto_init() }
@@ -579,7 +837,7 @@ func file_grpc_api_aline_proto_init() {
 			}
 		}
 		file_grpc_api_aline_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ExecuteReq); i {
+			switch v := v.(*Capacity); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -591,7 +849,7 @@ func file_grpc_api_aline_proto_init() {
 			}
 		}
 		file_grpc_api_aline_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*ExecuteResult); i {
+			switch v := v.(*ExecuteReq); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -603,6 +861,30 @@ func file_grpc_api_aline_proto_init() {
 			}
 		}
 		file_grpc_api_aline_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ExecuteResult); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_grpc_api_aline_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Approval); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_grpc_api_aline_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*File); i {
 			case 0:
 				return &v.state
@@ -621,7 +903,7 @@ func file_grpc_api_aline_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_grpc_api_aline_proto_rawDesc,
 			NumEnums:      2,
-			NumMessages:   4,
+			NumMessages:   6,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -0,0 +1,169 @@
+package job
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/logger"
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+)
+
+// NewJobAttempt 为 job detail 开始新的一次执行，返回新的执行序号
+// 如果当前这次执行还没有开始（状态为 NOTRUN），直接沿用当前的序号
+// 否则把当前的 job detail 和日志归档到 job-attempts 目录，再重置 job detail
+func NewJobAttempt(name string, id int) (int, error) {
+	jobDetail, err := GetJobDetail(name, id)
+	if err != nil {
+		return 0, err
+	}
+	attempt := jobDetail.CurrentAttempt()
+	if jobDetail.Status == model.STATUS_NOTRUN {
+		return attempt, nil
+	}
+
+	err = archiveJobAttempt(name, id, attempt)
+	if err != nil {
+		return 0, err
+	}
+
+	jobDetail.Attempt = attempt + 1
+	jobDetail.Status = model.STATUS_NOTRUN
+	jobDetail.StartTime = time.Now()
+	jobDetail.Duration = 0
+	jobDetail.Error = ""
+	jobDetail.FailureReason = ""
+	jobDetail.Worker = ""
+	jobDetail.ResumedFrom = ""
+	jobDetail.Approvals = nil
+	jobDetail.ActionResult = model.ActionResult{}
+	stages, err := jobDetail.Job.StageSort()
+	if err != nil {
+		return 0, err
+	}
+	jobDetail.Stages = stages
+	return jobDetail.Attempt, SaveJobDetail(name, jobDetail)
+}
+
+// archiveJobAttempt 归档某次执行的 job detail 和日志
+func archiveJobAttempt(name string, id, attempt int) error {
+	err := createDirIfNotExist(getJobAttemptDir(name, id))
+	if err != nil {
+		return err
+	}
+	content, err := ReadStringJobDetail(name, id)
+	if err != nil {
+		return err
+	}
+	err = saveStringToFile(getJobAttemptFilePath(name, id, attempt), content)
+	if err != nil {
+		return err
+	}
+	logPath := getJobDetailLogPath(name, id)
+	if !isFileExist(logPath) {
+		return nil
+	}
+	err = os.Rename(logPath, getJobAttemptLogPath(name, id, attempt))
+	if err != nil {
+		logger.Errorf("archive job log failed: %s", err)
+		return err
+	}
+	// 索引在读取归档的日志时重新建立
+	_ = os.Remove(output.IndexFilename(logPath))
+	return nil
+}
+
+// GetJobAttempts 列出 job detail 的所有执行记录，按执行序号升序，最后一个是当前这次执行
+func GetJobAttempts(name string, id int) ([]model.JobAttempt, error) {
+	current, err := GetJobDetail(name, id)
+	if err != nil {
+		return nil, err
+	}
+	attempts := make([]model.JobAttempt, 0)
+	files, err := os.ReadDir(getJobAttemptDir(name, id))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".yml" {
+			continue
+		}
+		attempt, err := strconv.Atoi(strings.TrimSuffix(file.Name(), ".yml"))
+		if err != nil {
+			continue
+		}
+		jobDetail, err := GetJobAttempt(name, id, attempt)
+		if err != nil {
+			logger.Errorf("get job attempt failed: %s", err)
+			continue
+		}
+		attempts = append(attempts, jobDetail.AttemptSummary())
+	}
+	sort.Slice(attempts, func(i, j int) bool {
+		return attempts[i].Attempt < attempts[j].Attempt
+	})
+	return append(attempts, current.AttemptSummary()), nil
+}
+
+// GetJobAttempt 获取某次执行的 job detail
+func GetJobAttempt(name string, id, attempt int) (*model.JobDetail, error) {
+	current, err := GetJobDetail(name, id)
+	if err != nil {
+		return nil, err
+	}
+	if attempt == current.CurrentAttempt() {
+		return current, nil
+	}
+	content, err := readStringFromFile(getJobAttemptFilePath(name, id, attempt))
+	if err != nil {
+		return nil, fmt.Errorf("job %s(%d) attempt %d not found", name, id, attempt)
+	}
+	jobDetail, err := ParseJobDetail(content)
+	if err != nil {
+		return nil, err
+	}
+	jobDetail.Attempt = attempt
+	return jobDetail, nil
+}
+
+// GetJobAttemptLog 获取某次执行的日志
+func GetJobAttemptLog(name string, id, attempt int) (*model.JobLog, error) {
+	current, err := GetJobDetail(name, id)
+	if err != nil {
+		return nil, err
+	}
+	if attempt == current.CurrentAttempt() {
+		return GetJobLog(name, id)
+	}
+	fileLog, err := output.ParseLogFile(getJobAttemptLogPath(name, id, attempt))
+	if err != nil {
+		logger.Errorf("parse log file failed, %v", err)
+		return nil, err
+	}
+	return &model.JobLog{
+		StartTime: fileLog.StartTime,
+		Duration:  fileLog.Duration,
+		Content:   strings.Join(fileLog.Lines, "\r"),
+		LastLine:  len(fileLog.Lines),
+	}, nil
+}
+
+// ResetJobLog 删除 job detail 当前的日志文件，新的一次执行会重新写日志
+func ResetJobLog(name string, id int) error {
+	logPath := getJobDetailLogPath(name, id)
+	if !isFileExist(logPath) {
+		return nil
+	}
+	_ = os.Remove(output.IndexFilename(logPath))
+	return os.Remove(logPath)
+}
+
+// deleteJobAttempts 删除 job detail 的所有执行记录
+func deleteJobAttempts(name string, id int) error {
+	return os.RemoveAll(getJobAttemptDir(name, id))
+}
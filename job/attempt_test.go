@@ -0,0 +1,104 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewJobAttempt(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	jobYaml := `version: "1"
+name: attempt
+stages:
+  build:
+    steps:
+      - name: echo
+        run: echo hello
+`
+	assert.NoError(t, SaveJob("attempt", jobYaml))
+	_, err := CreateJobDetail("attempt", 1)
+	assert.NoError(t, err)
+
+	// 还没执行过，沿用第 1 次
+	attempt, err := NewJobAttempt("attempt", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, attempt)
+
+	jobDetail, err := GetJobDetail("attempt", 1)
+	assert.NoError(t, err)
+	jobDetail.Status = model.STATUS_FAIL
+	jobDetail.Worker = "worker@127.0.0.1"
+	assert.NoError(t, SaveJobDetail("attempt", jobDetail))
+	assert.NoError(t, SaveJobLogString("attempt", 1, "[Job] Started on 2023-01-01T00:00:00Z\nfirst attempt\n"))
+
+	attempt, err = NewJobAttempt("attempt", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempt)
+
+	attempts, err := GetJobAttempts("attempt", 1)
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 2)
+	assert.Equal(t, 1, attempts[0].Attempt)
+	assert.Equal(t, model.STATUS_FAIL, attempts[0].Status)
+	assert.Equal(t, "worker@127.0.0.1", attempts[0].Worker)
+	assert.Equal(t, 2, attempts[1].Attempt)
+	assert.Equal(t, model.STATUS_NOTRUN, attempts[1].Status)
+
+	first, err := GetJobAttempt("attempt", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, model.STATUS_FAIL, first.Status)
+
+	firstLog, err := GetJobAttemptLog("attempt", 1, 1)
+	assert.NoError(t, err)
+	assert.Contains(t, firstLog.Content, "first attempt")
+
+	_, err = GetJobAttempt("attempt", 1, 3)
+	assert.Error(t, err)
+}
+
+func TestMakeJobFail(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	jobYaml := `version: "1"
+name: interrupted
+stages:
+  build:
+    steps:
+      - name: echo
+        run: echo hello
+  deploy:
+    needs:
+      - build
+    steps:
+      - name: echo
+        run: echo deploy
+`
+	assert.NoError(t, SaveJob("interrupted", jobYaml))
+	jobDetail, err := CreateJobDetail("interrupted", 1)
+	assert.NoError(t, err)
+	jobDetail.Status = model.STATUS_RUNNING
+	for i := range jobDetail.Stages {
+		if jobDetail.Stages[i].Name == "build" {
+			jobDetail.Stages[i].Status = model.STATUS_RUNNING
+			jobDetail.Stages[i].Stage.Steps[0].Status = model.STATUS_RUNNING
+		}
+	}
+	assert.NoError(t, SaveJobDetail("interrupted", jobDetail))
+
+	_, err = MakeJobFail("interrupted", 1, model.WorkerRestartedError)
+	assert.NoError(t, err)
+	jobDetail, err = GetJobDetail("interrupted", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, model.STATUS_FAIL, jobDetail.Status)
+	assert.Equal(t, model.WorkerRestartedError, jobDetail.Error)
+	for _, stage := range jobDetail.Stages {
+		if stage.Name == "build" {
+			assert.Equal(t, model.STATUS_FAIL, stage.Status)
+			assert.Equal(t, model.STATUS_FAIL, stage.Stage.Steps[0].Status)
+			assert.Equal(t, model.FAILURE_INFRASTRUCTURE, stage.Stage.Steps[0].Failure.Reason)
+		} else {
+			assert.Equal(t, model.STATUS_SKIPPED, stage.Status)
+		}
+	}
+}
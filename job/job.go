@@ -2,12 +2,14 @@ package job
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/hamster-shared/aline-engine/consts"
 	"github.com/hamster-shared/aline-engine/grpc/api"
@@ -96,18 +98,27 @@ func UpdateJobDetail(name string, job *model.JobDetail) error {
 	return SaveJobDetail(name, job)
 }
 
+// ParseJobDetail 反序列化 job detail yaml 字符串
+func ParseJobDetail(content string) (*model.JobDetail, error) {
+	var jobDetail model.JobDetail
+	err := yaml.Unmarshal([]byte(content), &jobDetail)
+	if err != nil {
+		logger.Errorf("deserialization job detail failed: %s", err.Error())
+		return nil, err
+	}
+	return &jobDetail, nil
+}
+
 // GetJobDetail get job detail
 func GetJobDetail(name string, id int) (*model.JobDetail, error) {
-	var jobDetail model.JobDetail
 	jobDetailString, err := readStringFromFile(GetJobDetailFilePath(name, id))
 	if err != nil {
 		return nil, err
 	}
 
 	//deserialization job detail yml file
-	err = yaml.Unmarshal([]byte(jobDetailString), &jobDetail)
+	jobDetail, err := ParseJobDetail(jobDetailString)
 	if err != nil {
-		logger.Errorf("get job,deserialization job detail file failed: %s", err.Error())
 		return nil, err
 	}
 
@@ -121,7 +132,7 @@ func GetJobDetail(name string, id int) (*model.JobDetail, error) {
 	if runningStage >= 0 && runningStage < len(jobDetail.Stages) {
 		jobDetail.Stages[runningStage].Duration = time.Since(jobDetail.Stages[runningStage].StartTime).Milliseconds()
 	}
-	return &jobDetail, nil
+	return jobDetail, nil
 }
 
 // JobList  job list
@@ -238,6 +249,13 @@ func DeleteJobDetail(name string, pipelineDetailId int) error {
 		logger.Error("delete job detail failed,job detail file not exist")
 		return fmt.Errorf("delete job detail failed,job detail file not exist")
 	}
+	err := deleteJobAttempts(name, pipelineDetailId)
+	if err != nil {
+		logger.Errorf("delete job attempts failed: %s", err)
+	}
+	if err := removeJobLogIndex(name, pipelineDetailId); err != nil {
+		logger.Errorf("remove job log index failed: %s", err)
+	}
 	return deleteFile(jobDetailFilePath)
 }
 
@@ -292,40 +310,132 @@ func GetJobLogString(name string, pipelineDetailId int) (string, error) {
 }
 
 // SaveJobLogString 保存 job 日志字符串
+// 先写入临时文件再改名，实时读取日志时不会读到被截断的文件
 func SaveJobLogString(name string, pipelineDetailId int, content string) error {
 	logPath := getJobDetailLogPath(name, pipelineDetailId)
-	return saveStringToFile(logPath, content)
+	if err := saveStringToFile(logPath+".tmp", content); err != nil {
+		return err
+	}
+	return os.Rename(logPath+".tmp", logPath)
 }
 
-// GetJobStepLog 获取 job 的 step 日志
-func GetJobStepLog(name string, id int, stageName, stepName string) (*output.Step, error) {
+// ReadJobLogChunk 读取 job 日志 offset 之后的内容，最多 max 字节，同时返回日志文件当前的长度
+// 末尾不完整的 utf-8 字符留到下次读取；日志不存在时返回空
+func ReadJobLogChunk(name string, id int, offset int64, max int) (string, int64, error) {
+	f, err := os.Open(getJobDetailLogPath(name, id))
+	if os.IsNotExist(err) {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	if offset >= info.Size() {
+		return "", info.Size(), nil
+	}
+	data := make([]byte, max)
+	n, err := f.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return "", info.Size(), err
+	}
+	return string(trimPartialRune(data[:n])), info.Size(), nil
+}
+
+// trimPartialRune 去掉末尾被截断的 utf-8 字符
+func trimPartialRune(data []byte) []byte {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return data[:i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+// AppendJobLog 把 worker 日志中从 offset 开始的 content 追加到 job 日志，返回保存后的日志长度
+// 和已经保存的部分重叠时只追加新的内容；offset 超过已保存的长度时不写入，worker 从返回的长度重新发送
+func AppendJobLog(name string, id int, offset int64, content string) (int64, error) {
 	logPath := getJobDetailLogPath(name, id)
-	fileLog, err := output.ParseLogFile(logPath)
+	if err := createDirIfNotExist(filepath.Dir(logPath)); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0777)
 	if err != nil {
-		logger.Errorf("parse log file failed, %v", err)
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	if offset > size || offset+int64(len(content)) <= size {
+		return size, nil
+	}
+	n, err := f.WriteString(content[size-offset:])
+	return size + int64(n), err
+}
+
+// ExportJobLog 按照 format 导出 job 日志，format 为 output.ExportText、output.ExportHTML 或 output.ExportArchive
+func ExportJobLog(name string, id int, format string, w io.Writer) error {
+	logPath := getJobDetailLogPath(name, id)
+	if !isFileExist(logPath) {
+		return fmt.Errorf("log of %s(%d) not found", name, id)
+	}
+	return output.Export(w, logPath, format, fmt.Sprintf("%s #%d", name, id))
+}
+
+// GetJobStepLog 获取 job 的 step 日志，包含 step 的耗时和最终状态，step 不存在时返回 nil
+func GetJobStepLog(name string, id int, stageName, stepName string) (*output.Step, error) {
+	step, err := output.ReadStepLog(getJobDetailLogPath(name, id), stageName, stepName)
+	if err != nil {
+		logger.Errorf("read step log failed, %v", err)
 		return nil, err
 	}
-	for _, stage := range fileLog.Stages {
-		if stage.Name == stageName {
-			steps := output.ParseStageSteps(&stage)
-			for _, step := range steps {
-				if step.Name == stepName {
-					return step, nil
-				}
+	if step != nil && step.StartTime.IsZero() {
+		// 之前版本的日志中没有 step 的耗时，使用 job 详情中记录的
+		fillStepTimeFromDetail(name, id, stageName, step)
+	}
+	return step, nil
+}
+
+func fillStepTimeFromDetail(name string, id int, stageName string, step *output.Step) {
+	detail, err := GetJobDetail(name, id)
+	if err != nil {
+		return
+	}
+	for _, stage := range detail.Stages {
+		if stage.Name != stageName {
+			continue
+		}
+		for _, s := range stage.Stage.Steps {
+			if s.Name == step.Name {
+				step.StartTime = s.StartTime
+				step.EndTime = s.EndTime
+				step.Duration = time.Duration(s.Duration) * time.Millisecond
+				step.Status = s.Status.ToString()
+				return
 			}
 		}
 	}
-	return nil, nil
 }
 
 // GetJobStageLog 获取 job 的 stage 日志
 func GetJobStageLog(name string, execId int, stageName string, start int) (*model.JobStageLog, error) {
-	logPath := getJobDetailLogPath(name, execId)
-	fileLog, err := output.ParseLogFile(logPath)
+	stage, err := output.ReadStageLog(getJobDetailLogPath(name, execId), stageName)
 	if err != nil {
-		logger.Errorf("parse log file failed, %v", err)
+		logger.Errorf("read stage log failed, %v", err)
 		return nil, err
 	}
+	if stage == nil {
+		return nil, fmt.Errorf("stage %s not found", stageName)
+	}
 
 	detail, err := GetJobDetail(name, execId)
 	if err != nil {
@@ -334,29 +444,24 @@ func GetJobStageLog(name string, execId int, stageName string, start int) (*mode
 
 	var stageDetail model.StageDetail
 
-	for _, stage := range detail.Stages {
-		if stage.Name == stageName {
-			stageDetail = stage
+	for _, detailStage := range detail.Stages {
+		if detailStage.Name == stageName {
+			stageDetail = detailStage
 		}
 	}
 
-	for _, stage := range fileLog.Stages {
-		if stage.Name == stageName {
-			var content string
-			if start >= 0 && start <= len(stage.Lines) {
-				content = strings.Join(stage.Lines[start:], "\r")
-			}
-
-			return &model.JobStageLog{
-				StartTime: stage.StartTime,
-				Duration:  stage.Duration,
-				Content:   content,
-				LastLine:  len(stage.Lines),
-				End:       stageDetail.Status == model.STATUS_SUCCESS || stageDetail.Status == model.STATUS_FAIL,
-			}, nil
-		}
+	var content string
+	if start >= 0 && start <= len(stage.Lines) {
+		content = strings.Join(stage.Lines[start:], "\r")
 	}
-	return nil, fmt.Errorf("stage %s not found", stageName)
+
+	return &model.JobStageLog{
+		StartTime: stage.StartTime,
+		Duration:  stage.Duration,
+		Content:   content,
+		LastLine:  len(stage.Lines),
+		End:       stageDetail.Status.IsTerminal(),
+	}, nil
 }
 
 // 就地更新 job 详情
@@ -514,3 +619,38 @@ func MakeJobStop(jobName string, jobDetailId int, errorString string) error {
 	jobDetail.Error = errorString
 	return SaveJobDetail(jobName, jobDetail)
 }
+
+// MakeJobFail 把没有正常结束的任务标记为失败，正在执行的 stage 和 step 也标记为失败，还没执行的标记为跳过
+func MakeJobFail(jobName string, jobDetailId int, errorString string) (*model.JobDetail, error) {
+	jobDetail, err := GetJobDetail(jobName, jobDetailId)
+	if err != nil {
+		return nil, err
+	}
+	finish := func(status *model.Status) {
+		switch *status {
+		case model.STATUS_RUNNING, model.STATUS_WAITING_APPROVAL, model.STATUS_QUEUED:
+			*status = model.STATUS_FAIL
+		case model.STATUS_NOTRUN:
+			*status = model.STATUS_SKIPPED
+		}
+	}
+	for i := range jobDetail.Stages {
+		finish(&jobDetail.Stages[i].Status)
+		for j := range jobDetail.Stages[i].Stage.Steps {
+			step := &jobDetail.Stages[i].Stage.Steps[j]
+			if step.Status == model.STATUS_RUNNING {
+				step.Failure = &model.StepFailure{Reason: model.FAILURE_INFRASTRUCTURE, Message: errorString}
+			}
+			finish(&step.Status)
+		}
+	}
+	jobDetail.Status = model.STATUS_FAIL
+	jobDetail.Error = errorString
+	if jobDetail.FailureReason == "" {
+		jobDetail.FailureReason = model.FAILURE_INFRASTRUCTURE
+	}
+	if !jobDetail.StartTime.IsZero() {
+		jobDetail.Duration = time.Since(jobDetail.StartTime).Milliseconds()
+	}
+	return jobDetail, SaveJobDetail(jobName, jobDetail)
+}
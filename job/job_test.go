@@ -233,3 +233,57 @@ func TestGetJobStepLog(t *testing.T) {
 	assert.Nil(t, err)
 	spew.Dump(step)
 }
+
+func TestAppendJobLog(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+
+	size, err := AppendJobLog("append", 1, 0, "line 1\n")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), size)
+	size, err = AppendJobLog("append", 1, 7, "line 2\n")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(14), size)
+	// 重发的内容和已经保存的部分重叠，只追加新的内容
+	size, err = AppendJobLog("append", 1, 7, "line 2\nline 3\n")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(21), size)
+	// 已经保存过的内容不再写入
+	size, err = AppendJobLog("append", 1, 0, "line 1\n")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(21), size)
+	// 中间缺了一段，不写入，返回已经保存的长度
+	size, err = AppendJobLog("append", 1, 30, "line 5\n")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(21), size)
+
+	content, err := GetJobLogString("append", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "line 1\nline 2\nline 3\n", content)
+}
+
+func TestReadJobLogChunk(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+
+	chunk, size, err := ReadJobLogChunk("chunk", 1, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "", chunk)
+	assert.Equal(t, int64(0), size)
+
+	assert.NoError(t, SaveJobLogString("chunk", 1, "ab你好\n"))
+	// 不返回被截断的字符
+	chunk, size, err = ReadJobLogChunk("chunk", 1, 0, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "ab", chunk)
+	assert.Equal(t, int64(9), size)
+	chunk, _, err = ReadJobLogChunk("chunk", 1, 2, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "你", chunk)
+	chunk, _, err = ReadJobLogChunk("chunk", 1, 5, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "好\n", chunk)
+	chunk, _, err = ReadJobLogChunk("chunk", 1, 9, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "", chunk)
+}
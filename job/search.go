@@ -0,0 +1,182 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+)
+
+const (
+	defaultSearchLimit = 500
+	// maxSearchContext 匹配行前后最多返回的行数
+	maxSearchContext = 20
+)
+
+// SearchLogs 在所有 job 的执行日志中搜索，结果按 job 名称排序，同一个 job 中新的执行记录在前
+// 开启了日志索引时，先根据索引排除一定不包含 query 的执行记录，索引中没有或者已经过期的执行记录直接搜索日志
+func SearchLogs(query string, filter model.LogSearchFilter) ([]model.LogSearchResult, error) {
+	if query == "" {
+		return nil, errors.New("query is empty")
+	}
+	match, err := newLogMatcher(query, filter)
+	if err != nil {
+		return nil, err
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	context := filter.Context
+	if context < 0 {
+		context = 0
+	}
+	if context > maxSearchContext {
+		context = maxSearchContext
+	}
+
+	names, err := searchJobNames(filter.Job)
+	if err != nil {
+		return nil, err
+	}
+	// 正则表达式无法确定一定包含哪些词，不使用索引
+	var terms []string
+	if !filter.Regex {
+		terms = queryTerms(query)
+	}
+
+	results := []model.LogSearchResult{}
+	for _, name := range names {
+		var candidates func(id int, size int64) bool
+		if len(terms) > 0 && LogSearchIndexEnabled() {
+			candidates = searchIndexCandidates(name, terms)
+		}
+		for _, id := range jobDetailIds(name) {
+			detail, err := GetJobDetail(name, id)
+			if err != nil || !matchSearchFilter(detail, filter) {
+				continue
+			}
+			logPath := getJobDetailLogPath(name, id)
+			info, err := os.Stat(logPath)
+			if err != nil {
+				continue
+			}
+			if candidates != nil && !candidates(id, info.Size()) {
+				continue
+			}
+			matches, err := output.SearchFile(logPath, match, output.SearchOptions{
+				Stage:   filter.Stage,
+				Context: context,
+				Limit:   limit - len(results),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("search log of %s(%d) failed: %w", name, id, err)
+			}
+			for _, m := range matches {
+				results = append(results, model.LogSearchResult{
+					Job:       name,
+					Id:        id,
+					Status:    detail.Status,
+					StartTime: detail.StartTime,
+					Match:     m,
+				})
+			}
+			if len(results) >= limit {
+				return results, nil
+			}
+		}
+	}
+	return results, nil
+}
+
+func newLogMatcher(query string, filter model.LogSearchFilter) (func(string) bool, error) {
+	if filter.Regex {
+		if filter.IgnoreCase {
+			query = "(?i)" + query
+		}
+		re, err := regexp.Compile(query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %w", err)
+		}
+		return re.MatchString, nil
+	}
+	if filter.IgnoreCase {
+		query = strings.ToLower(query)
+		return func(text string) bool {
+			return strings.Contains(strings.ToLower(text), query)
+		}, nil
+	}
+	return func(text string) bool {
+		return strings.Contains(text, query)
+	}, nil
+}
+
+// searchJobNames 需要搜索的 job，没有指定时为所有 job
+func searchJobNames(name string) ([]string, error) {
+	if name != "" {
+		if !isFileExist(getJobFileDir(name)) {
+			return nil, fmt.Errorf("job %s not found", name)
+		}
+		return []string{name}, nil
+	}
+	files, err := os.ReadDir(GetJobsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// jobDetailIds job 所有执行记录的 id，从大到小
+func jobDetailIds(name string) []int {
+	files, err := os.ReadDir(getJobDetailFileDir(name))
+	if err != nil {
+		return nil
+	}
+	var ids []int
+	for _, file := range files {
+		id, err := strconv.Atoi(strings.TrimSuffix(file.Name(), ".yml"))
+		if err == nil && !file.IsDir() {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	return ids
+}
+
+func matchSearchFilter(detail *model.JobDetail, filter model.LogSearchFilter) bool {
+	if len(filter.Status) > 0 {
+		found := false
+		for _, status := range filter.Status {
+			if detail.Status == status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !filter.Since.IsZero() && detail.StartTime.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && detail.StartTime.After(filter.Until) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,219 @@
+package job
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/hamster-shared/aline-engine/output"
+)
+
+// 日志索引记录每个词出现在哪些执行记录中，每个 job 一个索引文件，保存在日志目录中
+// 只用来排除一定不包含搜索内容的执行记录，匹配的行还是从日志中查找
+const searchIndexFilename = "search.idx"
+
+// 超过这个长度的词不记录在索引中
+const maxTermLength = 64
+
+var (
+	searchIndexEnabled int32
+	// searchIndexMu 同一个进程中同时只有一个修改索引
+	searchIndexMu sync.Mutex
+)
+
+// SetLogSearchIndex 是否在日志写完后更新日志索引，默认不开启
+func SetLogSearchIndex(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&searchIndexEnabled, v)
+}
+
+// LogSearchIndexEnabled 是否开启了日志索引
+func LogSearchIndexEnabled() bool {
+	return atomic.LoadInt32(&searchIndexEnabled) == 1
+}
+
+type searchIndex struct {
+	// 执行记录 id 和建立索引时日志文件的大小，和文件大小不一致时索引已经过期
+	Runs  map[int]int64    `json:"runs"`
+	Terms map[string][]int `json:"terms"`
+}
+
+func getSearchIndexPath(name string) string {
+	return filepath.Join(getJobDetailLogDir(name), searchIndexFilename)
+}
+
+func loadSearchIndex(name string) *searchIndex {
+	index := &searchIndex{Runs: map[int]int64{}, Terms: map[string][]int{}}
+	data, err := os.ReadFile(getSearchIndexPath(name))
+	if err != nil {
+		return index
+	}
+	if json.Unmarshal(data, index) != nil || index.Runs == nil || index.Terms == nil {
+		return &searchIndex{Runs: map[int]int64{}, Terms: map[string][]int{}}
+	}
+	return index
+}
+
+// save 先写入临时文件再改名，搜索时不会读到不完整的索引
+func (x *searchIndex) save(name string) error {
+	data, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	path := getSearchIndexPath(name)
+	if err := saveStringToFile(path+".tmp", string(data)); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (x *searchIndex) remove(id int) {
+	if _, ok := x.Runs[id]; !ok {
+		return
+	}
+	delete(x.Runs, id)
+	for term, ids := range x.Terms {
+		i := sort.SearchInts(ids, id)
+		if i < len(ids) && ids[i] == id {
+			ids = append(ids[:i], ids[i+1:]...)
+		}
+		if len(ids) == 0 {
+			delete(x.Terms, term)
+		} else {
+			x.Terms[term] = ids
+		}
+	}
+}
+
+func (x *searchIndex) add(id int, size int64, terms map[string]struct{}) {
+	x.remove(id)
+	x.Runs[id] = size
+	for term := range terms {
+		ids := x.Terms[term]
+		i := sort.SearchInts(ids, id)
+		ids = append(ids, 0)
+		copy(ids[i+1:], ids[i:])
+		ids[i] = id
+		x.Terms[term] = ids
+	}
+}
+
+// IndexJobLog 日志写完后把这次执行的日志加入索引，没有开启日志索引或者索引已经是最新的时什么都不做
+func IndexJobLog(name string, id int) error {
+	if !LogSearchIndexEnabled() {
+		return nil
+	}
+	logPath := getJobDetailLogPath(name, id)
+	info, err := os.Stat(logPath)
+	if err != nil {
+		return err
+	}
+
+	searchIndexMu.Lock()
+	defer searchIndexMu.Unlock()
+	index := loadSearchIndex(name)
+	if size, ok := index.Runs[id]; ok && size == info.Size() {
+		return nil
+	}
+	terms := make(map[string]struct{})
+	err = output.ScanEntries(logPath, func(_ int, entry output.Entry) bool {
+		for _, term := range splitTerms(entry.Text) {
+			terms[term.text] = struct{}{}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	index.add(id, info.Size(), terms)
+	return index.save(name)
+}
+
+// removeJobLogIndex 删除执行记录时从索引中移除
+func removeJobLogIndex(name string, id int) error {
+	searchIndexMu.Lock()
+	defer searchIndexMu.Unlock()
+	if !isFileExist(getSearchIndexPath(name)) {
+		return nil
+	}
+	index := loadSearchIndex(name)
+	if _, ok := index.Runs[id]; !ok {
+		return nil
+	}
+	index.remove(id)
+	return index.save(name)
+}
+
+// searchIndexCandidates 返回判断某次执行是否需要搜索的函数，terms 为搜索内容中一定会完整出现的词
+// 索引中没有这次执行，或者日志大小和建立索引时不一致时都需要搜索
+func searchIndexCandidates(name string, terms []string) func(id int, size int64) bool {
+	index := loadSearchIndex(name)
+	var found map[int]bool
+	for _, term := range terms {
+		ids := make(map[int]bool)
+		for _, id := range index.Terms[term] {
+			if found == nil || found[id] {
+				ids[id] = true
+			}
+		}
+		found = ids
+	}
+	return func(id int, size int64) bool {
+		indexed, ok := index.Runs[id]
+		return !ok || indexed != size || found[id]
+	}
+}
+
+type term struct {
+	text       string
+	start, end int
+}
+
+// splitTerms 把内容按字母、数字和下划线以外的字符分成小写的词
+func splitTerms(text string) []term {
+	var terms []term
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			terms = appendTerm(terms, text, start, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		terms = appendTerm(terms, text, start, len(text))
+	}
+	return terms
+}
+
+func appendTerm(terms []term, text string, start, end int) []term {
+	if end-start > maxTermLength {
+		return terms
+	}
+	return append(terms, term{text: strings.ToLower(text[start:end]), start: start, end: end})
+}
+
+// queryTerms 搜索内容中前后都有分隔符的词，匹配的行中一定包含完整的这些词
+// 开头和结尾的词可能只是日志中某个词的一部分，不能用来排除
+func queryTerms(query string) []string {
+	var result []string
+	for _, t := range splitTerms(query) {
+		if t.start > 0 && t.end < len(query) {
+			result = append(result, t.text)
+		}
+	}
+	return result
+}
@@ -0,0 +1,126 @@
+package job
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/logger"
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func createSearchJobLog(t *testing.T, name string, id int, status model.Status, lines ...string) {
+	detail, err := CreateJobDetail(name, id)
+	assert.NoError(t, err)
+	detail.Status = status
+	detail.StartTime = time.Date(2023, 1, id, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, SaveJobDetail(name, detail))
+	o := output.New(name, id)
+	o.NewStage("build")
+	o.NewStep("install")
+	for _, line := range lines {
+		o.WriteLine(line)
+	}
+	o.Done()
+}
+
+func TestSearchLogs(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	jobYaml := `version: "1"
+name: search
+stages:
+  build:
+    steps:
+      - name: install
+        run: npm install
+`
+	assert.NoError(t, SaveJob("search", jobYaml))
+	createSearchJobLog(t, "search", 1, model.STATUS_SUCCESS, "added 10 packages")
+	createSearchJobLog(t, "search", 2, model.STATUS_FAIL, "npm ERR! network ECONNRESET", "npm ERR! code 1")
+	createSearchJobLog(t, "search", 3, model.STATUS_FAIL, "npm ERR! network ECONNRESET")
+
+	results, err := SearchLogs("ECONNRESET", model.LogSearchFilter{Context: 1})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	assert.Equal(t, 3, results[0].Id)
+	assert.Equal(t, 2, results[1].Id)
+	assert.Equal(t, "search", results[1].Job)
+	assert.Equal(t, model.STATUS_FAIL, results[1].Status)
+	assert.Equal(t, "build", results[1].Stage)
+	assert.Equal(t, "install", results[1].Step)
+	assert.Equal(t, []string{"npm ERR! code 1"}, results[1].After)
+
+	results, err = SearchLogs("econnreset", model.LogSearchFilter{IgnoreCase: true, Since: time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC)})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, 3, results[0].Id)
+
+	results, err = SearchLogs(`added \d+ packages`, model.LogSearchFilter{Regex: true, Status: []model.Status{model.STATUS_SUCCESS}})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, 1, results[0].Id)
+
+	results, err = SearchLogs("ECONNRESET", model.LogSearchFilter{Stage: "deploy"})
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	_, err = SearchLogs("(", model.LogSearchFilter{Regex: true})
+	assert.Error(t, err)
+	_, err = SearchLogs("x", model.LogSearchFilter{Job: "missing"})
+	assert.Error(t, err)
+}
+
+func TestSearchIndex(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	SetLogSearchIndex(true)
+	defer SetLogSearchIndex(false)
+	jobYaml := `version: "1"
+name: indexed
+stages:
+  build:
+    steps:
+      - name: install
+        run: npm install
+`
+	assert.NoError(t, SaveJob("indexed", jobYaml))
+	createSearchJobLog(t, "indexed", 1, model.STATUS_FAIL, "npm ERR! network ECONNRESET")
+	createSearchJobLog(t, "indexed", 2, model.STATUS_SUCCESS, "added 10 packages")
+	assert.NoError(t, IndexJobLog("indexed", 1))
+	assert.NoError(t, IndexJobLog("indexed", 2))
+	assert.FileExists(t, getSearchIndexPath("indexed"))
+
+	index := loadSearchIndex("indexed")
+	assert.Equal(t, []int{1}, index.Terms["econnreset"])
+	assert.Equal(t, []int{1, 2}, index.Terms["install"])
+
+	// 开头和结尾的词可能只是一部分，只有中间的词用来排除
+	assert.Equal(t, []string{"network"}, queryTerms("ERR! network ECONN"))
+	candidates := searchIndexCandidates("indexed", queryTerms("ERR! network ECONN"))
+	info1, _ := os.Stat(getJobDetailLogPath("indexed", 1))
+	info2, _ := os.Stat(getJobDetailLogPath("indexed", 2))
+	assert.True(t, candidates(1, info1.Size()))
+	assert.False(t, candidates(2, info2.Size()))
+	// 日志在建立索引后有变化，需要搜索
+	assert.True(t, candidates(2, info2.Size()+1))
+	assert.True(t, candidates(3, 10))
+
+	results, err := SearchLogs("ERR! network ECONN", model.LogSearchFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, 1, results[0].Id)
+
+	// 还没有加入索引的执行记录直接搜索日志
+	createSearchJobLog(t, "indexed", 3, model.STATUS_FAIL, "npm ERR! network ECONNRESET")
+	results, err = SearchLogs(" network ", model.LogSearchFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	assert.NoError(t, DeleteJobDetail("indexed", 1))
+	index = loadSearchIndex("indexed")
+	assert.NotContains(t, index.Runs, 1)
+	assert.Empty(t, index.Terms["econnreset"])
+}
@@ -0,0 +1,85 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/logger"
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+)
+
+// streamInterval 实时日志检查日志文件的间隔
+var streamInterval = 200 * time.Millisecond
+
+// StreamJobLog 从日志文件的 offset 处开始持续推送新写入的行，job 结束并且日志读完后推送一条结束消息并关闭 channel
+// 远程 worker 的日志由 master 保存到本地，所以 master 和 worker 上都是读取本地的日志文件
+func StreamJobLog(ctx context.Context, name string, id int, offset int64) (<-chan model.JobLogEvent, error) {
+	if _, err := GetJobDetail(name, id); err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	events := make(chan model.JobLogEvent, 64)
+	go func() {
+		defer close(events)
+		send := func(event model.JobLogEvent) bool {
+			select {
+			case events <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// 日志文件被替换或者变短时（例如 job 重新执行）从头开始推送
+		follower := output.NewFollower(getJobDetailLogPath(name, id), offset)
+		// job 结束后再读一次，避免丢失结束前最后写入的日志
+		terminal := false
+		ticker := time.NewTicker(streamInterval)
+		defer ticker.Stop()
+		for {
+			lines, err := follower.Read()
+			if errors.Is(err, output.ErrLogReset) {
+				offset = 0
+				terminal = false
+				if !send(model.JobLogEvent{Offset: 0, Reset: true}) {
+					return
+				}
+				continue
+			}
+			if err != nil {
+				logger.Errorf("stream job log %s(%d) failed: %v", name, id, err)
+			}
+			for i := range lines {
+				offset = lines[i].Next
+				if !send(model.JobLogEvent{Line: &lines[i].Entry, Offset: offset}) {
+					return
+				}
+			}
+
+			if len(lines) == 0 {
+				if terminal {
+					status := model.STATUS_NOTRUN
+					if detail, err := GetJobDetail(name, id); err == nil {
+						status = detail.Status
+					}
+					send(model.JobLogEvent{Offset: offset, End: true, Status: status})
+					return
+				}
+				if detail, err := GetJobDetail(name, id); err == nil && detail.Status.IsTerminal() {
+					terminal = true
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,122 @@
+package job
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/logger"
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/hamster-shared/aline-engine/output"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamJobLog(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	streamInterval = 10 * time.Millisecond
+	jobYaml := `version: "1"
+name: stream
+stages:
+  build:
+    steps:
+      - name: echo
+        run: echo hello
+`
+	assert.NoError(t, SaveJob("stream", jobYaml))
+	_, err := CreateJobDetail("stream", 1)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events, err := StreamJobLog(ctx, "stream", 1, 0)
+	assert.NoError(t, err)
+
+	o := output.New("stream", 1)
+	o.NewStage("build")
+	o.NewStep("echo")
+	o.WriteLine("hello")
+
+	var texts []string
+	var offset int64
+	for event := range events {
+		assert.False(t, event.End)
+		texts = append(texts, event.Line.Text)
+		offset = event.Offset
+		if event.Line.Text == "hello" {
+			break
+		}
+	}
+	assert.Equal(t, "[Pipeline] Stage: build", texts[1])
+
+	o.WriteLine("bye")
+	o.Done()
+	detail, err := GetJobDetail("stream", 1)
+	assert.NoError(t, err)
+	detail.Status = model.STATUS_SUCCESS
+	assert.NoError(t, SaveJobDetail("stream", detail))
+
+	var last model.JobLogEvent
+	texts = nil
+	for event := range events {
+		if event.Line != nil {
+			texts = append(texts, event.Line.Text)
+		}
+		last = event
+	}
+	assert.Equal(t, "bye", texts[0])
+	assert.True(t, last.End)
+	assert.Equal(t, model.STATUS_SUCCESS, last.Status)
+	info, _ := os.Stat(o.Filename())
+	assert.Equal(t, info.Size(), last.Offset)
+
+	// 从上次的位置继续读取
+	events, err = StreamJobLog(ctx, "stream", 1, offset)
+	assert.NoError(t, err)
+	event := <-events
+	assert.Equal(t, "bye", event.Line.Text)
+
+	_, err = StreamJobLog(ctx, "stream", 2, 0)
+	assert.Error(t, err)
+}
+
+func TestStreamJobLogReplaced(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	streamInterval = 10 * time.Millisecond
+	jobYaml := `version: "1"
+name: replace
+stages:
+  build:
+    steps:
+      - name: echo
+        run: echo hello
+`
+	assert.NoError(t, SaveJob("replace", jobYaml))
+	_, err := CreateJobDetail("replace", 1)
+	assert.NoError(t, err)
+	logPath := getJobDetailLogPath("replace", 1)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(logPath), os.ModePerm))
+	assert.NoError(t, os.WriteFile(logPath, []byte("first 1\nfirst 2\n"), 0644))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events, err := StreamJobLog(ctx, "replace", 1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "first 1", (<-events).Line.Text)
+	assert.Equal(t, "first 2", (<-events).Line.Text)
+
+	// 重新执行生成了新的日志文件，并且在下次检查之前已经比之前读取的位置长
+	assert.NoError(t, os.WriteFile(logPath+".new", []byte("second attempt 1\nsecond attempt 2\n"), 0644))
+	assert.NoError(t, os.Rename(logPath+".new", logPath))
+
+	event := <-events
+	assert.True(t, event.Reset)
+	assert.Equal(t, int64(0), event.Offset)
+	event = <-events
+	assert.Equal(t, "second attempt 1", event.Line.Text)
+	assert.Equal(t, int64(17), event.Offset)
+	assert.Equal(t, "second attempt 2", (<-events).Line.Text)
+}
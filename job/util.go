@@ -92,6 +92,19 @@ func getJobDetailLogPath(name string, id int) string {
 	return filepath.Join(getJobDetailLogDir(name), strconv.Itoa(id)+".log")
 }
 
+// getJobAttemptDir 某个 job detail 的历史执行记录目录，每次执行保存为 <attempt>.yml 和 <attempt>.log
+func getJobAttemptDir(name string, id int) string {
+	return filepath.Join(utils.DefaultConfigDir(), consts.JOB_DIR_NAME, name, consts.JOB_ATTEMPT_DIR_NAME, strconv.Itoa(id))
+}
+
+func getJobAttemptFilePath(name string, id, attempt int) string {
+	return filepath.Join(getJobAttemptDir(name, id), strconv.Itoa(attempt)+".yml")
+}
+
+func getJobAttemptLogPath(name string, id, attempt int) string {
+	return filepath.Join(getJobAttemptDir(name, id), strconv.Itoa(attempt)+".log")
+}
+
 func getJobDetailLogDir(name string) string {
 	return filepath.Join(utils.DefaultConfigDir(), consts.JOB_DIR_NAME, name, consts.JOB_DETAIL_LOG_DIR_NAME)
 }
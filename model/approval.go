@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// Approval stage 开始执行前需要的人工审批
+type Approval struct {
+	// 允许审批的人，为空时任何人都可以审批
+	Approvers []string `yaml:"approvers,omitempty" json:"approvers"`
+	// 审批超时时间，例如 30m、24h，为空时使用默认值
+	Timeout string `yaml:"timeout,omitempty" json:"timeout"`
+}
+
+// CanApprove 判断 approver 是否有权限审批
+func (a *Approval) CanApprove(approver string) bool {
+	if len(a.Approvers) == 0 {
+		return true
+	}
+	for _, name := range a.Approvers {
+		if name == approver {
+			return true
+		}
+	}
+	return false
+}
+
+// ApprovalRecord 一次审批的记录
+type ApprovalRecord struct {
+	Stage    string    `yaml:"stage" json:"stage"`
+	Approver string    `yaml:"approver" json:"approver"`
+	Approved bool      `yaml:"approved" json:"approved"`
+	Comment  string    `yaml:"comment,omitempty" json:"comment"`
+	Time     time.Time `yaml:"time" json:"time"`
+}
@@ -44,9 +44,27 @@ type ActionResult struct {
 	MetaScanData []MetaScanReport `json:"metaScanData"`
 }
 
+// Merge 将 other 中的结果追加到 r 中，CodeInfo 以 other 中有 commit 的为准
+func (r *ActionResult) Merge(other *ActionResult) {
+	if other == nil {
+		return
+	}
+	if other.CodeInfo.CommitId != "" {
+		r.CodeInfo = other.CodeInfo
+	}
+	r.Artifactorys = append(r.Artifactorys, other.Artifactorys...)
+	r.Reports = append(r.Reports, other.Reports...)
+	r.Deploys = append(r.Deploys, other.Deploys...)
+	r.BuildData = append(r.BuildData, other.BuildData...)
+	r.MetaScanData = append(r.MetaScanData, other.MetaScanData...)
+}
+
 type CodeInfo struct {
-	Branch        string `json:"branch"`
-	CommitId      string `json:"commitId"`
+	Branch string `json:"branch"`
+	// 用于显示的短 commit id
+	CommitId string `json:"commitId"`
+	// 完整的 commit sha，恢复执行时按它重新检出
+	CommitSha     string `json:"commitSha,omitempty"`
 	CommitDate    string `json:"commitDate"`
 	CommitMessage string `json:"commitMessage"`
 }
@@ -0,0 +1,87 @@
+package model
+
+import "fmt"
+
+// PullPolicy runs-on 容器的镜像拉取策略
+type PullPolicy string
+
+const (
+	// PULL_ALWAYS 每次都拉取最新的镜像
+	PULL_ALWAYS PullPolicy = "always"
+	// PULL_IF_NOT_PRESENT 本地没有镜像时才拉取，默认的策略
+	PULL_IF_NOT_PRESENT PullPolicy = "if-not-present"
+	// PULL_NEVER 只使用本地的镜像
+	PULL_NEVER PullPolicy = "never"
+)
+
+// CONTAINER_USER_WORKER user 为这个值时，容器以 worker 的 uid:gid 运行，工作目录中不会留下 root 的文件
+const CONTAINER_USER_WORKER = "worker"
+
+// ContainerOptions runs-on 容器的选项，可以写在 stage 上，对其中所有的 step 生效，step 上的配置优先
+type ContainerOptions struct {
+	Pull PullPolicy `yaml:"pull,omitempty" json:"pull,omitempty"`
+	// 镜像仓库认证信息在密钥中的名称，密钥内容为 username:password 或者 json
+	Credentials string `yaml:"credentials,omitempty" json:"credentials,omitempty"`
+	// 容器中的用户，worker 表示使用 worker 的 uid:gid，为空时使用镜像的默认用户
+	User string            `yaml:"user,omitempty" json:"user,omitempty"`
+	Env  map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	// 网络模式，例如 host、none、bridge 或者网络的名称
+	Network string `yaml:"network,omitempty" json:"network,omitempty"`
+	// 覆盖镜像的 entrypoint，为空字符串时清除镜像的 entrypoint
+	// 容器需要一直运行：shell（sh、bash 等）会以 -c "exec cat" 启动，其他的 entrypoint 会收到参数 cat，需要执行它（例如 exec "$@"）
+	Entrypoint *string `yaml:"entrypoint,omitempty" json:"entrypoint,omitempty"`
+	// 容器中的工作目录，相对路径相对于任务的工作目录，为空时使用任务的工作目录
+	Workdir string `yaml:"workdir,omitempty" json:"workdir,omitempty"`
+}
+
+// MergeContainerOptions 合并 stage 和 step 上的容器选项，step 上没有配置的项使用 stage 的配置，env 合并
+func MergeContainerOptions(stage, step *ContainerOptions) *ContainerOptions {
+	if stage == nil {
+		return step
+	}
+	if step == nil {
+		return stage
+	}
+	merged := *step
+	if merged.Pull == "" {
+		merged.Pull = stage.Pull
+	}
+	if merged.Credentials == "" {
+		merged.Credentials = stage.Credentials
+	}
+	if merged.User == "" {
+		merged.User = stage.User
+	}
+	if merged.Network == "" {
+		merged.Network = stage.Network
+	}
+	if merged.Entrypoint == nil {
+		merged.Entrypoint = stage.Entrypoint
+	}
+	if merged.Workdir == "" {
+		merged.Workdir = stage.Workdir
+	}
+	if len(stage.Env) > 0 {
+		merged.Env = make(map[string]string, len(stage.Env)+len(step.Env))
+		for k, v := range stage.Env {
+			merged.Env[k] = v
+		}
+		for k, v := range step.Env {
+			merged.Env[k] = v
+		}
+	}
+	return &merged
+}
+
+// Validate 检查选项的格式
+func (o *ContainerOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+	switch o.Pull {
+	case "", PULL_ALWAYS, PULL_IF_NOT_PRESENT, PULL_NEVER:
+	default:
+		return fmt.Errorf("invalid pull policy: %s", o.Pull)
+	}
+	return nil
+}
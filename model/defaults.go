@@ -0,0 +1,32 @@
+package model
+
+// Defaults job 和 stage 上的默认配置，step 上没有配置时使用，stage 上的配置优先于 job
+type Defaults struct {
+	// shell step 使用的解释器：bash、sh、python、node 或者包含 {0} 的命令模板
+	Shell string `yaml:"shell,omitempty" json:"shell,omitempty"`
+	// shell step 的执行目录，相对路径相对于任务的工作目录
+	WorkingDirectory string `yaml:"working-directory,omitempty" json:"workingDirectory,omitempty"`
+	// shell step 运行的容器镜像
+	RunsOn string `yaml:"runs-on,omitempty" json:"runsOn,omitempty"`
+}
+
+// ApplyDefaults 把 job 和 stage 上的默认配置应用到 step 上，只对 shell step 生效
+func ApplyDefaults(step *Step, job, stage *Defaults) {
+	if step.Uses != "" && step.Uses != "shell" {
+		return
+	}
+	for _, defaults := range []*Defaults{stage, job} {
+		if defaults == nil {
+			continue
+		}
+		if step.Shell == "" {
+			step.Shell = defaults.Shell
+		}
+		if step.WorkingDirectory == "" {
+			step.WorkingDirectory = defaults.WorkingDirectory
+		}
+		if step.RunsOn == "" {
+			step.RunsOn = defaults.RunsOn
+		}
+	}
+}
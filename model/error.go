@@ -12,3 +12,27 @@ type SendJobError struct {
 func (e *SendJobError) Error() string {
 	return fmt.Sprintf("send job %s(%d) error: %s", e.JobName, e.JobID, e.Err)
 }
+
+// ApprovalError 审批请求无效，比如 stage 不在等待审批或者审批人没有权限
+type ApprovalError struct {
+	JobName string
+	JobID   int
+	Stage   string
+	Reason  string
+}
+
+func (e *ApprovalError) Error() string {
+	return fmt.Sprintf("approve job %s(%d) stage %s failed: %s", e.JobName, e.JobID, e.Stage, e.Reason)
+}
+
+// IllegalTransitionError 非法的状态变化，比如从 SUCCESS 回到 RUNNING
+type IllegalTransitionError struct {
+	Kind TransitionKind
+	Name string
+	From Status
+	To   Status
+}
+
+func (e *IllegalTransitionError) Error() string {
+	return fmt.Sprintf("illegal %s %s status transition: %s -> %s", e.Kind, e.Name, e.From.ToString(), e.To.ToString())
+}
@@ -0,0 +1,32 @@
+package model
+
+// FailureReason step 失败原因的分类
+type FailureReason string
+
+const (
+	// FAILURE_COMMAND_FAILED 命令或 action 执行失败，例如退出码不为 0
+	FAILURE_COMMAND_FAILED FailureReason = "command_failed"
+	// FAILURE_TIMEOUT 执行超时被结束
+	FAILURE_TIMEOUT FailureReason = "timeout"
+	// FAILURE_CANCELLED 执行过程中被取消
+	FAILURE_CANCELLED FailureReason = "cancelled"
+	// FAILURE_PRECHECK_FAILED action 的准备阶段失败，例如参数不正确、文件不存在
+	FAILURE_PRECHECK_FAILED FailureReason = "precheck_failed"
+	// FAILURE_PANIC action 发生 panic
+	FAILURE_PANIC FailureReason = "panic"
+	// FAILURE_INFRASTRUCTURE 执行环境的问题，例如容器启动失败、命令无法启动、worker 重启
+	FAILURE_INFRASTRUCTURE FailureReason = "infrastructure"
+	// FAILURE_RESOURCE_LIMIT 超出资源限制被结束
+	FAILURE_RESOURCE_LIMIT FailureReason = "resource_limit"
+)
+
+// StepFailure step 失败的详细信息，不需要解析日志就能知道失败的原因
+type StepFailure struct {
+	Reason  FailureReason `yaml:"reason" json:"reason"`
+	Message string        `yaml:"message,omitempty" json:"message"`
+	// 命令的退出码，被信号结束时为 0，此时 Signal 为信号的名字
+	ExitCode int    `yaml:"exitCode,omitempty" json:"exitCode,omitempty"`
+	Signal   string `yaml:"signal,omitempty" json:"signal,omitempty"`
+	// 失败前输出的最后几行
+	OutputTail []string `yaml:"outputTail,omitempty" json:"outputTail,omitempty"`
+}
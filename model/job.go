@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"time"
 
@@ -20,6 +21,16 @@ const (
 	STATUS_FAIL    Status = 2
 	STATUS_SUCCESS Status = 3
 	STATUS_STOP    Status = 4
+	// STATUS_QUEUED 已被 worker 接收，正在等待执行
+	STATUS_QUEUED Status = 5
+	// STATUS_SKIPPED 因为前面的 stage 或 step 失败，没有执行
+	STATUS_SKIPPED Status = 6
+	// STATUS_CANCELLED 执行过程中被取消
+	STATUS_CANCELLED Status = 7
+	// STATUS_TIMEOUT 执行超时
+	STATUS_TIMEOUT Status = 8
+	// STATUS_WAITING_APPROVAL 等待人工审批
+	STATUS_WAITING_APPROVAL Status = 9
 )
 
 func (s Status) ToString() string {
@@ -29,16 +40,37 @@ func (s Status) ToString() string {
 		"fail",
 		"success",
 		"stop",
+		"queued",
+		"skipped",
+		"cancelled",
+		"timeout",
+		"waiting approval",
+	}
+	if s < 0 || int(s) >= len(list) {
+		return "unknown"
 	}
 	return list[s]
 }
 
+// IsTerminal 是否为终态，终态之后不会再发生状态变化
+func (s Status) IsTerminal() bool {
+	switch s {
+	case STATUS_FAIL, STATUS_SUCCESS, STATUS_STOP, STATUS_SKIPPED, STATUS_CANCELLED, STATUS_TIMEOUT:
+		return true
+	}
+	return false
+}
+
 type Job struct {
 	Version   string            `yaml:"version,omitempty" json:"version"`
 	Name      string            `yaml:"name,omitempty" json:"name"`
 	Stages    map[string]Stage  `yaml:"stages,omitempty" json:"stages"`
 	Parameter map[string]string `yaml:"parameter,omitempty" json:"parameter"`
 	UserId    string            `yaml:"user_id"`
+	// worker 异常退出时的重试策略
+	Retry *RetryPolicy `yaml:"retry,omitempty" json:"retry,omitempty"`
+	// 对所有 shell step 生效的默认配置
+	Defaults *Defaults `yaml:"defaults,omitempty" json:"defaults,omitempty"`
 }
 
 type JobVo struct {
@@ -65,6 +97,45 @@ type JobDetail struct {
 	ActionResult `yaml:"actionResult" json:"actionResult"`
 	Output       *output.Output `json:"output"`
 	Error        string         `yaml:"error,omitempty" json:"error"`
+	// 第一个失败的 step 的失败原因分类，详细信息见 step 的 Failure
+	FailureReason FailureReason    `yaml:"failureReason,omitempty" json:"failureReason,omitempty"`
+	Approvals     []ApprovalRecord `yaml:"approvals,omitempty" json:"approvals"`
+	// 从哪个 stage 恢复执行的，为空表示完整执行
+	ResumedFrom string `yaml:"resumedFrom,omitempty" json:"resumedFrom,omitempty"`
+	// 第几次执行，同一个 job detail id 每次重新执行都会加一，从 1 开始
+	Attempt int `yaml:"attempt,omitempty" json:"attempt"`
+	// 执行该次任务的节点，name@address
+	Worker string `yaml:"worker,omitempty" json:"worker,omitempty"`
+}
+
+// CurrentAttempt 返回当前是第几次执行，旧的 job detail 没有记录时视为第 1 次
+func (jd *JobDetail) CurrentAttempt() int {
+	if jd.Attempt < 1 {
+		return 1
+	}
+	return jd.Attempt
+}
+
+// JobAttempt 一次执行的概要信息
+type JobAttempt struct {
+	Attempt   int       `json:"attempt"`
+	Status    Status    `json:"status"`
+	Worker    string    `json:"worker"`
+	StartTime time.Time `json:"startTime"`
+	Duration  int64     `json:"duration"`
+	Error     string    `json:"error"`
+}
+
+// AttemptSummary 生成该次执行的概要信息
+func (jd *JobDetail) AttemptSummary() JobAttempt {
+	return JobAttempt{
+		Attempt:   jd.CurrentAttempt(),
+		Status:    jd.Status,
+		Worker:    jd.Worker,
+		StartTime: jd.StartTime,
+		Duration:  jd.Duration,
+		Error:     jd.Error,
+	}
 }
 
 func (jd *JobDetail) ToString() string {
@@ -77,6 +148,20 @@ func (jd *JobDetail) ToString() string {
 
 // StageSort job 排序
 func (job *Job) StageSort() ([]StageDetail, error) {
+	groups, err := job.StageGroups()
+	if err != nil {
+		return nil, err
+	}
+	stageList := make([]StageDetail, 0, len(job.Stages))
+	for _, group := range groups {
+		stageList = append(stageList, group...)
+	}
+	return stageList, nil
+}
+
+// StageGroups 按依赖关系把 stage 分组，同一组的 stage 依赖都已满足，相互之间可以并行
+// 组内按名称排序，保证结果稳定
+func (job *Job) StageGroups() ([][]StageDetail, error) {
 	stages := make(map[string]Stage)
 	for key, stage := range job.Stages {
 		stages[key] = stage
@@ -84,9 +169,9 @@ func (job *Job) StageSort() ([]StageDetail, error) {
 
 	sortedMap := make(map[string]any)
 
-	stageList := make([]StageDetail, 0)
+	groups := make([][]StageDetail, 0)
 	for len(stages) > 0 {
-		last := len(stages)
+		ready := make([]string, 0)
 		for key, stage := range stages {
 			allContains := true
 			for _, needs := range stage.Needs {
@@ -96,19 +181,66 @@ func (job *Job) StageSort() ([]StageDetail, error) {
 				}
 			}
 			if allContains {
-				sortedMap[key] = ""
-				delete(stages, key)
-				stageList = append(stageList, NewStageDetail(key, stage))
+				ready = append(ready, key)
 			}
 		}
 
-		if len(stages) == last {
+		if len(ready) == 0 {
 			return nil, fmt.Errorf("cannot resolve dependency, %v", stages)
 		}
 
+		sort.Strings(ready)
+		group := make([]StageDetail, 0, len(ready))
+		for _, key := range ready {
+			group = append(group, NewStageDetail(key, stages[key]))
+			sortedMap[key] = ""
+			delete(stages, key)
+		}
+		groups = append(groups, group)
 	}
 
-	return stageList, nil
+	return groups, nil
+}
+
+// ResumeStages 计算从 fromStage 恢复执行时的 stage 列表，fromStage 为空时从第一个没有成功的 stage 开始
+// 返回的列表中，fromStage 之前的 stage 保留上一次执行的结果，从 fromStage 开始按 job 当前的定义重新生成
+// 第二个返回值是保留的 stage 数量，从这个下标开始的 stage 需要重新执行
+func (job *Job) ResumeStages(previous *JobDetail, fromStage string) ([]StageDetail, int, error) {
+	resumeIndex := -1
+	for i, stage := range previous.Stages {
+		if (fromStage == "" && stage.Status != STATUS_SUCCESS) || (fromStage != "" && stage.Name == fromStage) {
+			resumeIndex = i
+			break
+		}
+	}
+	if resumeIndex < 0 {
+		if fromStage == "" {
+			return nil, 0, fmt.Errorf("job %s(%d) has no failed stage to resume from", previous.Name, previous.Id)
+		}
+		return nil, 0, fmt.Errorf("stage %s not found in job %s(%d)", fromStage, previous.Name, previous.Id)
+	}
+
+	stageList := make([]StageDetail, 0, len(previous.Stages))
+	kept := make(map[string]bool)
+	for _, stage := range previous.Stages[:resumeIndex] {
+		if stage.Status != STATUS_SUCCESS {
+			return nil, 0, fmt.Errorf("stage %s did not succeed, cannot resume after it", stage.Name)
+		}
+		stage.Stage.Steps = append([]Step{}, stage.Stage.Steps...)
+		stageList = append(stageList, stage)
+		kept[stage.Name] = true
+	}
+
+	sorted, err := job.StageSort()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, stage := range sorted {
+		if !kept[stage.Name] {
+			stageList = append(stageList, stage)
+		}
+	}
+	return stageList, resumeIndex, nil
 }
 
 func (jd *JobDetail) AddArtifactory(file *os.File) error {
@@ -153,6 +285,61 @@ type JobLog struct {
 	LastLine int `json:"lastLine"`
 }
 
+// JobLogEvent 实时日志中的一条消息
+type JobLogEvent struct {
+	// 日志中的一行，结束消息中为 nil
+	Line *output.Entry `json:"line,omitempty"`
+
+	// 下一行在日志文件中的位置，断开后从这里继续读取
+	Offset int64 `json:"offset"`
+
+	// 日志重新开始，例如 job 重新执行，之前收到的内容应当丢弃
+	Reset bool `json:"reset,omitempty"`
+
+	// 最后一条消息，job 已经结束
+	End bool `json:"end,omitempty"`
+
+	// 结束时 job 的状态
+	Status Status `json:"status,omitempty"`
+}
+
+// LogSearchFilter 搜索日志的条件，没有设置的条件不过滤
+type LogSearchFilter struct {
+	// 按正则表达式匹配，默认按子串匹配
+	Regex bool `json:"regex"`
+
+	// 忽略大小写
+	IgnoreCase bool `json:"ignoreCase"`
+
+	// 只搜索这个 job
+	Job string `json:"job"`
+
+	// 只搜索这些状态的执行记录
+	Status []Status `json:"status"`
+
+	// 只搜索在这个时间范围内开始的执行记录
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+
+	// 只搜索这个 stage 的日志
+	Stage string `json:"stage"`
+
+	// 匹配行前后各返回几行
+	Context int `json:"context"`
+
+	// 最多返回几个结果，默认 500
+	Limit int `json:"limit"`
+}
+
+// LogSearchResult 日志中匹配的一行
+type LogSearchResult struct {
+	Job       string    `json:"job"`
+	Id        int       `json:"id"`
+	Status    Status    `json:"status"`
+	StartTime time.Time `json:"startTime"`
+	output.Match
+}
+
 type JobStageLog struct {
 	// 开始时间
 	StartTime time.Time `json:"startTime"`
@@ -211,6 +398,16 @@ func IntToStatus(s int) (Status, error) {
 		return STATUS_SUCCESS, nil
 	case 4:
 		return STATUS_STOP, nil
+	case 5:
+		return STATUS_QUEUED, nil
+	case 6:
+		return STATUS_SKIPPED, nil
+	case 7:
+		return STATUS_CANCELLED, nil
+	case 8:
+		return STATUS_TIMEOUT, nil
+	case 9:
+		return STATUS_WAITING_APPROVAL, nil
 	}
 	return STATUS_NOTRUN, fmt.Errorf("unknown status: %d", s)
 }
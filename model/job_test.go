@@ -0,0 +1,155 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newResumeTestJob() *Job {
+	return &Job{
+		Name: "resume",
+		Stages: map[string]Stage{
+			"checkout": {Steps: []Step{{Name: "git", Uses: "git-checkout"}}},
+			"check":    {Steps: []Step{{Name: "solhint", Uses: "solhint-check"}}, Needs: []string{"checkout"}},
+			"deploy":   {Steps: []Step{{Name: "deploy", Run: "echo deploy"}}, Needs: []string{"check"}},
+		},
+	}
+}
+
+func TestResumeStages(t *testing.T) {
+	job := newResumeTestJob()
+	previous := &JobDetail{
+		Id:  1,
+		Job: *job,
+		Stages: []StageDetail{
+			{Name: "checkout", Status: STATUS_SUCCESS, Stage: job.Stages["checkout"]},
+			{Name: "check", Status: STATUS_FAIL, Stage: job.Stages["check"]},
+			{Name: "deploy", Status: STATUS_SKIPPED, Stage: job.Stages["deploy"]},
+		},
+	}
+
+	stages, index, err := job.ResumeStages(previous, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, index)
+	assert.Len(t, stages, 3)
+	assert.Equal(t, "checkout", stages[0].Name)
+	assert.Equal(t, STATUS_SUCCESS, stages[0].Status)
+	assert.Equal(t, "check", stages[1].Name)
+	assert.Equal(t, STATUS_NOTRUN, stages[1].Status)
+	assert.Equal(t, "deploy", stages[2].Name)
+
+	_, _, err = job.ResumeStages(previous, "deploy")
+	assert.Error(t, err, "check failed, deploy can not be resumed")
+
+	_, _, err = job.ResumeStages(previous, "unknown")
+	assert.Error(t, err)
+}
+
+func TestStageGroups(t *testing.T) {
+	job := newResumeTestJob()
+	job.Stages["lint"] = Stage{Needs: []string{"checkout"}}
+	groups, err := job.StageGroups()
+	assert.NoError(t, err)
+	assert.Len(t, groups, 3)
+	assert.Equal(t, "checkout", groups[0][0].Name)
+	assert.Equal(t, "check", groups[1][0].Name)
+	assert.Equal(t, "lint", groups[1][1].Name)
+	assert.Equal(t, "deploy", groups[2][0].Name)
+
+	job.Stages["checkout"] = Stage{Needs: []string{"deploy"}}
+	_, err = job.StageGroups()
+	assert.Error(t, err)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	var none *RetryPolicy
+	assert.False(t, none.ShouldRetry(1, WorkerRestartedError))
+
+	p := &RetryPolicy{Max: 2}
+	assert.True(t, p.ShouldRetry(1, WorkerRestartedError))
+	assert.True(t, p.ShouldRetry(2, WorkerRestartedError))
+	assert.False(t, p.ShouldRetry(3, WorkerRestartedError))
+	// 普通的执行失败不重试
+	assert.False(t, p.ShouldRetry(1, "exit status 1"))
+}
+
+func TestParseBytes(t *testing.T) {
+	for input, expected := range map[string]int64{
+		"1024":  1024,
+		"512M":  512 << 20,
+		"2g":    2 << 30,
+		"1.5Gi": 3 << 29,
+		"10GB":  10 << 30,
+	} {
+		size, err := ParseBytes(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, size, input)
+	}
+	_, err := ParseBytes("abc")
+	assert.Error(t, err)
+	_, err = ParseBytes("-1M")
+	assert.Error(t, err)
+}
+
+func TestMergeResources(t *testing.T) {
+	stage := &Resources{CPU: "2", Memory: "1G", Pids: 100}
+	step := &Resources{Memory: "512M", Disk: "5G"}
+
+	merged := MergeResources(stage, step)
+	assert.Equal(t, &Resources{CPU: "2", Memory: "512M", Pids: 100, Disk: "5G"}, merged)
+	assert.Equal(t, "1G", stage.Memory)
+	assert.Equal(t, stage, MergeResources(stage, nil))
+	assert.Nil(t, MergeResources(nil, nil))
+
+	assert.NoError(t, merged.Validate())
+	assert.Error(t, (&Resources{CPU: "two"}).Validate())
+}
+
+func TestMergeContainerOptions(t *testing.T) {
+	entrypoint := "/bin/sh"
+	stage := &ContainerOptions{
+		Pull:       PULL_NEVER,
+		User:       CONTAINER_USER_WORKER,
+		Network:    "host",
+		Entrypoint: &entrypoint,
+		Env:        map[string]string{"A": "stage", "B": "stage"},
+	}
+	step := &ContainerOptions{Pull: PULL_ALWAYS, Env: map[string]string{"B": "step"}}
+
+	merged := MergeContainerOptions(stage, step)
+	assert.Equal(t, PULL_ALWAYS, merged.Pull)
+	assert.Equal(t, CONTAINER_USER_WORKER, merged.User)
+	assert.Equal(t, "host", merged.Network)
+	assert.Equal(t, &entrypoint, merged.Entrypoint)
+	assert.Equal(t, map[string]string{"A": "stage", "B": "step"}, merged.Env)
+	// 不修改 stage 上的配置
+	assert.Equal(t, "stage", stage.Env["B"])
+
+	assert.Equal(t, step, MergeContainerOptions(nil, step))
+	assert.Equal(t, stage, MergeContainerOptions(stage, nil))
+	assert.NoError(t, merged.Validate())
+	assert.Error(t, (&ContainerOptions{Pull: "sometimes"}).Validate())
+}
+
+func TestApplyDefaults(t *testing.T) {
+	job := &Defaults{Shell: "bash", WorkingDirectory: "app", RunsOn: "node:18"}
+	stage := &Defaults{WorkingDirectory: "web"}
+
+	step := Step{Run: "npm test"}
+	ApplyDefaults(&step, job, stage)
+	assert.Equal(t, "bash", step.Shell)
+	assert.Equal(t, "web", step.WorkingDirectory)
+	assert.Equal(t, "node:18", step.RunsOn)
+
+	step = Step{Run: "npm test", Shell: "sh", RunsOn: "node:20"}
+	ApplyDefaults(&step, job, nil)
+	assert.Equal(t, "sh", step.Shell)
+	assert.Equal(t, "node:20", step.RunsOn)
+
+	// 只对 shell step 生效
+	step = Step{Uses: "git-checkout"}
+	ApplyDefaults(&step, job, stage)
+	assert.Empty(t, step.RunsOn)
+	assert.Empty(t, step.WorkingDirectory)
+}
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Command int
 
 const (
@@ -12,6 +14,12 @@ type QueueMessage struct {
 	JobId      int
 	JobContent string
 	Command    Command
+	// 恢复执行时上一次执行的 job detail，以及从哪个 stage 开始恢复
+	PreviousDetail string
+	ResumeStage    string
+	// 第几次执行，以及执行该任务的节点
+	Attempt int
+	Worker  string
 }
 
 func NewStartQueueMsg(name, content string, id int) *QueueMessage {
@@ -47,3 +55,24 @@ func NewStatusChangeMsg(name string, id int, status Status) StatusChangeMessage
 		status,
 	}
 }
+
+// TransitionKind 发生状态变化的对象类型
+type TransitionKind string
+
+const (
+	TransitionJob   TransitionKind = "job"
+	TransitionStage TransitionKind = "stage"
+	TransitionStep  TransitionKind = "step"
+)
+
+// TransitionEvent 状态变化事件，job、stage、step 的每一次状态变化都会产生一个
+type TransitionEvent struct {
+	Kind    TransitionKind
+	JobName string
+	JobId   int
+	Stage   string // Kind 为 stage 或 step 时有值
+	Step    string // Kind 为 step 时有值
+	From    Status
+	To      Status
+	Time    time.Time
+}
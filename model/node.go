@@ -4,4 +4,23 @@ type Node struct {
 	// ip 地址
 	Name    string
 	Address string
+	// 最近一次上报的执行能力，nil 表示没有上报过
+	Capacity *NodeCapacity
+}
+
+// NodeCapacity 节点的执行能力
+type NodeCapacity struct {
+	// 最多同时执行多少个任务
+	Max int
+	// 正在执行的任务数
+	Running int
+	// 本地排队的任务数
+	Queued int
+	// 节点上注册的插件 action
+	Actions []string
+}
+
+// IsFull 节点是否已经没有空闲的执行槽位
+func (c *NodeCapacity) IsFull() bool {
+	return c != nil && c.Max > 0 && c.Running >= c.Max
 }
@@ -0,0 +1,42 @@
+package model
+
+// JobPlan dry-run 生成的执行计划，描述任务会怎样执行，不会真正执行任何 step
+type JobPlan struct {
+	Name string `json:"name"`
+	// 执行时使用的参数，敏感参数已经脱敏
+	Parameter map[string]string `json:"parameter"`
+	// 依赖关系分组，同一组的 stage 相互之间没有依赖，按组依次执行
+	Groups [][]string  `json:"groups"`
+	Stages []StagePlan `json:"stages"`
+}
+
+// StagePlan stage 的执行计划，按执行顺序排列
+type StagePlan struct {
+	Name  string   `json:"name"`
+	Group int      `json:"group"`
+	Needs []string `json:"needs,omitempty"`
+	// 需要审批通过后才会执行
+	Approval bool       `json:"approval"`
+	Steps    []StepPlan `json:"steps"`
+}
+
+// StepPlan step 的执行计划，with 和 run 已经替换了参数
+type StepPlan struct {
+	Name string `json:"name"`
+	Uses string `json:"uses"`
+	// action 的类型：shell、builtin、remote、unknown
+	Action string `json:"action"`
+	// remote action 所在的仓库
+	Source           string            `json:"source,omitempty"`
+	Run              string            `json:"run,omitempty"`
+	Shell            string            `json:"shell,omitempty"`
+	WorkingDirectory string            `json:"workingDirectory,omitempty"`
+	With             map[string]string `json:"with,omitempty"`
+	Image            string            `json:"image,omitempty"`
+	Volumes          []string          `json:"volumes,omitempty"`
+	Resources        *Resources        `json:"resources,omitempty"`
+	Container        *ContainerOptions `json:"container,omitempty"`
+	// 执行时会被跳过的 step 及原因
+	Skipped    bool   `json:"skipped"`
+	SkipReason string `json:"skipReason,omitempty"`
+}
@@ -5,7 +5,9 @@ type RemoteAction struct {
 	Description string                    `yaml:"description"`
 	Author      string                    `yaml:"author"`
 	Inputs      map[string]ActionInputArg `yaml:"inputs"`
-	Runs        ActionRun                 `yaml:"runs"`
+	// action 的输出，执行成功后记录到调用它的 step 上
+	Outputs map[string]ActionOutput `yaml:"outputs"`
+	Runs    ActionRun               `yaml:"runs"`
 }
 
 type ActionInputArg struct {
@@ -14,12 +16,40 @@ type ActionInputArg struct {
 	Required    bool   `yaml:"required"`
 }
 
+type ActionOutput struct {
+	Description string `yaml:"description"`
+	// 可以引用 ${{ steps.<id>.outputs.<name> }}，为空时使用 step 写到 ALINE_OUTPUT 中的同名输出
+	Value string `yaml:"value"`
+}
+
+// 远程 action 的执行方式
+const (
+	// ACTION_USING_SHELL 按顺序执行 steps 中的脚本，using 为空时也是这种方式
+	ACTION_USING_SHELL = "shell"
+	// ACTION_USING_COMPOSITE steps 中可以通过 uses 调用内置 action 或其他远程 action
+	ACTION_USING_COMPOSITE = "composite"
+	// ACTION_USING_DOCKER 在容器中执行 action
+	ACTION_USING_DOCKER = "docker"
+)
+
 type ActionRun struct {
 	Using string       `yaml:"using"`
 	Steps []ActionStep `yaml:"steps"`
+	// using 为 docker 时使用，action 中 Dockerfile 的路径，或者 docker://<image>
+	Image string `yaml:"image"`
+	// 覆盖镜像的 entrypoint
+	Entrypoint string            `yaml:"entrypoint"`
+	Args       []string          `yaml:"args"`
+	Env        map[string]string `yaml:"env"`
 }
 
 type ActionStep struct {
-	Run   string `yaml:"run"`
+	// 后面的 step 和 outputs 通过 id 引用这个 step 的输出
+	Id   string            `yaml:"id"`
+	Name string            `yaml:"name"`
+	Uses string            `yaml:"uses"`
+	With map[string]string `yaml:"with"`
+	Run  string            `yaml:"run"`
+	// Shell 为空时使用 sh
 	Shell string `yaml:"shell"`
 }
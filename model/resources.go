@@ -0,0 +1,139 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Resources step 可以使用的资源上限，可以写在 stage 上，对其中所有的 step 生效，step 上的配置优先
+type Resources struct {
+	// CPU 核数，可以是小数，例如 0.5、2
+	CPU string `yaml:"cpu,omitempty" json:"cpu,omitempty"`
+	// 内存上限，例如 512M、2G
+	Memory string `yaml:"memory,omitempty" json:"memory,omitempty"`
+	// 最多同时存在的进程（线程）数
+	Pids int `yaml:"pids,omitempty" json:"pids,omitempty"`
+	// 工作目录最多占用的磁盘空间，例如 10G
+	Disk string `yaml:"disk,omitempty" json:"disk,omitempty"`
+}
+
+// MergeResources 合并 stage 和 step 上的资源限制，step 上没有配置的项使用 stage 的配置
+func MergeResources(stage, step *Resources) *Resources {
+	if stage == nil {
+		return step
+	}
+	if step == nil {
+		return stage
+	}
+	merged := *step
+	if merged.CPU == "" {
+		merged.CPU = stage.CPU
+	}
+	if merged.Memory == "" {
+		merged.Memory = stage.Memory
+	}
+	if merged.Pids == 0 {
+		merged.Pids = stage.Pids
+	}
+	if merged.Disk == "" {
+		merged.Disk = stage.Disk
+	}
+	return &merged
+}
+
+// IsEmpty 是否没有任何限制
+func (r *Resources) IsEmpty() bool {
+	return r == nil || (r.CPU == "" && r.Memory == "" && r.Pids == 0 && r.Disk == "")
+}
+
+// CPUCores 解析 CPU 核数，没有配置时返回 0
+func (r *Resources) CPUCores() (float64, error) {
+	if r == nil || r.CPU == "" {
+		return 0, nil
+	}
+	cores, err := strconv.ParseFloat(r.CPU, 64)
+	if err != nil || cores <= 0 {
+		return 0, fmt.Errorf("invalid cpu limit: %s", r.CPU)
+	}
+	return cores, nil
+}
+
+// MemoryBytes 解析内存上限，没有配置时返回 0
+func (r *Resources) MemoryBytes() (int64, error) {
+	if r == nil || r.Memory == "" {
+		return 0, nil
+	}
+	return ParseBytes(r.Memory)
+}
+
+// DiskBytes 解析磁盘上限，没有配置时返回 0
+func (r *Resources) DiskBytes() (int64, error) {
+	if r == nil || r.Disk == "" {
+		return 0, nil
+	}
+	return ParseBytes(r.Disk)
+}
+
+// Validate 检查资源限制的格式是否正确
+func (r *Resources) Validate() error {
+	if _, err := r.CPUCores(); err != nil {
+		return err
+	}
+	if _, err := r.MemoryBytes(); err != nil {
+		return err
+	}
+	if _, err := r.DiskBytes(); err != nil {
+		return err
+	}
+	if r != nil && r.Pids < 0 {
+		return fmt.Errorf("invalid pids limit: %d", r.Pids)
+	}
+	return nil
+}
+
+// ParseBytes 解析 512M、2G、1024 这样的大小，单位为 1024 进制，不区分大小写，可以带 B 或 iB 后缀
+func ParseBytes(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(strings.TrimSuffix(str, "B"), "I")
+	multiple := int64(1)
+	if str != "" {
+		switch str[len(str)-1] {
+		case 'K':
+			multiple = 1 << 10
+		case 'M':
+			multiple = 1 << 20
+		case 'G':
+			multiple = 1 << 30
+		case 'T':
+			multiple = 1 << 40
+		}
+		if multiple > 1 {
+			str = str[:len(str)-1]
+		}
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return int64(value * float64(multiple)), nil
+}
+
+// ResourceLimitError step 超出资源限制被结束
+type ResourceLimitError struct {
+	// 超出的是哪一项：cpu、memory、pids、disk
+	Resource string
+	Limit    string
+	Err      error
+}
+
+func (e *ResourceLimitError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("resource limit exceeded: %s %s, %s", e.Resource, e.Limit, e.Err)
+	}
+	return fmt.Sprintf("resource limit exceeded: %s %s", e.Resource, e.Limit)
+}
+
+func (e *ResourceLimitError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,18 @@
+package model
+
+// WorkerRestartedError worker 在任务执行过程中退出，重启后把任务标记为失败时使用的错误信息
+const WorkerRestartedError = "worker restarted"
+
+// RetryPolicy job 的重试策略，只对 worker 异常退出导致失败的任务生效
+type RetryPolicy struct {
+	// 最多重新执行多少次，0 表示不重试
+	Max int `yaml:"max,omitempty" json:"max"`
+}
+
+// ShouldRetry 第 attempt 次执行因为 reason 失败后，是否需要重新执行
+func (p *RetryPolicy) ShouldRetry(attempt int, reason string) bool {
+	if p == nil || reason != WorkerRestartedError {
+		return false
+	}
+	return attempt <= p.Max
+}
@@ -6,8 +6,15 @@ import (
 )
 
 type Stage struct {
-	Steps []Step   `yaml:"steps,omitempty" json:"steps"`
-	Needs []string `yaml:"needs,omitempty" json:"needs"`
+	Steps    []Step    `yaml:"steps,omitempty" json:"steps"`
+	Needs    []string  `yaml:"needs,omitempty" json:"needs"`
+	Approval *Approval `yaml:"approval,omitempty" json:"approval,omitempty"`
+	// 对 stage 中所有 step 生效的资源限制
+	Resources *Resources `yaml:"resources,omitempty" json:"resources,omitempty"`
+	// 对 stage 中所有 step 生效的 runs-on 容器选项
+	Container *ContainerOptions `yaml:"container,omitempty" json:"container,omitempty"`
+	// 对 stage 中所有 shell step 生效的默认配置，优先于 job 上的配置
+	Defaults *Defaults `yaml:"defaults,omitempty" json:"defaults,omitempty"`
 }
 
 type StageDetail struct {
@@ -16,6 +23,8 @@ type StageDetail struct {
 	Status    Status    `json:"status"`
 	StartTime time.Time `json:"startTime"`
 	Duration  int64     `json:"duration"`
+	// 本 stage 中各个 step 产生的结果，用于从失败的 stage 恢复执行时保留之前成功的结果
+	Result *ActionResult `yaml:"result,omitempty" json:"result,omitempty"`
 }
 
 func NewStageDetail(name string, stage Stage) StageDetail {
@@ -3,14 +3,26 @@ package model
 import "time"
 
 type Step struct {
-	Name      string            `yaml:"name,omitempty" json:"name"`
-	Id        string            `yaml:"id,omitempty" json:"id"`
-	Uses      string            `yaml:"uses,omitempty" json:"uses"`
-	With      map[string]string `yaml:"with,omitempty" json:"with"`
-	RunsOn    string            `yaml:"runs-on,omitempty" json:"runsOn"`
-	Volumes   []string          `yaml:"volumes,omitempty" json:"volumes"`
-	Run       string            `yaml:"run,omitempty" json:"run"`
+	Name    string            `yaml:"name,omitempty" json:"name"`
+	Id      string            `yaml:"id,omitempty" json:"id"`
+	Uses    string            `yaml:"uses,omitempty" json:"uses"`
+	With    map[string]string `yaml:"with,omitempty" json:"with"`
+	RunsOn  string            `yaml:"runs-on,omitempty" json:"runsOn"`
+	Volumes []string          `yaml:"volumes,omitempty" json:"volumes"`
+	Run     string            `yaml:"run,omitempty" json:"run"`
+	// 执行 run 的解释器，为空时使用 sh
+	Shell string `yaml:"shell,omitempty" json:"shell,omitempty"`
+	// 执行 run 的目录，相对路径相对于任务的工作目录
+	WorkingDirectory string     `yaml:"working-directory,omitempty" json:"workingDirectory,omitempty"`
+	Resources        *Resources `yaml:"resources,omitempty" json:"resources,omitempty"`
+	// runs-on 容器的选项
+	Container *ContainerOptions `yaml:"container,omitempty" json:"container,omitempty"`
+	// action 执行成功后产生的输出
+	Outputs   map[string]string `yaml:"outputs,omitempty" json:"outputs,omitempty"`
 	Status    Status            `yaml:"status,omitempty" json:"status"`
 	StartTime time.Time         `yaml:"startTime,omitempty" json:"startTime"`
+	EndTime   time.Time         `yaml:"endTime,omitempty" json:"endTime"`
 	Duration  int64             `yaml:"duration,omitempty" json:"duration"`
+	// 失败、超时、被取消时的详细信息
+	Failure *StepFailure `yaml:"failure,omitempty" json:"failure,omitempty"`
 }
@@ -0,0 +1,220 @@
+package output
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 日志中保留工具输出的 ANSI 控制序列，显示时再去掉或者转换为 html
+var (
+	// ansiSequence CSI 序列（颜色、光标移动、清屏）、OSC 序列（标题、超链接）和其他两个字符的序列
+	ansiSequence = regexp.MustCompile("\x1b(?:\\[[0-?]*[ -/]*[@-~]|\\][^\x07\x1b]*(?:\x07|\x1b\\\\)|[@-Z\\\\-_])")
+	// ansiSequenceJSON json 编码后的 CSI 序列，ESC 被转义为 \u001b
+	ansiSequenceJSON = regexp.MustCompile(`\\u001[bB](?:\[[0-?]*[ -/]*[@-~])?`)
+)
+
+// StripANSI 去掉 ANSI 控制序列，只保留文本
+func StripANSI(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	return ansiSequence.ReplaceAllString(s, "")
+}
+
+// StripANSIJSON 去掉 json 编码后的内容中的 ANSI 控制序列
+func StripANSIJSON(data []byte) []byte {
+	return ansiSequenceJSON.ReplaceAll(data, nil)
+}
+
+// ansiStyle SGR 序列设置的样式
+type ansiStyle struct {
+	bold, faint, italic, underline, inverse, strike bool
+	// 颜色为空时使用默认颜色，0-15 为 class，其他为 #rrggbb
+	fg, bg string
+}
+
+func (s ansiStyle) isZero() bool {
+	return s == ansiStyle{}
+}
+
+// html 的 class 和 style 属性，16 色使用 class 方便修改配色，256 色和真彩色使用 style
+func (s ansiStyle) attrs() string {
+	var classes, styles []string
+	flags := []struct {
+		on   bool
+		name string
+	}{{s.bold, "bold"}, {s.faint, "faint"}, {s.italic, "italic"}, {s.underline, "underline"}, {s.strike, "strike"}}
+	for _, flag := range flags {
+		if flag.on {
+			classes = append(classes, "ansi-"+flag.name)
+		}
+	}
+	fg, bg := s.fg, s.bg
+	if s.inverse {
+		fg, bg = bg, fg
+		if fg == "" {
+			fg = "bg"
+		}
+		if bg == "" {
+			bg = "fg"
+		}
+	}
+	for _, c := range []struct{ prefix, color, property string }{{"ansi-fg-", fg, "color"}, {"ansi-bg-", bg, "background-color"}} {
+		switch {
+		case c.color == "":
+		case strings.HasPrefix(c.color, "#"):
+			styles = append(styles, c.property+":"+c.color)
+		default:
+			classes = append(classes, c.prefix+c.color)
+		}
+	}
+	var b strings.Builder
+	if len(classes) > 0 {
+		fmt.Fprintf(&b, ` class="%s"`, strings.Join(classes, " "))
+	}
+	if len(styles) > 0 {
+		fmt.Fprintf(&b, ` style="%s"`, strings.Join(styles, ";"))
+	}
+	return b.String()
+}
+
+// apply 按照 SGR 的参数修改样式，不支持的参数忽略
+func (s *ansiStyle) apply(params string) {
+	if params == "" {
+		*s = ansiStyle{}
+		return
+	}
+	codes := strings.Split(strings.ReplaceAll(params, ":", ";"), ";")
+	for i := 0; i < len(codes); i++ {
+		code, err := strconv.Atoi(codes[i])
+		if err != nil && codes[i] != "" {
+			continue
+		}
+		switch {
+		case code == 0:
+			*s = ansiStyle{}
+		case code == 1:
+			s.bold = true
+		case code == 2:
+			s.faint = true
+		case code == 3:
+			s.italic = true
+		case code == 4:
+			s.underline = true
+		case code == 7:
+			s.inverse = true
+		case code == 9:
+			s.strike = true
+		case code == 22:
+			s.bold, s.faint = false, false
+		case code == 23:
+			s.italic = false
+		case code == 24:
+			s.underline = false
+		case code == 27:
+			s.inverse = false
+		case code == 29:
+			s.strike = false
+		case code >= 30 && code <= 37:
+			s.fg = strconv.Itoa(code - 30)
+		case code == 38:
+			s.fg, i = extendedColor(codes, i)
+		case code == 39:
+			s.fg = ""
+		case code >= 40 && code <= 47:
+			s.bg = strconv.Itoa(code - 40)
+		case code == 48:
+			s.bg, i = extendedColor(codes, i)
+		case code == 49:
+			s.bg = ""
+		case code >= 90 && code <= 97:
+			s.fg = strconv.Itoa(code - 90 + 8)
+		case code >= 100 && code <= 107:
+			s.bg = strconv.Itoa(code - 100 + 8)
+		}
+	}
+}
+
+// extendedColor 解析 38;5;n 和 38;2;r;g;b，返回颜色和最后一个参数的位置
+func extendedColor(codes []string, i int) (string, int) {
+	if i+1 >= len(codes) {
+		return "", i
+	}
+	switch codes[i+1] {
+	case "5":
+		if i+2 >= len(codes) {
+			return "", len(codes)
+		}
+		n, err := strconv.Atoi(codes[i+2])
+		if err != nil || n < 0 || n > 255 {
+			return "", i + 2
+		}
+		if n < 16 {
+			return strconv.Itoa(n), i + 2
+		}
+		return xterm256(n), i + 2
+	case "2":
+		if i+4 >= len(codes) {
+			return "", len(codes)
+		}
+		var rgb [3]int
+		for j := range rgb {
+			v, err := strconv.Atoi(codes[i+2+j])
+			if err != nil || v < 0 || v > 255 {
+				return "", i + 4
+			}
+			rgb[j] = v
+		}
+		return fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2]), i + 4
+	}
+	return "", i + 1
+}
+
+// xterm256 256 色中 16 之后的颜色：6x6x6 的色块和 24 级灰度
+func xterm256(n int) string {
+	if n >= 232 {
+		v := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", v, v, v)
+	}
+	n -= 16
+	level := func(c int) int {
+		if c == 0 {
+			return 0
+		}
+		return 55 + c*40
+	}
+	return fmt.Sprintf("#%02x%02x%02x", level(n/36), level(n/6%6), level(n%6))
+}
+
+// ANSIToHTML 把一行内容转换为 html，颜色和样式转换为 span，其他控制序列去掉
+func ANSIToHTML(s string) string {
+	var b strings.Builder
+	var style ansiStyle
+	open := false
+	last := 0
+	for _, loc := range ansiSequence.FindAllStringIndex(s, -1) {
+		b.WriteString(html.EscapeString(s[last:loc[0]]))
+		last = loc[1]
+		seq := s[loc[0]:loc[1]]
+		if !strings.HasPrefix(seq, "\x1b[") || !strings.HasSuffix(seq, "m") {
+			continue
+		}
+		style.apply(seq[2 : len(seq)-1])
+		if open {
+			b.WriteString("</span>")
+			open = false
+		}
+		if !style.isZero() {
+			fmt.Fprintf(&b, "<span%s>", style.attrs())
+			open = true
+		}
+	}
+	b.WriteString(html.EscapeString(s[last:]))
+	if open {
+		b.WriteString("</span>")
+	}
+	return b.String()
+}
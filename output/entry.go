@@ -0,0 +1,184 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 日志中每一行的来源
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	// StreamSystem engine 自己写入的内容，例如 stage 和 step 的开始、耗时
+	StreamSystem = "system"
+)
+
+// maxLineSize 日志文件中一行的最大长度
+const maxLineSize = 16 * 1024 * 1024
+
+// Entry 日志文件中的一行，文件中每行是一个 json
+// stage 和 step 记录在每一行中，解析时不依赖输出的内容，用户输出以 [Pipeline] 开头也不会影响解析
+type Entry struct {
+	Time   time.Time `json:"ts"`
+	Stage  string    `json:"stage,omitempty"`
+	Step   string    `json:"step,omitempty"`
+	Stream string    `json:"stream"`
+	Text   string    `json:"text"`
+}
+
+// String 显示为旧的文本格式，stdout 和 stderr 的内容前面加上时间
+func (e Entry) String() string {
+	if e.Stream == StreamSystem {
+		return e.Text
+	}
+	return fmt.Sprintf("[%s] %s", e.Time.Format(time.RFC3339), e.Text)
+}
+
+func renderEntries(entries []Entry) string {
+	var b strings.Builder
+	for _, entry := range entries {
+		b.WriteString(entry.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// isEntryLine 是否为 json 格式日志中的一行，旧的文本格式的日志第一行为 [Job] Started on
+func isEntryLine(line string) bool {
+	if !strings.HasPrefix(line, "{") {
+		return false
+	}
+	var entry Entry
+	return json.Unmarshal([]byte(line), &entry) == nil && entry.Stream != ""
+}
+
+func decodeEntries(lines []string) ([]Entry, error) {
+	entries := make([]Entry, 0, len(lines))
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// decodeEntriesBytes 解析文件中的一段内容
+func decodeEntriesBytes(data []byte) ([]Entry, error) {
+	return decodeEntries(strings.Split(string(bytes.TrimRight(data, "\n")), "\n"))
+}
+
+// parseEntries 按照每一行记录的 stage 组织日志，stage 的耗时取自 engine 写入的内容
+func parseEntries(entries []Entry) Log {
+	var log Log
+	log.Lines = make([]string, 0, len(entries))
+	index := make(map[string]int)
+	for _, entry := range entries {
+		line := entry.String()
+		log.Lines = append(log.Lines, line)
+		if entry.Stage == "" {
+			if entry.Stream == StreamSystem {
+				parseJobTime(&log, entry.Text)
+			}
+			continue
+		}
+		i, ok := index[entry.Stage]
+		if !ok {
+			i = len(log.Stages)
+			index[entry.Stage] = i
+			log.Stages = append(log.Stages, Stage{Name: entry.Stage, StartTime: entry.Time, entries: []Entry{}})
+		}
+		stage := &log.Stages[i]
+		stage.Lines = append(stage.Lines, line)
+		stage.entries = append(stage.entries, entry)
+		// step 中的耗时属于 step
+		if entry.Stream == StreamSystem && entry.Step == "" {
+			parseStageTime(stage, entry.Text)
+		}
+	}
+	return log
+}
+
+// parseJobTime 解析 [Job] 开头的 job 的开始和结束时间
+func parseJobTime(log *Log, line string) {
+	if strings.HasPrefix(line, "[Job] Started on ") {
+		log.StartTime, _ = time.Parse(time.RFC3339, strings.TrimPrefix(line, "[Job] Started on "))
+	}
+	if strings.HasPrefix(line, "[Job] Finished on ") {
+		log.EndTime, log.Duration = parseEndTime(strings.TrimPrefix(line, "[Job] Finished on "))
+	}
+}
+
+// parseStageTime 解析 [TimeConsuming] 开头的 stage 的开始和结束时间
+func parseStageTime(stage *Stage, line string) {
+	if strings.HasPrefix(line, "[TimeConsuming] StartTime: ") {
+		stage.StartTime, _ = time.Parse(time.RFC3339, strings.TrimPrefix(line, "[TimeConsuming] StartTime: "))
+	}
+	if strings.HasPrefix(line, "[TimeConsuming] EndTime: ") {
+		stage.EndTime, stage.Duration = parseEndTime(strings.TrimPrefix(line, "[TimeConsuming] EndTime: "))
+	}
+}
+
+// parseEndTime 解析 "<RFC3339>, Duration: <duration>"
+func parseEndTime(s string) (time.Time, time.Duration) {
+	endTimeAndDuration := strings.Split(s, ",")
+	endTime, _ := time.Parse(time.RFC3339, endTimeAndDuration[0])
+	var duration time.Duration
+	if len(endTimeAndDuration) > 1 {
+		duration, _ = time.ParseDuration(strings.TrimPrefix(endTimeAndDuration[1], " Duration: "))
+	}
+	return endTime, duration
+}
+
+// parseStepTime 解析 step 开始和结束时写入的耗时，时间使用这一行记录的时间，比文本中的精确
+// 结束时的格式为 "[TimeConsuming] EndTime: <RFC3339>, Duration: <duration>, Status: <status>"
+func parseStepTime(step *Step, entry Entry) {
+	if strings.HasPrefix(entry.Text, "[TimeConsuming] StartTime: ") {
+		step.StartTime = entry.Time
+	}
+	if strings.HasPrefix(entry.Text, "[TimeConsuming] EndTime: ") {
+		step.EndTime = entry.Time
+		_, step.Duration = parseEndTime(strings.TrimPrefix(entry.Text, "[TimeConsuming] EndTime: "))
+		if i := strings.Index(entry.Text, ", Status: "); i >= 0 {
+			step.Status = entry.Text[i+len(", Status: "):]
+		}
+	}
+}
+
+// entrySteps 按照每一行记录的 step 组织一个 stage 中的日志，不包含 engine 写入的 step 开始和耗时的内容
+func entrySteps(entries []Entry) []*Step {
+	var result []*Step
+	steps := make(map[string]*Step)
+	for _, entry := range entries {
+		if entry.Step == "" {
+			continue
+		}
+		step, ok := steps[entry.Step]
+		if !ok {
+			step = &Step{Name: entry.Step}
+			steps[entry.Step] = step
+			result = append(result, step)
+		}
+		if entry.Stream == StreamSystem {
+			if strings.HasPrefix(entry.Text, "[Pipeline] Step: ") {
+				continue
+			}
+			if strings.HasPrefix(entry.Text, "[TimeConsuming] ") {
+				parseStepTime(step, entry)
+				continue
+			}
+		}
+		step.lines = append(step.lines, entry.String())
+	}
+	for _, step := range result {
+		step.fillContent()
+	}
+	return result
+}
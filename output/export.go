@@ -0,0 +1,268 @@
+package output
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 导出日志的格式
+const (
+	// ExportText 去掉 ANSI 控制序列的文本，和旧的文本格式一致
+	ExportText = "text"
+	// ExportHTML 带颜色的 html，每个 stage、step 和每一行都有锚点
+	ExportHTML = "html"
+	// ExportArchive zip 压缩包，包含原始日志、文本和 html
+	ExportArchive = "zip"
+)
+
+// ExportFilename 导出的文件名，例如 1.txt、1.html、1.zip
+func ExportFilename(filename, format string) string {
+	base := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	switch format {
+	case ExportText:
+		return base + ".txt"
+	case ExportHTML:
+		return base + ".html"
+	}
+	return base + "." + format
+}
+
+// ExportContentType 导出的文件的 Content-Type
+func ExportContentType(format string) string {
+	switch format {
+	case ExportText:
+		return "text/plain; charset=utf-8"
+	case ExportHTML:
+		return "text/html; charset=utf-8"
+	}
+	return "application/zip"
+}
+
+// Export 按照 format 导出日志文件，不支持的格式返回错误
+func Export(w io.Writer, filename, format, title string) error {
+	switch format {
+	case ExportText:
+		return RenderText(w, filename)
+	case ExportHTML:
+		return RenderHTML(w, filename, title)
+	case ExportArchive:
+		return WriteArchive(w, filename, title)
+	}
+	return fmt.Errorf("unsupported export format: %s", format)
+}
+
+// RenderText 把日志渲染为去掉 ANSI 控制序列的文本
+func RenderText(w io.Writer, filename string) error {
+	bw := bufio.NewWriter(w)
+	err := ScanEntries(filename, func(_ int, entry Entry) bool {
+		entry.Text = StripANSI(entry.Text)
+		bw.WriteString(entry.String())
+		bw.WriteByte('\n')
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+const htmlHeader = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { margin: 0; background: #1e1e1e; color: #d4d4d4; font-family: sans-serif; }
+nav { padding: 12px 16px; border-bottom: 1px solid #333; }
+nav a { color: #9cdcfe; text-decoration: none; }
+nav ul { margin: 4px 0; }
+h2, h3 { margin: 0; padding: 8px 16px; font-size: 14px; background: #252526; }
+h3 { padding-left: 32px; font-weight: normal; }
+h2 a, h3 a { color: inherit; text-decoration: none; }
+pre { margin: 0; padding: 0 16px; font: 13px/1.5 monospace; white-space: pre-wrap; word-break: break-all; }
+.line { display: block; }
+.line:target { background: #3a3d41; }
+.ln { display: inline-block; width: 5em; color: #6e7681; text-decoration: none; user-select: none; }
+.ts { color: #6e7681; }
+.system { color: #9cdcfe; }
+.stderr { color: #f48771; }
+.ansi-bold { font-weight: bold; }
+.ansi-faint { opacity: 0.7; }
+.ansi-italic { font-style: italic; }
+.ansi-underline { text-decoration: underline; }
+.ansi-strike { text-decoration: line-through; }
+.ansi-fg-0 { color: #000000; } .ansi-bg-0 { background-color: #000000; }
+.ansi-fg-1 { color: #cd3131; } .ansi-bg-1 { background-color: #cd3131; }
+.ansi-fg-2 { color: #0dbc79; } .ansi-bg-2 { background-color: #0dbc79; }
+.ansi-fg-3 { color: #e5e510; } .ansi-bg-3 { background-color: #e5e510; }
+.ansi-fg-4 { color: #2472c8; } .ansi-bg-4 { background-color: #2472c8; }
+.ansi-fg-5 { color: #bc3fbc; } .ansi-bg-5 { background-color: #bc3fbc; }
+.ansi-fg-6 { color: #11a8cd; } .ansi-bg-6 { background-color: #11a8cd; }
+.ansi-fg-7 { color: #e5e5e5; } .ansi-bg-7 { background-color: #e5e5e5; }
+.ansi-fg-8 { color: #666666; } .ansi-bg-8 { background-color: #666666; }
+.ansi-fg-9 { color: #f14c4c; } .ansi-bg-9 { background-color: #f14c4c; }
+.ansi-fg-10 { color: #23d18b; } .ansi-bg-10 { background-color: #23d18b; }
+.ansi-fg-11 { color: #f5f543; } .ansi-bg-11 { background-color: #f5f543; }
+.ansi-fg-12 { color: #3b8eea; } .ansi-bg-12 { background-color: #3b8eea; }
+.ansi-fg-13 { color: #d670d6; } .ansi-bg-13 { background-color: #d670d6; }
+.ansi-fg-14 { color: #29b8db; } .ansi-bg-14 { background-color: #29b8db; }
+.ansi-fg-15 { color: #ffffff; } .ansi-bg-15 { background-color: #ffffff; }
+.ansi-fg-bg { color: #1e1e1e; } .ansi-bg-fg { background-color: #d4d4d4; }
+</style>
+</head>
+<body>
+`
+
+// StageAnchor stage 在 html 中的锚点
+func StageAnchor(stage string) string {
+	return "stage-" + anchorName(stage)
+}
+
+// StepAnchor step 在 html 中的锚点
+func StepAnchor(stage, step string) string {
+	return "step-" + anchorName(stage) + "-" + anchorName(step)
+}
+
+// LineAnchor 行在 html 中的锚点，行号和 ScanEntries 的一致
+func LineAnchor(lineNo int) string {
+	return fmt.Sprintf("L%d", lineNo)
+}
+
+// id 中不能有空白字符
+func anchorName(name string) string {
+	return strings.Join(strings.Fields(name), "-")
+}
+
+// RenderHTML 把日志渲染为一个 html 页面，开头是 stage 和 step 的目录
+// 需要读取两遍日志文件，第一遍生成目录
+func RenderHTML(w io.Writer, filename, title string) error {
+	type tocStage struct {
+		name  string
+		steps []string
+	}
+	var toc []*tocStage
+	seen := make(map[string]bool)
+	err := ScanEntries(filename, func(_ int, entry Entry) bool {
+		if entry.Stage == "" {
+			return true
+		}
+		if !seen[entry.Stage] {
+			seen[entry.Stage] = true
+			toc = append(toc, &tocStage{name: entry.Stage})
+		}
+		key := entry.Stage + "\x00" + entry.Step
+		if entry.Step != "" && !seen[key] {
+			seen[key] = true
+			for _, stage := range toc {
+				if stage.name == entry.Stage {
+					stage.steps = append(stage.steps, entry.Step)
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, htmlHeader, html.EscapeString(title))
+	bw.WriteString("<nav>\n<ul>\n")
+	for _, stage := range toc {
+		fmt.Fprintf(bw, "<li><a href=\"#%s\">%s</a>", html.EscapeString(StageAnchor(stage.name)), html.EscapeString(stage.name))
+		if len(stage.steps) > 0 {
+			bw.WriteString("<ul>")
+			for _, step := range stage.steps {
+				fmt.Fprintf(bw, "<li><a href=\"#%s\">%s</a></li>", html.EscapeString(StepAnchor(stage.name, step)), html.EscapeString(step))
+			}
+			bw.WriteString("</ul>")
+		}
+		bw.WriteString("</li>\n")
+	}
+	bw.WriteString("</ul>\n</nav>\n<pre>")
+
+	// stage 和 step 的标题只在第一次出现时写入，锚点在同一个页面中不能重复
+	var stage, step string
+	anchored := make(map[string]bool)
+	heading := func(tag, anchor, text string) {
+		if anchored[anchor] {
+			return
+		}
+		anchored[anchor] = true
+		fmt.Fprintf(bw, "</pre>\n<%s id=\"%s\"><a href=\"#%s\">%s</a></%s>\n<pre>", tag, html.EscapeString(anchor), html.EscapeString(anchor), html.EscapeString(text), tag)
+	}
+	err = ScanEntries(filename, func(lineNo int, entry Entry) bool {
+		if entry.Stage != stage {
+			stage, step = entry.Stage, ""
+			if stage != "" {
+				heading("h2", StageAnchor(stage), stage)
+			}
+		}
+		if entry.Step != step {
+			step = entry.Step
+			if step != "" {
+				heading("h3", StepAnchor(stage, step), step)
+			}
+		}
+		anchor := LineAnchor(lineNo)
+		fmt.Fprintf(bw, "<span class=\"line %s\" id=\"%s\"><a class=\"ln\" href=\"#%s\">%d</a>", entry.Stream, anchor, anchor, lineNo)
+		if entry.Stream != StreamSystem && !entry.Time.IsZero() {
+			fmt.Fprintf(bw, "<span class=\"ts\">[%s]</span> ", entry.Time.Format(time.RFC3339))
+		}
+		bw.WriteString(ANSIToHTML(entry.Text))
+		bw.WriteString("</span>")
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	bw.WriteString("</pre>\n</body>\n</html>\n")
+	return bw.Flush()
+}
+
+// WriteArchive 把原始日志、文本和 html 打包为 zip，原始日志中保留了 ANSI 控制序列
+func WriteArchive(w io.Writer, filename, title string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	create := func(name string) (io.Writer, error) {
+		return zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: info.ModTime()})
+	}
+	raw, err := create(filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(raw, f); err != nil {
+		return err
+	}
+	text, err := create(ExportFilename(filename, ExportText))
+	if err != nil {
+		return err
+	}
+	if err := RenderText(text, filename); err != nil {
+		return err
+	}
+	page, err := create(ExportFilename(filename, ExportHTML))
+	if err != nil {
+		return err
+	}
+	if err := RenderHTML(page, filename, title); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,121 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrLogReset 日志文件被重新写入，例如 job 重新执行
+var ErrLogReset = errors.New("log reset")
+
+// Line 日志文件中的一行，Next 为下一行在文件中的位置
+type Line struct {
+	Entry
+	Next int64
+}
+
+// ReadFrom 读取日志文件中 offset 之后完整的行，最后一行没有写完时不返回，下次从这一行继续读取
+// 文件不存在时返回空；文件比 offset 小时说明日志已经重新开始，返回 ErrLogReset
+// 旧的文本格式的日志每行作为 system 的内容返回
+func ReadFrom(filename string, offset int64) ([]Line, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < offset {
+		return nil, ErrLogReset
+	}
+	return readLines(f, offset)
+}
+
+// Follower 持续读取一个日志文件新写入的行
+// 除了文件变短，文件被替换（例如 job 重新执行时生成了新的日志文件）也会返回 ErrLogReset，即使新文件已经比读取的位置长
+type Follower struct {
+	filename string
+	offset   int64
+	// 上次读取的文件，用来判断文件是否被替换
+	info os.FileInfo
+}
+
+// NewFollower 从 offset 处开始读取日志文件
+func NewFollower(filename string, offset int64) *Follower {
+	return &Follower{filename: filename, offset: offset}
+}
+
+// Offset 下一次读取的位置
+func (f *Follower) Offset() int64 {
+	return f.offset
+}
+
+// Read 读取上次读取之后完整的行，返回 ErrLogReset 时读取的位置已经回到文件开头
+func (f *Follower) Read() ([]Line, error) {
+	file, err := os.Open(f.filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	replaced := f.info != nil && !os.SameFile(f.info, info)
+	f.info = info
+	if replaced || info.Size() < f.offset {
+		f.offset = 0
+		return nil, ErrLogReset
+	}
+	lines, err := readLines(file, f.offset)
+	if len(lines) > 0 {
+		f.offset = lines[len(lines)-1].Next
+	}
+	return lines, err
+}
+
+func readLines(f *os.File, offset int64) ([]Line, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var lines []Line
+	reader := bufio.NewReader(f)
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return lines, err
+		}
+		offset += int64(len(data))
+		var entry Entry
+		if json.Unmarshal(data, &entry) != nil || entry.Stream == "" {
+			entry = Entry{Stream: StreamSystem, Text: trimNewline(string(data))}
+		}
+		lines = append(lines, Line{Entry: entry, Next: offset})
+	}
+	return lines, nil
+}
+
+func trimNewline(s string) string {
+	if len(s) > 0 && s[len(s)-1] == '\n' {
+		s = s[:len(s)-1]
+	}
+	if len(s) > 0 && s[len(s)-1] == '\r' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
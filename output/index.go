@@ -0,0 +1,228 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// Index json 格式日志的索引，记录每个 stage 和 step 在日志文件中的位置
+// 读取某个 stage 或 step 的日志时直接读取对应的位置，不需要解析整个文件
+type Index struct {
+	// 建立索引时日志文件的大小，和文件大小不一致时重新建立索引
+	Size   int64        `json:"size"`
+	Stages []IndexRange `json:"stages"`
+}
+
+// IndexRange stage 或 step 的日志在文件中的位置 [Offset, End)
+type IndexRange struct {
+	Name   string       `json:"name"`
+	Offset int64        `json:"offset"`
+	End    int64        `json:"end"`
+	Steps  []IndexRange `json:"steps,omitempty"`
+}
+
+// IndexFilename 日志文件的索引文件，和日志文件在同一个目录
+func IndexFilename(filename string) string {
+	return filename + ".idx"
+}
+
+// indexer 按照写入的顺序建立索引，同一个 stage 或 step 连续的行合并为一段
+type indexer struct {
+	index Index
+}
+
+func (x *indexer) add(entry Entry, offset, end int64) {
+	x.index.Size = end
+	if entry.Stage == "" {
+		return
+	}
+	n := len(x.index.Stages)
+	if n == 0 || x.index.Stages[n-1].Name != entry.Stage {
+		x.index.Stages = append(x.index.Stages, IndexRange{Name: entry.Stage, Offset: offset})
+		n++
+	}
+	stage := &x.index.Stages[n-1]
+	stage.End = end
+	if entry.Step == "" {
+		return
+	}
+	m := len(stage.Steps)
+	if m == 0 || stage.Steps[m-1].Name != entry.Step {
+		stage.Steps = append(stage.Steps, IndexRange{Name: entry.Step, Offset: offset})
+		m++
+	}
+	stage.Steps[m-1].End = end
+}
+
+// saveIndex 先写入临时文件再改名，读取时不会读到不完整的索引
+func saveIndex(filename string, index Index) error {
+	if filename == "" {
+		return nil
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	tmp := IndexFilename(filename) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, IndexFilename(filename))
+}
+
+// LoadIndex 读取日志文件的索引，索引不存在或者已经过期时重新建立
+// 旧的文本格式的日志没有索引，返回 nil
+func LoadIndex(filename string) (*Index, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := os.ReadFile(IndexFilename(filename)); err == nil {
+		var index Index
+		if json.Unmarshal(data, &index) == nil && index.Size == info.Size() {
+			return &index, nil
+		}
+	}
+	return buildIndex(filename)
+}
+
+// buildIndex 扫描日志文件建立索引，最后一行不完整时不包含在索引中
+func buildIndex(filename string) (*Index, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var x indexer
+	var offset int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entry Entry
+		if json.Unmarshal(line, &entry) != nil || entry.Stream == "" {
+			if offset == 0 {
+				// 旧的文本格式
+				return nil, nil
+			}
+			offset += int64(len(line))
+			x.index.Size = offset
+			continue
+		}
+		x.add(entry, offset, offset+int64(len(line)))
+		offset += int64(len(line))
+	}
+	if info, err := f.Stat(); err == nil && info.Size() == x.index.Size {
+		_ = saveIndex(filename, x.index)
+	}
+	return &x.index, nil
+}
+
+// readRanges 读取日志文件中的几段内容
+func readRanges(filename string, ranges []IndexRange) ([]Entry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []Entry
+	for _, r := range ranges {
+		data := make([]byte, r.End-r.Offset)
+		if _, err := f.ReadAt(data, r.Offset); err != nil {
+			return nil, err
+		}
+		part, err := decodeEntriesBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, part...)
+	}
+	return entries, nil
+}
+
+// ReadStageLog 读取一个 stage 的日志，stage 不存在时返回 nil
+// json 格式的日志根据索引只读取 stage 所在的位置，旧的文本格式解析整个文件
+func ReadStageLog(filename, stageName string) (*Stage, error) {
+	index, err := LoadIndex(filename)
+	if err != nil {
+		return nil, err
+	}
+	if index == nil {
+		log, err := ParseLogFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		for i := range log.Stages {
+			if log.Stages[i].Name == stageName {
+				return &log.Stages[i], nil
+			}
+		}
+		return nil, nil
+	}
+
+	var ranges []IndexRange
+	for _, stage := range index.Stages {
+		if stage.Name == stageName {
+			ranges = append(ranges, stage)
+		}
+	}
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+	entries, err := readRanges(filename, ranges)
+	if err != nil {
+		return nil, err
+	}
+	stages := parseEntries(entries).Stages
+	if len(stages) == 0 {
+		return nil, nil
+	}
+	return &stages[0], nil
+}
+
+// ReadStepLog 读取一个 step 的日志，step 不存在时返回 nil
+func ReadStepLog(filename, stageName, stepName string) (*Step, error) {
+	index, err := LoadIndex(filename)
+	if err != nil {
+		return nil, err
+	}
+	var steps []*Step
+	if index == nil {
+		stage, err := ReadStageLog(filename, stageName)
+		if err != nil || stage == nil {
+			return nil, err
+		}
+		steps = ParseStageSteps(stage)
+	} else {
+		var ranges []IndexRange
+		for _, stage := range index.Stages {
+			if stage.Name != stageName {
+				continue
+			}
+			for _, step := range stage.Steps {
+				if step.Name == stepName {
+					ranges = append(ranges, step)
+				}
+			}
+		}
+		entries, err := readRanges(filename, ranges)
+		if err != nil {
+			return nil, err
+		}
+		steps = entrySteps(entries)
+	}
+	for _, step := range steps {
+		if step.Name == stepName {
+			return step, nil
+		}
+	}
+	return nil, nil
+}
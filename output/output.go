@@ -2,11 +2,14 @@ package output
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hamster-shared/aline-engine/consts"
@@ -14,17 +17,60 @@ import (
 	"github.com/hamster-shared/aline-engine/utils"
 )
 
+// 内存中只保留最近写入的内容，其余的内容持续写入文件，一次执行的日志再多也不会占用太多内存
+const (
+	// DefaultMaxLogSize 默认每次执行最多保存的 stdout 和 stderr 的大小
+	DefaultMaxLogSize int64 = 100 << 20
+	// retainLines 写入文件之后内存中保留的行数，用于 Tail
+	retainLines = 1000
+	// maxPendingLines 没有写入文件的行超过这个数量时立即写入
+	maxPendingLines = 4096
+	flushInterval   = 500 * time.Millisecond
+)
+
+var maxLogSize = DefaultMaxLogSize
+
+// SetMaxLogSize 设置每次执行最多保存的 stdout 和 stderr 的大小，超过后的内容会被丢弃并在日志中标记
+// 小于等于 0 表示不限制，只对之后创建的 Output 生效
+func SetMaxLogSize(size int64) {
+	atomic.StoreInt64(&maxLogSize, size)
+}
+
+// MaxLogSize 每次执行最多保存的 stdout 和 stderr 的大小
+func MaxLogSize() int64 {
+	return atomic.LoadInt64(&maxLogSize)
+}
+
+// Output 一次执行的日志，可以在多个协程中同时写入和读取
 type Output struct {
-	Name               string
-	ID                 int
-	buffer             []string
-	f                  *os.File
-	mu                 sync.Mutex
+	Name string
+	ID   int
+	mu   sync.Mutex
+	// 最近写入的行，buffer[0] 是第 base 行，fileCursor 之前的行已经写入文件
+	buffer     []Entry
+	base       int
+	total      int
+	fileCursor int
+	f          *os.File
+	// 日志文件是否可以读取，文件创建失败时只能从内存中读取最近的内容
+	persisted          bool
 	filename           string
-	fileCursor         int
-	bufferCursor       int
 	stageTimeConsuming map[string]TimeConsuming
-	timeConsuming      TimeConsuming
+	// step 的耗时和状态，key 为 stage/step
+	stepTimes     map[string]StepTime
+	timeConsuming TimeConsuming
+	// 当前的 stage 和 step，写入的每一行都会记录
+	stage, step string
+	// 已经写入文件的字节数和 step 的索引
+	written int64
+	indexer indexer
+	// limit 为 0 时不限制日志大小，size 为已经保存的 stdout 和 stderr 的大小
+	limit, size int64
+	truncated   bool
+	// 上次标记之后丢弃的行数和大小
+	dropped      int
+	droppedBytes int64
+	stop         chan struct{}
 }
 
 type Log struct {
@@ -41,12 +87,19 @@ type Stage struct {
 	Duration  time.Duration
 	Name      string
 	Lines     []string
+	// json 格式的日志中 stage 的每一行，旧的文本格式为空
+	entries []Entry
 }
 
 type Step struct {
 	Name    string `json:"name"`
 	lines   []string
 	Content string `json:"content"`
+	// step 的耗时和最终状态，没有记录时为零值，例如之前版本的日志
+	StartTime time.Time     `json:"startTime"`
+	EndTime   time.Time     `json:"endTime"`
+	Duration  time.Duration `json:"duration"`
+	Status    string        `json:"status"`
 }
 
 func (s *Step) fillContent() {
@@ -60,17 +113,28 @@ type TimeConsuming struct {
 	Duration  time.Duration
 }
 
+// StepTime step 的耗时和最终状态
+type StepTime struct {
+	TimeConsuming
+	Status string
+}
+
 // New 新建一个 Output 对象，会自动初始化文件，以及定时将内容写入文件
 func New(name string, id int) *Output {
 	o := &Output{
 		Name:   name,
 		ID:     id,
-		buffer: make([]string, 0, 16),
+		buffer: make([]Entry, 0, 16),
 		timeConsuming: TimeConsuming{
 			StartTime: time.Now().UTC(),
 		},
 		stageTimeConsuming: make(map[string]TimeConsuming),
-		// stepTimeConsuming:  make(map[string]TimeConsuming),
+		stepTimes:          make(map[string]StepTime),
+		limit:              MaxLogSize(),
+		stop:               make(chan struct{}),
+	}
+	if o.limit < 0 {
+		o.limit = 0
 	}
 
 	err := o.initFile()
@@ -88,6 +152,8 @@ func New(name string, id int) *Output {
 
 // Duration 返回持续时间
 func (o *Output) Duration() time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	if o.timeConsuming.Done {
 		return o.timeConsuming.Duration
 	}
@@ -96,11 +162,15 @@ func (o *Output) Duration() time.Duration {
 
 // TimeConsuming 返回耗时信息
 func (o *Output) TimeConsuming() TimeConsuming {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	return o.timeConsuming
 }
 
 // StageDuration 返回某个 Stage 的持续时间
 func (o *Output) StageDuration(name string) time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	stageTimeConsuming, ok := o.stageTimeConsuming[name]
 	if !ok {
 		return 0
@@ -117,45 +187,94 @@ func (o *Output) StageDuration(name string) time.Duration {
 // Done 标记输出已完成，会将缓存中的内容刷入文件，然后关闭文件
 func (o *Output) Done() {
 	logger.Trace("output done, flush all, close file")
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.timeConsuming.Done {
+		return
+	}
 	now := time.Now().UTC()
 
 	// 将之前的 Stage 标记为完成
-	for k, v := range o.stageTimeConsuming {
-		if !v.Done {
-			v.EndTime = now
-			v.Duration = v.EndTime.Sub(v.StartTime)
-			v.Done = true
-			o.stageTimeConsuming[k] = v
-			o.WriteLineWithNoTime(fmt.Sprintf("[TimeConsuming] EndTime: %s, Duration: %s", v.EndTime.Format(time.RFC3339), v.Duration))
-		}
-	}
+	o.endStages(now)
+	o.stage, o.step = "", ""
 
-	o.mu.Lock()
 	o.timeConsuming.Done = true
 	o.timeConsuming.EndTime = now
 	o.timeConsuming.Duration = now.Sub(o.timeConsuming.StartTime)
-	o.flush(o.buffer[o.fileCursor:])
-	o.flush([]string{fmt.Sprintf("\n[Job] Finished on %s, Duration: %s\n\n", now.Format(time.RFC3339), o.timeConsuming.Duration)})
-	o.f.Close()
-	o.mu.Unlock()
+	o.write(StreamSystem, fmt.Sprintf("[Job] Finished on %s, Duration: %s", now.Format(time.RFC3339), o.timeConsuming.Duration))
+	if err := o.flush(); err != nil {
+		logger.Error(err)
+	}
+	if o.f != nil {
+		o.f.Close()
+		o.f = nil
+	}
+	if o.stop != nil {
+		close(o.stop)
+	}
 }
 
 // WriteLine 将一行普通内容写入输出
 func (o *Output) WriteLine(line string) {
-	timeFormat := fmt.Sprintf("[%s] ", time.Now().UTC().Format(time.RFC3339))
-	// 如果不是以换行符结尾，自动添加
-	if !strings.HasSuffix(line, "\n") {
-		line += "\n"
-	}
-	o.buffer = append(o.buffer, timeFormat+line)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.write(StreamStdout, line)
 }
 
+// WriteErrLine 将一行标准错误的内容写入输出
+func (o *Output) WriteErrLine(line string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.write(StreamStderr, line)
+}
+
+// WriteLineWithNoTime 写入一行 engine 自己的内容，显示时前面不加时间
 func (o *Output) WriteLineWithNoTime(line string) {
-	// 如果不是以换行符结尾，自动添加
-	if !strings.HasSuffix(line, "\n") {
-		line += "\n"
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.write(StreamSystem, line)
+}
+
+// write 追加一行，包含换行符时拆成多行，调用时需要持有锁
+// stdout 和 stderr 的内容超过大小限制后丢弃，engine 自己写入的内容总是保留，日志的结构不受影响
+func (o *Output) write(stream, line string) {
+	now := time.Now().UTC()
+	for _, text := range strings.Split(strings.TrimSuffix(line, "\n"), "\n") {
+		if stream != StreamSystem && o.limit > 0 {
+			if o.size+int64(len(text)) > o.limit {
+				if !o.truncated {
+					o.truncated = true
+					o.appendEntry(Entry{Time: now, Stage: o.stage, Step: o.step, Stream: StreamSystem,
+						Text: fmt.Sprintf("[Truncated] log exceeds the limit of %d bytes, further output is dropped", o.limit)})
+				}
+				o.dropped++
+				o.droppedBytes += int64(len(text))
+				continue
+			}
+			o.size += int64(len(text))
+		}
+		o.appendEntry(Entry{Time: now, Stage: o.stage, Step: o.step, Stream: stream, Text: text})
+	}
+}
+
+func (o *Output) appendEntry(entry Entry) {
+	o.buffer = append(o.buffer, entry)
+	o.total++
+	if o.total-o.fileCursor >= maxPendingLines {
+		if err := o.flush(); err != nil {
+			logger.Error(err)
+		}
 	}
-	o.buffer = append(o.buffer, line)
+}
+
+// markDropped 在 step、stage 结束时记录丢弃了多少内容
+func (o *Output) markDropped() {
+	if o.dropped == 0 {
+		return
+	}
+	o.appendEntry(Entry{Time: time.Now().UTC(), Stage: o.stage, Step: o.step, Stream: StreamSystem,
+		Text: fmt.Sprintf("[Truncated] %d lines (%d bytes) dropped", o.dropped, o.droppedBytes)})
+	o.dropped, o.droppedBytes = 0, 0
 }
 
 // WriteCommandLine 将一行命令行内容写入输出，其实就是在前面加上了一个 "> "
@@ -163,92 +282,178 @@ func (o *Output) WriteCommandLine(line string) {
 	o.WriteLine("> " + line)
 }
 
-// Content 总是返回从起始到现在的所有内容
-func (o *Output) Content() string {
-	o.bufferCursor = len(o.buffer)
-	return strings.Join(o.buffer[:o.bufferCursor], "")
+// Len 返回当前已经写入的条数，配合 Tail 获取某一段输出
+func (o *Output) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.total
 }
 
-// NewContent 总是返回自上次读取后新出现的内容
-func (o *Output) NewContent() string {
-	if o.bufferCursor >= len(o.buffer) {
-		return ""
+// Tail 返回第 from 条之后写入的内容中的最后 n 行，不包含时间
+// 内存中只保留最近的内容，from 之后的内容太多时只返回还在内存中的部分
+func (o *Output) Tail(from, n int) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if from < 0 || from > o.total {
+		from = 0
 	}
-	endIndex := len(o.buffer)
-	result := strings.Join(o.buffer[o.bufferCursor:endIndex], "")
-	o.bufferCursor = endIndex
-	return result
+	if from < o.base {
+		from = o.base
+	}
+	entries := o.buffer[from-o.base:]
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, entry.Text)
+	}
+	return lines
 }
 
 // NewStage 会写入以 [Pipeline] Stage: 开头的一行，表示一个新的 Stage 开始
 func (o *Output) NewStage(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	// 将之前的 Stage 标记为完成
+	o.endStages(time.Now().UTC())
+
+	o.stage, o.step = name, ""
+	o.write(StreamSystem, "[Pipeline] Stage: "+name)
+
+	startTime := time.Now().UTC()
+	o.write(StreamSystem, "[TimeConsuming] StartTime: "+startTime.Format(time.RFC3339))
+	o.stageTimeConsuming[name] = TimeConsuming{
+		StartTime: startTime,
+	}
+}
+
+// endStages 将没有完成的 Stage 标记为完成，调用时需要持有锁
+func (o *Output) endStages(now time.Time) {
+	o.endStep(now, "")
+	o.markDropped()
 	for k, v := range o.stageTimeConsuming {
 		if !v.Done {
-			v.EndTime = time.Now().UTC()
+			v.EndTime = now
 			v.Duration = v.EndTime.Sub(v.StartTime)
 			v.Done = true
 			o.stageTimeConsuming[k] = v
-			o.WriteLineWithNoTime(fmt.Sprintf("[TimeConsuming] EndTime: %s, Duration: %s", v.EndTime.Format(time.RFC3339), v.Duration))
-			// o.WriteLineWithNoTime("} ")
+			o.stage, o.step = k, ""
+			o.write(StreamSystem, fmt.Sprintf("[TimeConsuming] EndTime: %s, Duration: %s", v.EndTime.Format(time.RFC3339), v.Duration))
 		}
 	}
+}
+
+// NewStep 会写入以 [Pipeline] Step: 开头的一行，表示一个新的 Step 开始，上一个 Step 没有结束时标记为结束
+func (o *Output) NewStep(name string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now().UTC()
+	o.endStep(now, "")
+	o.markDropped()
+	o.step = name
+	o.appendEntry(Entry{Time: now, Stage: o.stage, Step: name, Stream: StreamSystem, Text: "[Pipeline] Step: " + name})
+	o.appendEntry(Entry{Time: now, Stage: o.stage, Step: name, Stream: StreamSystem,
+		Text: "[TimeConsuming] StartTime: " + now.Format(time.RFC3339)})
+	if o.stepTimes == nil {
+		o.stepTimes = make(map[string]StepTime)
+	}
+	o.stepTimes[o.stage+"/"+name] = StepTime{TimeConsuming: TimeConsuming{StartTime: now}}
+}
 
-	o.WriteLineWithNoTime("\n")
-	o.WriteLineWithNoTime("[Pipeline] Stage: " + name)
-	// o.WriteLineWithNoTime("{ ")
+// EndStep 标记当前的 Step 结束，记录耗时和最终状态，例如 success、fail、cancelled
+// 之后写入的内容不再属于这个 Step
+func (o *Output) EndStep(status string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.endStep(time.Now().UTC(), status)
+}
 
-	startTime := time.Now().UTC()
-	o.WriteLineWithNoTime("[TimeConsuming] StartTime: " + startTime.Format(time.RFC3339))
-	o.stageTimeConsuming[name] = TimeConsuming{
-		StartTime: startTime,
+// endStep 调用时需要持有锁，没有正在执行的 Step 时什么都不做
+func (o *Output) endStep(now time.Time, status string) {
+	if o.step == "" {
+		return
+	}
+	o.markDropped()
+	key := o.stage + "/" + o.step
+	stepTime, ok := o.stepTimes[key]
+	if ok && !stepTime.Done {
+		stepTime.Done = true
+		stepTime.EndTime = now
+		stepTime.Duration = now.Sub(stepTime.StartTime)
+		stepTime.Status = status
+		o.stepTimes[key] = stepTime
+		line := fmt.Sprintf("[TimeConsuming] EndTime: %s, Duration: %s", now.Format(time.RFC3339), stepTime.Duration)
+		if status != "" {
+			line += ", Status: " + status
+		}
+		o.appendEntry(Entry{Time: now, Stage: o.stage, Step: o.step, Stream: StreamSystem, Text: line})
 	}
+	o.step = ""
 }
 
-// NewStep 会写入以 [Pipeline] Step: 开头的一行，表示一个新的 Step 开始
-func (o *Output) NewStep(name string) {
-	o.WriteLineWithNoTime("[Pipeline] Step: " + name)
+// StepTime 返回某个 Step 的耗时和最终状态，Step 还没有开始时返回 false
+func (o *Output) StepTime(stage, step string) (StepTime, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	stepTime, ok := o.stepTimes[stage+"/"+step]
+	return stepTime, ok
 }
 
 // 在一个协程中定时刷入文件
 func (o *Output) timedWriteFile() {
-	endIndex := 0
-	go func(endIndex int) {
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
 		for {
+			select {
+			case <-o.stop:
+				return
+			case <-ticker.C:
+			}
 			o.mu.Lock()
-			if o.timeConsuming.Done {
-				o.mu.Unlock()
-				break
+			if err := o.flush(); err != nil {
+				logger.Error(err)
 			}
 			o.mu.Unlock()
+		}
+	}()
+}
 
-			if len(o.buffer) <= endIndex {
-				time.Sleep(500 * time.Millisecond)
-				continue
-			}
-
-			endIndex = len(o.buffer)
-			err := o.flush(o.buffer[o.fileCursor:endIndex])
+// flush 把没有写入文件的行写入文件，每行一个 json，同时更新 step 的索引，调用时需要持有锁
+// 写入之后内存中只保留最近的 retainLines 行
+func (o *Output) flush() error {
+	pending := o.buffer[o.fileCursor-o.base:]
+	if o.f != nil && len(pending) > 0 {
+		var buf bytes.Buffer
+		for _, entry := range pending {
+			data, err := json.Marshal(entry)
 			if err != nil {
-				logger.Error(err)
+				return err
 			}
-			o.fileCursor = endIndex
-			time.Sleep(500 * time.Millisecond)
+			offset := o.written + int64(buf.Len())
+			buf.Write(data)
+			buf.WriteByte('\n')
+			o.indexer.add(entry, offset, o.written+int64(buf.Len()))
 		}
-	}(endIndex)
-}
-
-// 刷入文件
-func (o *Output) flush(arr []string) error {
-	if o.f == nil {
-		return nil
-	}
-	for _, line := range arr {
-		if _, err := o.f.WriteString(line); err != nil {
-			logger.Error(err)
+		n, err := o.f.Write(buf.Bytes())
+		o.written += int64(n)
+		if err == nil {
+			err = saveIndex(o.filename, o.indexer.index)
+		}
+		if err != nil {
+			// 写入失败的内容不再重试，否则内存中的内容会一直增长
+			o.fileCursor = o.total
 			return err
 		}
 	}
+	o.fileCursor = o.total
+
+	if cut := o.total - retainLines; cut > o.base {
+		// 复制到新的数组，释放之前的内容
+		o.buffer = append(make([]Entry, 0, retainLines), o.buffer[cut-o.base:]...)
+		o.base = cut
+	}
 	return nil
 }
 
@@ -270,13 +475,14 @@ func (o *Output) initFile() error {
 		return err
 	}
 
-	f, err := os.OpenFile(o.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	f, err := os.OpenFile(o.filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		logger.Errorf("Failed to create output log file %s, err: %s\n", o.filename, err)
 		o.mu.Unlock()
 		return err
 	}
 	o.f = f
+	o.persisted = true
 	o.mu.Unlock()
 	logger.Tracef("Create output log file %s success\n", o.filename)
 	return nil
@@ -289,15 +495,91 @@ func (o *Output) Filename() string {
 
 // StageOutputList 返回存储了 Stage 输出的列表
 func (o *Output) StageOutputList() []Stage {
-	return parseLogLines(o.buffer[:]).Stages
+	o.mu.Lock()
+	if !o.persisted {
+		entries := append([]Entry(nil), o.buffer...)
+		o.mu.Unlock()
+		return parseEntries(entries).Stages
+	}
+	if err := o.flush(); err != nil {
+		logger.Error(err)
+	}
+	o.mu.Unlock()
+	log, err := ParseLogFile(o.filename)
+	if err != nil {
+		logger.Errorf("parse log file failed, %v", err)
+	}
+	return log.Stages
 }
 
-// ParseLogFile 解析日志文件，返回 Log 对象
+// Reader 按顺序读取 Output 写入的内容，每个 Reader 记录自己读到的位置，多个 Reader 互不影响
+type Reader struct {
+	o *Output
+	// 下一行在内存中的序号和在日志文件中的位置
+	next   int
+	offset int64
+}
+
+// NewReader 从头开始读取的 Reader
+func (o *Output) NewReader() *Reader {
+	return &Reader{o: o}
+}
+
+// Read 返回上次读取之后写入的行
+// 内容先写入日志文件再从文件中读取；日志文件创建失败时只能读到还在内存中的内容
+func (r *Reader) Read() ([]Entry, error) {
+	o := r.o
+	o.mu.Lock()
+	if !o.persisted {
+		from := r.next
+		if from < o.base {
+			from = o.base
+		}
+		entries := append([]Entry(nil), o.buffer[from-o.base:]...)
+		r.next = o.total
+		o.mu.Unlock()
+		return entries, nil
+	}
+	err := o.flush()
+	o.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := ReadFrom(o.filename, r.offset)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(lines))
+	for _, line := range lines {
+		entries = append(entries, line.Entry)
+		r.offset = line.Next
+	}
+	return entries, nil
+}
+
+// ReadString 返回上次读取之后写入的内容，显示为文本格式
+func (r *Reader) ReadString() (string, error) {
+	entries, err := r.Read()
+	if err != nil {
+		return "", err
+	}
+	return renderEntries(entries), nil
+}
+
+// ParseLogFile 解析日志文件，返回 Log 对象，支持 json 格式和旧的文本格式
 func ParseLogFile(filename string) (Log, error) {
 	lines, err := ReadFileLines(filename)
 	if err != nil {
 		return Log{}, err
 	}
+	if len(lines) > 0 && isEntryLine(lines[0]) {
+		entries, err := decodeEntries(lines)
+		if err != nil {
+			return Log{}, fmt.Errorf("parse log file %s: %w", filename, err)
+		}
+		return parseEntries(entries), nil
+	}
 	result := parseLogLines(lines)
 	return result, nil
 }
@@ -310,6 +592,7 @@ func ReadFileLines(filename string) ([]string, error) {
 	}
 	defer f.Close()
 	fileScanner := bufio.NewScanner(f)
+	fileScanner.Buffer(make([]byte, 64*1024), maxLineSize)
 	fileScanner.Split(bufio.ScanLines)
 	var lines []string
 	for fileScanner.Scan() {
@@ -329,23 +612,7 @@ func parseLogLines(lines []string) Log {
 	var stageOutputMap = make(map[string][]string)
 	for _, line := range lines {
 		if strings.HasPrefix(line, "[Job]") || line == "\n" || line == "" {
-			if strings.HasPrefix(line, "[Job] Started on ") {
-				startTime := strings.TrimPrefix(line, "[Job] Started on ")
-				log.StartTime, _ = time.Parse(time.RFC3339, startTime)
-			}
-			if strings.HasPrefix(line, "[Job] Finished on ") {
-				endTimeAndDuration := strings.TrimPrefix(line, "[Job] Finished on ")
-				endTimeAndDurationSlice := strings.Split(endTimeAndDuration, ",")
-				endTime := endTimeAndDurationSlice[0]
-				log.EndTime, _ = time.Parse(time.RFC3339, endTime)
-
-				if len(endTimeAndDurationSlice) > 1 {
-					duration := endTimeAndDurationSlice[1]
-					duration = strings.TrimPrefix(duration, " Duration: ")
-					log.Duration, _ = time.ParseDuration(duration)
-				}
-			}
-
+			parseJobTime(&log, line)
 			continue
 		}
 		if strings.HasPrefix(line, "[Pipeline] Stage: ") {
@@ -359,32 +626,12 @@ func parseLogLines(lines []string) Log {
 	for k, v := range stageOutputMap {
 		for i := range stageNameList {
 			if stageNameList[i] == k {
-				var startTime, endTime time.Time
-				var duration time.Duration
-				for _, line := range v {
-					if strings.HasPrefix(line, "[TimeConsuming] StartTime: ") {
-						startTimeString := strings.TrimPrefix(line, "[TimeConsuming] StartTime: ")
-						startTime, _ = time.Parse(time.RFC3339, startTimeString)
-					}
-					if strings.HasPrefix(line, "[TimeConsuming] EndTime: ") {
-						endTimeString := strings.TrimPrefix(line, "[TimeConsuming] EndTime: ")
-						endTimeAndDurationSlice := strings.Split(endTimeString, ",")
-						endTime, _ = time.Parse(time.RFC3339, endTimeAndDurationSlice[0])
-
-						if len(endTimeAndDurationSlice) > 1 {
-							durationString := endTimeAndDurationSlice[1]
-							durationString = strings.TrimPrefix(durationString, " Duration: ")
-							duration, _ = time.ParseDuration(durationString)
-						}
-					}
-				}
-
 				stageOutput := Stage{
-					Name:      k,
-					Lines:     v,
-					StartTime: startTime,
-					EndTime:   endTime,
-					Duration:  duration,
+					Name:  k,
+					Lines: v,
+				}
+				for _, line := range v {
+					parseStageTime(&stageOutput, line)
 				}
 				log.Stages = append(log.Stages, stageOutput)
 			}
@@ -396,6 +643,9 @@ func parseLogLines(lines []string) Log {
 
 // ParseStageSteps 解析一个 stage 中的 step
 func ParseStageSteps(stage *Stage) []*Step {
+	if stage.entries != nil {
+		return entrySteps(stage.entries)
+	}
 	var stepNameList []string
 	var stepMap = make(map[string]*Step)
 	for _, s := range stage.Lines {
@@ -1,14 +1,22 @@
 package output
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/hamster-shared/aline-engine/logger"
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestNew(t *testing.T) {
@@ -42,33 +50,117 @@ func TestParseLogFile(t *testing.T) {
 	spew.Dump(result)
 }
 
-func TestContent(t *testing.T) {
-	logger.Init().ToStdoutAndFile().SetLevel(logrus.TraceLevel)
+func TestReader(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
 	testOutput := New("test", 10085)
+	first := testOutput.NewReader()
 
 	testOutput.NewStage("第一阶段")
 	testOutput.WriteLine("第一行")
-	testOutput.WriteLine("第二行")
-	testOutput.WriteLine("第三行")
-	testOutput.WriteLine("第四行")
-	testOutput.WriteLine("第五行")
+	testOutput.WriteErrLine("第二行")
 
-	fmt.Println("读取所有内容：", testOutput.Content())
+	content, err := first.ReadString()
+	assert.NoError(t, err)
+	assert.Contains(t, content, "[Pipeline] Stage: 第一阶段\n")
+	assert.Contains(t, content, "] 第二行\n")
 
 	testOutput.NewStage("第二阶段")
-	testOutput.WriteLine("第一行")
-	testOutput.WriteLine("第二行")
 	testOutput.WriteLine("第三行")
-	testOutput.WriteLine("第四行")
-	testOutput.WriteLine("第五行")
 
-	fmt.Println("读取新出现的内容：", testOutput.NewContent())
+	// 每个 Reader 有自己的位置
+	second := testOutput.NewReader()
+	entries, err := first.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "第三行", entries[len(entries)-1].Text)
+	assert.Equal(t, StreamSystem, entries[0].Stream)
+	entries, err = second.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "[Job] Started on "+testOutput.TimeConsuming().StartTime.Format(time.RFC3339), entries[0].Text)
 
-	if len(testOutput.NewContent()) != 0 {
-		t.Error("new content length error")
-	}
+	content, err = first.ReadString()
+	assert.NoError(t, err)
+	assert.Empty(t, content)
 
 	testOutput.Done()
+	entries, err = first.Read()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(entries[len(entries)-1].Text, "[Job] Finished on "))
+}
+
+func TestConcurrentWrite(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	o := New("concurrent", 1)
+	o.NewStage("build")
+	reader := o.NewReader()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 2000; j++ {
+				o.WriteLine(fmt.Sprintf("%d-%d", i, j))
+				if j%100 == 0 {
+					_ = o.Tail(0, 10)
+				}
+			}
+		}(i)
+	}
+	read := 0
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		entries, err := reader.Read()
+		assert.NoError(t, err)
+		read += len(entries)
+	}
+	o.Done()
+	entries, err := reader.Read()
+	assert.NoError(t, err)
+	read += len(entries)
+
+	// job 开始、stage 开始、stage 耗时 2 行、job 结束
+	assert.Equal(t, 8000+5, read)
+	assert.Equal(t, 8000+5, o.Len())
+	o.mu.Lock()
+	assert.LessOrEqual(t, len(o.buffer), retainLines)
+	o.mu.Unlock()
+}
+
+func TestMaxLogSize(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	SetMaxLogSize(10)
+	defer SetMaxLogSize(DefaultMaxLogSize)
+
+	o := New("limit", 1)
+	o.NewStage("build")
+	o.NewStep("echo")
+	o.WriteLine("12345")
+	o.WriteLine("67890")
+	o.WriteLine("dropped")
+	o.WriteErrLine("dropped too")
+	o.NewStep("next")
+	o.WriteLine("also dropped")
+	o.Done()
+
+	step, err := ReadStepLog(o.Filename(), "build", "echo")
+	assert.NoError(t, err)
+	assert.Len(t, step.lines, 4)
+	assert.Equal(t, "[Truncated] log exceeds the limit of 10 bytes, further output is dropped", step.lines[2])
+	assert.Equal(t, "[Truncated] 2 lines (18 bytes) dropped", step.lines[3])
+	step, err = ReadStepLog(o.Filename(), "build", "next")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"[Truncated] 1 lines (12 bytes) dropped"}, step.lines)
 }
 
 func TestStageOutputList(t *testing.T) {
@@ -233,3 +325,290 @@ func Test_parseStageSteps(t *testing.T) {
 	spew.Dump(steps)
 
 }
+
+func TestTail(t *testing.T) {
+	o := &Output{}
+	o.WriteLine("before")
+	mark := o.Len()
+	o.NewStep("build")
+	o.WriteLine("first")
+	o.WriteLine("second\nthird")
+	o.WriteLine("[not a time] fourth")
+
+	tail := o.Tail(mark, 3)
+	if strings.Join(tail, "|") != "second|third|[not a time] fourth" {
+		t.Errorf("unexpected tail: %v", tail)
+	}
+	tail = o.Tail(mark, 10)
+	if len(tail) != 6 || tail[0] != "[Pipeline] Step: build" {
+		t.Errorf("unexpected tail: %v", tail)
+	}
+}
+
+func TestStructuredLog(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	o := New("structured", 1)
+	o.NewStage("build")
+	o.NewStep("compile")
+	o.WriteLine("[Pipeline] Stage: fake")
+	o.WriteErrLine("[Pipeline] Step: fake")
+	o.NewStep("test")
+	o.WriteLine("ok\n[TimeConsuming] EndTime: fake")
+	o.NewStage("deploy")
+	o.NewStep("upload")
+	o.WriteLine("uploaded")
+	o.Done()
+
+	lines, err := ReadFileLines(o.Filename())
+	assert.NoError(t, err)
+	var entry Entry
+	assert.NoError(t, json.Unmarshal([]byte(lines[5]), &entry))
+	assert.Equal(t, Entry{Time: entry.Time, Stage: "build", Step: "compile", Stream: StreamStdout, Text: "[Pipeline] Stage: fake"}, entry)
+
+	log, err := ParseLogFile(o.Filename())
+	assert.NoError(t, err)
+	assert.Len(t, log.Stages, 2)
+	assert.False(t, log.StartTime.IsZero())
+	assert.False(t, log.EndTime.IsZero())
+
+	stage, err := ReadStageLog(o.Filename(), "build")
+	assert.NoError(t, err)
+	assert.Equal(t, "build", stage.Name)
+	assert.Equal(t, "[Pipeline] Stage: build", stage.Lines[0])
+	assert.False(t, stage.EndTime.IsZero())
+	steps := ParseStageSteps(stage)
+	assert.Len(t, steps, 2)
+	assert.Len(t, steps[0].lines, 2)
+
+	step, err := ReadStepLog(o.Filename(), "build", "test")
+	assert.NoError(t, err)
+	assert.Len(t, step.lines, 2)
+	assert.True(t, strings.HasSuffix(step.lines[1], "] [TimeConsuming] EndTime: fake"))
+
+	step, err = ReadStepLog(o.Filename(), "deploy", "compile")
+	assert.NoError(t, err)
+	assert.Nil(t, step)
+	stage, err = ReadStageLog(o.Filename(), "missing")
+	assert.NoError(t, err)
+	assert.Nil(t, stage)
+}
+
+func TestLogIndex(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	o := New("index", 1)
+	o.NewStage("build")
+	o.NewStep("compile")
+	o.WriteLine("compiled")
+	o.Done()
+
+	index, err := LoadIndex(o.Filename())
+	assert.NoError(t, err)
+	info, _ := os.Stat(o.Filename())
+	assert.Equal(t, info.Size(), index.Size)
+	assert.Equal(t, "compile", index.Stages[0].Steps[0].Name)
+
+	// 日志文件从 master 同步过来时索引过期，读取时重新建立
+	f, err := os.OpenFile(o.Filename(), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"ts":"2023-01-01T00:00:00Z","stage":"deploy","step":"upload","stream":"stdout","text":"uploaded"}` + "\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	step, err := ReadStepLog(o.Filename(), "deploy", "upload")
+	assert.NoError(t, err)
+	assert.Equal(t, "[2023-01-01T00:00:00Z] uploaded", step.Content)
+	data, err := os.ReadFile(IndexFilename(o.Filename()))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"name":"deploy"`)
+}
+
+func TestLegacyLog(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "1.log")
+	legacy := `[Job] Started on 2023-03-16T16:47:31Z
+
+
+[Pipeline] Stage: 第一阶段
+[TimeConsuming] StartTime: 2023-03-16T16:47:31Z
+[Pipeline] Step: 步骤 1
+[2023-03-16T16:47:31Z] 第一行
+[Pipeline] Step: 步骤 2
+[2023-03-16T16:47:31Z] 第二行
+[TimeConsuming] EndTime: 2023-03-16T16:47:32Z, Duration: 1s
+
+[Job] Finished on 2023-03-16T16:47:32Z, Duration: 1s
+`
+	assert.NoError(t, os.WriteFile(filename, []byte(legacy), 0644))
+
+	index, err := LoadIndex(filename)
+	assert.NoError(t, err)
+	assert.Nil(t, index)
+	stage, err := ReadStageLog(filename, "第一阶段")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, stage.Duration)
+	step, err := ReadStepLog(filename, "第一阶段", "步骤 2")
+	assert.NoError(t, err)
+	assert.Equal(t, "[2023-03-16T16:47:31Z] 第二行", step.Content)
+	log, err := ParseLogFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, log.Duration)
+}
+
+func TestStepTime(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	o := New("step-time", 1)
+	o.NewStage("build")
+	o.NewStep("compile")
+	o.WriteLine("compiled")
+	time.Sleep(10 * time.Millisecond)
+	o.EndStep("success")
+	o.WriteLine("after step")
+	o.NewStep("test")
+	o.WriteLine("testing")
+	o.NewStep("lint")
+	o.EndStep("fail")
+	o.Done()
+
+	stepTime, ok := o.StepTime("build", "compile")
+	assert.True(t, ok)
+	assert.Equal(t, "success", stepTime.Status)
+	assert.GreaterOrEqual(t, stepTime.Duration, 10*time.Millisecond)
+	_, ok = o.StepTime("build", "missing")
+	assert.False(t, ok)
+
+	step, err := ReadStepLog(o.Filename(), "build", "compile")
+	assert.NoError(t, err)
+	assert.Equal(t, "success", step.Status)
+	assert.Equal(t, stepTime.StartTime, step.StartTime)
+	assert.Equal(t, stepTime.EndTime, step.EndTime)
+	assert.Equal(t, stepTime.Duration, step.Duration)
+	assert.Len(t, step.lines, 1)
+
+	// 没有调用 EndStep 的 step 在下一个 step 开始时结束，没有状态
+	step, err = ReadStepLog(o.Filename(), "build", "test")
+	assert.NoError(t, err)
+	assert.Empty(t, step.Status)
+	assert.False(t, step.EndTime.IsZero())
+	step, err = ReadStepLog(o.Filename(), "build", "lint")
+	assert.NoError(t, err)
+	assert.Equal(t, "fail", step.Status)
+
+	stage, err := ReadStageLog(o.Filename(), "build")
+	assert.NoError(t, err)
+	assert.Contains(t, stage.Lines[len(stage.Lines)-1], "[TimeConsuming] EndTime: ")
+	assert.NotContains(t, stage.Lines[len(stage.Lines)-1], "Status")
+	assert.Equal(t, o.StageDuration("build"), stage.Duration)
+}
+
+func TestSearchFile(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	o := New("search", 1)
+	o.NewStage("build")
+	o.NewStep("compile")
+	o.WriteLine("line 1")
+	o.WriteLine("error: connection refused")
+	o.WriteLine("line 3")
+	o.NewStage("test")
+	o.NewStep("unit")
+	o.WriteLine("error: assertion failed")
+	o.Done()
+
+	contains := func(s string) func(string) bool {
+		return func(text string) bool { return strings.Contains(text, s) }
+	}
+	matches, err := SearchFile(o.Filename(), contains("error:"), SearchOptions{Context: 1})
+	assert.NoError(t, err)
+	assert.Len(t, matches, 2)
+	assert.Equal(t, "build", matches[0].Stage)
+	assert.Equal(t, "compile", matches[0].Step)
+	assert.Equal(t, []string{"line 1"}, matches[0].Before)
+	assert.Equal(t, []string{"line 3"}, matches[0].After)
+	log, err := ParseLogFile(o.Filename())
+	assert.NoError(t, err)
+	assert.Contains(t, log.Lines[matches[0].Line-1], "error: connection refused")
+
+	matches, err = SearchFile(o.Filename(), contains("error:"), SearchOptions{Stage: "test", Context: 5})
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, "unit", matches[0].Step)
+	for _, line := range matches[0].Before {
+		assert.NotContains(t, line, "line 3")
+	}
+
+	matches, err = SearchFile(o.Filename(), contains("error:"), SearchOptions{Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+
+	filename := filepath.Join(t.TempDir(), "1.log")
+	legacy := "[Job] Started on 2023-03-16T16:47:31Z\n[Pipeline] Stage: build\n[Pipeline] Step: compile\n[2023-03-16T16:47:31Z] error: disk full\n"
+	assert.NoError(t, os.WriteFile(filename, []byte(legacy), 0644))
+	matches, err = SearchFile(filename, contains("disk full"), SearchOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, 4, matches[0].Line)
+	assert.Equal(t, "build", matches[0].Stage)
+	assert.Equal(t, "compile", matches[0].Step)
+}
+
+func TestStripANSI(t *testing.T) {
+	assert.Equal(t, "error: 1 problem", StripANSI("\x1b[1m\x1b[31merror\x1b[0m: 1 problem"))
+	assert.Equal(t, "  Compiling foo", StripANSI("\x1b[0m\x1b[1m\x1b[32m  Compiling\x1b[0m foo\x1b[K"))
+	assert.Equal(t, "link", StripANSI("\x1b]8;;https://example.com\x1b\\link\x1b]8;;\x07"))
+	// 不是控制序列的内容保留
+	assert.Equal(t, "[1m] [Pipeline]", StripANSI("[1m] [Pipeline]"))
+	assert.Equal(t, `{"message":"warning"}`, string(StripANSIJSON([]byte(`{"message":"\u001b[33mwarning\u001b[39m"}`))))
+}
+
+func TestANSIToHTML(t *testing.T) {
+	assert.Equal(t, `<span class="ansi-bold ansi-fg-1">error</span>: a &lt; b`, ANSIToHTML("\x1b[1;31merror\x1b[0m: a < b"))
+	assert.Equal(t, `<span class="ansi-fg-10">ok</span>`, ANSIToHTML("\x1b[92mok\x1b[39m"))
+	assert.Equal(t, `<span style="color:#ff8000">x</span>`, ANSIToHTML("\x1b[38;2;255;128;0mx"))
+	assert.Equal(t, `<span style="color:#ff0000">x</span>`, ANSIToHTML("\x1b[38;5;196mx"))
+	assert.Equal(t, `<span class="ansi-fg-3">a</span><span class="ansi-fg-3 ansi-bg-4">b</span>c`, ANSIToHTML("\x1b[33ma\x1b[44mb\x1b[mc"))
+	assert.Equal(t, "done", ANSIToHTML("\x1b[2Kdone"))
+}
+
+func TestExport(t *testing.T) {
+	logger.Init().ToStdout()
+	t.Setenv("HOME", t.TempDir())
+	o := New("export", 1)
+	o.NewStage("build")
+	o.NewStep("compile code")
+	o.WriteLine("\x1b[32mCompiled\x1b[0m <ok>")
+	o.Done()
+
+	var text strings.Builder
+	assert.NoError(t, Export(&text, o.Filename(), ExportText, "export #1"))
+	assert.Contains(t, text.String(), "] Compiled <ok>\n")
+	assert.NotContains(t, text.String(), "\x1b")
+
+	var page strings.Builder
+	assert.NoError(t, Export(&page, o.Filename(), ExportHTML, "export #1"))
+	assert.Contains(t, page.String(), "<title>export #1</title>")
+	assert.Contains(t, page.String(), `<a href="#stage-build">build</a>`)
+	assert.Contains(t, page.String(), `<h3 id="step-build-compile-code">`)
+	assert.Contains(t, page.String(), `<span class="ansi-fg-2">Compiled</span> &lt;ok&gt;`)
+	matches, err := SearchFile(o.Filename(), func(text string) bool { return strings.Contains(text, "Compiled") }, SearchOptions{})
+	assert.NoError(t, err)
+	assert.Contains(t, page.String(), `id="`+LineAnchor(matches[0].Line)+`"`)
+
+	var archive bytes.Buffer
+	assert.NoError(t, Export(&archive, o.Filename(), ExportArchive, "export #1"))
+	zr, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	assert.NoError(t, err)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"1.log", "1.txt", "1.html"}, names)
+	rc, err := zr.File[0].Open()
+	assert.NoError(t, err)
+	raw, _ := io.ReadAll(rc)
+	rc.Close()
+	// 原始日志中保留 ANSI 控制序列
+	assert.Contains(t, string(raw), `\u001b[32mCompiled`)
+
+	assert.Error(t, Export(io.Discard, o.Filename(), "pdf", ""))
+}
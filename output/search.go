@@ -0,0 +1,120 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+)
+
+// ScanEntries 逐行读取日志文件，不会把整个文件读入内存，fn 返回 false 时停止读取
+// lineNo 从 1 开始，和 ParseLogFile 返回的 Lines 的顺序一致
+// 旧的文本格式的日志每行作为 system 的内容，stage 和 step 根据 [Pipeline] 开头的行确定
+func ScanEntries(filename string, fn func(lineNo int, entry Entry) bool) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	legacy := false
+	var stage, step string
+	for lineNo := 1; ; lineNo++ {
+		data, err := reader.ReadBytes('\n')
+		if len(data) == 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		var entry Entry
+		if lineNo == 1 {
+			legacy = json.Unmarshal(data, &entry) != nil || entry.Stream == ""
+		}
+		if legacy {
+			text := trimNewline(string(data))
+			if strings.HasPrefix(text, "[Pipeline] Stage: ") {
+				stage, step = strings.TrimPrefix(text, "[Pipeline] Stage: "), ""
+			} else if strings.HasPrefix(text, "[Pipeline] Step: ") {
+				step = strings.TrimPrefix(text, "[Pipeline] Step: ")
+			}
+			entry = Entry{Stage: stage, Step: step, Stream: StreamSystem, Text: text}
+		} else if lineNo > 1 && (json.Unmarshal(data, &entry) != nil || entry.Stream == "") {
+			entry = Entry{Stream: StreamSystem, Text: trimNewline(string(data))}
+		}
+		if !fn(lineNo, entry) {
+			return nil
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Match 日志中匹配的一行和前后的内容
+type Match struct {
+	Line   int      `json:"line"`
+	Stage  string   `json:"stage"`
+	Step   string   `json:"step"`
+	Text   string   `json:"text"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// SearchOptions 搜索一个日志文件的条件
+type SearchOptions struct {
+	// 只搜索这个 stage 的内容，为空时搜索整个日志，前后的内容也只包含这个 stage 的
+	Stage string
+	// 匹配行前后各返回几行
+	Context int
+	// 最多返回几个结果，0 表示不限制
+	Limit int
+}
+
+// SearchFile 在日志文件中查找 match 返回 true 的行，匹配的是输出的内容，不包含时间
+func SearchFile(filename string, match func(text string) bool, opts SearchOptions) ([]Match, error) {
+	var matches []Match
+	// 还需要后面几行内容的结果
+	var pending []int
+	var before []string
+	err := ScanEntries(filename, func(lineNo int, entry Entry) bool {
+		if opts.Stage != "" && entry.Stage != opts.Stage {
+			return true
+		}
+		for i := 0; i < len(pending); {
+			m := &matches[pending[i]]
+			m.After = append(m.After, entry.Text)
+			if len(m.After) >= opts.Context {
+				pending = append(pending[:i], pending[i+1:]...)
+				continue
+			}
+			i++
+		}
+		if opts.Limit > 0 && len(matches) >= opts.Limit {
+			// 已经够了，等待最后几个结果的后面几行
+			return len(pending) > 0
+		}
+		if match(entry.Text) {
+			matches = append(matches, Match{
+				Line:   lineNo,
+				Stage:  entry.Stage,
+				Step:   entry.Step,
+				Text:   entry.Text,
+				Before: append([]string(nil), before...),
+			})
+			if opts.Context > 0 {
+				pending = append(pending, len(matches)-1)
+			}
+		}
+		if opts.Context > 0 {
+			if len(before) == opts.Context {
+				before = before[1:]
+			}
+			before = append(before, entry.Text)
+		}
+		return true
+	})
+	return matches, err
+}
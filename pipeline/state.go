@@ -1,77 +1,50 @@
 package pipeline
 
-type State string
-
-const (
-	StateNotRun  = State("NotRun")
-	StateRunning = State("Running")
-	StateDone    = State("Done")
-	StateFail    = State("Fail")
-)
-
-var (
-	NotRunInstance  = &NotRunStep{}
-	RunningInstance = &RunningStep{}
-	DoneInstance    = &DoneStep{}
-	FailInstance    = &FailStep{}
-)
-
-type IStepState interface {
-	getState() State
-	execute(machine *StepMachine)
-}
-
-// NotRunStep 未开始状态
-type NotRunStep struct {
-}
-
-func (step *NotRunStep) getState() State {
-	return StateNotRun
-}
-
-func (step *NotRunStep) execute(sm *StepMachine) {
-
-	sm.stepState = RunningInstance
-}
-
-// RunningStep 运行中状态
-type RunningStep struct {
-}
-
-func NewRunningStep() *RunningStep {
-	return &RunningStep{}
-}
-
-func (step *RunningStep) getState() State {
-	return StateRunning
-}
-
-func (step *RunningStep) execute(sm *StepMachine) {
-	// will not happen
-	sm.stepState = DoneInstance
-
-}
-
-// DoneStep 完成状态
-type DoneStep struct {
-}
-
-func (step *DoneStep) getState() State {
-	return StateDone
-}
-
-func (step *DoneStep) execute(sm *StepMachine) {
-	// will not happen
-}
-
-// FailStep 失败状态
-type FailStep struct {
-}
-
-func (step *FailStep) getState() State {
-	return StateFail
-}
-
-func (step *FailStep) execute(sm *StepMachine) {
-	// will not happen
+import "github.com/hamster-shared/aline-engine/model"
+
+// transitions 每个状态允许迁移到的下一个状态，终态不能再迁移
+// NOTRUN、QUEUED 可以直接到 FAIL，表示还没开始执行就失败了，比如 stage 依赖无法解析
+var transitions = map[model.Status][]model.Status{
+	model.STATUS_NOTRUN: {
+		model.STATUS_QUEUED,
+		model.STATUS_RUNNING,
+		model.STATUS_WAITING_APPROVAL,
+		model.STATUS_SKIPPED,
+		model.STATUS_CANCELLED,
+		model.STATUS_STOP,
+		model.STATUS_FAIL,
+	},
+	model.STATUS_QUEUED: {
+		model.STATUS_RUNNING,
+		model.STATUS_SKIPPED,
+		model.STATUS_CANCELLED,
+		model.STATUS_STOP,
+		model.STATUS_FAIL,
+	},
+	model.STATUS_RUNNING: {
+		model.STATUS_SUCCESS,
+		model.STATUS_FAIL,
+		model.STATUS_STOP,
+		model.STATUS_CANCELLED,
+		model.STATUS_TIMEOUT,
+		model.STATUS_WAITING_APPROVAL,
+	},
+	// 等待审批时，job 从 RUNNING 进入，审批通过后回到 RUNNING；stage 从 NOTRUN 进入
+	model.STATUS_WAITING_APPROVAL: {
+		model.STATUS_RUNNING,
+		model.STATUS_FAIL,
+		model.STATUS_STOP,
+		model.STATUS_CANCELLED,
+		model.STATUS_TIMEOUT,
+	},
+}
+
+// CanTransition 判断能否从 from 迁移到 to
+func CanTransition(from, to model.Status) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
 }
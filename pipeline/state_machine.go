@@ -1,19 +1,70 @@
 package pipeline
 
-type StepMachine struct {
-	stepState IStepState
+import (
+	"sync"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/model"
+)
+
+// StateMachine 驱动 job、stage、step 的状态变化
+// 它直接修改所绑定的状态字段，每次合法的变化都会通过 emit 发出一个 TransitionEvent
+type StateMachine struct {
+	mu     sync.Mutex
+	status *model.Status
+	event  model.TransitionEvent
+	emit   func(event model.TransitionEvent)
 }
 
-func InitialStepStateMachine() *StepMachine {
-	return &StepMachine{
-		stepState: NotRunInstance,
+// NewStateMachine 创建状态机，status 为需要驱动的状态字段，event 中的 Kind、JobName、JobId、Stage、Step 会被带到每个事件中
+func NewStateMachine(status *model.Status, event model.TransitionEvent, emit func(event model.TransitionEvent)) *StateMachine {
+	return &StateMachine{
+		status: status,
+		event:  event,
+		emit:   emit,
 	}
 }
 
-func (s *StepMachine) Run() {
-	s.stepState.execute(s)
+// State 当前状态
+func (s *StateMachine) State() model.Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.status
+}
+
+// Transition 迁移到 to 状态，非法的迁移会返回 IllegalTransitionError，状态保持不变
+func (s *StateMachine) Transition(to model.Status) error {
+	s.mu.Lock()
+	from := *s.status
+	if !CanTransition(from, to) {
+		s.mu.Unlock()
+		return &model.IllegalTransitionError{
+			Kind: s.event.Kind,
+			Name: s.name(),
+			From: from,
+			To:   to,
+		}
+	}
+	*s.status = to
+	event := s.event
+	s.mu.Unlock()
+
+	event.From = from
+	event.To = to
+	event.Time = time.Now()
+	if s.emit != nil {
+		s.emit(event)
+	}
+	return nil
 }
 
-func (s *StepMachine) State() State {
-	return s.stepState.getState()
+func (s *StateMachine) name() string {
+	switch s.event.Kind {
+	case model.TransitionStage:
+		return s.event.Stage
+	case model.TransitionStep:
+		return s.event.Step
+	default:
+		return s.event.JobName
+	}
 }
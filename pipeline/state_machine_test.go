@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"testing"
+
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateMachineTransition(t *testing.T) {
+	status := model.STATUS_NOTRUN
+	var events []model.TransitionEvent
+	machine := NewStateMachine(&status, model.TransitionEvent{
+		Kind:    model.TransitionStep,
+		JobName: "hello",
+		JobId:   1,
+		Stage:   "build",
+		Step:    "compile",
+	}, func(event model.TransitionEvent) {
+		events = append(events, event)
+	})
+
+	assert.NoError(t, machine.Transition(model.STATUS_QUEUED))
+	assert.NoError(t, machine.Transition(model.STATUS_RUNNING))
+	assert.NoError(t, machine.Transition(model.STATUS_SUCCESS))
+	assert.Equal(t, model.STATUS_SUCCESS, status)
+
+	err := machine.Transition(model.STATUS_RUNNING)
+	assert.Error(t, err)
+	assert.IsType(t, &model.IllegalTransitionError{}, err)
+	assert.Equal(t, model.STATUS_SUCCESS, machine.State())
+
+	assert.Len(t, events, 3)
+	assert.Equal(t, model.STATUS_RUNNING, events[2].From)
+	assert.Equal(t, model.STATUS_SUCCESS, events[2].To)
+	assert.Equal(t, "compile", events[2].Step)
+}
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from model.Status
+		to   model.Status
+		want bool
+	}{
+		{model.STATUS_NOTRUN, model.STATUS_RUNNING, true},
+		{model.STATUS_NOTRUN, model.STATUS_SKIPPED, true},
+		{model.STATUS_QUEUED, model.STATUS_CANCELLED, true},
+		{model.STATUS_RUNNING, model.STATUS_TIMEOUT, true},
+		{model.STATUS_RUNNING, model.STATUS_QUEUED, false},
+		{model.STATUS_SUCCESS, model.STATUS_RUNNING, false},
+		{model.STATUS_FAIL, model.STATUS_SUCCESS, false},
+		{model.STATUS_SKIPPED, model.STATUS_RUNNING, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from.ToString()+"->"+tt.to.ToString(), func(t *testing.T) {
+			assert.Equal(t, tt.want, CanTransition(tt.from, tt.to))
+		})
+	}
+}
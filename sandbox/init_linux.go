@@ -0,0 +1,311 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// Apply 让命令在新的 user、mount、pid、network namespace 中启动
+// 命令以 init 进程运行，准备好根目录后再执行真正的命令，见 runInit
+func (s *Sandbox) Apply(cmd *exec.Cmd) error {
+	if s == nil {
+		return nil
+	}
+	// 沙箱中的路径和宿主机上相同，命令在 cmd.Dir 中执行
+	spec := s.spec
+	spec.Dir = cmd.Dir
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, initEnv+"=1", specEnv+"="+string(data))
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+		syscall.CLONE_NEWNET | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC
+	// 沙箱中的 root 对应宿主机上的 worker 用户，不会有额外的权限
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+	return nil
+}
+
+func init() {
+	if os.Getenv(initEnv) == "" {
+		return
+	}
+	os.Exit(runInit())
+}
+
+// 沙箱中只读挂载的系统目录
+var systemDirs = []string{"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64", "/libx32", "/etc", "/opt"}
+
+// 沙箱中可以使用的设备
+var devices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// runInit 沙箱中的 1 号进程：准备根目录和网络，执行命令并等待它结束，返回命令的退出码
+func runInit() int {
+	var spec initSpec
+	if err := json.Unmarshal([]byte(os.Getenv(specEnv)), &spec); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: invalid spec: %s\n", err)
+		return 125
+	}
+	_ = os.Unsetenv(initEnv)
+	_ = os.Unsetenv(specEnv)
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "sandbox: no command")
+		return 125
+	}
+	if err := setupRoot(&spec); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: setup root failed: %s\n", err)
+		return 125
+	}
+	if err := setupLoopback(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: setup loopback failed: %s\n", err)
+		return 125
+	}
+
+	env := os.Environ()
+	env = append(env, "HOME=/tmp")
+	if spec.ProxySocket != "" {
+		if err := forwardProxy(spec.ProxySocket); err != nil {
+			fmt.Fprintf(os.Stderr, "sandbox: start proxy failed: %s\n", err)
+			return 125
+		}
+		proxyURL := "http://127.0.0.1:" + strconv.Itoa(proxyPort)
+		for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+			env = append(env, key+"="+proxyURL)
+		}
+		env = append(env, "NO_PROXY=localhost,127.0.0.1", "no_proxy=localhost,127.0.0.1")
+	}
+
+	cmd := exec.Command(os.Args[1], os.Args[2:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = env
+	cmd.Dir = spec.Workdir
+	if spec.Dir != "" {
+		cmd.Dir = spec.Dir
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %s\n", err)
+		return 127
+	}
+	// 1 号进程默认忽略信号，需要转发给命令
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			_ = cmd.Process.Signal(sig)
+		}
+	}()
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		return 125
+	}
+	return 0
+}
+
+// setupRoot 在 tmpfs 上搭建新的根目录，只挂载系统目录、工作目录和声明的 volume，然后切换过去
+func setupRoot(spec *initSpec) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make / private: %w", err)
+	}
+	root := spec.Root
+	if err := syscall.Mount("tmpfs", root, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755"); err != nil {
+		return fmt.Errorf("mount root: %w", err)
+	}
+	for _, dir := range systemDirs {
+		info, err := os.Lstat(dir)
+		if err != nil {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			// 例如 /bin -> usr/bin
+			target, err := os.Readlink(dir)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(target, filepath.Join(root, dir)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := bindMount(dir, filepath.Join(root, dir), true); err != nil {
+			return err
+		}
+	}
+
+	dev := filepath.Join(root, "dev")
+	if err := os.MkdirAll(filepath.Join(dev, "shm"), 0755); err != nil {
+		return err
+	}
+	for _, device := range devices {
+		if _, err := os.Stat(filepath.Join("/dev", device)); err != nil {
+			continue
+		}
+		if err := bindMount(filepath.Join("/dev", device), filepath.Join(dev, device), false); err != nil {
+			return err
+		}
+	}
+	if err := syscall.Mount("tmpfs", filepath.Join(dev, "shm"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("mount /dev/shm: %w", err)
+	}
+	for name, target := range map[string]string{"fd": "/proc/self/fd", "stdin": "/proc/self/fd/0", "stdout": "/proc/self/fd/1", "stderr": "/proc/self/fd/2"} {
+		if err := os.Symlink(target, filepath.Join(dev, name)); err != nil {
+			return err
+		}
+	}
+
+	// 新的 pid namespace 中只能看到沙箱里的进程
+	if err := os.MkdirAll(filepath.Join(root, "proc"), 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("proc", filepath.Join(root, "proc"), "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mount /proc: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "tmp"), 0755); err != nil {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", filepath.Join(root, "tmp"), "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("mount /tmp: %w", err)
+	}
+
+	for _, b := range spec.Binds {
+		if err := bindMount(b.Source, filepath.Join(root, b.Target), b.ReadOnly); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Chdir(root); err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot root: %w", err)
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmount old root: %w", err)
+	}
+	return os.Chdir("/")
+}
+
+// bindMount 把 source 挂载到 target，target 不存在时按照 source 的类型创建
+func bindMount(source, target string, readOnly bool) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		err = os.MkdirAll(target, 0755)
+	} else {
+		err = os.MkdirAll(filepath.Dir(target), 0755)
+		if err == nil {
+			var f *os.File
+			f, err = os.OpenFile(target, os.O_CREATE, 0644)
+			if err == nil {
+				_ = f.Close()
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind %s: %w", source, err)
+	}
+	if !readOnly {
+		return nil
+	}
+	// user namespace 中重新挂载时必须保留原来的 nosuid、nodev 等标志，否则会被拒绝
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(target, &stat); err != nil {
+		return err
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	for st, ms := range map[int64]uintptr{
+		1:    syscall.MS_RDONLY,
+		2:    syscall.MS_NOSUID,
+		4:    syscall.MS_NODEV,
+		8:    syscall.MS_NOEXEC,
+		1024: syscall.MS_NOATIME,
+		2048: syscall.MS_NODIRATIME,
+		4096: syscall.MS_RELATIME,
+	} {
+		if int64(stat.Flags)&st != 0 {
+			flags |= ms
+		}
+	}
+	if err := syscall.Mount("", target, "", flags, ""); err != nil {
+		return fmt.Errorf("remount %s read-only: %w", source, err)
+	}
+	return nil
+}
+
+// setupLoopback 新的 network namespace 中只有一个没有启用的 lo
+func setupLoopback() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	var ifr struct {
+		name  [syscall.IFNAMSIZ]byte
+		flags uint16
+		_     [22]byte
+	}
+	copy(ifr.name[:], "lo")
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return errno
+	}
+	ifr.flags |= syscall.IFF_UP | syscall.IFF_RUNNING
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// forwardProxy 在沙箱的 lo 上监听代理端口，转发到宿主机上代理的 unix socket
+func forwardProxy(socket string) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(proxyPort)))
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				upstream, err := net.Dial("unix", socket)
+				if err != nil {
+					_ = conn.Close()
+					return
+				}
+				pipe(conn, upstream)
+			}()
+		}
+	}()
+	return nil
+}
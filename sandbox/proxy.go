@@ -0,0 +1,180 @@
+package sandbox
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/logger"
+)
+
+// proxy 宿主机上的 http 代理，沙箱中只能通过它访问白名单中的地址
+type proxy struct {
+	listener  net.Listener
+	allow     allowList
+	transport *http.Transport
+}
+
+func startProxy(socket string, allow []string) (*proxy, error) {
+	_ = os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	p := &proxy{
+		listener:  listener,
+		allow:     newAllowList(allow),
+		transport: &http.Transport{Proxy: nil},
+	}
+	go func() {
+		_ = http.Serve(listener, p)
+	}()
+	return p, nil
+}
+
+func (p *proxy) close() {
+	_ = p.listener.Close()
+	p.transport.CloseIdleConnections()
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.connect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "sandbox proxy only accepts absolute urls", http.StatusBadRequest)
+		return
+	}
+	host := r.URL.Host
+	if r.URL.Port() == "" {
+		host = net.JoinHostPort(r.URL.Hostname(), "80")
+	}
+	if !p.allow.allowed(host) {
+		p.deny(w, host)
+		return
+	}
+	r.RequestURI = ""
+	r.Header.Del("Proxy-Connection")
+	r.Header.Del("Proxy-Authorization")
+	resp, err := p.transport.RoundTrip(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// connect 处理 https 等使用 CONNECT 建立的隧道
+func (p *proxy) connect(w http.ResponseWriter, r *http.Request) {
+	if !p.allow.allowed(r.Host) {
+		p.deny(w, r.Host)
+		return
+	}
+	target, err := net.DialTimeout("tcp", r.Host, 10*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		_ = target.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		_ = target.Close()
+		return
+	}
+	_, _ = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	pipe(conn, target)
+}
+
+func (p *proxy) deny(w http.ResponseWriter, host string) {
+	logger.Warnf("sandbox proxy denied %s", host)
+	http.Error(w, "sandbox network access to "+host+" is not allowed", http.StatusForbidden)
+}
+
+// pipe 双向转发，任意一边关闭后结束
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copyConn := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go copyConn(a, b)
+	go copyConn(b, a)
+	<-done
+	_ = a.Close()
+	_ = b.Close()
+}
+
+// allowList 网络白名单
+type allowList struct {
+	hosts    []string // 任意端口
+	suffixes []string // *.domain
+	ports    []string // host:port
+	networks []*net.IPNet
+}
+
+func newAllowList(rules []string) allowList {
+	var l allowList
+	for _, rule := range rules {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		if rule == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(rule); err == nil {
+			l.networks = append(l.networks, network)
+		} else if strings.HasPrefix(rule, "*.") {
+			l.suffixes = append(l.suffixes, rule[1:])
+		} else if _, _, err := net.SplitHostPort(rule); err == nil {
+			l.ports = append(l.ports, rule)
+		} else {
+			l.hosts = append(l.hosts, rule)
+		}
+	}
+	return l
+}
+
+// allowed hostPort 是否在白名单中，CIDR 只匹配直接使用 ip 的地址
+func (l allowList) allowed(hostPort string) bool {
+	host, _, err := net.SplitHostPort(strings.ToLower(hostPort))
+	if err != nil {
+		return false
+	}
+	for _, rule := range l.ports {
+		if rule == strings.ToLower(hostPort) {
+			return true
+		}
+	}
+	for _, rule := range l.hosts {
+		if rule == host {
+			return true
+		}
+	}
+	for _, suffix := range l.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range l.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
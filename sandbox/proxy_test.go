@@ -0,0 +1,82 @@
+package sandbox
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hamster-shared/aline-engine/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init().ToStdout()
+	os.Exit(m.Run())
+}
+
+func TestAllowList(t *testing.T) {
+	l := newAllowList([]string{"github.com", "*.npmjs.org", "example.com:8443", "10.0.0.0/8", ""})
+	assert.True(t, l.allowed("github.com:443"))
+	assert.True(t, l.allowed("GitHub.com:22"))
+	assert.False(t, l.allowed("api.github.com:443"))
+	assert.True(t, l.allowed("registry.npmjs.org:443"))
+	assert.False(t, l.allowed("npmjs.org:443"))
+	assert.True(t, l.allowed("example.com:8443"))
+	assert.False(t, l.allowed("example.com:443"))
+	assert.True(t, l.allowed("10.1.2.3:80"))
+	assert.False(t, l.allowed("11.1.2.3:80"))
+	assert.False(t, l.allowed("github.com"))
+}
+
+func TestProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	socket := filepath.Join(t.TempDir(), "proxy.sock")
+	p, err := startProxy(socket, []string{"127.0.0.1/32"})
+	assert.NoError(t, err)
+	defer p.close()
+
+	proxyURL, _ := url.Parse("http://sandbox-proxy")
+	client := &http.Client{Transport: &http.Transport{
+		Proxy: http.ProxyURL(proxyURL),
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		},
+	}}
+
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", string(body))
+
+	resp, err = client.Get("http://localhost.invalid/")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestParseVolume(t *testing.T) {
+	b, err := parseVolume("/data:/mnt/data:ro")
+	assert.NoError(t, err)
+	assert.Equal(t, bind{Source: "/data", Target: "/mnt/data", ReadOnly: true}, b)
+
+	b, err = parseVolume("/cache")
+	assert.NoError(t, err)
+	assert.Equal(t, bind{Source: "/cache", Target: "/cache"}, b)
+
+	_, err = parseVolume("cache:/cache")
+	assert.Error(t, err)
+	_, err = parseVolume("/a:/b:rx")
+	assert.Error(t, err)
+}
@@ -0,0 +1,166 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hamster-shared/aline-engine/utils"
+)
+
+const (
+	// initEnv 有这个环境变量时，进程作为沙箱的 init 进程运行，见 init_linux.go
+	initEnv = "ALINE_SANDBOX_INIT"
+	// specEnv 传给 init 进程的沙箱配置，json 格式
+	specEnv = "ALINE_SANDBOX_SPEC"
+	// proxyPort 沙箱中 http 代理监听的端口
+	proxyPort = 3128
+)
+
+// Config worker 上的沙箱配置，对所有在宿主机上执行的 shell step 生效
+type Config struct {
+	Enabled bool
+	// 沙箱中允许访问的网络地址，为空时沙箱中没有网络
+	// 支持 host、host:port、*.domain、CIDR，通过 http 代理访问，只对使用 HTTP_PROXY/HTTPS_PROXY 的程序生效
+	AllowNetwork []string
+}
+
+var config Config
+
+// Configure 设置 worker 的沙箱配置
+func Configure(c Config) {
+	config = c
+}
+
+// Enabled 是否开启了沙箱
+func Enabled() bool {
+	return config.Enabled
+}
+
+// Spec 一次执行需要放进沙箱的目录
+type Spec struct {
+	// 工作目录，可读写，在沙箱中的路径和宿主机上相同
+	Workdir string
+	// 临时目录，存放脚本，可读写，在沙箱中的路径和宿主机上相同
+	TmpDir string
+	// step 声明的 volume，格式为 host:sandbox[:ro]
+	Volumes []string
+}
+
+// bind 挂载到沙箱中的目录
+type bind struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"readOnly"`
+}
+
+// initSpec 传给 init 进程的配置
+type initSpec struct {
+	// 沙箱的根目录，在 mount namespace 中挂载 tmpfs，宿主机上只能看到一个空目录
+	Root    string `json:"root"`
+	Workdir string `json:"workdir"`
+	// 命令的当前目录，为空时使用 Workdir
+	Dir   string `json:"dir,omitempty"`
+	Binds []bind `json:"binds"`
+	// 宿主机上 http 代理的 unix socket，为空表示沙箱中没有网络
+	ProxySocket string `json:"proxySocket,omitempty"`
+}
+
+// Sandbox 一个 step 使用的沙箱，nil 表示不使用沙箱，可以直接调用它的方法
+type Sandbox struct {
+	executable string
+	spec       initSpec
+	root       string
+	proxy      *proxy
+}
+
+// New 按照 worker 的配置为 spec 创建沙箱，没有开启沙箱时返回 nil
+func New(spec Spec) (*Sandbox, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: %s", err)
+	}
+	binds := []bind{
+		{Source: spec.Workdir, Target: spec.Workdir},
+		{Source: spec.TmpDir, Target: spec.TmpDir},
+	}
+	for _, volume := range spec.Volumes {
+		b, err := parseVolume(volume)
+		if err != nil {
+			return nil, err
+		}
+		binds = append(binds, b)
+	}
+
+	// 根目录不能放在会挂载到沙箱中的目录下面
+	root, err := os.MkdirTemp("", "aline-sandbox-")
+	if err != nil {
+		return nil, err
+	}
+	s := &Sandbox{
+		executable: executable,
+		root:       root,
+		spec: initSpec{
+			Root:    root,
+			Workdir: spec.Workdir,
+			Binds:   binds,
+		},
+	}
+	if len(config.AllowNetwork) > 0 {
+		// 临时目录会挂载到沙箱中，沙箱中通过相同的路径访问代理
+		socket := filepath.Join(spec.TmpDir, "proxy-"+utils.RandSeq(6)+".sock")
+		s.proxy, err = startProxy(socket, config.AllowNetwork)
+		if err != nil {
+			_ = os.RemoveAll(root)
+			return nil, err
+		}
+		s.spec.ProxySocket = socket
+	}
+	return s, nil
+}
+
+// Command 返回在沙箱中执行 commands 的命令，创建命令后还需要调用 Apply
+func (s *Sandbox) Command(commands []string) []string {
+	if s == nil {
+		return commands
+	}
+	return append([]string{s.executable}, commands...)
+}
+
+// Close 停止代理，删除沙箱的临时文件
+func (s *Sandbox) Close() {
+	if s == nil {
+		return
+	}
+	if s.proxy != nil {
+		s.proxy.close()
+		_ = os.Remove(s.spec.ProxySocket)
+	}
+	_ = os.Remove(s.root)
+}
+
+// parseVolume 解析 host:sandbox[:ro]，只写一个路径时在沙箱中使用相同的路径
+func parseVolume(volume string) (bind, error) {
+	parts := strings.Split(volume, ":")
+	b := bind{Source: parts[0], Target: parts[0]}
+	if len(parts) > 1 && parts[1] != "" {
+		b.Target = parts[1]
+	}
+	if len(parts) > 2 {
+		switch parts[2] {
+		case "ro":
+			b.ReadOnly = true
+		case "rw":
+		default:
+			return bind{}, fmt.Errorf("invalid volume %s: unknown mode %s", volume, parts[2])
+		}
+	}
+	if len(parts) > 3 || !filepath.IsAbs(b.Source) || !filepath.IsAbs(b.Target) {
+		return bind{}, fmt.Errorf("invalid volume %s: paths must be absolute", volume)
+	}
+	return b, nil
+}
@@ -0,0 +1,94 @@
+package sandbox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runInSandbox 在沙箱中执行 shell 命令，系统不支持 user namespace 时跳过
+func runInSandbox(t *testing.T, config Config, spec Spec, script string) (string, error) {
+	Configure(config)
+	defer Configure(Config{})
+	s, err := New(spec)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	commands := s.Command([]string{"sh", "-c", script})
+	cmd := exec.Command(commands[0], commands[1:]...)
+	assert.NoError(t, s.Apply(cmd))
+	out, err := cmd.CombinedOutput()
+	if err != nil && strings.Contains(string(out), "sandbox: setup") || os.IsPermission(err) {
+		t.Skipf("user namespace is not available: %s %s", err, out)
+	}
+	return string(out), err
+}
+
+func TestSandboxIsolation(t *testing.T) {
+	if _, err := os.Stat("/proc/self/ns/user"); err != nil {
+		t.Skip("user namespace is not available")
+	}
+	workdir, tmpDir, volume := t.TempDir(), t.TempDir(), t.TempDir()
+	secret := filepath.Join(t.TempDir(), "secret")
+	assert.NoError(t, os.WriteFile(secret, []byte("secret"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(volume, "input"), []byte("input"), 0644))
+
+	out, err := runInSandbox(t, Config{Enabled: true}, Spec{Workdir: workdir, TmpDir: tmpDir, Volumes: []string{volume + ":/data:ro"}}, strings.Join([]string{
+		"echo built > output",
+		"cat /data/input",
+		"test ! -e " + secret + " && echo no-secret",
+		"touch /data/new 2>/dev/null || echo read-only",
+		"test ! -e /proc/" + strconv.Itoa(os.Getpid()) + " && echo no-host-process",
+	}, "\n"))
+	assert.NoError(t, err, out)
+	assert.Contains(t, out, "input")
+	assert.Contains(t, out, "no-secret")
+	assert.Contains(t, out, "read-only")
+	// 新的 pid namespace 中看不到宿主机上的进程
+	assert.Contains(t, out, "no-host-process")
+
+	built, err := os.ReadFile(filepath.Join(workdir, "output"))
+	assert.NoError(t, err)
+	assert.Equal(t, "built\n", string(built))
+}
+
+func TestSandboxExitCode(t *testing.T) {
+	if _, err := os.Stat("/proc/self/ns/user"); err != nil {
+		t.Skip("user namespace is not available")
+	}
+	_, err := runInSandbox(t, Config{Enabled: true}, Spec{Workdir: t.TempDir(), TmpDir: t.TempDir()}, "exit 3")
+	var exitErr *exec.ExitError
+	assert.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.ExitCode())
+}
+
+func TestSandboxNetwork(t *testing.T) {
+	if _, err := os.Stat("/proc/self/ns/user"); err != nil {
+		t.Skip("user namespace is not available")
+	}
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("curl is not installed")
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("allowed"))
+	}))
+	defer server.Close()
+
+	// 没有白名单时沙箱中没有网络
+	out, _ := runInSandbox(t, Config{Enabled: true}, Spec{Workdir: t.TempDir(), TmpDir: t.TempDir()}, "curl -s --max-time 2 "+server.URL+" || echo offline")
+	assert.Contains(t, out, "offline")
+
+	// 测试服务器在宿主机的 127.0.0.1 上，沙箱中的 127.0.0.1 默认不走代理，这里强制走代理
+	out, err := runInSandbox(t, Config{Enabled: true, AllowNetwork: []string{"127.0.0.1/32"}}, Spec{Workdir: t.TempDir(), TmpDir: t.TempDir()},
+		"curl -s --noproxy '' --max-time 5 "+server.URL+"\necho\ncurl -s -o /dev/null -w '%{http_code}' --max-time 5 http://10.255.255.1/")
+	assert.NoError(t, err, out)
+	assert.Contains(t, out, "allowed")
+	assert.Contains(t, out, "403")
+}
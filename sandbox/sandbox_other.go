@@ -0,0 +1,16 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Apply 沙箱依赖 linux namespace，其他系统上开启沙箱时拒绝执行
+func (s *Sandbox) Apply(cmd *exec.Cmd) error {
+	if s == nil {
+		return nil
+	}
+	return errors.New("sandbox is only supported on linux")
+}
@@ -0,0 +1,71 @@
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hamster-shared/aline-engine/utils"
+)
+
+// ErrNotFound 密钥不存在
+var ErrNotFound = errors.New("secret not found")
+
+// Store 密钥存储，pipeline 中只写密钥的名称，执行时由 worker 读取密钥的内容
+type Store interface {
+	Get(name string) (string, error)
+}
+
+// DirStore worker 本地目录中的密钥，每个密钥一个文件，文件名为密钥的名称
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore dir 为空时使用 ~/.aline/secrets
+func NewDirStore(dir string) *DirStore {
+	if dir == "" {
+		dir = filepath.Join(utils.DefaultConfigDir(), "secrets")
+	}
+	return &DirStore{dir: dir}
+}
+
+// Get 读取密钥，去掉末尾的换行
+func (s *DirStore) Get(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+var (
+	store   Store
+	storeMu sync.Mutex
+)
+
+// Configure 设置 worker 使用的密钥存储
+func Configure(s Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store = s
+}
+
+// Get 从 worker 的密钥存储中读取密钥，没有设置时使用默认目录
+func Get(name string) (string, error) {
+	storeMu.Lock()
+	if store == nil {
+		store = NewDirStore("")
+	}
+	s := store
+	storeMu.Unlock()
+	return s.Get(name)
+}
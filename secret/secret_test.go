@@ -0,0 +1,34 @@
+package secret
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirStore(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "registry"), []byte("user:pass\r\n"), 0600))
+	store := NewDirStore(dir)
+
+	value, err := store.Get("registry")
+	assert.NoError(t, err)
+	assert.Equal(t, "user:pass", value)
+
+	_, err = store.Get("missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	for _, name := range []string{"", "..", "../registry", "a/b"} {
+		_, err = store.Get(name)
+		assert.Error(t, err, name)
+	}
+
+	Configure(store)
+	defer Configure(nil)
+	value, err = Get("registry")
+	assert.NoError(t, err)
+	assert.Equal(t, "user:pass", value)
+}
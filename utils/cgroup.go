@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot 为 step 创建 cgroup 的父目录，worker 需要有写权限，并且可以开启 cpu、memory、pids 控制器
+var cgroupRoot = "/sys/fs/cgroup/aline"
+
+// SetCgroupRoot 设置创建 cgroup 的父目录，为空时使用默认值
+func SetCgroupRoot(dir string) {
+	if dir != "" {
+		cgroupRoot = dir
+	}
+}
+
+// CgroupLimits cgroup 的资源上限，为 0 的项不限制
+type CgroupLimits struct {
+	CPU    float64
+	Memory int64
+	Pids   int
+}
+
+// ParseCgroupEvents 解析 memory.events、pids.events 这样每行一个 "key value" 的文件
+func ParseCgroupEvents(content string) map[string]int64 {
+	events := make(map[string]int64)
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		events[fields[0]] = value
+	}
+	return events
+}
+
+// DirSize 计算目录下所有文件占用的大小，读取失败的文件会被忽略
+func DirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
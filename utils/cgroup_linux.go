@@ -0,0 +1,89 @@
+//go:build linux
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Cgroup 为一个 step 创建的 cgroup v2
+type Cgroup struct {
+	path string
+}
+
+// NewCgroup 在 cgroupRoot 下创建名为 name 的 cgroup 并设置资源上限
+// 系统不支持 cgroup v2 或者没有权限时返回错误，调用方可以选择不限制资源继续执行
+func NewCgroup(name string, limits CgroupLimits) (*Cgroup, error) {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		return nil, errors.New("cgroup v2 is not available")
+	}
+	if err := os.MkdirAll(cgroupRoot, 0755); err != nil {
+		return nil, err
+	}
+	// 子 cgroup 需要的控制器，已经开启或者不支持的会返回错误，这里忽略，后面写入上限时再报错
+	for _, controller := range []string{"+cpu", "+memory", "+pids"} {
+		_ = os.WriteFile(filepath.Join(cgroupRoot, "cgroup.subtree_control"), []byte(controller), 0644)
+	}
+	c := &Cgroup{path: filepath.Join(cgroupRoot, name)}
+	if err := os.Mkdir(c.path, 0755); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	settings := make(map[string]string)
+	if limits.CPU > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d 100000", int64(limits.CPU*100000))
+	}
+	if limits.Memory > 0 {
+		settings["memory.max"] = strconv.FormatInt(limits.Memory, 10)
+	}
+	if limits.Pids > 0 {
+		settings["pids.max"] = strconv.Itoa(limits.Pids)
+	}
+	for file, value := range settings {
+		if err := os.WriteFile(filepath.Join(c.path, file), []byte(value), 0644); err != nil {
+			c.Remove()
+			return nil, fmt.Errorf("set cgroup %s failed: %s", file, err)
+		}
+	}
+	// 不允许使用 swap 绕过内存限制，没有开启 swap 统计时这个文件不存在
+	if limits.Memory > 0 {
+		_ = os.WriteFile(filepath.Join(c.path, "memory.swap.max"), []byte("0"), 0644)
+	}
+	return c, nil
+}
+
+// Path cgroup 的目录
+func (c *Cgroup) Path() string {
+	return c.path
+}
+
+// Wrap 让命令启动时先把自己加入 cgroup 再执行，之后启动的子进程都会在这个 cgroup 中
+func (c *Cgroup) Wrap(commands []string) []string {
+	wrapped := []string{"sh", "-c", `echo $$ > "$0" && exec "$@"`, filepath.Join(c.path, "cgroup.procs")}
+	return append(wrapped, commands...)
+}
+
+// Events 读取 cgroup 的事件计数，例如 memory.events 中的 oom_kill，pids.events 中的 max
+func (c *Cgroup) Events(file string) map[string]int64 {
+	data, err := os.ReadFile(filepath.Join(c.path, file))
+	if err != nil {
+		return map[string]int64{}
+	}
+	return ParseCgroupEvents(string(data))
+}
+
+// Remove 结束 cgroup 中剩余的进程并删除 cgroup
+func (c *Cgroup) Remove() {
+	_ = os.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0644)
+	for i := 0; i < 10; i++ {
+		err := os.Remove(c.path)
+		if err == nil || os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
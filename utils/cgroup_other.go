@@ -0,0 +1,27 @@
+//go:build !linux
+
+package utils
+
+import "errors"
+
+// Cgroup 只在 linux 上支持
+type Cgroup struct{}
+
+// NewCgroup 只在 linux 上支持，其他系统总是返回错误
+func NewCgroup(name string, limits CgroupLimits) (*Cgroup, error) {
+	return nil, errors.New("cgroup is only supported on linux")
+}
+
+func (c *Cgroup) Path() string {
+	return ""
+}
+
+func (c *Cgroup) Wrap(commands []string) []string {
+	return commands
+}
+
+func (c *Cgroup) Events(file string) map[string]int64 {
+	return map[string]int64{}
+}
+
+func (c *Cgroup) Remove() {}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCgroupEvents(t *testing.T) {
+	events := ParseCgroupEvents("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n")
+	assert.Equal(t, int64(1), events["oom_kill"])
+	assert.Equal(t, int64(3), events["max"])
+	assert.NotContains(t, events, "")
+}
+
+func TestDirSize(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a"), make([]byte, 100), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b"), make([]byte, 50), 0644))
+	assert.Equal(t, int64(150), DirSize(dir))
+}
+
+func TestCgroupLimits(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("cgroup is only supported on linux")
+	}
+	SetCgroupRoot(filepath.Join("/sys/fs/cgroup", "aline-test"))
+	c, err := NewCgroup("limits", CgroupLimits{Memory: 64 << 20, Pids: 16})
+	if err != nil {
+		t.Skipf("cgroup v2 is not available: %s", err)
+	}
+	defer os.Remove(cgroupRoot)
+	defer c.Remove()
+
+	memory, err := os.ReadFile(filepath.Join(c.Path(), "memory.max"))
+	assert.NoError(t, err)
+	assert.Equal(t, "67108864\n", string(memory))
+
+	commands := c.Wrap([]string{"cat", "/proc/self/cgroup"})
+	out, err := NewCommand(context.Background(), commands[0], commands[1:]...).Output()
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "aline-test/limits")
+}
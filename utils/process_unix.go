@@ -0,0 +1,50 @@
+//go:build !windows
+
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// setProcessGroup 让命令在新的进程组中运行，取消时可以连同子进程一起结束
+func setProcessGroup(c *exec.Cmd) {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Setpgid = true
+}
+
+// terminateProcessGroup 向整个进程组发送 SIGTERM
+func terminateProcessGroup(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGTERM)
+}
+
+// killProcessGroup 向整个进程组发送 SIGKILL
+func killProcessGroup(p *os.Process) error {
+	return syscall.Kill(-p.Pid, syscall.SIGKILL)
+}
+
+// processGroupAlive 进程组中是否还有进程
+func processGroupAlive(p *os.Process) bool {
+	return syscall.Kill(-p.Pid, 0) == nil
+}
+
+// KillProcessGroup 结束进程组中的所有进程，进程组已经不存在时什么都不做
+func KillProcessGroup(pgid int) error {
+	if pgid <= 0 || syscall.Kill(-pgid, 0) != nil {
+		return nil
+	}
+	return syscall.Kill(-pgid, syscall.SIGKILL)
+}
+
+// BootID 本次开机的唯一标识，读取失败时返回空字符串
+func BootID() string {
+	data, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
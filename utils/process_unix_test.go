@@ -0,0 +1,64 @@
+//go:build !windows
+
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdKillProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	ctx, cancel := context.WithCancel(context.Background())
+	// 子进程忽略 SIGTERM，只能被 SIGKILL 结束
+	c := NewCommand(ctx, "sh", "-c", "sh -c 'trap \"\" TERM; echo $$ > "+pidFile+"; sleep 30' & wait")
+	c.GracePeriod = 200 * time.Millisecond
+	assert.NoError(t, c.Start())
+
+	var childPid int
+	assert.Eventually(t, func() bool {
+		data, err := os.ReadFile(pidFile)
+		if err != nil {
+			return false
+		}
+		childPid, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	start := time.Now()
+	cancel()
+	assert.Error(t, c.Wait())
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	// 子进程也被结束了
+	assert.Eventually(t, func() bool {
+		return !processAlive(childPid)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// processAlive 进程是否还在运行，已经退出但还没被回收的僵尸进程视为已经结束
+func processAlive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+func TestCmdOutput(t *testing.T) {
+	out, err := NewCommand(context.Background(), "echo", "hello").Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", string(out))
+}
@@ -0,0 +1,40 @@
+//go:build windows
+
+package utils
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup windows 上没有进程组，什么都不做
+func setProcessGroup(c *exec.Cmd) {}
+
+// terminateProcessGroup windows 上不支持 SIGTERM，直接结束进程
+func terminateProcessGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+// killProcessGroup 结束进程
+func killProcessGroup(p *os.Process) error {
+	return p.Kill()
+}
+
+// processGroupAlive windows 上只结束主进程，不需要等待
+func processGroupAlive(p *os.Process) bool {
+	return false
+}
+
+// KillProcessGroup 结束进程
+func KillProcessGroup(pgid int) error {
+	p, err := os.FindProcess(pgid)
+	if err != nil {
+		return nil
+	}
+	return p.Kill()
+}
+
+// BootID windows 上不支持，返回空字符串
+func BootID() string {
+	return ""
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
@@ -13,6 +14,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hamster-shared/aline-engine/consts"
 )
@@ -38,39 +40,79 @@ func GetUIDAndGID() (string, string, error) {
 type Cmd struct {
 	ctx context.Context
 	*exec.Cmd
+	// 进程退出后关闭
+	exited chan struct{}
+	// 被取消时，整个进程组都结束后关闭
+	stopped chan struct{}
+	// 结束进程时先发送 SIGTERM，超过这个时间还没退出就发送 SIGKILL
+	GracePeriod time.Duration
+	// 进程启动和退出时的回调，参数为进程组 id，用来记录正在运行的进程
+	OnStart func(pgid int)
+	OnExit  func(pgid int)
 }
 
 // NewCommand is like exec.CommandContext but ensures that subprocesses
 // are killed when the context times out, not just the top level process.
+// 命令在自己的进程组中运行，取消时先向整个进程组发送 SIGTERM，超过 GracePeriod 后再发送 SIGKILL
 func NewCommand(ctx context.Context, command string, args ...string) *Cmd {
-	return &Cmd{ctx, exec.Command(command, args...)}
+	return &Cmd{
+		ctx:         ctx,
+		Cmd:         exec.Command(command, args...),
+		GracePeriod: consts.KILL_GRACE_SECOND * time.Second,
+	}
 }
 
 func (c *Cmd) Start() error {
-	// Force-enable setpgid bit so that we can kill child processes when the
-	// context times out or is canceled.
-	//if c.Cmd.SysProcAttr == nil {
-	//	c.Cmd.SysProcAttr = &syscall.SysProcAttr{}
-	//}
-	//c.Cmd.SysProcAttr.Setpgid = true
+	setProcessGroup(c.Cmd)
 	err := c.Cmd.Start()
 	if err != nil {
 		return err
 	}
+	c.exited = make(chan struct{})
+	c.stopped = make(chan struct{})
+	if c.OnStart != nil {
+		c.OnStart(c.Cmd.Process.Pid)
+	}
 	go func() {
-		<-c.ctx.Done()
-		p := c.Cmd.Process
-		if p == nil {
+		defer close(c.stopped)
+		select {
+		case <-c.exited:
 			return
+		case <-c.ctx.Done():
 		}
+		p := c.Cmd.Process
 		// Kill by negative PID to kill the process group, which includes
 		// the top-level process we spawned as well as any subprocesses
 		// it spawned.
-		//_ = syscall.Kill(-p.Pid, syscall.SIGKILL)
+		_ = terminateProcessGroup(p)
+		// 主进程退出之后，子进程可能还在运行，要等整个进程组都结束
+		deadline := time.After(c.GracePeriod)
+		for processGroupAlive(p) {
+			select {
+			case <-deadline:
+				_ = killProcessGroup(p)
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
 	}()
 	return nil
 }
 
+// Wait 等待进程退出，被取消时会等到整个进程组都结束才返回
+// 返回的错误和 exec.CommandContext 一致，为进程的退出错误
+func (c *Cmd) Wait() error {
+	err := c.Cmd.Wait()
+	if c.exited != nil {
+		close(c.exited)
+		<-c.stopped
+		if c.OnExit != nil {
+			c.OnExit(c.Cmd.Process.Pid)
+		}
+	}
+	return err
+}
+
 func (c *Cmd) Run() error {
 	if err := c.Start(); err != nil {
 		return err
@@ -78,6 +120,23 @@ func (c *Cmd) Run() error {
 	return c.Wait()
 }
 
+// Output 和 exec.Cmd.Output 相同，但是使用进程组结束子进程
+func (c *Cmd) Output() ([]byte, error) {
+	var stdout bytes.Buffer
+	c.Stdout = &stdout
+	err := c.Run()
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput 和 exec.Cmd.CombinedOutput 相同，但是使用进程组结束子进程
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	var b bytes.Buffer
+	c.Stdout = &b
+	c.Stderr = &b
+	err := c.Run()
+	return b.Bytes(), err
+}
+
 func DefaultConfigDir() string {
 	userHomeDir, err := os.UserHomeDir()
 	if err != nil {
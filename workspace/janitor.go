@@ -0,0 +1,191 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/container"
+	"github.com/hamster-shared/aline-engine/logger"
+	"github.com/hamster-shared/aline-engine/model"
+)
+
+// 旧版本遗留的脚本超过这个时间没有修改就认为已经没有任务在使用
+const legacyScriptTTL = time.Hour
+
+// StartJanitor 按照 policy.JanitorInterval 定时回收工作目录，返回的函数用来停止
+func (m *Manager) StartJanitor() func() {
+	interval := m.policy.JanitorInterval
+	if interval <= 0 {
+		interval = DefaultPolicy().JanitorInterval
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			m.Clean(time.Now())
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// finished 已经结束的工作目录
+type finished struct {
+	dir  string
+	meta *Meta
+}
+
+// Clean 回收工作目录：
+// 1. 执行成功且策略要求删除的
+// 2. 执行失败且超过保留时间的
+// 3. 每个 job 超出 KeepLast 的最旧的工作目录
+// 4. 找不到对应工作目录的 _tmp 目录，以及旧版本遗留的脚本
+// 5. 已经停止的任务容器
+func (m *Manager) Clean(now time.Time) {
+	jobs, err := os.ReadDir(m.root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("janitor read workspace root %s failed: %s", m.root, err)
+		}
+	} else {
+		for _, job := range jobs {
+			if job.IsDir() {
+				m.cleanJob(filepath.Join(m.root, job.Name()), now)
+			}
+		}
+	}
+	m.cleanLegacyScripts(now)
+	pruneContainers()
+}
+
+func (m *Manager) cleanJob(jobDir string, now time.Time) {
+	entries, err := os.ReadDir(jobDir)
+	if err != nil {
+		logger.Warnf("janitor read %s failed: %s", jobDir, err)
+		return
+	}
+	var kept []finished
+	for _, entry := range entries {
+		name := entry.Name()
+		dir := filepath.Join(jobDir, name)
+		switch {
+		case entry.IsDir() && strings.HasSuffix(name, "_tmp"):
+			// 工作目录已经不存在或者已经结束的 _tmp 目录
+			base := strings.TrimSuffix(dir, "_tmp")
+			if !m.IsActive(base) {
+				m.removeOrphan(dir)
+			}
+		case entry.IsDir():
+			if _, _, ok := parseDirName(name); !ok || m.IsActive(dir) {
+				continue
+			}
+			meta, err := readMeta(dir + ".yml")
+			if err != nil || !meta.Status.IsTerminal() {
+				// 没有结束却也不在使用中，说明 worker 在执行过程中退出了，按失败处理
+				info, statErr := entry.Info()
+				if statErr != nil {
+					continue
+				}
+				meta = &Meta{Status: model.STATUS_FAIL, FinishTime: info.ModTime()}
+			}
+			if m.expired(meta, now) {
+				m.remove(dir)
+				continue
+			}
+			kept = append(kept, finished{dir: dir, meta: meta})
+		case strings.HasSuffix(name, ".yml"):
+			// 工作目录已经被删除，只剩下状态文件
+			base := strings.TrimSuffix(dir, ".yml")
+			if _, err := os.Stat(base); os.IsNotExist(err) {
+				m.removeOrphan(dir)
+			}
+		}
+	}
+
+	if m.policy.KeepLast <= 0 || len(kept) <= m.policy.KeepLast {
+		return
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].meta.FinishTime.After(kept[j].meta.FinishTime)
+	})
+	for _, f := range kept[m.policy.KeepLast:] {
+		m.remove(f.dir)
+	}
+}
+
+// expired 已经结束的工作目录是否应该被回收
+func (m *Manager) expired(meta *Meta, now time.Time) bool {
+	if meta.Status == model.STATUS_SUCCESS && m.policy.DeleteOnSuccess {
+		return true
+	}
+	if meta.Status != model.STATUS_SUCCESS && m.policy.KeepFailedFor > 0 {
+		return now.Sub(meta.FinishTime) > m.policy.KeepFailedFor
+	}
+	return false
+}
+
+func (m *Manager) removeOrphan(path string) {
+	if err := os.RemoveAll(path); err != nil {
+		logger.Warnf("janitor remove %s failed: %s", path, err)
+		return
+	}
+	logger.Debugf("janitor removed orphan %s", path)
+}
+
+// cleanLegacyScripts 清理旧版本 <jobName>_tmp 目录下遗留的脚本
+func (m *Manager) cleanLegacyScripts(now time.Time) {
+	if m.legacyRoot == "" {
+		return
+	}
+	tmpDirs, err := filepath.Glob(filepath.Join(m.legacyRoot, "*_tmp"))
+	if err != nil {
+		return
+	}
+	for _, tmpDir := range tmpDirs {
+		scripts, err := filepath.Glob(filepath.Join(tmpDir, "*.sh"))
+		if err != nil {
+			continue
+		}
+		for _, script := range scripts {
+			info, err := os.Stat(script)
+			if err != nil || now.Sub(info.ModTime()) < legacyScriptTTL {
+				continue
+			}
+			m.removeOrphan(script)
+		}
+	}
+}
+
+// pruneContainers 删除已经停止的任务容器，没有可用的容器运行时时跳过
+func pruneContainers() {
+	rt := container.Default()
+	ctx := context.Background()
+	containers, err := rt.List(ctx, ContainerLabel)
+	if errors.Is(err, container.ErrUnavailable) {
+		return
+	}
+	if err != nil {
+		logger.Warnf("janitor list containers failed: %s", err)
+		return
+	}
+	for _, c := range containers {
+		if c.Running {
+			continue
+		}
+		if err := rt.Remove(ctx, c.ID); err != nil {
+			logger.Warnf("janitor remove container %s failed: %s", c.ID, err)
+			continue
+		}
+		logger.Debugf("janitor removed container %s", c.ID)
+	}
+}
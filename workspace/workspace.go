@@ -0,0 +1,221 @@
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/logger"
+	"github.com/hamster-shared/aline-engine/model"
+	"gopkg.in/yaml.v3"
+)
+
+// ContainerLabel 执行任务时创建的容器都会带上这个 label，janitor 据此清理已经停止的容器
+const ContainerLabel = "aline.managed"
+
+// Workspace 一次执行独占的工作目录
+type Workspace struct {
+	JobName string
+	JobID   int
+	Attempt int
+	// 工作目录，代码检出和命令执行都在这里
+	Dir string
+	// 临时目录，存放 shell 脚本等临时文件
+	TmpDir string
+}
+
+func (w *Workspace) key() string {
+	return w.Dir
+}
+
+// metaFilePath 记录工作目录状态的文件，和工作目录放在同一级
+func (w *Workspace) metaFilePath() string {
+	return w.Dir + ".yml"
+}
+
+// Meta 工作目录的状态，janitor 根据它来决定是否回收
+type Meta struct {
+	JobName    string       `yaml:"jobName"`
+	JobID      int          `yaml:"jobId"`
+	Attempt    int          `yaml:"attempt"`
+	Status     model.Status `yaml:"status"`
+	StartTime  time.Time    `yaml:"startTime"`
+	FinishTime time.Time    `yaml:"finishTime,omitempty"`
+}
+
+// Policy 工作目录的回收策略
+type Policy struct {
+	// 执行成功后立即删除工作目录
+	DeleteOnSuccess bool
+	// 执行失败的工作目录保留多久，0 表示一直保留，直到超出 KeepLast
+	KeepFailedFor time.Duration
+	// 每个 job 最多保留多少个已经结束的工作目录，0 表示不限制
+	KeepLast int
+	// janitor 多久执行一次
+	JanitorInterval time.Duration
+}
+
+// DefaultRoot 默认的工作目录根目录 ~/workdir/workspaces
+func DefaultRoot() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, "workdir", "workspaces")
+}
+
+// DefaultPolicy 默认的回收策略
+func DefaultPolicy() Policy {
+	return Policy{
+		DeleteOnSuccess: true,
+		KeepFailedFor:   24 * time.Hour,
+		KeepLast:        5,
+		JanitorInterval: 10 * time.Minute,
+	}
+}
+
+// Manager 管理某个根目录下所有的工作目录
+type Manager struct {
+	root   string
+	policy Policy
+
+	mu     sync.Mutex
+	active map[string]*Workspace // key: workspace dir，正在使用的工作目录
+	// 旧版本直接在这个目录下使用 <jobName> 和 <jobName>_tmp，janitor 会清理里面遗留的脚本
+	legacyRoot string
+}
+
+// NewManager 创建工作目录管理器，root 为所有工作目录的根目录
+func NewManager(root string, policy Policy) *Manager {
+	return &Manager{
+		root:   root,
+		policy: policy,
+		active: make(map[string]*Workspace),
+	}
+}
+
+// SetLegacyRoot 设置旧版本的工作目录根目录
+func (m *Manager) SetLegacyRoot(dir string) {
+	m.legacyRoot = dir
+}
+
+// Root 所有工作目录的根目录
+func (m *Manager) Root() string {
+	return m.root
+}
+
+// Create 为一次执行创建工作目录，路径为 <root>/<jobName>/<id>-<attempt>
+// 如果目录已经存在（比如 worker 重启后重新执行），会先清空，保证不会读到上一次执行留下的文件
+func (m *Manager) Create(jobName string, id, attempt int) (*Workspace, error) {
+	if attempt < 1 {
+		attempt = 1
+	}
+	dir := filepath.Join(m.root, jobName, fmt.Sprintf("%d-%d", id, attempt))
+	ws := &Workspace{
+		JobName: jobName,
+		JobID:   id,
+		Attempt: attempt,
+		Dir:     dir,
+		TmpDir:  dir + "_tmp",
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.active[ws.key()]; ok {
+		return nil, fmt.Errorf("workspace %s is in use", dir)
+	}
+	for _, d := range []string{ws.Dir, ws.TmpDir} {
+		if err := os.RemoveAll(d); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(d, os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	err := writeMeta(ws.metaFilePath(), &Meta{
+		JobName:   jobName,
+		JobID:     id,
+		Attempt:   attempt,
+		Status:    model.STATUS_RUNNING,
+		StartTime: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.active[ws.key()] = ws
+	return ws, nil
+}
+
+// Finish 执行结束，记录最终状态并按照回收策略处理工作目录
+func (m *Manager) Finish(ws *Workspace, status model.Status) {
+	m.mu.Lock()
+	delete(m.active, ws.key())
+	m.mu.Unlock()
+
+	// 临时文件只在执行过程中有用
+	if err := os.RemoveAll(ws.TmpDir); err != nil {
+		logger.Warnf("remove workspace tmp dir %s failed: %s", ws.TmpDir, err)
+	}
+	if status == model.STATUS_SUCCESS && m.policy.DeleteOnSuccess {
+		m.remove(ws.Dir)
+		return
+	}
+	meta, err := readMeta(ws.metaFilePath())
+	if err != nil {
+		logger.Warnf("read workspace meta %s failed: %s", ws.metaFilePath(), err)
+		meta = &Meta{JobName: ws.JobName, JobID: ws.JobID, Attempt: ws.Attempt}
+	}
+	meta.Status = status
+	meta.FinishTime = time.Now()
+	if err := writeMeta(ws.metaFilePath(), meta); err != nil {
+		logger.Warnf("write workspace meta %s failed: %s", ws.metaFilePath(), err)
+	}
+}
+
+// IsActive 工作目录是否正在被使用
+func (m *Manager) IsActive(dir string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.active[dir]
+	return ok
+}
+
+// remove 删除工作目录以及它的临时目录和状态文件
+func (m *Manager) remove(dir string) {
+	for _, p := range []string{dir, dir + "_tmp", dir + ".yml"} {
+		if err := os.RemoveAll(p); err != nil {
+			logger.Warnf("remove workspace %s failed: %s", p, err)
+		}
+	}
+	logger.Debugf("workspace %s removed", dir)
+}
+
+func readMeta(path string) (*Meta, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var meta Meta
+	err = yaml.Unmarshal(data, &meta)
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func writeMeta(path string, meta *Meta) error {
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// parseDirName 解析 <id>-<attempt> 形式的工作目录名
+func parseDirName(name string) (int, int, bool) {
+	var id, attempt int
+	n, err := fmt.Sscanf(name, "%d-%d", &id, &attempt)
+	if err != nil || n != 2 || fmt.Sprintf("%d-%d", id, attempt) != name {
+		return 0, 0, false
+	}
+	return id, attempt, true
+}
@@ -0,0 +1,103 @@
+package workspace
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hamster-shared/aline-engine/logger"
+	"github.com/hamster-shared/aline-engine/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	logger.Init().ToStdout()
+	os.Exit(m.Run())
+}
+
+func TestCreateWorkspace(t *testing.T) {
+	root := t.TempDir()
+	m := NewManager(root, DefaultPolicy())
+
+	ws, err := m.Create("build", 3, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "build", "3-2"), ws.Dir)
+	assert.DirExists(t, ws.Dir)
+	assert.DirExists(t, ws.TmpDir)
+	assert.True(t, m.IsActive(ws.Dir))
+
+	// 同一次执行不能重复创建
+	_, err = m.Create("build", 3, 2)
+	assert.Error(t, err)
+
+	// 不同的执行互不影响
+	other, err := m.Create("build", 3, 3)
+	assert.NoError(t, err)
+	assert.NotEqual(t, ws.Dir, other.Dir)
+}
+
+func TestFinishWorkspace(t *testing.T) {
+	m := NewManager(t.TempDir(), DefaultPolicy())
+
+	success, err := m.Create("build", 1, 1)
+	assert.NoError(t, err)
+	m.Finish(success, model.STATUS_SUCCESS)
+	assert.NoDirExists(t, success.Dir)
+	assert.NoFileExists(t, success.metaFilePath())
+
+	failed, err := m.Create("build", 2, 1)
+	assert.NoError(t, err)
+	m.Finish(failed, model.STATUS_FAIL)
+	assert.DirExists(t, failed.Dir)
+	assert.NoDirExists(t, failed.TmpDir)
+	assert.False(t, m.IsActive(failed.Dir))
+	meta, err := readMeta(failed.metaFilePath())
+	assert.NoError(t, err)
+	assert.Equal(t, model.STATUS_FAIL, meta.Status)
+}
+
+func TestJanitorClean(t *testing.T) {
+	root := t.TempDir()
+	m := NewManager(root, Policy{DeleteOnSuccess: true, KeepFailedFor: time.Hour, KeepLast: 2})
+
+	var failed []*Workspace
+	for i := 1; i <= 3; i++ {
+		ws, err := m.Create("build", i, 1)
+		assert.NoError(t, err)
+		m.Finish(ws, model.STATUS_FAIL)
+		failed = append(failed, ws)
+	}
+	running, err := m.Create("build", 4, 1)
+	assert.NoError(t, err)
+	orphanTmp := filepath.Join(root, "build", "9-1_tmp")
+	assert.NoError(t, os.MkdirAll(orphanTmp, os.ModePerm))
+
+	m.Clean(time.Now())
+	// 只保留最近的 2 个失败的工作目录
+	assert.NoDirExists(t, failed[0].Dir)
+	assert.DirExists(t, failed[1].Dir)
+	assert.DirExists(t, failed[2].Dir)
+	// 正在使用的不会被回收
+	assert.DirExists(t, running.Dir)
+	assert.DirExists(t, running.TmpDir)
+	assert.NoDirExists(t, orphanTmp)
+
+	// 超过保留时间的失败工作目录会被回收
+	m.Clean(time.Now().Add(2 * time.Hour))
+	assert.NoDirExists(t, failed[1].Dir)
+	assert.NoDirExists(t, failed[2].Dir)
+	assert.DirExists(t, running.Dir)
+}
+
+func TestParseDirName(t *testing.T) {
+	id, attempt, ok := parseDirName("12-3")
+	assert.True(t, ok)
+	assert.Equal(t, 12, id)
+	assert.Equal(t, 3, attempt)
+
+	_, _, ok = parseDirName("12-3_tmp")
+	assert.False(t, ok)
+	_, _, ok = parseDirName("src")
+	assert.False(t, ok)
+}